@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useSampling sets the sample settings for the rest of the test
+func useSampling(t *testing.T, interval, budget time.Duration) {
+	t.Helper()
+	cfg := *currentConfig()
+	cfg.HTTP.MaxSamples = 5
+	cfg.HTTP.SampleInterval = interval
+	cfg.HTTP.SampleBudget = budget
+	cfg.HTTP.Timeout = 10 * time.Second
+	useConfig(t, &cfg)
+}
+
+// slowServer answers every request after delay
+func slowServer(t *testing.T, delay time.Duration) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte("<title>ok</title>"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Fast samples all fit a budget shorter than the probe timeout
+func TestSampleAvailability(t *testing.T) {
+	useSampling(t, 10*time.Millisecond, time.Second)
+	server := slowServer(t, 0)
+
+	first := ProbeResponse{Status: "200", ProbeTime: 1}
+	sample := sampleAvailability(context.Background(), server.URL, first, 5)
+	if sample.Taken != 5 || sample.Failures != 0 || sample.Flapping {
+		t.Errorf("sample %+v, want 5 taken without failures", sample)
+	}
+}
+
+// Slow samples stop at the budget, and the one it cuts short is not
+// counted as a failure
+func TestSampleAvailabilityBudget(t *testing.T) {
+	useSampling(t, 10*time.Millisecond, 500*time.Millisecond)
+	server := slowServer(t, 200*time.Millisecond)
+
+	start := time.Now()
+	first := ProbeResponse{Status: "200", ProbeTime: 10}
+	sample := sampleAvailability(context.Background(), server.URL, first, 5)
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Errorf("sampling took %v on a 500ms budget", elapsed)
+	}
+	if sample.Taken < 2 || sample.Taken >= 5 || sample.Failures != 0 {
+		t.Errorf("sample %+v, want some but not all taken, no failures", sample)
+	}
+}
+
+// A job's availability samples go out with its exports
+func TestExportAvailability(t *testing.T) {
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	job.AddResult("test", Result{Host: "www.example.com", Source: "test", Status: "discovered", Timestamp: time.Now()})
+	job.AddResult("test", Result{Host: "api.example.com", Source: "test", Status: "discovered", Timestamp: time.Now()})
+	job.Complete()
+	sample := &AvailabilitySample{Requested: 3, Taken: 3, Failures: 1, MinMS: 10, MedianMS: 20, MaxMS: 30, Flapping: true}
+	job.RecordProbe("WWW.example.com", ProbeResponse{Status: "200", Availability: sample})
+
+	response := httptest.NewRecorder()
+	jobExportHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"/export?format=csv", nil), job)
+	rows, err := csv.NewReader(response.Body).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("csv export: %d rows, %v", len(rows), err)
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	for _, row := range rows[1:] {
+		want := []string{"3", "1", "20", "true"}
+		if row[0] == "api.example.com" {
+			want = []string{"", "", "", ""}
+		}
+		got := []string{row[columns["samples"]], row[columns["sample_failures"]], row[columns["median_ms"]], row[columns["flapping"]]}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: samples, failures, median, flapping %v; want %v", row[0], got, want)
+		}
+	}
+
+	response = httptest.NewRecorder()
+	jobExportHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"/export?format=json", nil), job)
+	var results []Result
+	if err := json.NewDecoder(response.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if got := result.Availability; (result.Host == "www.example.com") != (got != nil && *got == *sample) {
+			t.Errorf("%s: json availability %+v", result.Host, got)
+		}
+	}
+	for _, host := range job.MergedHosts() {
+		if got := host.Availability; (host.Host == "www.example.com") != (got != nil && *got == *sample) {
+			t.Errorf("%s: host availability %+v", host.Host, got)
+		}
+	}
+}
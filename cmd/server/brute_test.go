@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// bruteStream runs the DNS brute-force stream with query and returns the
+// hosts it found in order, and its progress messages
+func bruteStream(t *testing.T, server *httptest.Server, query url.Values) (hosts, progress []string) {
+	t.Helper()
+	for _, event := range streamEvents(t, server.URL+"/api/dns/stream?"+query.Encode()) {
+		var data struct {
+			Host    string `json:"host"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(event.data, &data)
+		switch event.name {
+		case "message":
+			hosts = append(hosts, data.Host)
+		case "progress":
+			progress = append(progress, data.Message)
+		}
+	}
+	return hosts, progress
+}
+
+// depth= brute forces the names each level finds again, once each
+func TestBruteForceDepth(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	for depth, want := range map[string][]string{
+		"":  {"corp." + tt.Zone, "vpn." + tt.Zone},
+		"2": {"corp." + tt.Zone, "vpn.corp." + tt.Zone, "vpn." + tt.Zone},
+	} {
+		query := url.Values{"target": {tt.Zone}, "words": {"vpn,corp"}}
+		if depth != "" {
+			query.Set("depth", depth)
+		}
+		hosts, progress := bruteStream(t, server, query)
+		slices.Sort(hosts)
+		if !slices.Equal(hosts, want) {
+			t.Errorf("depth %q found %v, want %v", depth, hosts, want)
+		}
+		if depth == "2" && !slices.Equal(progress, []string{"Level 1 of 2: brute forcing 1 domain(s)", "Level 2 of 2: brute forcing 2 domain(s)"}) {
+			t.Errorf("depth 2 reported levels %q", progress)
+		}
+	}
+
+	query := url.Values{"target": {tt.Zone}, "words": {"vpn"}, "depth": {"99"}}
+	resp, err := http.Get(server.URL + "/api/dns/stream?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("depth=99 answered HTTP %d, want 400", resp.StatusCode)
+	}
+}
+
+// categories= and words= choose the labels, each tried once, in order and
+// up to limit=; an unknown category is refused with the valid ones listed
+func TestBruteLabels(t *testing.T) {
+	labels := func(query url.Values) []string {
+		t.Helper()
+		parsed, err := parseBruteLabels(query)
+		if err != nil {
+			t.Fatalf("%v: %v", query, err)
+		}
+		var tried []string
+		parsed.Each(func(label string) bool {
+			tried = append(tried, label)
+			return true
+		})
+		return tried
+	}
+
+	common := labels(url.Values{"categories": {"common"}})
+	if !slices.Equal(common, commonSubdomains["common"]) {
+		t.Errorf("categories=common tried %v", common)
+	}
+	both := labels(url.Values{"words": {"API,corp,api"}, "categories": {"common,development"}})
+	if both[0] != "api" || both[1] != "corp" || len(both) != len(slices.Compact(slices.Sorted(slices.Values(both)))) {
+		t.Errorf("words and categories tried %v, want api, corp, then each category word once", both)
+	}
+	for _, word := range commonSubdomains["development"] {
+		if !slices.Contains(both, word) {
+			t.Errorf("development's %s not tried", word)
+		}
+	}
+	if limited := labels(url.Values{"categories": {"common"}, "limit": {"3"}}); !slices.Equal(limited, common[:3]) {
+		t.Errorf("limit=3 tried %v", limited)
+	}
+
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+	query := url.Values{"target": {tt.Zone}, "categories": {"common,nosuch"}}
+	resp, err := http.Get(server.URL + "/api/dns/stream?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), `"nosuch"`) || !strings.Contains(string(body), "common, ") {
+		t.Errorf("unknown category: HTTP %d %q, want 400 listing the categories", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Reloads racing requests leave each request on the one snapshot it
+// started with, and requests after a reload on the new one. Run with -race.
+func TestConfigSnapshots(t *testing.T) {
+	useConfig(t, currentConfig())
+	t.Setenv("HTTP_USER_AGENT", "agent-0")
+
+	handler := withMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		cfg := configFrom(r.Context())
+		agent := cfg.HTTP.UserAgent
+		time.Sleep(time.Millisecond)
+		if again := configFrom(r.Context()); again != cfg || again.HTTP.UserAgent != agent {
+			t.Errorf("request started on %q and ended on %q", agent, again.HTTP.UserAgent)
+		}
+		fmt.Fprint(w, agent)
+	})
+
+	stop := make(chan struct{})
+	reloaded := make(chan struct{})
+	go func() {
+		defer close(reloaded)
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			os.Setenv("HTTP_USER_AGENT", fmt.Sprintf("agent-%d", i))
+			reloadConfig()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-reloaded
+
+	os.Setenv("HTTP_USER_AGENT", "agent-final")
+	reloadConfig()
+	response := httptest.NewRecorder()
+	handler(response, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	if got := response.Body.String(); got != "agent-final" {
+		t.Errorf("request after the last reload saw %q, want agent-final", got)
+	}
+}
+
+// A job's concurrency=, dns_timeout= and probe_timeout= scope its config
+// snapshot within the MaxJob bounds, dns_qps= paces its queries, and the
+// job records the settings it ran with
+func TestJobOverrides(t *testing.T) {
+	cfg := *currentConfig()
+	cfg.Security.MaxJobDNSConcurrency = 50
+	cfg.Security.MaxJobDNSTimeout = 5 * time.Second
+	cfg.Security.MaxJobProbeTimeout = 10 * time.Second
+	cfg.Security.MaxJobDNSQPS = 100
+	ctx := withConfig(context.Background(), &cfg)
+
+	scoped, err := jobConfig(ctx, url.Values{"concurrency": {"50"}, "dns_timeout": {"2s"}, "probe_timeout": {"3s"}})
+	if err != nil || scoped.DNS.Concurrency != 50 || scoped.DNS.Timeout != 2*time.Second || scoped.HTTP.Timeout != 3*time.Second {
+		t.Fatalf("scoped config %+v %+v, %v", scoped.DNS, scoped.HTTP, err)
+	}
+	if cfg.DNS.Timeout == 2*time.Second && cfg.HTTP.Timeout == 3*time.Second {
+		t.Error("the overrides changed the config they were scoped from")
+	}
+	if client := probeHTTPClient(withConfig(ctx, scoped)); client.Timeout != 3*time.Second {
+		t.Errorf("probe client under probe_timeout=3s times out after %v", client.Timeout)
+	}
+	for _, bad := range []url.Values{
+		{"concurrency": {"51"}}, {"concurrency": {"0"}}, {"dns_timeout": {"6s"}}, {"dns_timeout": {"soon"}},
+		{"probe_timeout": {"-1s"}}, {"probe_timeout": {"11s"}}, {"dns_qps": {"101"}}, {"dns_qps": {"0"}},
+	} {
+		if _, err := jobConfig(ctx, bad); err == nil {
+			t.Errorf("%s accepted", bad.Encode())
+		}
+	}
+
+	// The bucket starts full, a second's worth of queries; the rest wait
+	job, jobCtx := createJob(ctx, "qps.example.com", []string{"test"}, url.Values{"dns_qps": {"20"}})
+	defer job.Complete()
+	start := time.Now()
+	for range 30 {
+		if err := dnsQPS.wait(jobCtx, "192.0.2.53:53"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("30 queries at dns_qps=20 took %v, want about 500ms", elapsed)
+	}
+
+	tt, testCfg := startTestTargetT(t)
+	useConfig(t, testCfg)
+	server := httptest.NewServer(newRouter(testCfg))
+	defer server.Close()
+	query := url.Values{"target": {tt.Zone}, "words": {"vpn"}, "concurrency": {"3"}, "dns_timeout": {"2s"}, "dns_qps": {"50"}}
+	streamEvents(t, server.URL+"/api/dns/stream?"+query.Encode())
+	job = latestJob(tt.Zone, nil)
+	job.mu.RLock()
+	settings := job.Settings
+	job.mu.RUnlock()
+	if settings == nil || settings.DNSConcurrency != 3 || settings.DNSTimeout != "2s" || settings.DNSQPS != 50 ||
+		settings.ProbeTimeout != testCfg.HTTP.Timeout.String() || !slices.Equal(settings.Overrides, []string{"concurrency", "dns_timeout", "dns_qps"}) {
+		t.Errorf("job recorded settings %+v", settings)
+	}
+	query.Set("dns_qps", "1000000")
+	resp, err := http.Get(server.URL + "/api/dns/stream?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "dns_qps") {
+		t.Errorf("dns_qps past the bound: HTTP %d %q", resp.StatusCode, body)
+	}
+}
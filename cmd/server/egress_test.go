@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// egressEcho answers with the address in ip after delay, counting lookups
+type egressEcho struct {
+	ip      atomic.Value
+	lookups int64
+	delay   time.Duration
+}
+
+func (e *egressEcho) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&e.lookups, 1)
+	time.Sleep(e.delay)
+	fmt.Fprintln(w, e.ip.Load())
+}
+
+// useEgressEcho points the egress lookups at a fresh echo endpoint with an
+// hour-long cache and empties the cache
+func useEgressEcho(t *testing.T, delay time.Duration) *egressEcho {
+	t.Helper()
+	echo := &egressEcho{delay: delay}
+	echo.ip.Store("198.51.100.1")
+	server := httptest.NewServer(echo)
+	t.Cleanup(server.Close)
+
+	cfg := *currentConfig()
+	cfg.Egress.EchoEndpoints = []string{server.URL}
+	cfg.Egress.CacheTTL = time.Hour
+	cfg.Egress.Timeout = 5 * time.Second
+	useConfig(t, &cfg)
+
+	clearEgress := func() {
+		egressTracker.mu.Lock()
+		egressTracker.ips = nil
+		egressTracker.mu.Unlock()
+	}
+	clearEgress()
+	t.Cleanup(clearEgress)
+	return echo
+}
+
+// egressAtEnd waits for the completion check of job's egress
+func egressAtEnd(t *testing.T, job *Job) ([]string, bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		job.mu.RLock()
+		ips, changed := job.EgressIPsAtEnd, job.EgressChanged
+		job.mu.RUnlock()
+		if ips != nil {
+			return ips, changed
+		}
+	}
+	t.Fatalf("job %s never re-checked its egress", job.ID)
+	return nil, false
+}
+
+// The completion check looks the egress up afresh even while the cache
+// still holds the starting IPs
+func TestEgressChanged(t *testing.T) {
+	echo := useEgressEcho(t, 0)
+
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	job.awaitEgress(context.Background())
+	echo.ip.Store("198.51.100.2")
+	job.Complete()
+	if ips, changed := egressAtEnd(t, job); !changed || !slices.Equal(ips, []string{"198.51.100.2"}) {
+		t.Errorf("egress at end %v (changed %v), want 198.51.100.2 changed", ips, changed)
+	}
+	if !slices.Equal(job.EgressIPs, []string{"198.51.100.1"}) {
+		t.Errorf("egress at start %v, want 198.51.100.1", job.EgressIPs)
+	}
+
+	// The next job starts from the refreshed cache and ends on the same IP
+	lookups := atomic.LoadInt64(&echo.lookups)
+	unchanged, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	unchanged.Complete()
+	if ips, changed := egressAtEnd(t, unchanged); changed || !slices.Equal(ips, []string{"198.51.100.2"}) {
+		t.Errorf("egress at end %v (changed %v), want 198.51.100.2 unchanged", ips, changed)
+	}
+	if got := atomic.LoadInt64(&echo.lookups) - lookups; got != 1 {
+		t.Errorf("cached start and fresh end took %d lookups, want 1", got)
+	}
+}
+
+// egressSource reports the starting egress IPs its job had recorded by
+// the time it ran
+type egressSource struct {
+	job  *Job
+	seen chan []string
+}
+
+func (s egressSource) Name() string { return "egress" }
+
+func (s egressSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	s.job.mu.RLock()
+	s.seen <- s.job.EgressIPs
+	s.job.mu.RUnlock()
+	return nil
+}
+
+// Sources start only once the starting egress is recorded, however slow
+// the lookup, and a job that ends early still compares against it
+func TestEgressBeforeSources(t *testing.T) {
+	useEgressEcho(t, 200*time.Millisecond)
+
+	job, ctx := createJob(context.Background(), "example.com", []string{"egress"}, nil)
+	source := egressSource{job: job, seen: make(chan []string, 1)}
+	runSources(ctx, job, "example.com", []sourceEntry{{
+		source:  source,
+		label:   "egress",
+		timeout: func(TimeoutConfig) time.Duration { return 5 * time.Second },
+	}}, nil, sourceHooks{
+		result: func(Result) {},
+		notice: func(string, string, string) {},
+		event:  func(string, string, interface{}) {},
+		done:   func(string, string, int) {},
+	})
+	if seen := <-source.seen; !slices.Equal(seen, []string{"198.51.100.1"}) {
+		t.Errorf("source ran with egress %v recorded, want 198.51.100.1", seen)
+	}
+
+	early, _ := createJob(context.Background(), "example.org", []string{"test"}, nil)
+	early.Fail(context.Canceled)
+	if ips, changed := egressAtEnd(t, early); changed || !slices.Equal(ips, []string{"198.51.100.1"}) {
+		t.Errorf("egress at end %v (changed %v), want 198.51.100.1 unchanged", ips, changed)
+	}
+	job.Complete()
+}
+
+// stunEcho answers STUN binding requests with ip as the mapped address
+func stunEcho(t *testing.T, network, address string, ip net.IP) string {
+	t.Helper()
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		request := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(request)
+			if err != nil {
+				return
+			}
+			if n < 20 {
+				continue
+			}
+			family, mapped := byte(0x01), ip.To4()
+			if mapped == nil {
+				family, mapped = 0x02, ip.To16()
+			}
+			key := request[4:20]
+			response := append([]byte{0x01, 0x01, 0, byte(8 + len(mapped))}, request[4:20]...)
+			response = append(response, 0x00, 0x20, 0, byte(4+len(mapped)), 0, family, 0, 0)
+			for i, b := range mapped {
+				response = append(response, b^key[i])
+			}
+			conn.WriteTo(response, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// The egress holds an address of each family, from the first endpoint
+// answering over it: here the HTTP echo over IPv4 and STUN over IPv6
+func TestEgressFamilies(t *testing.T) {
+	useEgressEcho(t, 0)
+	cfg := *currentConfig()
+	stun := stunEcho(t, "udp6", "[::1]:0", net.ParseIP("2001:db8::1"))
+	cfg.Egress.EchoEndpoints = append(cfg.Egress.EchoEndpoints, "stun:"+stun)
+	useConfig(t, &cfg)
+
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	job.awaitEgress(context.Background())
+	job.Complete()
+	want := []string{"198.51.100.1", "2001:db8::1"}
+	job.mu.RLock()
+	ips := job.EgressIPs
+	job.mu.RUnlock()
+	if !slices.Equal(ips, want) {
+		t.Errorf("egress %v, want %v", ips, want)
+	}
+	if ips, _ := egressAtEnd(t, job); !slices.Equal(ips, want) {
+		t.Errorf("egress at end %v, want %v", ips, want)
+	}
+
+	// An IPv4 STUN answer decodes too
+	v4 := stunEcho(t, "udp4", "127.0.0.1:0", net.ParseIP("203.0.113.7"))
+	if ip, err := stunEgressIP(context.Background(), "udp4", v4); err != nil || ip.String() != "203.0.113.7" {
+		t.Errorf("STUN over IPv4: %v, %v", ip, err)
+	}
+}
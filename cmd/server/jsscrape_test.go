@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSScrapeHosts(t *testing.T) {
+	cfg := loadConfig()
+	cfg.Security.AllowedDomains = []string{"example.com"}
+
+	for _, tc := range []struct {
+		list    string
+		want    string
+		wantErr string
+	}{
+		{list: "example.com, www.example.com,API.example.com", want: "example.com,www.example.com,api.example.com"},
+		{list: "127.0.0.1", wantErr: "IP address"},
+		{list: "[::1]", wantErr: "IP address"},
+		{list: "169.254.169.254", wantErr: "IP address"},
+		{list: "www.example.com:8080", wantErr: "not a bare hostname"},
+		{list: "www.example.com/admin", wantErr: "not a bare hostname"},
+		{list: "user@www.example.com", wantErr: "not a bare hostname"},
+		{list: "metadata.google.internal", wantErr: "not example.com or one of its subdomains"},
+		{list: "evil.com", wantErr: "not example.com or one of its subdomains"},
+		{list: "example.com.evil.com", wantErr: "not example.com or one of its subdomains"},
+	} {
+		hosts, err := jsScrapeHosts(cfg, "example.com", tc.list)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%q: got %v, %v; want error containing %q", tc.list, hosts, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || strings.Join(hosts, ",") != tc.want {
+			t.Errorf("%q: got %v, %v; want %s", tc.list, hosts, err, tc.want)
+		}
+	}
+
+	// ALLOWED_DOMAINS narrower than the target still applies
+	cfg.Security.AllowedDomains = []string{"www.example.com"}
+	if _, err := jsScrapeHosts(cfg, "example.com", "admin.example.com"); err == nil || !strings.Contains(err.Error(), "not in allowed list") {
+		t.Errorf("admin.example.com outside ALLOWED_DOMAINS: got %v", err)
+	}
+}
@@ -1,26 +1,72 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"container/list"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
+	"crypto/x509"
+	"embed"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
+	htmltemplate "html/template"
 	"io"
+	"io/fs"
+	"iter"
 	"log"
+	"maps"
+	"math"
+	"math/bits"
+	mathrand "math/rand/v2"
+	"mime"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/miekg/dns"
+	"go.etcd.io/bbolt"
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/sync/errgroup"
 )
 
 // Build information injected at compile time
@@ -28,18 +74,32 @@ var (
 	version   = "2.2.0"
 	buildTime = "unknown"
 	gitCommit = "unknown"
+
+	// "true" (-X main.passiveBuild=true) builds a binary that always runs
+	// passive-only, whatever PASSIVE_ONLY says
+	passiveBuild = "false"
 )
 
 // Configuration structure for better settings management
 type Config struct {
 	Port       string
 	LogLevel   string
+	DataDir    string
 	Timeouts   TimeoutConfig
 	DNS        DNSConfig
 	HTTP       HTTPConfig
 	RateLimit  RateLimitConfig
 	Security   SecurityConfig
 	Monitoring MonitoringConfig
+	APIKeys    APIKeyConfig
+	Egress     EgressConfig
+	Processing ProcessingConfig
+	Admin      AdminConfig
+	Locale     LocaleConfig
+	Cloud      CloudConfig
+	Shadow     ShadowConfig
+	Jobs       JobConfig
+	Upstreams  UpstreamConfig
 }
 
 type TimeoutConfig struct {
@@ -48,7 +108,16 @@ type TimeoutConfig struct {
 	DNS       time.Duration
 	Search    time.Duration
 	Permute   time.Duration
+	Feedback  time.Duration
 	Zone      time.Duration
+	LeakIX    time.Duration
+	SPF       time.Duration
+	Records   time.Duration
+	PTR       time.Duration
+	ASN       time.Duration
+	JSScrape  time.Duration
+	SRV       time.Duration
+	Takeover  time.Duration
 	HTTPProbe time.Duration
 }
 
@@ -57,6 +126,71 @@ type DNSConfig struct {
 	Concurrency int
 	Retries     int
 	Timeout     time.Duration
+
+	// One IP[:port] server per line, replacing Servers when it loads
+	ServersFile string
+
+	// Dial and handshake timeout for DNS-over-TLS servers
+	TLSTimeout time.Duration
+
+	// Address families brute-force lookups ask for: ipv4, ipv6 or both
+	QueryTypes string
+
+	// Longest CNAME chain followed before giving up on a name
+	CNAMEMaxDepth int
+
+	// Highest depth= for brute force, which repeats the wordlist under
+	// each name the previous level found
+	MaxBruteDepth int
+
+	// Most candidates one mode=smart permutation scan generates
+	PermuteMaxCandidates int
+
+	// Most candidates the feedback source derives from one combined
+	// scan's passive results
+	FeedbackMaxCandidates int
+
+	// Whether resolved names pointing only into AWS, GCP or Azure are
+	// checked for addresses that no longer answer, and how long a
+	// connection attempt may take
+	UnboundIPCheck   bool
+	UnboundIPTimeout time.Duration
+
+	// Reverse sweep bounds
+	PTRPrefixLength int
+	PTRMaxIPs       int
+	ASNMaxQueries   int
+
+	// Port zone transfers are requested on at each nameserver
+	AXFRPort string
+
+	// Bounds for the per-candidate debug log of brute-force scans
+	CandidateLogMaxEntries int
+	CandidateLogMaxBytes   int64
+
+	// External wordlists as name=path (or just path), compiled to disk at
+	// startup and streamed during scans
+	Wordlists []string
+
+	// Most labels one POST /api/wordlists upload may hold
+	UploadMaxWords int
+
+	// Text file that extends the embedded "extended" category, or replaces
+	// it with WordlistMode "replace"
+	WordlistFile string
+	WordlistMode string
+
+	// Answer cache per resolver: entry cap (0 disables it) and upper
+	// bounds on how long answers and NXDOMAIN/NODATA are kept
+	CacheSize        int
+	CacheMaxTTL      time.Duration
+	CacheNegativeTTL time.Duration
+
+	// Outbound query rate caps, across all servers and for each server
+	// address (0 leaves it unlimited). They hold for every resolver in the
+	// process, scan-specific pools included.
+	MaxQPS          int
+	MaxQPSPerServer int
 }
 
 type HTTPConfig struct {
@@ -65,6 +199,64 @@ type HTTPConfig struct {
 	Timeout       time.Duration
 	MaxBodySize   int64
 	SkipTLSVerify bool
+
+	// Proxy for every outbound HTTP request (http://, https:// or
+	// socks5://), and hosts that bypass it. Without ProxyURL the process
+	// environment's HTTP(S)_PROXY applies.
+	ProxyURL string
+	NoProxy  []string
+
+	// Headers added to every probe, e.g. an auth cookie. A batch's own
+	// headers take precedence.
+	ExtraHeaders http.Header
+
+	// Availability sampling: extra probes per live host, spaced
+	// SampleInterval apart and bounded in total by SampleBudget
+	MaxSamples     int
+	SampleInterval time.Duration
+	SampleBudget   time.Duration
+
+	// Per-scan limits for the JavaScript crawling source
+	JSMaxFiles int
+	JSMaxBytes int64
+
+	// Outbound request pools. Discovery (upstream source APIs) and probes
+	// (requests to scanned hosts) never wait on each other; probes are also
+	// limited per host and spaced ProbeHostDelay apart.
+	DiscoveryConcurrency int
+	ProbeConcurrency     int
+	ProbePerHost         int
+	ProbeHostDelay       time.Duration
+
+	// Most URLs one batch probe visits; hosts × ports past it are skipped
+	ProbeMaxTargets int
+
+	// Batch probes look a name up before the HTTP request and report the
+	// ones that don't resolve as no-dns. Off for names only /etc/hosts or
+	// a proxy knows.
+	ProbeSkipDead bool
+
+	// Probe results are reused for ProbeCacheTTL (0 disables the cache),
+	// least recently used first out past ProbeCacheSize entries. With
+	// ProbeCachePersist and DATA_DIR the cache survives restarts.
+	ProbeCacheTTL     time.Duration
+	ProbeCacheSize    int
+	ProbeCachePersist bool
+
+	// Probes failing on a connection reset, an early EOF or a timeout are
+	// tried ProbeRetries more times, ProbeRetryBackoff apart (doubling);
+	// with ProbeRetry5xx so are 5xx answers. 4xx answers never are.
+	ProbeRetries      int
+	ProbeRetryBackoff time.Duration
+	ProbeRetry5xx     bool
+
+	// Probes connect to the addresses the scan's DNS resolvers give rather
+	// than the system's, and to ProbeHTTPPort and ProbeHTTPSPort in place
+	// of 80 and 443: for targets only DNS_SERVERS know, served on other
+	// ports, such as the synthetic test target
+	ProbeViaResolvers bool
+	ProbeHTTPPort     int
+	ProbeHTTPSPort    int
 }
 
 type RateLimitConfig struct {
@@ -78,36 +270,204 @@ type SecurityConfig struct {
 	BlockedUserAgents []string
 	MaxConcurrentJobs int
 	EnableCORS        bool
+
+	// ed25519 key (PEM, PKCS#8) used to sign bundles and exports
+	SigningKeyPath string
+
+	// Cooperative target locks for scans with active sources
+	TargetLocks   bool
+	TargetLockTTL time.Duration
+
+	// Client API keys and their roles (viewer or operator). Empty leaves
+	// the API open, with operator access for everyone.
+	ClientKeys map[string]string
+
+	// Bounds on what a job may ask for with concurrency=, dns_timeout=,
+	// probe_timeout= and dns_qps=
+	MaxJobDNSConcurrency int
+	MaxJobDNSTimeout     time.Duration
+	MaxJobProbeTimeout   time.Duration
+	MaxJobDNSQPS         int
 }
 
 type MonitoringConfig struct {
 	EnableMetrics bool
 	EnableHealth  bool
 	MetricsPort   string
+	BindAttempts  int
+}
+
+// Result post-processing pipeline settings
+type ProcessingConfig struct {
+	RulesFile      string
+	DefaultTimeout time.Duration
+
+	// JSON file of takeover fingerprints added to, or replacing by
+	// provider, the built-in ones
+	TakeoverFingerprints string
+}
+
+// Optional API keys for third-party sources
+type APIKeyConfig struct {
+	LeakIX string
+}
+
+// Base URLs of the services the sources query, for a mirror or for the
+// synthetic test target's stand-ins
+type UpstreamConfig struct {
+	Wayback  string
+	CrtSh    string
+	Search   string
+	LeakIX   string
+	RIPEstat string
+}
+
+// Egress IP discovery settings. Endpoints are HTTPS ip-echo URLs or
+// stun:host:port entries, tried in order.
+type EgressConfig struct {
+	EchoEndpoints []string
+	CacheTTL      time.Duration
+	Timeout       time.Duration
+}
+
+// Operator endpoints. MaintenanceAction decides what happens to running
+// jobs when maintenance is entered: "abort" cancels them, "drain" lets them
+// finish while new scans are refused, "pause" holds their work until
+// maintenance is left.
+type AdminConfig struct {
+	Token             string
+	MaintenanceAction string
+}
+
+// Budget of shadow runs, which try a candidate resolver pool or source set
+// next to a scan: how many may run at once, their DNS concurrency and how
+// long they may take
+type ShadowConfig struct {
+	MaxRuns        int
+	DNSConcurrency int
+	Timeout        time.Duration
+}
+
+// How long finished jobs are kept, and how many jobs at most. Running jobs
+// are never evicted. Zero disables either limit.
+type JobConfig struct {
+	Retention time.Duration
+	MaxJobs   int
+
+	// How long an enumerate/stream job outlives its client, for the
+	// EventSource to reconnect with Last-Event-ID and resume it
+	ResumeWait time.Duration
+
+	// How often jobs.db is compacted (0 never), and how much its jobs may
+	// take before the earliest finished are evicted (0 for no limit)
+	CompactInterval time.Duration
+	StoreMaxBytes   int64
+}
+
+// Cloud provider range feeds. Until a feed has been fetched, and whenever
+// fetching it fails, the embedded snapshot is used. A zero RefreshInterval
+// never fetches; an empty URL skips that provider.
+type CloudConfig struct {
+	RefreshInterval time.Duration
+	AWSURL          string
+	GCPURL          string
+	AzureURL        string // ServiceTags_Public JSON; Microsoft has no stable URL
+	CloudflareURL   string
+}
+
+// Message catalogs for user-facing web text. Dir may hold extra or
+// overriding <lang>.json catalogs next to the built-in ones.
+type LocaleConfig struct {
+	Default string
+	Dir     string
 }
 
 // Enhanced statistics and metrics
 type Statistics struct {
-	TotalRequests     int64
-	ActiveJobs        int64
-	CompletedJobs     int64
-	FailedJobs        int64
-	TotalSubdomains   int64
-	TotalProbes       int64
-	SuccessfulProbes  int64
-	DNSQueries        int64
-	StartTime         time.Time
-	LastActivity      time.Time
-	SourceStats       map[string]*SourceStats
-	mu                sync.RWMutex
+	TotalRequests    int64
+	ActiveJobs       int64
+	CompletedJobs    int64
+	FailedJobs       int64
+	TotalSubdomains  int64
+	TotalProbes      int64
+	SuccessfulProbes int64
+	DNSQueries       int64
+	DNSRetries       int64
+	DNSTCPFallbacks  int64
+	DNSTimeouts      int64
+	DNSRcodes        [16]int64 // responses by rcode
+	DNSCacheHits     int64
+	DNSQPSWaiting    int64 // queries waiting for a DNS_MAX_QPS token
+	DNSQPSDelayed    int64
+	DNSCacheMisses   int64
+	ProbeCacheHits   int64
+	ProbeCacheMisses int64
+	DanglingCNAMEs   int64
+	UnboundIPs       int64
+	ExpiredJobs      int64 // evicted after JOB_RETENTION
+	OverLimitJobs    int64 // evicted to stay within MAX_JOBS
+	OversizeJobs     int64 // evicted to keep the job store within JOB_STORE_MAX_BYTES
+	DeletedJobs      int64 // deleted through the API
+	StartTime        time.Time
+	LastActivity     time.Time
+	SourceStats      map[string]*SourceStats
+	Retries          map[string]*int64 // by retry policy subsystem
+	mu               sync.RWMutex
 }
 
 type SourceStats struct {
-	Requests   int64
-	Responses  int64
-	Errors     int64
-	Duration   time.Duration
-	LastUsed   time.Time
+	Requests  int64
+	Responses int64
+	Errors    int64
+	Duration  time.Duration
+	LastUsed  time.Time
+
+	// Upstream anomalies
+	UnrequestedEncoding int64
+	TruncatedResponses  int64
+
+	// Resolver flakiness seen by the source's lookups
+	DNSRetries  int64
+	DNSFailures int64
+}
+
+// retryCounter returns the retry count of subsystem, creating it on
+// first use
+func retryCounter(subsystem string) *int64 {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	counter, ok := stats.Retries[subsystem]
+	if !ok {
+		counter = new(int64)
+		stats.Retries[subsystem] = counter
+	}
+	return counter
+}
+
+// retryCounts returns the retries so far by subsystem
+func retryCounts() map[string]int64 {
+	stats.mu.RLock()
+	defer stats.mu.RUnlock()
+
+	counts := make(map[string]int64, len(stats.Retries))
+	for subsystem, counter := range stats.Retries {
+		counts[subsystem] = atomic.LoadInt64(counter)
+	}
+	return counts
+}
+
+// sourceStats returns the stats entry for source, creating it on first use
+func sourceStats(source string) *SourceStats {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	entry, ok := stats.SourceStats[source]
+	if !ok {
+		entry = &SourceStats{}
+		stats.SourceStats[source] = entry
+	}
+	return entry
 }
 
 // Enhanced job management
@@ -116,10 +476,334 @@ type Job struct {
 	Target    string
 	Sources   []string
 	StartTime time.Time
+	EndTime   time.Time
+	Duration  time.Duration
 	Status    string
 	Results   map[string][]Result
-	Cancel    context.CancelFunc
+	Cancel    context.CancelFunc `json:"-"`
 	mu        sync.RWMutex
+
+	// Time maintenance held the job paused, which Duration leaves out, and
+	// maintenance.pausedFor() when the job started
+	Paused        time.Duration `json:"paused_ns,omitempty"`
+	pausedAtStart time.Duration
+
+	// Releases the job's context when it finishes. Cancel may be wrapped
+	// to stop more than the job (a shadow run), release never is.
+	release context.CancelFunc
+
+	// Whether every Results slice is in canonical order (see sortResults),
+	// and how many times sortResults has reordered them
+	resultsSorted bool
+	sorts         int
+
+	// Distinct hosts across all results; subdomains exclude the apex
+	UniqueHosts      int `json:"unique_hosts"`
+	UniqueSubdomains int `json:"unique_subdomains"`
+	hosts            map[string]struct{}
+
+	// Resolved hosts per cloud provider; "other" is everything outside
+	// the published provider ranges
+	HostsByProvider map[string]int `json:"hosts_by_provider,omitempty"`
+	hostProviders   map[string]string
+
+	// Dangling records found, by status (dangling-cname, unbound-ip)
+	Dangling map[string]int `json:"dangling,omitempty"`
+
+	// Per-source start/end timings
+	SourceTimings map[string]*SourceTiming
+
+	// How far each source has got, see JobProgress
+	Progress *JobProgress `json:"progress,omitempty"`
+
+	// The DNS and probe settings the job ran with, overrides included
+	Settings *JobSettings `json:"settings,omitempty"`
+
+	// Request options the job was started with, and the rerun chain
+	Options    map[string]string
+	ParentID   string
+	BaselineID string
+	Changes    []string
+
+	// Set on the parent of a multi-target scan, which runs nothing itself:
+	// its targets, and the jobs started for them so far
+	Targets  []string `json:"targets,omitempty"`
+	Children []string `json:"children,omitempty"`
+
+	// Who started an enumerate/stream job (see scanOwner), the only client
+	// that may resume its stream
+	Owner string `json:"owner,omitempty"`
+
+	// Who aborted the job and when, for cancelled jobs
+	AbortedBy string    `json:"aborted_by,omitempty"`
+	AbortedAt time.Time `json:"aborted_at,omitzero"`
+
+	// Scanner egress IPs observed at job start and re-checked at completion
+	EgressIPs      []string
+	EgressIPsAtEnd []string
+	EgressChanged  bool
+	egressRecorded chan struct{}
+
+	// Per-candidate brute-force outcomes, only kept for debug=true scans
+	candidates *candidateLog
+
+	// Results waiting for the post-processors, from the first result on
+	processing *processingQueue
+
+	// What a combined scan has streamed so far, for /api/jobs/<id>/stream
+	// and for enumerate/stream clients that resume with Last-Event-ID.
+	// Dropped when the job finishes, keeping just its last event.
+	events    *jobEventLog
+	lastEvent jobEvent
+
+	// enumerate/stream clients connected to the job, and the timer that
+	// cancels it once the last one has been gone for Jobs.ResumeWait
+	streams  int
+	orphaned *time.Timer
+
+	// Resolvers the job's lookups went to. CustomResolvers is set when they
+	// came from resolvers= rather than the global pool, in which case their
+	// health is snapshotted when the job finishes.
+	Resolvers       []string         `json:"resolvers,omitempty"`
+	CustomResolvers bool             `json:"custom_resolvers,omitempty"`
+	ResolverHealth  []ResolverHealth `json:"resolver_health,omitempty"`
+	resolver        *DNSResolver
+
+	// Set when the DNS path answered for names that cannot exist. Brute
+	// force drops answers made only of these addresses, and the job's
+	// results are marked unreliable.
+	Tainted         bool     `json:"tainted,omitempty"`
+	InterceptionIPs []string `json:"interception_ips,omitempty"`
+	interception    sync.Once
+	poison          map[string]bool
+
+	// Whether the server ran passive-only or the job a passive profile,
+	// so a record can show that no traffic reached the target
+	PassiveOnly bool `json:"passive_only"`
+
+	// Registered look-alikes of the target from mode=typo permutations.
+	// Other domains, so kept apart from Results and out of every host count.
+	Lookalikes []Result `json:"lookalikes,omitempty"`
+
+	// Favicon hash of each host probed with favicon=true for this job
+	Favicons map[string]int32 `json:"favicons,omitempty"`
+
+	// Latest status, body SHA-256, security headers and page class of each
+	// host probed for this job
+	ProbeStatuses   map[string]string           `json:"probe_statuses,omitempty"`
+	BodyHashes      map[string]string           `json:"body_hashes,omitempty"`
+	SecurityHeaders map[string]*SecurityHeaders `json:"security_headers,omitempty"`
+	PageClasses     map[string]string           `json:"page_classes,omitempty"`
+
+	// Availability of each host probed with samples= for this job
+	Availability map[string]*AvailabilitySample `json:"availability,omitempty"`
+
+	// What a random name under the target answered, by scheme:port, for
+	// probes with wildcard=true; null where nothing answered. Hosts whose
+	// probe matched are in WildcardHosts.
+	WildcardBaselines map[string]*WildcardBaseline `json:"wildcard_baselines,omitempty"`
+	WildcardHosts     map[string]bool              `json:"wildcard_hosts,omitempty"`
+	baselines         map[string]*probeBaseline
+
+	// Comparison with a shadow run of a candidate configuration. Shadow
+	// runs have jobs of their own that are never registered, indexed or
+	// counted; shadow is set on those.
+	Shadow *ShadowReport `json:"shadow,omitempty"`
+	shadow bool
+}
+
+// SourceTiming is when a source of a job ran. Duration leaves out the
+// time maintenance held it paused, which is Paused.
+type SourceTiming struct {
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Paused   time.Duration `json:"paused_ns,omitempty"`
+	Outcome  string        `json:"outcome,omitempty"`
+
+	pausedAt time.Duration // maintenance.pausedFor() at Start
+}
+
+// newSourceTiming starts timing a source at start, which is now
+func newSourceTiming(start time.Time) *SourceTiming {
+	return &SourceTiming{Start: start, pausedAt: maintenance.pausedFor()}
+}
+
+// stop records the end of the source's run, given maintenance.pausedFor()
+// then
+func (t *SourceTiming) stop(end time.Time, paused time.Duration) {
+	t.End = end
+	t.Paused = paused - t.pausedAt
+	t.Duration = t.End.Sub(t.Start) - t.Paused
+}
+
+// JobProgress tracks how far a job's sources have got. Brute-force and
+// permutation sources know their candidate count up front and count
+// lookups against it; other sources cannot tell how much is left and only
+// count the hosts they report, as indeterminate progress. Methods are safe
+// for concurrent use and on a nil JobProgress.
+type JobProgress struct {
+	mu      sync.Mutex
+	sources map[string]*SourceProgress
+}
+
+// SourceProgress is one source's part of a ProgressReport. Phase is
+// running, resolving, or the source's outcome once it is done.
+type SourceProgress struct {
+	Phase         string  `json:"phase"`
+	Message       string  `json:"message,omitempty"`
+	Total         int64   `json:"total,omitempty"`
+	Completed     int64   `json:"completed"`
+	Percent       float64 `json:"percent,omitempty"`
+	Indeterminate bool    `json:"indeterminate"`
+
+	counted bool // completed counts candidates rather than hosts
+}
+
+// ProgressReport is a snapshot of JobProgress, as the job's "progress" and
+// the SSE progress event. Total and Completed add up the sources that know
+// their total; Indeterminate is set when none does.
+type ProgressReport struct {
+	Total         int64                     `json:"total"`
+	Completed     int64                     `json:"completed"`
+	Percent       float64                   `json:"percent"`
+	Indeterminate bool                      `json:"indeterminate"`
+	Sources       map[string]SourceProgress `json:"sources"`
+}
+
+func newJobProgress(sources []string) *JobProgress {
+	p := &JobProgress{sources: make(map[string]*SourceProgress)}
+	for _, source := range sources {
+		p.Start(source)
+	}
+	return p
+}
+
+// source returns source's entry, adding it if needed; the caller holds p.mu
+func (p *JobProgress) source(source string) *SourceProgress {
+	sp, ok := p.sources[source]
+	if !ok {
+		sp = &SourceProgress{Phase: "running"}
+		p.sources[source] = sp
+	}
+	return sp
+}
+
+func (p *JobProgress) update(source string, fn func(sp *SourceProgress)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(p.source(source))
+}
+
+// Start marks source as running
+func (p *JobProgress) Start(source string) {
+	p.update(source, func(sp *SourceProgress) { sp.Phase = "running" })
+}
+
+func (p *JobProgress) Phase(source, phase string) {
+	p.update(source, func(sp *SourceProgress) { sp.Phase = phase })
+}
+
+// Message records source's latest progress notice
+func (p *JobProgress) Message(source, message string) {
+	p.update(source, func(sp *SourceProgress) { sp.Message = message })
+}
+
+// Expect adds n candidates to source's total
+func (p *JobProgress) Expect(source string, n int) {
+	p.update(source, func(sp *SourceProgress) {
+		sp.Total += int64(n)
+		sp.counted = true
+	})
+}
+
+// Advance counts n of source's candidates as done
+func (p *JobProgress) Advance(source string, n int) {
+	p.update(source, func(sp *SourceProgress) {
+		sp.Completed += int64(n)
+		sp.counted = true
+	})
+}
+
+// Found counts a host source reported, unless it counts candidates
+func (p *JobProgress) Found(source string) {
+	p.update(source, func(sp *SourceProgress) {
+		if !sp.counted {
+			sp.Completed++
+		}
+	})
+}
+
+// Finish sets source's phase to its outcome
+func (p *JobProgress) Finish(source, outcome string) {
+	p.update(source, func(sp *SourceProgress) { sp.Phase = outcome })
+}
+
+// settle marks the sources still running complete, as the job's end does
+// with their timings
+func (p *JobProgress) settle() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sp := range p.sources {
+		if sp.Phase == "running" || sp.Phase == "resolving" {
+			sp.Phase = "complete"
+		}
+	}
+}
+
+func (p *JobProgress) Report() ProgressReport {
+	report := ProgressReport{Sources: make(map[string]SourceProgress)}
+	if p == nil {
+		report.Indeterminate = true
+		return report
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, sp := range p.sources {
+		source := *sp
+		source.Indeterminate = source.Total == 0
+		if !source.Indeterminate {
+			source.Percent = progressPercent(source.Completed, source.Total)
+			report.Total += source.Total
+			report.Completed += source.Completed
+		}
+		report.Sources[name] = source
+	}
+	report.Indeterminate = report.Total == 0
+	report.Percent = progressPercent(report.Completed, report.Total)
+	return report
+}
+
+// progressPercent is completed out of total in percent, to one decimal
+func progressPercent(completed, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(min(completed, total))*1000/float64(total)) / 10
+}
+
+func (p *JobProgress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Report())
+}
+
+// UnmarshalJSON restores a stored job's progress from its report
+func (p *JobProgress) UnmarshalJSON(data []byte) error {
+	var report ProgressReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return err
+	}
+	p.sources = make(map[string]*SourceProgress, len(report.Sources))
+	for name, source := range report.Sources {
+		source.counted = source.Total > 0
+		p.sources[name] = &source
+	}
+	return nil
 }
 
 type JobManager struct {
@@ -137,14 +821,176 @@ type Result struct {
 	Error     string    `json:"error,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 	ProbeTime int64     `json:"probe_time_ms,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Severity  string    `json:"severity,omitempty"`
+
+	// Unicode form of an internationalized Host, which stays punycode
+	Display string `json:"display,omitempty"`
+
+	// For feedback results, the found name the host was derived from and
+	// the source that found it
+	Seed       string `json:"seed,omitempty"`
+	SeedSource string `json:"seed_source,omitempty"`
+
+	// Addresses the host resolved to, for sources that resolve it
+	IPs []ResultIP `json:"ips,omitempty"`
+
+	// Canonical name at the end of the host's CNAME chain, and the chain
+	CNAME      string   `json:"cname,omitempty"`
+	CNAMEChain []string `json:"cname_chain,omitempty"`
+
+	// Response code of a lookup that failed, on "dns-error" results
+	Rcode string `json:"rcode,omitempty"`
+
+	// When the upstream last saw the evidence, as opposed to Timestamp
+	// which is when we observed it. Zero when the source gives no date.
+	EvidenceTime time.Time `json:"evidence_time,omitzero"`
+	EvidenceAge  string    `json:"evidence_age,omitempty"`
+
+	// Cloud provider and region of the first resolved address that falls
+	// in a published provider range
+	CloudProvider string `json:"cloud_provider,omitempty"`
+	CloudRegion   string `json:"cloud_region,omitempty"`
+
+	// Page class of the host from the job's probes and whether it only
+	// answered like the target's catch-all, in result listings, and its
+	// availability samples there and in exports
+	PageClass        string              `json:"page_class,omitempty"`
+	WildcardResponse bool                `json:"wildcard_response,omitempty"`
+	Availability     *AvailabilitySample `json:"availability,omitempty"`
+}
+
+// ResultIP is one resolved address of a result, its family (ipv4/ipv6)
+// and the cloud range it falls in, if any
+type ResultIP struct {
+	Address  string `json:"address"`
+	Family   string `json:"family"`
+	Provider string `json:"provider,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Service  string `json:"service,omitempty"`
+}
+
+// resultIPs labels resolved addresses with their family and cloud range
+func resultIPs(ips []net.IP) []ResultIP {
+	labeled := make([]ResultIP, len(ips))
+	for i, ip := range ips {
+		labeled[i] = ResultIP{Address: ip.String(), Family: ipFamily(ip)}
+		if r, ok := classifyCloudIP(ip); ok {
+			labeled[i].Provider, labeled[i].Region, labeled[i].Service = r.Provider, r.Region, r.Service
+		}
+	}
+	return labeled
+}
+
+// ipFamily names the address family of ip, ipv4 or ipv6
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// setCloudProvider fills in the result's provider from its addresses
+func (r *Result) setCloudProvider() {
+	if r.CloudProvider != "" {
+		return
+	}
+	for _, ip := range r.IPs {
+		if ip.Provider != "" {
+			r.CloudProvider, r.CloudRegion = ip.Provider, ip.Region
+			return
+		}
+	}
+}
+
+// evidenceAge buckets how old evidence was when it was observed: current
+// (within 90 days), <1y, 1-3y or stale
+func evidenceAge(evidence, observed time.Time) string {
+	if evidence.IsZero() {
+		return ""
+	}
+	age := observed.Sub(evidence)
+	switch {
+	case age < 90*24*time.Hour:
+		return "current"
+	case age < 365*24*time.Hour:
+		return "<1y"
+	case age < 3*365*24*time.Hour:
+		return "1-3y"
+	}
+	return "stale"
 }
 
 // Enhanced DNS resolver with connection pooling
 type DNSResolver struct {
-	servers []string
-	clients []*dns.Client
-	current int64
-	mu      sync.RWMutex
+	servers    []string
+	transports []string // udp, doh for https:// or dot for tls:// servers
+	addresses  []string // host:port dialled for udp and dot servers
+	clients    []*dns.Client
+	tcpClients []*dns.Client // created on a server's first truncated answer
+	dohClient  *http.Client
+	timeout    time.Duration
+	cache      *dnsCache // nil when DNS_CACHE_SIZE is 0
+	health     []*resolverHealth
+	current    int64
+	mu         sync.RWMutex
+
+	base *DNSResolver // what withTimeout derived this one from
+}
+
+// resolverHealth counts queries and failures against one server, and keeps
+// the outcomes of its latest queries for server selection
+type resolverHealth struct {
+	queries     int64
+	failures    int64
+	mu          sync.Mutex
+	lastError   string
+	lastErrorAt time.Time
+
+	window  [resolverWindow]resolverSample
+	samples int // filled entries of window
+	next    int
+	streak  int // consecutive failures
+	// Derived from window on every sample
+	successRate float64
+	latencyP50  time.Duration
+	unhealthy   bool
+	probeAt     time.Time // while unhealthy, when it may get a query again
+}
+
+type resolverSample struct {
+	latency time.Duration
+	ok      bool
+}
+
+const (
+	// Outcomes kept per server
+	resolverWindow = 64
+	// Outcomes needed before a server can be judged unhealthy by its
+	// success rate, and failures in a row that make it unhealthy anyway
+	resolverMinSamples = 8
+	resolverMaxStreak  = 3
+	// Success rate below which a server is unhealthy
+	resolverMinSuccessRate = 0.5
+	// How often an unhealthy server gets a query to see if it recovered
+	resolverProbeInterval = 5 * time.Second
+	// Latency assumed for servers with no successful query yet
+	resolverAssumedLatency = 20 * time.Millisecond
+	// Servers weighed per query in pools too large to weigh them all
+	resolverPickSample = 8
+)
+
+// ResolverHealth is a snapshot of one server's resolverHealth
+type ResolverHealth struct {
+	Server       string    `json:"server"`
+	Transport    string    `json:"transport"`
+	State        string    `json:"state"` // healthy or unhealthy
+	Queries      int64     `json:"queries"`
+	Failures     int64     `json:"failures"`
+	SuccessRate  float64   `json:"success_rate"`   // over the latest queries
+	LatencyP50MS float64   `json:"latency_p50_ms"` // of the latest successful queries
+	LastError    string    `json:"last_error,omitempty"`
+	LastErrorAt  time.Time `json:"last_error_at,omitzero"`
 }
 
 // Rate limiter implementation
@@ -156,17 +1002,17 @@ type RateLimiter struct {
 
 var (
 	// Enhanced regex patterns
-	hostRe     = regexp.MustCompile(`https?://([^/\s"'<>]+)`)
-	titleRe    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
-	domainRe   = regexp.MustCompile(`^([a-zA-Z0-9-]+\.)*[a-zA-Z0-9-]+\.[a-zA-Z]{2,}$`)
-	
+	hostRe   = regexp.MustCompile(`https?://([^/\s"'<>]+)`)
+	titleRe  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	domainRe = regexp.MustCompile(`^([a-zA-Z0-9-]+\.)*[a-zA-Z0-9-]+\.([a-zA-Z]{2,}|[xX][nN]--[a-zA-Z0-9-]+)$`)
+	wordRe   = regexp.MustCompile(`^[a-z0-9_-]{1,63}(\.[a-z0-9_-]{1,63})*$`)
+
 	// Global instances
-	config       *Config
-	stats        *Statistics
-	jobManager   *JobManager
-	dnsResolver  *DNSResolver
-	rateLimiter  *RateLimiter
-	
+	stats       *Statistics
+	jobManager  *JobManager
+	rateLimiter *RateLimiter
+	processors  *ProcessorPipeline
+
 	// Enhanced wordlist with categorization
 	commonSubdomains = map[string][]string{
 		"common": {
@@ -207,38 +1053,182 @@ var (
 	}
 )
 
-func init() {
-	config = loadConfig()
+// The active configuration. Handlers take one snapshot per request (see
+// withMiddleware) so a reload mid-scan doesn't change behavior halfway through.
+var configSnapshot atomic.Pointer[Config]
+
+func currentConfig() *Config {
+	return configSnapshot.Load()
+}
+
+func setConfig(cfg *Config) {
+	configSnapshot.Store(cfg)
+}
+
+type configContextKey struct{}
+
+// withConfig attaches a config snapshot to ctx
+func withConfig(ctx context.Context, cfg *Config) context.Context {
+	return context.WithValue(ctx, configContextKey{}, cfg)
+}
+
+// configFrom returns the snapshot attached to ctx, or the current config for
+// work not started from a request
+func configFrom(ctx context.Context) *Config {
+	if cfg, ok := ctx.Value(configContextKey{}).(*Config); ok {
+		return cfg
+	}
+	return currentConfig()
+}
+
+// reloadConfig swaps in a freshly loaded configuration. Requests already in
+// flight keep the snapshot they started with.
+func reloadConfig() {
+	if err := applyConfigFile(); err != nil {
+		log.Printf("Config reload failed: %v", err)
+		return
+	}
+	previous := currentConfig()
+	next := loadConfig()
+	setConfig(next)
+	reloadDNSResolver(next)
+	reloadProbeClient(next)
+
+	if next.Port != previous.Port {
+		log.Printf("Warning: PORT changed to %s; the main listener keeps port %s until restart", next.Port, previous.Port)
+	}
+	if passiveBuild != "true" && getEnvBool("PASSIVE_ONLY", false) != passiveOnly {
+		log.Printf("Warning: PASSIVE_ONLY changed; passive-only mode stays %v until restart", passiveOnly)
+	}
+	rebindMetricsServer(previous)
+	log.Printf("Configuration reloaded")
+}
+
+// applyConfigFile exports the KEY=VALUE lines of CONFIG_FILE, if set, into
+// the environment so loadConfig picks them up. Values in the file take
+// precedence over the process environment.
+func applyConfigFile() error {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "export "))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, n+1)
+		}
+		os.Setenv(strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"'`))
+	}
+	return nil
+}
+
+func watchReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		reloadConfig()
+	}
+}
+
+// initialize loads the configuration and builds the global components from it
+func initialize() {
+	setConfig(loadConfig())
+	initializePassiveMode()
 	stats = &Statistics{
 		StartTime:   time.Now(),
 		SourceStats: make(map[string]*SourceStats),
+		Retries:     make(map[string]*int64),
 	}
 	jobManager = &JobManager{
 		jobs: make(map[string]*Job),
 	}
 	initializeDNSResolver()
+	initializeProbeClient()
+	initializeProbeCache()
 	initializeRateLimiter()
+	initializeProcessors()
+	initializeMaintenance()
+	initializeJobStore()
+	initializeJobRetention()
+	initializeCatalogs()
+	initializeWordlists()
+	initializeCloudRanges()
+	initializeTakeoverFingerprints()
+	initializeTechFingerprints()
+	initializePageSignatures()
+	initializeSigning()
 	setupLogging()
 }
 
 func loadConfig() *Config {
-	return &Config{
+	cfg := &Config{
 		Port:     getEnvString("PORT", "8080"),
 		LogLevel: getEnvString("LOG_LEVEL", "INFO"),
+		DataDir:  getEnvString("DATA_DIR", ""),
 		Timeouts: TimeoutConfig{
 			Wayback:   getEnvDuration("TIMEOUT_WAYBACK", 5*time.Minute),
 			CrtSh:     getEnvDuration("TIMEOUT_CRTSH", 5*time.Minute),
 			DNS:       getEnvDuration("TIMEOUT_DNS", 10*time.Minute),
 			Search:    getEnvDuration("TIMEOUT_SEARCH", 5*time.Minute),
 			Permute:   getEnvDuration("TIMEOUT_PERMUTE", 10*time.Minute),
+			Feedback:  getEnvDuration("TIMEOUT_FEEDBACK", 15*time.Minute),
 			Zone:      getEnvDuration("TIMEOUT_ZONE", 2*time.Minute),
+			LeakIX:    getEnvDuration("TIMEOUT_LEAKIX", 2*time.Minute),
+			SPF:       getEnvDuration("TIMEOUT_SPF", 2*time.Minute),
+			Records:   getEnvDuration("TIMEOUT_RECORDS", 2*time.Minute),
+			PTR:       getEnvDuration("TIMEOUT_PTR", 10*time.Minute),
+			ASN:       getEnvDuration("TIMEOUT_ASN", 15*time.Minute),
+			JSScrape:  getEnvDuration("TIMEOUT_JSSCRAPE", 5*time.Minute),
+			SRV:       getEnvDuration("TIMEOUT_SRV", 2*time.Minute),
+			Takeover:  getEnvDuration("TIMEOUT_TAKEOVER", 5*time.Minute),
 			HTTPProbe: getEnvDuration("HTTP_PROBE_TIMEOUT", 10*time.Second),
 		},
 		DNS: DNSConfig{
 			Servers:     getEnvStringSlice("DNS_SERVERS", []string{"8.8.8.8:53", "1.1.1.1:53", "208.67.222.222:53"}),
+			ServersFile: getEnvString("DNS_SERVERS_FILE", ""),
 			Concurrency: getEnvInt("DNS_CONCURRENCY", 50),
 			Retries:     getEnvInt("DNS_RETRIES", 2),
 			Timeout:     getEnvDuration("DNS_TIMEOUT", 3*time.Second),
+			QueryTypes:  strings.ToLower(getEnvString("DNS_QUERY_TYPES", "both")),
+			TLSTimeout:  getEnvDuration("DNS_TLS_TIMEOUT", 5*time.Second),
+
+			CNAMEMaxDepth: getEnvInt("CNAME_MAX_DEPTH", 8),
+			MaxBruteDepth: getEnvInt("DNS_MAX_DEPTH", 3),
+
+			PermuteMaxCandidates:  getEnvInt("PERMUTE_MAX_CANDIDATES", 50000),
+			FeedbackMaxCandidates: getEnvInt("FEEDBACK_MAX_CANDIDATES", 10000),
+
+			UnboundIPCheck:   getEnvBool("UNBOUND_IP_CHECK", true),
+			UnboundIPTimeout: getEnvDuration("UNBOUND_IP_TIMEOUT", 3*time.Second),
+
+			PTRPrefixLength: getEnvInt("PTR_SWEEP_PREFIX", 24),
+			PTRMaxIPs:       getEnvInt("PTR_MAX_IPS", 4096),
+			ASNMaxQueries:   getEnvInt("ASN_MAX_PTR_QUERIES", 16384),
+
+			AXFRPort: getEnvString("AXFR_PORT", "53"),
+
+			CandidateLogMaxEntries: getEnvInt("CANDIDATE_LOG_MAX_ENTRIES", 100000),
+			CandidateLogMaxBytes:   getEnvInt64("CANDIDATE_LOG_MAX_BYTES", 4*1024*1024), // 4MB compressed
+
+			Wordlists:      getEnvStringSlice("DNS_WORDLISTS", nil),
+			UploadMaxWords: getEnvInt("WORDLIST_UPLOAD_MAX_WORDS", 500000),
+			WordlistFile:   getEnvString("DNS_WORDLIST_FILE", ""),
+			WordlistMode:   strings.ToLower(getEnvString("DNS_WORDLIST_MODE", "extend")),
+
+			CacheSize:        getEnvInt("DNS_CACHE_SIZE", 100000),
+			CacheMaxTTL:      getEnvDuration("DNS_CACHE_MAX_TTL", 10*time.Minute),
+			CacheNegativeTTL: getEnvDuration("DNS_CACHE_NEGATIVE_TTL", time.Minute),
+
+			MaxQPS:          getEnvInt("DNS_MAX_QPS", 0),
+			MaxQPSPerServer: getEnvInt("DNS_MAX_QPS_PER_SERVER", 0),
 		},
 		HTTP: HTTPConfig{
 			UserAgent:     getEnvString("HTTP_USER_AGENT", "Mozilla/5.0 (compatible; SubdomainScanner/2.0; +https://github.com/security/subdomain-enum)"),
@@ -246,6 +1236,32 @@ func loadConfig() *Config {
 			Timeout:       getEnvDuration("HTTP_TIMEOUT", 10*time.Second),
 			MaxBodySize:   getEnvInt64("HTTP_MAX_BODY_SIZE", 1024*1024), // 1MB
 			SkipTLSVerify: getEnvBool("HTTP_SKIP_TLS_VERIFY", true),
+			ProxyURL:      getEnvString("HTTP_PROXY_URL", ""),
+			NoProxy:       getEnvStringSlice("HTTP_NO_PROXY", getEnvStringSlice("NO_PROXY", nil)),
+			ExtraHeaders:  parseExtraHeaders(getEnvString("HTTP_EXTRA_HEADERS", "")),
+
+			MaxSamples:     getEnvInt("HTTP_PROBE_MAX_SAMPLES", 5),
+			SampleInterval: getEnvDuration("HTTP_PROBE_SAMPLE_INTERVAL", 2*time.Second),
+			SampleBudget:   getEnvDuration("HTTP_PROBE_SAMPLE_BUDGET", 30*time.Second),
+
+			JSMaxFiles: getEnvInt("JS_MAX_FILES", 200),
+			JSMaxBytes: getEnvInt64("JS_MAX_BYTES", 20*1024*1024), // 20MB
+
+			DiscoveryConcurrency: getEnvInt("HTTP_DISCOVERY_CONCURRENCY", 32),
+			ProbeConcurrency:     getEnvInt("HTTP_PROBE_CONCURRENCY", 64),
+			ProbePerHost:         getEnvInt("HTTP_PROBE_PER_HOST", 2),
+			ProbeHostDelay:       getEnvDuration("HTTP_PROBE_HOST_DELAY", 100*time.Millisecond),
+			ProbeMaxTargets:      getEnvInt("HTTP_PROBE_MAX_TARGETS", 5000),
+			ProbeSkipDead:        getEnvBool("HTTP_PROBE_SKIP_DEAD", true),
+			ProbeCacheTTL:        getEnvDuration("PROBE_CACHE_TTL", time.Hour),
+			ProbeCacheSize:       getEnvInt("PROBE_CACHE_SIZE", 10000),
+			ProbeCachePersist:    getEnvBool("PROBE_CACHE_PERSIST", false),
+			ProbeRetries:         getEnvInt("HTTP_PROBE_RETRIES", 1),
+			ProbeRetryBackoff:    getEnvDuration("HTTP_PROBE_RETRY_BACKOFF", 250*time.Millisecond),
+			ProbeRetry5xx:        getEnvBool("HTTP_PROBE_RETRY_5XX", false),
+			ProbeViaResolvers:    getEnvBool("HTTP_PROBE_VIA_RESOLVERS", false),
+			ProbeHTTPPort:        getEnvInt("HTTP_PROBE_HTTP_PORT", 80),
+			ProbeHTTPSPort:       getEnvInt("HTTP_PROBE_HTTPS_PORT", 443),
 		},
 		RateLimit: RateLimitConfig{
 			RequestsPerSecond: getEnvInt("RATE_LIMIT_RPS", 10),
@@ -257,49 +1273,324 @@ func loadConfig() *Config {
 			BlockedUserAgents: getEnvStringSlice("BLOCKED_USER_AGENTS", []string{"bot", "crawler", "spider"}),
 			MaxConcurrentJobs: getEnvInt("MAX_CONCURRENT_JOBS", 10),
 			EnableCORS:        getEnvBool("ENABLE_CORS", true),
+
+			SigningKeyPath: getEnvString("SIGNING_KEY_PATH", ""),
+
+			TargetLocks:   getEnvBool("TARGET_LOCKS", false),
+			TargetLockTTL: getEnvDuration("TARGET_LOCK_TTL", 2*time.Hour),
+
+			ClientKeys: parseClientKeys(getEnvStringSlice("API_KEYS", nil)),
+
+			MaxJobDNSConcurrency: getEnvInt("MAX_JOB_DNS_CONCURRENCY", 200),
+			MaxJobDNSTimeout:     getEnvDuration("MAX_JOB_DNS_TIMEOUT", 30*time.Second),
+			MaxJobProbeTimeout:   getEnvDuration("MAX_JOB_PROBE_TIMEOUT", time.Minute),
+			MaxJobDNSQPS:         getEnvInt("MAX_JOB_DNS_QPS", 1000),
 		},
 		Monitoring: MonitoringConfig{
 			EnableMetrics: getEnvBool("ENABLE_METRICS", true),
 			EnableHealth:  getEnvBool("ENABLE_HEALTH", true),
 			MetricsPort:   getEnvString("METRICS_PORT", "9090"),
+			BindAttempts:  getEnvInt("METRICS_BIND_ATTEMPTS", 5),
 		},
-	}
-}
+		APIKeys: APIKeyConfig{
+			LeakIX: getEnvString("LEAKIX_API_KEY", ""),
+		},
+		Upstreams: UpstreamConfig{
+			Wayback:  getEnvString("WAYBACK_BASE_URL", "https://web.archive.org"),
+			CrtSh:    getEnvString("CRTSH_BASE_URL", "https://crt.sh"),
+			Search:   getEnvString("SEARCH_BASE_URL", "https://www.google.com"),
+			LeakIX:   getEnvString("LEAKIX_BASE_URL", "https://leakix.net"),
+			RIPEstat: getEnvString("RIPESTAT_BASE_URL", "https://stat.ripe.net"),
+		},
+		Processing: ProcessingConfig{
+			RulesFile:      getEnvString("POSTPROCESS_RULES_FILE", ""),
+			DefaultTimeout: getEnvDuration("POSTPROCESS_TIMEOUT", 2*time.Second),
 
-func setupLogging() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	if config.LogLevel == "DEBUG" {
+			TakeoverFingerprints: getEnvString("TAKEOVER_FINGERPRINTS", ""),
+		},
+		Egress: EgressConfig{
+			EchoEndpoints: getEnvStringSlice("EGRESS_ECHO_ENDPOINTS", []string{"https://api.ipify.org", "https://icanhazip.com", "stun:stun.l.google.com:19302"}),
+			CacheTTL:      getEnvDuration("EGRESS_CACHE_TTL", 5*time.Minute),
+			Timeout:       getEnvDuration("EGRESS_TIMEOUT", 5*time.Second),
+		},
+		Locale: LocaleConfig{
+			Default: getEnvString("DEFAULT_LOCALE", "en"),
+			Dir:     getEnvString("LOCALES_DIR", ""),
+		},
+		Cloud: CloudConfig{
+			RefreshInterval: getEnvDuration("CLOUD_RANGES_REFRESH", 0),
+			AWSURL:          getEnvString("CLOUD_RANGES_AWS_URL", "https://ip-ranges.amazonaws.com/ip-ranges.json"),
+			GCPURL:          getEnvString("CLOUD_RANGES_GCP_URL", "https://www.gstatic.com/ipranges/cloud.json"),
+			AzureURL:        getEnvString("CLOUD_RANGES_AZURE_URL", ""),
+			CloudflareURL:   getEnvString("CLOUD_RANGES_CLOUDFLARE_URL", "https://api.cloudflare.com/client/v4/ips"),
+		},
+		Admin: AdminConfig{
+			Token:             getEnvString("ADMIN_TOKEN", ""),
+			MaintenanceAction: getEnvString("MAINTENANCE_ACTION", "abort"),
+		},
+		Shadow: ShadowConfig{
+			MaxRuns:        getEnvInt("SHADOW_MAX_RUNS", 2),
+			DNSConcurrency: getEnvInt("SHADOW_DNS_CONCURRENCY", 10),
+			Timeout:        getEnvDuration("SHADOW_TIMEOUT", 15*time.Minute),
+		},
+		Jobs: JobConfig{
+			Retention: getEnvDuration("JOB_RETENTION", 24*time.Hour),
+			MaxJobs:   getEnvInt("MAX_JOBS", 1000),
+
+			ResumeWait: getEnvDuration("STREAM_RESUME_WAIT", 30*time.Second),
+
+			CompactInterval: getEnvDuration("JOB_STORE_COMPACT_INTERVAL", 24*time.Hour),
+			StoreMaxBytes:   getEnvInt64("JOB_STORE_MAX_BYTES", 0),
+		},
+	}
+
+	if cfg.DNS.ServersFile != "" {
+		servers, err := loadResolverFile(cfg.DNS.ServersFile)
+		if err != nil {
+			log.Printf("Warning: DNS_SERVERS_FILE: %v; using DNS_SERVERS", err)
+		} else {
+			cfg.DNS.Servers = servers
+		}
+	}
+	return cfg
+}
+
+func setupLogging() {
+	cfg := currentConfig()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if cfg.LogLevel == "DEBUG" {
 		log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
 	}
 }
 
+// The resolver for scans that bring none of their own. Reloads swap it;
+// queries already in flight finish on the one they started with.
+var sharedResolver atomic.Pointer[DNSResolver]
+
+func defaultResolver() *DNSResolver {
+	return sharedResolver.Load()
+}
+
 func initializeDNSResolver() {
-	dnsResolver = &DNSResolver{
-		servers: config.DNS.Servers,
-		clients: make([]*dns.Client, len(config.DNS.Servers)),
-	}
-	
-	for i := range dnsResolver.clients {
-		dnsResolver.clients[i] = &dns.Client{
-			Timeout: config.DNS.Timeout,
-			Net:     "udp",
+	cfg := currentConfig()
+	sharedResolver.Store(newDNSResolver(cfg.DNS.Servers, cfg.DNS.Timeout))
+	if !passiveOnly {
+		go defaultResolver().checkTLSServers()
+	}
+}
+
+// reloadDNSResolver swaps in a resolver for cfg's servers if they or the
+// timeout changed. Servers kept from the previous set keep their health
+// history, and the answer cache carries over.
+func reloadDNSResolver(cfg *Config) {
+	previous := defaultResolver()
+	if slices.Equal(previous.servers, cfg.DNS.Servers) && previous.timeout == cfg.DNS.Timeout {
+		return
+	}
+	next := newDNSResolver(cfg.DNS.Servers, cfg.DNS.Timeout)
+	kept := make(map[string]*resolverHealth, len(previous.servers))
+	for i, server := range previous.servers {
+		kept[server] = previous.health[i]
+	}
+	retained := 0
+	for i, server := range next.servers {
+		if health, ok := kept[server]; ok {
+			next.health[i] = health
+			retained++
+		}
+	}
+	if next.cache != nil && previous.cache != nil {
+		next.cache = previous.cache
+	}
+	sharedResolver.Store(next)
+	log.Printf("DNS servers reloaded: %d servers (%d kept, %d added, %d removed)",
+		len(next.servers), retained, len(next.servers)-retained, len(previous.servers)-retained)
+	if !passiveOnly {
+		go next.checkTLSServers()
+	}
+}
+
+// loadResolverFile reads DNS_SERVERS_FILE: one IP[:port] server per line,
+// with blank lines and # comments skipped. Invalid lines are logged and
+// skipped, as large public resolver lists tend to have a few.
+func loadResolverFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	seen := make(map[string]bool)
+	invalid := 0
+	for n, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		server, err := parseResolverAddress(line)
+		if err != nil {
+			if invalid++; invalid <= 10 {
+				log.Printf("Warning: %s:%d: %v", path, n+1, err)
+			}
+			continue
+		}
+		if !seen[server] {
+			seen[server] = true
+			servers = append(servers, server)
+		}
+	}
+	if invalid > 10 {
+		log.Printf("Warning: %s: %d invalid lines skipped in total", path, invalid)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s lists no valid servers", path)
+	}
+	return servers, nil
+}
+
+// newDNSResolver builds a resolver rotating over servers. Each resolver
+// tracks the health of its own servers.
+func newDNSResolver(servers []string, timeout time.Duration) *DNSResolver {
+	dr := &DNSResolver{
+		servers:    servers,
+		transports: make([]string, len(servers)),
+		addresses:  make([]string, len(servers)),
+		clients:    make([]*dns.Client, len(servers)),
+		tcpClients: make([]*dns.Client, len(servers)),
+		timeout:    timeout,
+		health:     make([]*resolverHealth, len(servers)),
+	}
+	if cfg := currentConfig().DNS; cfg.CacheSize > 0 {
+		dr.cache = newDNSCache(cfg.CacheSize, cfg.CacheMaxTTL, cfg.CacheNegativeTTL)
+	}
+	for i := range dr.servers {
+		dr.transports[i] = resolverTransport(servers[i])
+		dr.addresses[i] = servers[i]
+		if dr.transports[i] == "dot" {
+			dr.addresses[i], _ = dotEndpoint(servers[i])
+		}
+		dr.health[i] = &resolverHealth{}
+	}
+	if containsString(dr.transports, "doh") {
+		// Brute force keeps many queries in flight; let them share a
+		// few long-lived connections instead of the discovery pool
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = currentConfig().DNS.Concurrency
+		dr.dohClient = &http.Client{Timeout: timeout, Transport: transport}
+	}
+	return dr
+}
+
+// restrictDoHDials makes dr's DoH connections refuse the addresses
+// dohHostAllowed refuses by name, which a name in resolvers= may still
+// resolve to
+func (dr *DNSResolver) restrictDoHDials() {
+	if dr.dohClient == nil {
+		return
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, _ := net.SplitHostPort(address)
+			if ip := net.ParseIP(host); ip == nil || blockedDoHAddress(ip) {
+				return fmt.Errorf("DoH server address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	dr.dohClient.Transport.(*http.Transport).DialContext = dialer.DialContext
+}
+
+// withTimeout returns dr waiting timeout for each answer instead: dr itself
+// when that is its timeout already, otherwise a resolver over the same
+// servers sharing their health and the answer cache
+func (dr *DNSResolver) withTimeout(timeout time.Duration) *DNSResolver {
+	if dr == nil || dr.timeout == timeout {
+		return dr
+	}
+	scoped := newDNSResolver(dr.servers, timeout)
+	scoped.base = dr
+	scoped.health = dr.health
+	scoped.cache = dr.cache
+	if dr.dohClient != nil {
+		scoped.dohClient = &http.Client{Timeout: timeout, Transport: dr.dohClient.Transport}
+	}
+	return scoped
+}
+
+// resolverTransport returns how queries reach server: "doh" for
+// https:// URLs (RFC 8484), "dot" for tls:// ones (RFC 7858), "udp"
+// otherwise
+func resolverTransport(server string) string {
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		return "doh"
+	case strings.HasPrefix(server, "tls://"):
+		return "dot"
+	}
+	return "udp"
+}
+
+// dnsServerTransports maps each server to its transport
+func dnsServerTransports(servers []string) map[string]string {
+	transports := make(map[string]string, len(servers))
+	for _, server := range servers {
+		transports[server] = resolverTransport(server)
+	}
+	return transports
+}
+
+// dotEndpoint splits a tls://host[:port][#name] server into the address
+// to dial, defaulting the port to 853, and the name the certificate is
+// checked against: #name if given, otherwise host. The fragment lets an
+// IP address be dialled while verifying the provider's hostname.
+func dotEndpoint(server string) (address, serverName string) {
+	rest := strings.TrimPrefix(server, "tls://")
+	rest, serverName, _ = strings.Cut(rest, "#")
+	host, port, err := net.SplitHostPort(rest)
+	if err != nil {
+		host, port = strings.Trim(rest, "[]"), "853"
+	}
+	if serverName == "" {
+		serverName = host
+	}
+	return net.JoinHostPort(host, port), serverName
+}
+
+// checkTLSServers asks every DNS-over-TLS server for the root NS and logs
+// the ones that cannot be reached. It only warns: the server may come up
+// later, and queries rotate past it meanwhile. Callers don't start it in
+// passive-only mode.
+func (dr *DNSResolver) checkTLSServers() {
+	for i, transport := range dr.transports {
+		if transport != "dot" {
+			continue
+		}
+		msg := &dns.Msg{}
+		msg.SetQuestion(".", dns.TypeNS)
+		ctx, cancel := context.WithTimeout(context.Background(), currentConfig().DNS.TLSTimeout+dr.timeout)
+		_, _, err := dr.client(i).ExchangeContext(ctx, msg, dr.addresses[i])
+		cancel()
+		if err != nil {
+			log.Printf("Warning: DNS-over-TLS server %s is unreachable: %v", dr.servers[i], err)
 		}
 	}
 }
 
 func initializeRateLimiter() {
+	cfg := currentConfig()
 	rateLimiter = &RateLimiter{
-		tokens:   make(chan struct{}, config.RateLimit.BurstSize),
-		capacity: config.RateLimit.BurstSize,
+		tokens:   make(chan struct{}, cfg.RateLimit.BurstSize),
+		capacity: cfg.RateLimit.BurstSize,
 	}
-	
+
 	// Fill initial tokens
-	for i := 0; i < config.RateLimit.BurstSize; i++ {
+	for i := 0; i < cfg.RateLimit.BurstSize; i++ {
 		rateLimiter.tokens <- struct{}{}
 	}
-	
+
 	// Start refill goroutine
-	rateLimiter.refill = time.NewTicker(time.Second / time.Duration(config.RateLimit.RequestsPerSecond))
+	rateLimiter.refill = time.NewTicker(time.Second / time.Duration(cfg.RateLimit.RequestsPerSecond))
 	go func() {
 		for range rateLimiter.refill.C {
 			select {
@@ -319,6 +1610,20 @@ func main() {
 		healthCheck   = flag.Bool("health-check", false, "Perform health check and exit")
 		port          = flag.String("port", "", "Override port setting")
 		logLevel      = flag.String("log-level", "", "Override log level (DEBUG, INFO, WARN, ERROR)")
+		testTarget    = flag.Bool("test-target", false, "Run the synthetic test target environment for demos and integration tests")
+		testTargetDNS = flag.String("test-target-dns", "127.0.0.1:15353", "Listen address for the synthetic test target DNS server")
+
+		bench            = flag.Bool("bench", false, "Load-test the API against the synthetic test target and exit")
+		benchDuration    = flag.Duration("bench-duration", time.Minute, "How long the benchmark generates load")
+		benchConcurrency = flag.Int("bench-concurrency", 24, "Concurrent scan clients during the benchmark (above RATE_LIMIT_BURST to exercise the limiter)")
+		benchBaseline    = flag.String("bench-baseline", "", "Benchmark report JSON to compare against")
+		benchOutput      = flag.String("bench-output", "", "Write the benchmark report JSON here (usable as the next baseline)")
+		benchThreshold   = flag.Float64("bench-threshold", 20, "Allowed regression against the baseline, in percent")
+
+		verifyPassive = flag.Bool("verify-passive", false, "Scan the synthetic test target passive-only and fail if any traffic reached it")
+
+		verifyBundle = flag.String("verify-bundle", "", "Verify a job bundle (.zip) or signed JSON export against --public-key and exit")
+		publicKey    = flag.String("public-key", "", "PEM ed25519 public key for --verify-bundle")
 	)
 	flag.Parse()
 
@@ -345,26 +1650,54 @@ func main() {
 		fmt.Printf("  LOG_LEVEL              Log level (DEBUG, INFO, WARN, ERROR)\n")
 		fmt.Printf("  DNS_SERVERS            Comma-separated DNS servers\n")
 		fmt.Printf("  DNS_CONCURRENCY        DNS query concurrency (default: 50)\n")
+		fmt.Printf("  DNS_QUERY_TYPES        Address lookups: ipv4, ipv6 or both (default: both)\n")
+		fmt.Printf("  CNAME_MAX_DEPTH        Longest CNAME chain followed (default: 8)\n")
+		fmt.Printf("  DNS_MAX_DEPTH          Highest brute-force depth= (default: 3)\n")
+		fmt.Printf("  PERMUTE_MAX_CANDIDATES Most mode=smart permutation candidates (default: 50000)\n")
+		fmt.Printf("  FEEDBACK_MAX_CANDIDATES Most feedback permutation candidates per scan (default: 10000)\n")
+		fmt.Printf("  UNBOUND_IP_CHECK       Flag cloud addresses that no longer answer (default: true)\n")
 		fmt.Printf("  RATE_LIMIT_RPS         Rate limit requests per second (default: 10)\n")
+		fmt.Printf("  LEAKIX_API_KEY         Optional LeakIX API key\n")
+		fmt.Printf("  *_BASE_URL             Upstream of a source: WAYBACK, CRTSH, SEARCH, LEAKIX, RIPESTAT\n")
+		fmt.Printf("  API_KEYS               Client keys as key:role (viewer or operator)\n")
+		fmt.Printf("  ADMIN_TOKEN            Bearer token for /api/admin endpoints\n")
+		fmt.Printf("  MAINTENANCE_ACTION     Maintenance for running jobs: abort, drain or pause (default: abort)\n")
+		fmt.Printf("  DATA_DIR               Directory for persisted state, job history and audit log\n")
+		fmt.Printf("  JOB_RETENTION          How long finished jobs are kept (default: 24h)\n")
+		fmt.Printf("  MAX_JOBS               Most jobs kept, oldest finished evicted first (default: 1000)\n")
+		fmt.Printf("  STREAM_RESUME_WAIT     How long a scan waits for its stream to reconnect (default: 30s)\n")
+		fmt.Printf("  JOB_STORE_COMPACT_INTERVAL  How often jobs.db is compacted, 0 never (default: 24h)\n")
+		fmt.Printf("  JOB_STORE_MAX_BYTES    Size of stored jobs past which the oldest finished are evicted (default: 0, no limit)\n")
+		fmt.Printf("  SIGNING_KEY_PATH       ed25519 private key (PEM) for signing bundles and exports\n")
+		fmt.Printf("  PASSIVE_ONLY           Disable every source and request that reaches the target\n")
 		fmt.Printf("  TIMEOUT_*              Various timeout settings\n")
 		fmt.Printf("\nExamples:\n")
 		fmt.Printf("  %s                     # Start with default settings\n", os.Args[0])
 		fmt.Printf("  %s --port 9080         # Use custom port\n", os.Args[0])
 		fmt.Printf("  %s --health-check      # Health check for containers\n", os.Args[0])
+		fmt.Printf("  %s --test-target       # Run the synthetic test target\n", os.Args[0])
+		fmt.Printf("  %s --bench --bench-baseline bench.json  # Check for performance regressions\n", os.Args[0])
+		fmt.Printf("  %s --verify-passive    # Prove passive-only mode sends nothing to the target\n", os.Args[0])
+		fmt.Printf("  %s --verify-bundle job.zip --public-key signing.pub  # Check evidence integrity\n", os.Args[0])
 		fmt.Printf("\nFor more information, visit: https://github.com/thespecialone1/subdomain-enum\n")
 		os.Exit(0)
 	}
 
-	// Handle health check flag (for Docker/K8s)
-	if *healthCheck {
-		if err := performHealthCheck(); err != nil {
-			log.Printf("Health check failed: %v", err)
+	// Verification needs only the public key, not a configured server
+	if *verifyBundle != "" {
+		summary, err := verifyEvidence(*verifyBundle, *publicKey)
+		if err != nil {
+			fmt.Printf("Verification FAILED: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Health check passed")
+		fmt.Println(summary)
 		os.Exit(0)
 	}
 
+	if err := applyConfigFile(); err != nil {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
+
 	// Override config with command line arguments
 	if *port != "" {
 		os.Setenv("PORT", *port)
@@ -372,95 +1705,195 @@ func main() {
 	if *logLevel != "" {
 		os.Setenv("LOG_LEVEL", *logLevel)
 	}
+	if *verifyPassive {
+		os.Setenv("PASSIVE_ONLY", "true")
+	}
 
-	// Load configuration
-	config = loadConfig()
-	
-	// Initialize other components...
-	stats = &Statistics{
-		StartTime:   time.Now(),
-		SourceStats: make(map[string]*SourceStats),
+	// Load configuration and initialize components
+	initialize()
+	cfg := currentConfig()
+
+	// Handle health check flag (for Docker/K8s)
+	if *healthCheck {
+		if err := performHealthCheck(); err != nil {
+			log.Printf("Health check failed: %v", err)
+			os.Exit(1)
+		}
+		fmt.Println("Health check passed")
+		os.Exit(0)
 	}
-	jobManager = &JobManager{
-		jobs: make(map[string]*Job),
+
+	// Benchmark the API against the synthetic test target
+	if *bench {
+		passed, err := runBench(BenchOptions{
+			Duration:    *benchDuration,
+			Concurrency: *benchConcurrency,
+			Baseline:    *benchBaseline,
+			Output:      *benchOutput,
+			Threshold:   *benchThreshold,
+			DNSAddr:     *testTargetDNS,
+		})
+		if err != nil {
+			log.Fatalf("Benchmark failed: %v", err)
+		}
+		if !passed {
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
-	initializeDNSResolver()
-	initializeRateLimiter()
-	setupLogging()
 
+	// Prove passive-only mode sends nothing to the target
+	if *verifyPassive {
+		passed, err := runPassiveCheck(*testTargetDNS)
+		if err != nil {
+			log.Fatalf("Passive-only check failed: %v", err)
+		}
+		if !passed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Run the synthetic test target instead of the scanner
+	if *testTarget {
+		if err := runTestTarget(*testTargetDNS); err != nil {
+			log.Fatalf("Test target failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	mux := newRouter(cfg)
+
+	// Start separate metrics server only if explicitly configured; it
+	// records its own state when disabled or sharing the main port
+	go startMetricsServer()
+
+	// SIGHUP reloads configuration from the environment
+	go watchReloadSignal()
+
+	log.Printf("🚀 Advanced Subdomain Enumeration Tool v%s starting...", version)
+	log.Printf("📊 Configuration: DNS Servers: %v, Concurrency: %d, Rate Limit: %d/s",
+		cfg.DNS.Servers, cfg.DNS.Concurrency, cfg.RateLimit.RequestsPerSecond)
+	log.Printf("🌐 Web Interface: http://localhost:%s", cfg.Port)
+
+	if cfg.Monitoring.EnableMetrics {
+		log.Printf("📈 Metrics available at: http://localhost:%s/metrics", cfg.Port)
+		if cfg.Monitoring.MetricsPort != cfg.Port {
+			log.Printf("📊 Dedicated metrics server starting on port %s", cfg.Monitoring.MetricsPort)
+		}
+	}
+
+	if cfg.Monitoring.EnableHealth {
+		log.Printf("🏥 Health checks: http://localhost:%s/health", cfg.Port)
+	}
+
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+
+	// SIGINT and SIGTERM shut down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	log.Printf("✅ Server ready and listening on port %s", cfg.Port)
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down")
+	shutdown(server)
+}
+
+// shutdownTimeout is how long a shutdown waits for the requests in flight,
+// streams included, before closing their connections
+const shutdownTimeout = 10 * time.Second
+
+// shutdown stops server, then writes the jobs still queued for the job
+// store and closes it, so stopping the process loses no job. Jobs still
+// running are stored as such and come back interrupted.
+func shutdown(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Warning: requests still running after %v, closing them: %v", shutdownTimeout, err)
+		server.Close()
+	}
+	closeJobStore()
+}
+
+// newRouter registers the web interface and every API endpoint
+func newRouter(cfg *Config) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// Enhanced middleware - serve static files without middleware for better performance
 	mux.Handle("/", http.FileServer(http.Dir("./public/")))
 
 	// API endpoints with middleware
-	mux.HandleFunc("/api/wayback/stream", withMiddleware(waybackStream))
-	mux.HandleFunc("/api/crtsh/stream", withMiddleware(crtshStream))
-	mux.HandleFunc("/api/dns/stream", withMiddleware(dnsStream))
-	mux.HandleFunc("/api/search/stream", withMiddleware(searchEngineStream))
-	mux.HandleFunc("/api/permute/stream", withMiddleware(permuteStream))
-	mux.HandleFunc("/api/zone/stream", withMiddleware(zoneTransferStream))
+	for _, entry := range sourceRegistry {
+		mux.HandleFunc("/api/"+entry.source.Name()+"/stream", withMiddleware(withScanGuard(sourceStreamHandler(entry))))
+	}
+	for _, name := range disabledSources {
+		mux.HandleFunc("/api/"+name+"/stream", withMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			writeUnknownSource(w, name)
+		}))
+	}
+	mux.HandleFunc("/api/enumerate/stream", withMiddleware(withScanGuard(enumerateStream)))
+	mux.HandleFunc("/ws/enumerate", withMiddleware(withScanGuard(enumerateWebSocket)))
+	mux.HandleFunc("/api/scan", withMiddleware(withScanGuard(scanHandler)))
 
 	// Enhanced endpoints
-	mux.HandleFunc("/api/probe", withMiddleware(probeHandler))
+	mux.HandleFunc("/api/probe", withMiddleware(withScanGuard(probeHandler)))
+	mux.HandleFunc("/api/probe/stream", withMiddleware(withScanGuard(probeStreamHandler)))
 	mux.HandleFunc("/api/jobs", withMiddleware(jobsHandler))
 	mux.HandleFunc("/api/jobs/", withMiddleware(jobDetailHandler))
+	mux.HandleFunc("/api/diff", withMiddleware(diffHandler))
+	mux.HandleFunc("/api/targets", withMiddleware(targetsHandler))
+	mux.HandleFunc("/api/targets/", withMiddleware(targetsHandler))
+	mux.HandleFunc("/api/hosts/", withMiddleware(hostDetailHandler))
 	mux.HandleFunc("/api/abort", withMiddleware(abortHandler))
 	mux.HandleFunc("/api/status", withMiddleware(statusHandler))
 	mux.HandleFunc("/api/stats", withMiddleware(statsHandler))
 	mux.HandleFunc("/api/config", withMiddleware(configHandler))
+	mux.HandleFunc("/api/wordlists", withMiddleware(wordlistsHandler))
+	mux.HandleFunc("/api/wordlists/", withMiddleware(wordlistsHandler))
 	mux.HandleFunc("/api/version", withMiddleware(versionHandler))
+	mux.HandleFunc("/api/whoami", withMiddleware(whoamiHandler))
+	mux.HandleFunc("/api/messages", withMiddleware(messagesHandler))
+	mux.HandleFunc("/api/admin/maintenance", withMiddleware(withAdmin(maintenanceHandler)))
+	mux.HandleFunc("/api/admin/resolvers/reload", withMiddleware(withAdmin(resolverReloadHandler)))
+	mux.HandleFunc("/api/admin/compact", withMiddleware(withAdmin(compactHandler)))
 
 	// Health and monitoring endpoints on main server
-	if config.Monitoring.EnableHealth {
+	if cfg.Monitoring.EnableHealth {
 		mux.HandleFunc("/health", healthHandler)
 		mux.HandleFunc("/ready", readinessHandler)
 	}
-	
+
 	// Always enable metrics on main server for convenience
 	mux.HandleFunc("/metrics", metricsHandler)
-	
-	// Start separate metrics server only if explicitly configured
-	if config.Monitoring.EnableMetrics && config.Monitoring.MetricsPort != config.Port {
-		go startMetricsServer()
-	}
 
-	log.Printf("🚀 Advanced Subdomain Enumeration Tool v%s starting...", version)
-	log.Printf("📊 Configuration: DNS Servers: %v, Concurrency: %d, Rate Limit: %d/s", 
-		config.DNS.Servers, config.DNS.Concurrency, config.RateLimit.RequestsPerSecond)
-	log.Printf("🌐 Web Interface: http://localhost:%s", config.Port)
-	
-	if config.Monitoring.EnableMetrics {
-		log.Printf("📈 Metrics available at: http://localhost:%s/metrics", config.Port)
-		if config.Monitoring.MetricsPort != config.Port {
-			log.Printf("📊 Dedicated metrics server starting on port %s", config.Monitoring.MetricsPort)
-		}
-	}
-	
-	if config.Monitoring.EnableHealth {
-		log.Printf("🏥 Health checks: http://localhost:%s/health", config.Port)
-	}
-	
-	server := &http.Server{
-		Addr:         ":" + config.Port,
-		Handler:      mux,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
-	
-	log.Printf("✅ Server ready and listening on port %s", config.Port)
-	log.Fatal(server.ListenAndServe())
+	return mux
 }
 
 // Enhanced middleware with security, logging, and rate limiting
 func withMiddleware(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Snapshot the config once for the whole request
+		cfg := currentConfig()
+		r = r.WithContext(withConfig(r.Context(), cfg))
+
 		// Security headers
-		if config.Security.EnableCORS {
+		if cfg.Security.EnableCORS {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		}
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "DENY")
@@ -487,1255 +1920,17223 @@ func withMiddleware(handler http.HandlerFunc) http.HandlerFunc {
 			duration := time.Since(start)
 			log.Printf("[%s] %s %s - %v", r.Method, r.URL.Path, r.RemoteAddr, duration)
 			atomic.AddInt64(&stats.TotalRequests, 1)
+			stats.mu.Lock()
 			stats.LastActivity = time.Now()
+			stats.mu.Unlock()
 		}()
 
 		// User agent filtering
 		userAgent := r.Header.Get("User-Agent")
-		for _, blocked := range config.Security.BlockedUserAgents {
+		for _, blocked := range cfg.Security.BlockedUserAgents {
 			if strings.Contains(strings.ToLower(userAgent), strings.ToLower(blocked)) {
 				http.Error(w, "Blocked user agent", http.StatusForbidden)
 				return
 			}
 		}
 
+		r, ok := authorize(w, r)
+		if !ok {
+			return
+		}
+
 		handler(w, r)
 	}
 }
 
-// Enhanced DNS resolution with load balancing and error handling
-func (dr *DNSResolver) LookupHost(ctx context.Context, host string) ([]net.IP, error) {
-	serverIndex := atomic.AddInt64(&dr.current, 1) % int64(len(dr.servers))
-	client := dr.clients[serverIndex]
-	server := dr.servers[serverIndex]
+// errNXDomain matches lookups answered with NXDOMAIN
+var errNXDomain = errors.New("NXDOMAIN")
 
-	msg := &dns.Msg{}
-	msg.SetQuestion(dns.Fqdn(host), dns.TypeA)
-	msg.RecursionDesired = true
+// RcodeError is returned by lookups the server answered with an rcode
+// other than NOERROR. errors.Is(err, errNXDomain) holds for NXDOMAIN.
+type RcodeError struct {
+	Name  string
+	Rcode int
+}
 
-	response, _, err := client.ExchangeContext(ctx, msg, server)
-	if err != nil {
-		atomic.AddInt64(&stats.DNSQueries, 1)
-		return nil, fmt.Errorf("DNS query failed for %s: %w", host, err)
-	}
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, dns.RcodeToString[e.Rcode])
+}
 
-	var ips []net.IP
-	for _, answer := range response.Answer {
-		if a, ok := answer.(*dns.A); ok {
-			ips = append(ips, a.A)
-		}
-	}
+func (e *RcodeError) Is(target error) bool {
+	return target == errNXDomain && e.Rcode == dns.RcodeNameError
+}
 
-	atomic.AddInt64(&stats.DNSQueries, 1)
-	if len(ips) == 0 {
-		return nil, fmt.Errorf("no A records found for %s", host)
+// lookupOutcome classifies a lookup error: nxdomain, servfail, refused
+// (or another rcode in lower case), timeout, no-answer when the name
+// exists without records of the type, or error. Nil is resolved.
+func lookupOutcome(err error) string {
+	var rcodeErr *RcodeError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "resolved"
+	case errors.As(err, &rcodeErr):
+		return strings.ToLower(dns.RcodeToString[rcodeErr.Rcode])
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case strings.Contains(err.Error(), " records found for "):
+		return "no-answer"
 	}
+	return "error"
+}
 
-	return ips, nil
+// Enhanced DNS resolution with load balancing and error handling
+func (dr *DNSResolver) LookupHost(ctx context.Context, host string) ([]net.IP, error) {
+	return dr.lookupAddrs(ctx, host, dns.TypeA)
 }
 
-// Enhanced SSE headers with better caching control
-func sseHeader(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Pragma", "no-cache")
-	w.Header().Set("Expires", "0")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
+// LookupHostAll returns the host's addresses in the families selected by
+// DNS_QUERY_TYPES, querying A and AAAA in parallel in "both" mode
+func (dr *DNSResolver) LookupHostAll(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := dr.lookupHostAll(ctx, host)
+	return ips, err
 }
 
-// Enhanced job management with better tracking
-func createJob(target string, sources []string) *Job {
-	jobID := fmt.Sprintf("%s_%d", target, time.Now().Unix())
-	
-	job := &Job{
-		ID:        jobID,
-		Target:    target,
-		Sources:   sources,
-		StartTime: time.Now(),
-		Status:    "running",
-		Results:   make(map[string][]Result),
+// lookupHostAll is LookupHostAll that also returns the CNAMEs the answers
+// followed from host
+func (dr *DNSResolver) lookupHostAll(ctx context.Context, host string) ([]net.IP, []string, error) {
+	switch configFrom(ctx).DNS.QueryTypes {
+	case "ipv4":
+		return dr.lookupAnswer(ctx, host, dns.TypeA)
+	case "ipv6":
+		return dr.lookupAnswer(ctx, host, dns.TypeAAAA)
 	}
 
-	jobManager.mu.Lock()
-	jobManager.jobs[jobID] = job
-	jobManager.mu.Unlock()
-
-	atomic.AddInt64(&stats.ActiveJobs, 1)
-	return job
-}
-
-func (j *Job) AddResult(source string, result Result) {
-	j.mu.Lock()
-	defer j.mu.Unlock()
-	
-	if j.Results[source] == nil {
-		j.Results[source] = make([]Result, 0)
+	qtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+	answers := make([][]net.IP, len(qtypes))
+	cnames := make([][]string, len(qtypes))
+	errs := make([]error, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func(i int, qtype uint16) {
+			defer wg.Done()
+			answers[i], cnames[i], errs[i] = dr.lookupAnswer(ctx, host, qtype)
+		}(i, qtype)
 	}
-	j.Results[source] = append(j.Results[source], result)
-	atomic.AddInt64(&stats.TotalSubdomains, 1)
-}
+	wg.Wait()
 
-func (j *Job) Complete() {
-	j.mu.Lock()
-	j.Status = "completed"
-	j.mu.Unlock()
-	
-	atomic.AddInt64(&stats.ActiveJobs, -1)
-	atomic.AddInt64(&stats.CompletedJobs, 1)
+	// Both answers walk the same chain; keep the longer one
+	chain := cnames[0]
+	if len(cnames[1]) > len(chain) {
+		chain = cnames[1]
+	}
+	ips := append(answers[0], answers[1]...)
+	if len(ips) > 0 {
+		return ips, chain, nil
+	}
+	// Neither family answered: NXDOMAIN is the most useful explanation,
+	// then another rcode such as SERVFAIL
+	for _, err := range errs {
+		if errors.Is(err, errNXDomain) {
+			return nil, chain, err
+		}
+	}
+	var rcodeErr *RcodeError
+	for _, err := range errs {
+		if errors.As(err, &rcodeErr) {
+			return nil, chain, err
+		}
+	}
+	return nil, chain, errs[0]
 }
 
-func (j *Job) Fail(err error) {
-	j.mu.Lock()
-	j.Status = fmt.Sprintf("failed: %v", err)
-	j.mu.Unlock()
-	
-	atomic.AddInt64(&stats.ActiveJobs, -1)
-	atomic.AddInt64(&stats.FailedJobs, 1)
+// lookupAddrs returns the A or AAAA addresses of host
+func (dr *DNSResolver) lookupAddrs(ctx context.Context, host string, qtype uint16) ([]net.IP, error) {
+	ips, _, err := dr.lookupAnswer(ctx, host, qtype)
+	return ips, err
 }
 
-// Enhanced probe handler with better error handling and caching
-func probeHandler(w http.ResponseWriter, r *http.Request) {
-	targetURL := r.URL.Query().Get("url")
-	if targetURL == "" {
-		http.Error(w, "missing url parameter", http.StatusBadRequest)
-		return
-	}
-
-	parsedURL, err := url.Parse(targetURL)
+// lookupAnswer returns the A or AAAA addresses of host along with the CNAME
+// chain the answer section walks from host, which may be non-empty even
+// when there are no addresses
+func (dr *DNSResolver) lookupAnswer(ctx context.Context, host string, qtype uint16) ([]net.IP, []string, error) {
+	response, err := dr.query(ctx, host, qtype)
 	if err != nil {
-		writeProbeError(w, "invalid URL", err)
-		return
+		return nil, nil, err
 	}
 
-	// Validate domain if restrictions are set
-	if len(config.Security.AllowedDomains) > 0 {
-		allowed := false
-		for _, domain := range config.Security.AllowedDomains {
-			if strings.HasSuffix(parsedURL.Hostname(), domain) {
-				allowed = true
+	var chain []string
+	name := dns.Fqdn(host)
+	for range response.Answer {
+		next := ""
+		for _, answer := range response.Answer {
+			if cname, ok := answer.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+				next = cname.Target
 				break
 			}
 		}
-		if !allowed {
-			writeProbeError(w, "domain not allowed", fmt.Errorf("domain %s not in allowed list", parsedURL.Hostname()))
-			return
+		if next == "" {
+			break
 		}
+		chain = append(chain, strings.TrimSuffix(strings.ToLower(next), "."))
+		name = next
 	}
 
-	startTime := time.Now()
-	result := probeURL(r.Context(), targetURL)
-	result.ProbeTime = time.Since(startTime).Milliseconds()
-
-	atomic.AddInt64(&stats.TotalProbes, 1)
-	if result.Status != "0" && result.Error == "" {
-		atomic.AddInt64(&stats.SuccessfulProbes, 1)
+	if response.Rcode != dns.RcodeSuccess {
+		return nil, chain, &RcodeError{Name: host, Rcode: response.Rcode}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	var ips []net.IP
+	for _, answer := range response.Answer {
+		switch rr := answer.(type) {
+		case *dns.A:
+			ips = append(ips, rr.A)
+		case *dns.AAAA:
+			ips = append(ips, rr.AAAA)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, chain, fmt.Errorf("no %s records found for %s", dns.TypeToString[qtype], host)
+	}
+
+	return ips, chain, nil
 }
 
-func probeURL(ctx context.Context, targetURL string) ProbeResponse {
-	client := &http.Client{
-		Timeout: config.HTTP.Timeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: config.HTTP.SkipTLSVerify,
-			},
-			DialContext: (&net.Dialer{
-				Timeout:   5 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-		},
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= config.HTTP.MaxRedirects {
-				return fmt.Errorf("too many redirects (%d)", len(via))
+// ResolveChain follows host's CNAMEs up to maxDepth hops and returns the
+// chain with the final addresses. When resolvers stop at a CNAME without
+// chasing it, the chain is continued from its last name. A chain that ends
+// without addresses is returned along with the error, since a dangling
+// CNAME is itself worth reporting.
+func (dr *DNSResolver) ResolveChain(ctx context.Context, host string, maxDepth int) ([]string, []net.IP, error) {
+	var chain []string
+	name := host
+	for {
+		ips, cnames, err := dr.lookupHostAll(ctx, name)
+		for _, cname := range cnames {
+			if strings.EqualFold(cname, host) || containsString(chain, cname) {
+				return chain, nil, fmt.Errorf("CNAME loop at %s for %s", cname, host)
 			}
-			return nil
-		},
+			chain = append(chain, cname)
+		}
+		if len(chain) > maxDepth {
+			return chain[:maxDepth], nil, fmt.Errorf("CNAME chain for %s is longer than %d", host, maxDepth)
+		}
+		if len(ips) > 0 || len(cnames) == 0 || ctx.Err() != nil {
+			return chain, ips, err
+		}
+		name = cnames[len(cnames)-1]
 	}
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
-	if err != nil {
-		return ProbeResponse{
-			Status: "0",
-			Title:  "Request creation failed",
-			Error:  err.Error(),
+// wildcardProbes is how many random labels DetectWildcard resolves
+const wildcardProbes = 3
+
+// DetectWildcard resolves a few random labels under domain. If any of them
+// resolve the domain has wildcard DNS, and the union of the addresses they
+// returned is the wildcard IP set.
+func (dr *DNSResolver) DetectWildcard(ctx context.Context, domain string) ([]net.IP, bool) {
+	seen := make(map[string]bool)
+	var ips []net.IP
+	for i := 0; i < wildcardProbes; i++ {
+		label := strings.ToLower(rand.Text())[:12]
+		answers, err := dr.LookupHostAll(ctx, label+"."+domain)
+		if err != nil {
+			continue
+		}
+		for _, ip := range answers {
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				ips = append(ips, ip)
+			}
 		}
 	}
+	sort.Slice(ips, func(i, j int) bool { return bytes.Compare(ips[i], ips[j]) < 0 })
+	return ips, len(ips) > 0
+}
 
-	req.Header.Set("User-Agent", config.HTTP.UserAgent)
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	req.Header.Set("Accept-Encoding", "gzip, deflate")
-	req.Header.Set("DNT", "1")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Upgrade-Insecure-Requests", "1")
+// interceptionTLDs are the unrelated TLDs DetectInterception probes;
+// .invalid is reserved and can never resolve
+var interceptionTLDs = []string{"com", "net", "org", "invalid"}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return ProbeResponse{
-			Status: "0",
-			Title:  "Connection failed",
-			Error:  err.Error(),
+// DetectInterception resolves random names under unrelated TLDs, none of
+// which can exist. Captive portals, filtering resolvers and some ISPs
+// answer them anyway with a redirect address; those addresses are
+// returned.
+func (dr *DNSResolver) DetectInterception(ctx context.Context) ([]net.IP, bool) {
+	seen := make(map[string]bool)
+	var ips []net.IP
+	for _, tld := range interceptionTLDs {
+		label := strings.ToLower(rand.Text())[:20]
+		answers, err := dr.LookupHostAll(ctx, label+"."+tld)
+		if err != nil {
+			continue
+		}
+		for _, ip := range answers {
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				ips = append(ips, ip)
+			}
 		}
 	}
-	defer resp.Body.Close()
+	sort.Slice(ips, func(i, j int) bool { return bytes.Compare(ips[i], ips[j]) < 0 })
+	return ips, len(ips) > 0
+}
 
-	body, err := io.ReadAll(io.LimitReader(resp.Body, config.HTTP.MaxBodySize))
+// LookupTXT returns the TXT strings for name, joining multi-part records
+func (dr *DNSResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	response, err := dr.query(ctx, name, dns.TypeTXT)
 	if err != nil {
-		return ProbeResponse{
-			Status: fmt.Sprintf("%d", resp.StatusCode),
-			Title:  "Failed to read response",
-			Error:  err.Error(),
+		return nil, err
+	}
+
+	var records []string
+	for _, answer := range response.Answer {
+		if txt, ok := answer.(*dns.TXT); ok {
+			records = append(records, strings.Join(txt.Txt, ""))
 		}
 	}
 
-	title := extractTitle(string(body))
-	return ProbeResponse{
-		Status: fmt.Sprintf("%d", resp.StatusCode),
-		Title:  title,
-		Error:  "",
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no TXT records found for %s", name)
 	}
+
+	return records, nil
 }
 
-func extractTitle(html string) string {
-	matches := titleRe.FindStringSubmatch(html)
-	if len(matches) < 2 {
-		return "No title"
+// LookupMX returns the mail exchanger hostnames for name
+func (dr *DNSResolver) LookupMX(ctx context.Context, name string) ([]string, error) {
+	response, err := dr.query(ctx, name, dns.TypeMX)
+	if err != nil {
+		return nil, err
 	}
-	
-	title := strings.TrimSpace(matches[1])
-	title = strings.ReplaceAll(title, "\n", " ")
-	title = strings.ReplaceAll(title, "\r", " ")
-	title = regexp.MustCompile(`\s+`).ReplaceAllString(title, " ")
-	
-	if len(title) > 100 {
-		title = title[:100] + "..."
+
+	var hosts []string
+	for _, answer := range response.Answer {
+		if mx, ok := answer.(*dns.MX); ok {
+			hosts = append(hosts, strings.TrimSuffix(strings.ToLower(mx.Mx), "."))
+		}
 	}
-	
-	return title
+	return hosts, nil
 }
 
-type ProbeResponse struct {
-	Status    string `json:"status"`
-	Title     string `json:"title"`
-	Error     string `json:"error"`
-	ProbeTime int64  `json:"probe_time_ms,omitempty"`
-}
+// LookupNS returns the nameserver hostnames for name
+func (dr *DNSResolver) LookupNS(ctx context.Context, name string) ([]string, error) {
+	response, err := dr.query(ctx, name, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
 
-func writeProbeError(w http.ResponseWriter, message string, err error) {
-	response := ProbeResponse{
-		Status: "0",
-		Title:  "Connection failed",
-		Error:  fmt.Sprintf("%s: %v", message, err),
+	var hosts []string
+	for _, answer := range response.Answer {
+		if ns, ok := answer.(*dns.NS); ok {
+			hosts = append(hosts, strings.TrimSuffix(strings.ToLower(ns.Ns), "."))
+		}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	return hosts, nil
 }
 
-// Enhanced statistics handler
-func statsHandler(w http.ResponseWriter, r *http.Request) {
-	stats.mu.RLock()
-	defer stats.mu.RUnlock()
-
-	uptime := time.Since(stats.StartTime)
-	
-	response := map[string]interface{}{
-		"uptime_seconds":     uptime.Seconds(),
-		"total_requests":     atomic.LoadInt64(&stats.TotalRequests),
-		"active_jobs":        atomic.LoadInt64(&stats.ActiveJobs),
-		"completed_jobs":     atomic.LoadInt64(&stats.CompletedJobs),
-		"failed_jobs":        atomic.LoadInt64(&stats.FailedJobs),
-		"total_subdomains":   atomic.LoadInt64(&stats.TotalSubdomains),
-		"total_probes":       atomic.LoadInt64(&stats.TotalProbes),
-		"successful_probes":  atomic.LoadInt64(&stats.SuccessfulProbes),
-		"dns_queries":        atomic.LoadInt64(&stats.DNSQueries),
-		"last_activity":      stats.LastActivity,
-		"source_stats":       stats.SourceStats,
-		"memory_usage":       getMemoryUsage(),
-		"dns_servers":        config.DNS.Servers,
-		"rate_limit":         fmt.Sprintf("%d/s", config.RateLimit.RequestsPerSecond),
+// LookupCNAME returns the CNAME target for name, or "" when there is none
+func (dr *DNSResolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+	response, err := dr.query(ctx, name, dns.TypeCNAME)
+	if err != nil {
+		return "", err
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	for _, answer := range response.Answer {
+		if cname, ok := answer.(*dns.CNAME); ok {
+			return strings.TrimSuffix(strings.ToLower(cname.Target), "."), nil
+		}
+	}
+	return "", nil
 }
 
-// Health check handlers
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":    "healthy",
-		"timestamp": time.Now().Format(time.RFC3339),
-		"version":   "2.0.0",
-	})
+// LookupSOA returns the primary nameserver named in the SOA record for name
+func (dr *DNSResolver) LookupSOA(ctx context.Context, name string) (string, error) {
+	response, err := dr.query(ctx, name, dns.TypeSOA)
+	if err != nil {
+		return "", err
+	}
+
+	for _, answer := range response.Answer {
+		if soa, ok := answer.(*dns.SOA); ok {
+			return strings.TrimSuffix(strings.ToLower(soa.Ns), "."), nil
+		}
+	}
+	return "", nil
 }
 
-func readinessHandler(w http.ResponseWriter, r *http.Request) {
-	// Check if critical services are ready
-	ready := true
-	checks := make(map[string]bool)
-	
-	// Check DNS resolver
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-	
-	_, err := dnsResolver.LookupHost(ctx, "google.com")
-	checks["dns"] = err == nil
+// LookupPTR returns the reverse DNS names for ip
+func (dr *DNSResolver) LookupPTR(ctx context.Context, ip net.IP) ([]string, error) {
+	arpa, err := dns.ReverseAddr(ip.String())
 	if err != nil {
-		ready = false
+		return nil, err
 	}
-	
-	status := http.StatusOK
-	if !ready {
-		status = http.StatusServiceUnavailable
+
+	response, err := dr.query(ctx, arpa, dns.TypePTR)
+	if err != nil {
+		return nil, err
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"ready":  ready,
-		"checks": checks,
-	})
-}
 
-// Enhanced configuration handler
-func configHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		// Return current configuration (sanitized)
-		sanitizedConfig := map[string]interface{}{
-			"timeouts": map[string]string{
-				"wayback": config.Timeouts.Wayback.String(),
-				"crtsh":   config.Timeouts.CrtSh.String(),
-				"dns":     config.Timeouts.DNS.String(),
-				"search":  config.Timeouts.Search.String(),
-				"permute": config.Timeouts.Permute.String(),
-				"zone":    config.Timeouts.Zone.String(),
-			},
-			"dns": map[string]interface{}{
-				"servers":     config.DNS.Servers,
-				"concurrency": config.DNS.Concurrency,
-				"timeout":     config.DNS.Timeout.String(),
-			},
-			"rate_limit": map[string]interface{}{
-				"requests_per_second": config.RateLimit.RequestsPerSecond,
-				"burst_size":          config.RateLimit.BurstSize,
-			},
-			"wordlist_categories": getWordlistCategories(),
+	var names []string
+	for _, answer := range response.Answer {
+		if ptr, ok := answer.(*dns.PTR); ok {
+			names = append(names, strings.TrimSuffix(strings.ToLower(ptr.Ptr), "."))
 		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(sanitizedConfig)
-		return
 	}
-	
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	return names, nil
 }
 
-func getWordlistCategories() map[string]int {
-	categories := make(map[string]int)
-	for category, words := range commonSubdomains {
-		categories[category] = len(words)
-	}
-	return categories
+// SRVTarget is one SRV record answer
+type SRVTarget struct {
+	Target   string `json:"target"`
+	Port     uint16 `json:"port"`
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
 }
 
-// Utility functions for environment variable parsing
-func getEnvString(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// LookupSRV returns the SRV targets published at name, which is the full
+// _service._proto owner name. A "." target (service explicitly not offered)
+// is left out.
+func (dr *DNSResolver) LookupSRV(ctx context.Context, name string) ([]SRVTarget, error) {
+	response, err := dr.query(ctx, name, dns.TypeSRV)
+	if err != nil {
+		return nil, err
 	}
-	return defaultValue
-}
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.Atoi(value); err == nil {
-			return parsed
+	var targets []SRVTarget
+	for _, answer := range response.Answer {
+		srv, ok := answer.(*dns.SRV)
+		if !ok {
+			continue
 		}
+		host := strings.TrimSuffix(strings.ToLower(srv.Target), ".")
+		if host == "" {
+			continue
+		}
+		targets = append(targets, SRVTarget{Target: host, Port: srv.Port, Priority: srv.Priority, Weight: srv.Weight})
 	}
-	return defaultValue
+	return targets, nil
 }
 
-func getEnvInt64(key string, defaultValue int64) int64 {
-	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil {
-			return parsed
-		}
-	}
-	return defaultValue
+// ASNInfo is the origin data Team Cymru publishes for an address
+type ASNInfo struct {
+	ASN    string `json:"asn"`
+	Prefix string `json:"prefix"`
+	Name   string `json:"name,omitempty"`
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.ParseBool(value); err == nil {
-			return parsed
+// LookupASN maps an IPv4 address to its origin ASN via origin.asn.cymru.com
+func (dr *DNSResolver) LookupASN(ctx context.Context, ip net.IP) ([]ASNInfo, error) {
+	v4 := ip.To4()
+	if v4 == nil {
+		return nil, fmt.Errorf("ASN lookup supports IPv4 only: %s", ip)
+	}
+
+	name := fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", v4[3], v4[2], v4[1], v4[0])
+	records, err := dr.LookupTXT(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ASNInfo
+	for _, record := range records {
+		if info, ok := parseCymruOrigin(record); ok {
+			infos = append(infos, info)
 		}
 	}
-	return defaultValue
+	return infos, nil
 }
 
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if parsed, err := time.ParseDuration(value); err == nil {
-			return parsed
-		}
+// LookupASNName returns the registered name of asn via AS<n>.asn.cymru.com
+func (dr *DNSResolver) LookupASNName(ctx context.Context, asn string) (string, error) {
+	records, err := dr.LookupTXT(ctx, fmt.Sprintf("AS%s.asn.cymru.com", asn))
+	if err != nil {
+		return "", err
 	}
-	return defaultValue
+
+	// "15169 | US | arin | 2000-03-30 | GOOGLE, US"
+	fields := strings.Split(records[0], "|")
+	if len(fields) < 5 {
+		return "", fmt.Errorf("unexpected ASN record: %q", records[0])
+	}
+	return strings.TrimSpace(fields[4]), nil
 }
 
-func getEnvStringSlice(key string, defaultValue []string) []string {
-	if value := os.Getenv(key); value != "" {
-		return strings.Split(value, ",")
+// parseCymruOrigin parses "15169 | 8.8.8.0/24 | US | arin | 1992-12-01".
+// Multi-origin prefixes list several ASNs separated by spaces.
+func parseCymruOrigin(record string) (ASNInfo, bool) {
+	fields := strings.Split(record, "|")
+	if len(fields) < 2 {
+		return ASNInfo{}, false
 	}
-	return defaultValue
+
+	asns := strings.Fields(fields[0])
+	prefix := strings.TrimSpace(fields[1])
+	if len(asns) == 0 {
+		return ASNInfo{}, false
+	}
+	if _, _, err := net.ParseCIDR(prefix); err != nil {
+		return ASNInfo{}, false
+	}
+	return ASNInfo{ASN: asns[0], Prefix: prefix}, true
 }
 
-// Memory usage monitoring
-func getMemoryUsage() map[string]interface{} {
-	// This is a simplified version - you might want to use runtime.MemStats
-	return map[string]interface{}{
-		"goroutines": "runtime.NumGoroutine() would go here",
-		"note":       "Implement with runtime.MemStats for production",
+// RetryPolicy says how often and how patiently one kind of operation is
+// retried. The policies of all call sites are defined together below.
+type RetryPolicy struct {
+	// Subsystem labels the retries in the retry counters
+	Subsystem string
+	// Attempts is the total number of tries, the first one included
+	Attempts int
+	// Base is the backoff before the first retry; it doubles after every
+	// further attempt up to Max
+	Base time.Duration
+	Max  time.Duration
+	// FullJitter waits a random time between zero and the backoff instead
+	// of the backoff itself, so operations that failed together do not
+	// retry in lockstep
+	FullJitter bool
+	// AttemptTimeout bounds each try and Deadline all of them, backoff
+	// included. Zero leaves it to the caller's context.
+	AttemptTimeout time.Duration
+	Deadline       time.Duration
+	// Retryable classifies errors; nil retries every error
+	Retryable func(error) bool
+	// OnRetry, if set, is called before waiting to retry a failed attempt
+	OnRetry func(attempt int, err error)
+}
+
+// dnsRetryPolicy retries a lookup DNS.Retries times. Only the passive-only
+// guard is final; timeouts and lame servers are what the retries are for.
+func dnsRetryPolicy(cfg *Config) RetryPolicy {
+	return RetryPolicy{
+		Subsystem:  "dns",
+		Attempts:   max(cfg.DNS.Retries, 0) + 1,
+		Base:       50 * time.Millisecond,
+		Max:        2 * time.Second,
+		FullJitter: true,
+		Retryable: func(err error) bool {
+			return !errors.Is(err, errPassiveOnly)
+		},
 	}
 }
 
-// Metrics server for Prometheus integration
-func startMetricsServer() {
-	if !config.Monitoring.EnableMetrics {
-		return
+// metricsBindRetryPolicy waits for the metrics port to be released, e.g. by
+// a previous instance that is still shutting down
+func metricsBindRetryPolicy(cfg *Config) RetryPolicy {
+	return RetryPolicy{
+		Subsystem: "metrics_bind",
+		Attempts:  max(cfg.Monitoring.BindAttempts, 1),
+		Base:      time.Second,
+		Max:       30 * time.Second,
 	}
-	
-	// Don't start separate server if using same port as main server
-	if config.Monitoring.MetricsPort == config.Port {
-		log.Printf("Metrics server using main server port %s", config.Port)
-		return
+}
+
+// probeRetryPolicy retries probes that failed on the network, or with a
+// 5xx when HTTP.ProbeRetry5xx is set
+func probeRetryPolicy(cfg *Config) RetryPolicy {
+	return RetryPolicy{
+		Subsystem:  "probe",
+		Attempts:   max(cfg.HTTP.ProbeRetries, 0) + 1,
+		Base:       cfg.HTTP.ProbeRetryBackoff,
+		Max:        5 * time.Second,
+		FullJitter: true,
+		Retryable: func(err error) bool {
+			return !errors.Is(err, errProbe5xx) || cfg.HTTP.ProbeRetry5xx
+		},
 	}
-	
-	metricsMux := http.NewServeMux()
-	metricsMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `<!DOCTYPE html>
-<html>
-<head>
-    <title>Metrics Server</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 40px; background: #f5f5f5; }
-        .container { background: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
-        .header { color: #333; border-bottom: 2px solid #4ade80; padding-bottom: 10px; }
-        .metrics-link { 
-            display: inline-block; 
-            background: #4ade80; 
-            color: white; 
-            text-decoration: none; 
-            padding: 10px 20px; 
-            border-radius: 5px; 
-            margin: 10px 0;
-        }
-        .metrics-link:hover { background: #22c55e; }
-        .info { background: #f0f9ff; padding: 15px; border-radius: 5px; margin: 20px 0; }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <h1 class="header">Subdomain Scanner Metrics Server</h1>
-        <div class="info">
-            <p><strong>Main Application:</strong> <a href="http://localhost:%s">http://localhost:%s</a></p>
-            <p><strong>Metrics Endpoint:</strong> <a href="/metrics" class="metrics-link">View Prometheus Metrics</a></p>
-            <p><strong>Health Check:</strong> <a href="http://localhost:%s/health">http://localhost:%s/health</a></p>
-        </div>
-        <h3>Available Endpoints:</h3>
-        <ul>
-            <li><a href="/metrics">/metrics</a> - Prometheus metrics format</li>
-            <li><a href="http://localhost:%s/api/stats">http://localhost:%s/api/stats</a> - JSON statistics</li>
-        </ul>
-    </div>
-</body>
-</html>`, config.Port, config.Port, config.Port, config.Port, config.Port, config.Port)
-	})
-	metricsMux.HandleFunc("/metrics", metricsHandler)
-	metricsMux.HandleFunc("/health", healthHandler)
-	
-	server := &http.Server{
-		Addr:         ":" + config.Monitoring.MetricsPort,
-		Handler:      metricsMux,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
+}
+
+// backoff returns the wait before retrying a failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.Base << min(attempt, 30)
+	if p.Max > 0 && (wait > p.Max || wait < p.Base) {
+		wait = p.Max
 	}
-	
-	log.Printf("Starting dedicated metrics server on port %s", config.Monitoring.MetricsPort)
-	
-	// Use a more graceful error handling instead of log.Fatal
-	if err := server.ListenAndServe(); err != nil {
-		log.Printf("Metrics server error (port %s may be in use): %v", config.Monitoring.MetricsPort, err)
-		log.Printf("Metrics are still available on main server: http://localhost:%s/metrics", config.Port)
+	if p.FullJitter && wait > 0 {
+		wait = time.Duration(mathrand.Int64N(int64(wait) + 1))
 	}
+	return wait
 }
 
-func metricsHandler(w http.ResponseWriter, r *http.Request) {
-	// Prometheus metrics format
+// retry calls fn until it succeeds, fails with an error the policy does not
+// retry, or runs out of attempts. A retry that could not start before the
+// context's deadline is not waited for, and cancelling the context stops
+// the backoff. The error of the last attempt is returned.
+func retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context, attempt int) error) error {
+	if policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Deadline)
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.AttemptTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.AttemptTimeout)
+		}
+		err := fn(attemptCtx, attempt)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt+1 >= policy.Attempts || ctx.Err() != nil {
+			return err
+		}
+		if policy.Retryable != nil && !policy.Retryable(err) {
+			return err
+		}
+
+		wait := policy.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		atomic.AddInt64(retryCounter(policy.Subsystem), 1)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// query sends a question to the next server in the rotation. Timeouts,
+// SERVFAIL and REFUSED are retried as dnsRetryPolicy says, each time
+// against the following server. NXDOMAIN is an answer and is returned
+// straight away. When every attempt fails the last response or error is
+// returned.
+func (dr *DNSResolver) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	if dr.cache != nil {
+		if cached, ok := dr.cache.get(name, qtype); ok {
+			atomic.AddInt64(&stats.DNSCacheHits, 1)
+			return cached, nil
+		}
+		atomic.AddInt64(&stats.DNSCacheMisses, 1)
+	}
+
+	var tried []int
+	source := sourceRunFrom(ctx).source
+	policy := dnsRetryPolicy(configFrom(ctx))
+	policy.OnRetry = func(int, error) {
+		atomic.AddInt64(&stats.DNSRetries, 1)
+		if source != "" {
+			atomic.AddInt64(&sourceStats(source).DNSRetries, 1)
+		}
+	}
+
+	var response *dns.Msg
+	err := retry(ctx, policy, func(ctx context.Context, attempt int) error {
+		i := dr.pick(tried)
+		tried = append(tried, i)
+		var err error
+		response, err = dr.exchange(ctx, i, name, qtype)
+		if err != nil {
+			return err
+		}
+		if retryableRcode(response.Rcode) {
+			dr.health[i].recordFailure(fmt.Sprintf("%s for %s", dns.RcodeToString[response.Rcode], name))
+			return &RcodeError{Name: name, Rcode: response.Rcode}
+		}
+		return nil
+	})
+	if err == nil {
+		dr.cache.put(name, qtype, response)
+		return response, nil
+	}
+	if ctx.Err() != nil {
+		return response, err
+	}
+	if source != "" {
+		atomic.AddInt64(&sourceStats(source).DNSFailures, 1)
+	}
+	var rcodeErr *RcodeError
+	if errors.As(err, &rcodeErr) {
+		// Out of retries on SERVFAIL or REFUSED: the last answer stands
+		return response, nil
+	}
+	return response, err
+}
+
+// dnsCache keeps answers by name and type for their TTL, least recently
+// used first out once it holds size entries
+type dnsCache struct {
+	size        int
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[dnsCacheKey]*list.Element
+	order   *list.List // of *dnsCacheEntry, most recently used in front
+}
+
+type dnsCacheKey struct {
+	name  string
+	qtype uint16
+}
+
+type dnsCacheEntry struct {
+	key     dnsCacheKey
+	msg     *dns.Msg
+	expires time.Time
+}
+
+func newDNSCache(size int, maxTTL, negativeTTL time.Duration) *dnsCache {
+	return &dnsCache{
+		size:        size,
+		maxTTL:      maxTTL,
+		negativeTTL: negativeTTL,
+		entries:     make(map[dnsCacheKey]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// get returns a copy of the cached answer for name and qtype, if it has
+// not expired
+func (c *dnsCache) get(name string, qtype uint16) (*dns.Msg, bool) {
+	key := dnsCacheKey{strings.ToLower(dns.Fqdn(name)), qtype}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*dnsCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.msg.Copy(), true
+}
+
+// remaining returns how much longer the records for name and qtype stay
+// cached, without counting as a use of them. Zero when none are cached,
+// including when the cached answer is negative.
+func (c *dnsCache) remaining(name string, qtype uint16) time.Duration {
+	if c == nil {
+		return 0
+	}
+	key := dnsCacheKey{strings.ToLower(dns.Fqdn(name)), qtype}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return 0
+	}
+	entry := element.Value.(*dnsCacheEntry)
+	if entry.msg.Rcode != dns.RcodeSuccess || len(entry.msg.Answer) == 0 {
+		return 0
+	}
+	return max(time.Until(entry.expires), 0)
+}
+
+// put stores msg for as long as its TTL allows. Answers nothing may be
+// cached for (SERVFAIL, TTL 0, no SOA on a negative answer) are skipped.
+func (c *dnsCache) put(name string, qtype uint16, msg *dns.Msg) {
+	if c == nil {
+		return
+	}
+	ttl := c.ttl(msg)
+	if ttl <= 0 {
+		return
+	}
+	key := dnsCacheKey{strings.ToLower(dns.Fqdn(name)), qtype}
+	entry := &dnsCacheEntry{key: key, msg: msg.Copy(), expires: time.Now().Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dnsCacheEntry).key)
+	}
+}
+
+// ttl is how long msg may be cached: the lowest answer TTL, or for
+// NXDOMAIN and NODATA the SOA's negative TTL (RFC 2308), each capped
+func (c *dnsCache) ttl(msg *dns.Msg) time.Duration {
+	negative := msg.Rcode == dns.RcodeNameError || msg.Rcode == dns.RcodeSuccess && len(msg.Answer) == 0
+	if msg.Truncated || !negative && msg.Rcode != dns.RcodeSuccess {
+		return 0
+	}
+	if negative {
+		for _, rr := range msg.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				seconds := min(soa.Hdr.Ttl, soa.Minttl)
+				return min(time.Duration(seconds)*time.Second, c.negativeTTL)
+			}
+		}
+		return 0
+	}
+	seconds := msg.Answer[0].Header().Ttl
+	for _, rr := range msg.Answer[1:] {
+		seconds = min(seconds, rr.Header().Ttl)
+	}
+	return min(time.Duration(seconds)*time.Second, c.maxTTL)
+}
+
+// Len returns how many answers are cached, expired ones included until
+// they are looked up or evicted
+func (c *dnsCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// retryableRcode reports whether a response code says more about the
+// server than about the name
+func retryableRcode(rcode int) bool {
+	return rcode == dns.RcodeServerFailure || rcode == dns.RcodeRefused
+}
+
+// exchange sends a single question to the i-th server and records the
+// outcome against that server's health
+func (dr *DNSResolver) exchange(ctx context.Context, i int, name string, qtype uint16) (*dns.Msg, error) {
+	if isPassive(ctx) {
+		return nil, fmt.Errorf("DNS query failed for %s: %w", name, errPassiveOnly)
+	}
+	if err := dnsQPS.wait(ctx, dr.servers[i]); err != nil {
+		return nil, fmt.Errorf("DNS query failed for %s: %w", name, err)
+	}
+	msg := &dns.Msg{}
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = true
+
+	var response *dns.Msg
+	var err error
+	start := time.Now()
+	if dr.transports[i] == "doh" {
+		response, err = dr.exchangeDoH(ctx, i, msg)
+	} else {
+		response, _, err = dr.client(i).ExchangeContext(ctx, msg, dr.addresses[i])
+	}
+	atomic.AddInt64(&stats.DNSQueries, 1)
+	health := dr.health[i]
+	atomic.AddInt64(&health.queries, 1)
+	if err != nil {
+		// Our own cancellation says nothing about the server
+		if ctx.Err() == nil {
+			health.observe(time.Since(start), false)
+			health.recordFailure(err.Error())
+			if lookupOutcome(err) == "timeout" {
+				atomic.AddInt64(&stats.DNSTimeouts, 1)
+			}
+		}
+		return nil, fmt.Errorf("DNS query failed for %s: %w", name, err)
+	}
+
+	// A truncated UDP answer is missing records; ask the same server again
+	// over TCP rather than use what fit
+	if response.Truncated && dr.transports[i] == "udp" {
+		if err := dnsQPS.wait(ctx, dr.servers[i]); err != nil {
+			return nil, fmt.Errorf("DNS query over TCP failed for %s: %w", name, err)
+		}
+		atomic.AddInt64(&stats.DNSTCPFallbacks, 1)
+		response, _, err = dr.tcpClient(i).ExchangeContext(ctx, msg, dr.addresses[i])
+		atomic.AddInt64(&stats.DNSQueries, 1)
+		atomic.AddInt64(&health.queries, 1)
+		if err != nil {
+			if ctx.Err() == nil {
+				health.observe(time.Since(start), false)
+				health.recordFailure("TCP: " + err.Error())
+			}
+			return nil, fmt.Errorf("DNS query over TCP failed for %s: %w", name, err)
+		}
+	}
+
+	health.observe(time.Since(start), !retryableRcode(response.Rcode))
+	atomic.AddInt64(&stats.DNSRcodes[response.Rcode&0xf], 1)
+	return response, nil
+}
+
+// dnsQPS paces outbound DNS queries to DNS_MAX_QPS overall and
+// DNS_MAX_QPS_PER_SERVER per server address, and a job's queries to its
+// dns_qps=
+var dnsQPS = &queryRateLimits{servers: make(map[string]*tokenBucket)}
+
+type jobQPSContextKey struct{}
+
+// withJobQPS paces the queries made under ctx to rate per second, on top
+// of the server-wide caps
+func withJobQPS(ctx context.Context, rate int) context.Context {
+	return context.WithValue(ctx, jobQPSContextKey{}, newTokenBucket(rate))
+}
+
+type queryRateLimits struct {
+	global  *tokenBucket
+	servers map[string]*tokenBucket
+	mu      sync.Mutex
+}
+
+// wait blocks until a query to server may be sent under both caps, and
+// not while maintenance pauses the job it is for, or until ctx is done.
+// Rates come from the current configuration, so a reload applies to the
+// next query.
+func (l *queryRateLimits) wait(ctx context.Context, server string) error {
+	if err := maintenance.awaitResume(ctx); err != nil {
+		return err
+	}
+	cfg := configFrom(ctx).DNS
+	job, _ := ctx.Value(jobQPSContextKey{}).(*tokenBucket)
+	if cfg.MaxQPS <= 0 && cfg.MaxQPSPerServer <= 0 && job == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	var buckets []*tokenBucket
+	if cfg.MaxQPS > 0 {
+		if l.global == nil {
+			l.global = newTokenBucket(cfg.MaxQPS)
+		}
+		l.global.setRate(cfg.MaxQPS)
+		buckets = append(buckets, l.global)
+	}
+	if cfg.MaxQPSPerServer > 0 {
+		bucket, ok := l.servers[server]
+		if !ok {
+			bucket = newTokenBucket(cfg.MaxQPSPerServer)
+			l.servers[server] = bucket
+		}
+		bucket.setRate(cfg.MaxQPSPerServer)
+		buckets = append(buckets, bucket)
+	}
+	l.mu.Unlock()
+	if job != nil {
+		buckets = append(buckets, job)
+	}
+
+	for n, bucket := range buckets {
+		if err := bucket.wait(ctx); err != nil {
+			// Hand back what was taken so a cancelled query costs nothing
+			for _, taken := range buckets[:n] {
+				taken.refund()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// tokenBucket allows rate events per second with bursts of up to rate.
+// Waiters reserve their token up front, so they are served in order.
+type tokenBucket struct {
+	rate   float64
+	tokens float64 // negative while waiters hold reservations
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (b *tokenBucket) setRate(rate int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = float64(rate)
+}
+
+// reserve takes a token and returns how long to wait before using it
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+1, b.rate)
+}
+
+// wait takes a token, sleeping until it is due. Giving up on ctx returns
+// the token.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	delay := b.reserve()
+	if delay == 0 {
+		return nil
+	}
+	atomic.AddInt64(&stats.DNSQPSDelayed, 1)
+	atomic.AddInt64(&stats.DNSQPSWaiting, 1)
+	defer atomic.AddInt64(&stats.DNSQPSWaiting, -1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.refund()
+		return ctx.Err()
+	}
+}
+
+// exchangeDoH sends msg to the i-th server as an RFC 8484 POST
+func (dr *DNSResolver) exchangeDoH(ctx context.Context, i int, msg *dns.Msg) (*dns.Msg, error) {
+	// The ID is zero on the wire so caches can share answers
+	id := msg.Id
+	msg.Id = 0
+	packed, err := msg.Pack()
+	msg.Id = id
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dr.servers[i], bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := dr.dohClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+
+	response := new(dns.Msg)
+	if err := response.Unpack(body); err != nil {
+		return nil, fmt.Errorf("DoH server sent a bad message: %w", err)
+	}
+	response.Id = id
+	return response, nil
+}
+
+// client returns the client for the i-th server, creating it on first use
+// so that resolver lists with thousands of servers only pay for the ones
+// they query
+func (dr *DNSResolver) client(i int) *dns.Client {
+	dr.mu.RLock()
+	client := dr.clients[i]
+	dr.mu.RUnlock()
+	if client != nil {
+		return client
+	}
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.clients[i] == nil {
+		dr.clients[i] = &dns.Client{
+			Timeout: dr.timeout,
+			Net:     "udp",
+		}
+		if dr.transports[i] == "dot" {
+			_, serverName := dotEndpoint(dr.servers[i])
+			// Timeout would cover the handshake too; split it so a slow
+			// handshake is bounded by DNS_TLS_TIMEOUT alone
+			dr.clients[i] = &dns.Client{
+				Net:          "tcp-tls",
+				DialTimeout:  currentConfig().DNS.TLSTimeout,
+				ReadTimeout:  dr.timeout,
+				WriteTimeout: dr.timeout,
+				TLSConfig:    &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12},
+			}
+		}
+	}
+	return dr.clients[i]
+}
+
+// tcpClient returns the TCP client for the i-th server, creating it on
+// first use
+func (dr *DNSResolver) tcpClient(i int) *dns.Client {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.tcpClients[i] == nil {
+		dr.tcpClients[i] = &dns.Client{
+			Timeout: dr.timeout,
+			Net:     "tcp",
+		}
+	}
+	return dr.tcpClients[i]
+}
+
+// observe adds a query outcome to the server's window. A success while
+// unhealthy, i.e. from a recovery probe, starts the window afresh.
+func (h *resolverHealth) observe(latency time.Duration, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ok && h.unhealthy {
+		h.samples, h.next = 0, 0
+	}
+	h.window[h.next] = resolverSample{latency: latency, ok: ok}
+	h.next = (h.next + 1) % resolverWindow
+	h.samples = min(h.samples+1, resolverWindow)
+	if ok {
+		h.streak = 0
+	} else {
+		h.streak++
+	}
+
+	var latencies []time.Duration
+	for _, sample := range h.window[:h.samples] {
+		if sample.ok {
+			latencies = append(latencies, sample.latency)
+		}
+	}
+	h.successRate = float64(len(latencies)) / float64(h.samples)
+	h.latencyP50 = 0
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		h.latencyP50 = latencies[len(latencies)/2]
+	}
+
+	unhealthy := h.samples >= resolverMinSamples && h.successRate < resolverMinSuccessRate ||
+		h.streak >= resolverMaxStreak
+	if unhealthy && !h.unhealthy {
+		h.probeAt = time.Now().Add(resolverProbeInterval)
+	}
+	h.unhealthy = unhealthy
+}
+
+// weight is the server's share of queries while healthy: its success rate
+// over its median latency
+func (h *resolverHealth) weight() (float64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.unhealthy {
+		return 0, false
+	}
+	latency := h.latencyP50
+	if latency == 0 {
+		latency = resolverAssumedLatency
+	}
+	// Smoothed, so a server with a few failures and little else still
+	// gets enough queries to be judged
+	rate := (h.successRate*float64(h.samples) + 1) / float64(h.samples+2)
+	return rate / latency.Seconds(), true
+}
+
+// claimProbe reports whether an unhealthy server is due a recovery probe,
+// and if so schedules the next one
+func (h *resolverHealth) claimProbe(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.unhealthy || now.Before(h.probeAt) {
+		return false
+	}
+	h.probeAt = now.Add(resolverProbeInterval)
+	return true
+}
+
+// pick chooses the server for a query's next attempt, skipping the ones in
+// tried while others are left. Healthy servers are picked at random,
+// weighted towards fast, reliable ones; an unhealthy server only gets the
+// occasional recovery probe. With none healthy, servers take turns. Large
+// pools (resolver lists) are judged on a random sample of servers per
+// query rather than all of them.
+func (dr *DNSResolver) pick(tried []int) int {
+	if len(dr.servers) == 1 {
+		return 0
+	}
+	now := time.Now()
+	skip := func(i int) bool {
+		return len(tried) < len(dr.servers) && slices.Contains(tried, i)
+	}
+
+	pool := make([]int, 0, min(len(dr.servers), resolverPickSample))
+	if len(dr.servers) <= 2*resolverPickSample {
+		for i := range dr.servers {
+			pool = append(pool, i)
+		}
+	} else {
+		for range resolverPickSample {
+			pool = append(pool, mathrand.IntN(len(dr.servers)))
+		}
+	}
+
+	var candidates []int
+	var weights []float64
+	total := 0.0
+	for _, i := range pool {
+		if skip(i) || slices.Contains(candidates, i) {
+			continue
+		}
+		health := dr.health[i]
+		weight, healthy := health.weight()
+		if !healthy {
+			if health.claimProbe(now) {
+				return i
+			}
+			continue
+		}
+		candidates = append(candidates, i)
+		weights = append(weights, weight)
+		total += weight
+	}
+
+	if len(candidates) == 0 {
+		for {
+			i := int(atomic.AddInt64(&dr.current, 1) % int64(len(dr.servers)))
+			if !skip(i) {
+				return i
+			}
+		}
+	}
+	r := mathrand.Float64() * total
+	for n, weight := range weights {
+		if r < weight {
+			return candidates[n]
+		}
+		r -= weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+// recordFailure counts a failed query against the server
+func (h *resolverHealth) recordFailure(message string) {
+	atomic.AddInt64(&h.failures, 1)
+	h.mu.Lock()
+	h.lastError = message
+	h.lastErrorAt = time.Now()
+	h.mu.Unlock()
+}
+
+// Servers returns the servers the resolver rotates over
+func (dr *DNSResolver) Servers() []string {
+	return append([]string(nil), dr.servers...)
+}
+
+// Health returns a snapshot of per-server query and failure counts
+func (dr *DNSResolver) Health() []ResolverHealth {
+	snapshot := make([]ResolverHealth, len(dr.servers))
+	for i, health := range dr.health {
+		health.mu.Lock()
+		state := "healthy"
+		if health.unhealthy {
+			state = "unhealthy"
+		}
+		snapshot[i] = ResolverHealth{
+			Server:       dr.servers[i],
+			Transport:    dr.transports[i],
+			State:        state,
+			Queries:      atomic.LoadInt64(&health.queries),
+			Failures:     atomic.LoadInt64(&health.failures),
+			SuccessRate:  health.successRate,
+			LatencyP50MS: float64(health.latencyP50.Microseconds()) / 1000,
+			LastError:    health.lastError,
+			LastErrorAt:  health.lastErrorAt,
+		}
+		health.mu.Unlock()
+	}
+	return snapshot
+}
+
+// errResolverNoAnswer is wrapped when a requested resolver does not answer
+var errResolverNoAnswer = errors.New("resolver did not answer")
+
+// maxJobResolvers caps how many servers a single scan may name
+const maxJobResolvers = 8
+
+// parseResolvers validates a comma-separated resolvers= list of IP[:port]
+// entries, defaulting the port to 53, https://host[:port]/dns-query DoH
+// endpoints or tls://host[:port][#name] DoT servers
+func parseResolvers(list string) ([]string, error) {
+	var servers []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if resolverTransport(entry) == "doh" {
+			u, err := url.Parse(entry)
+			switch {
+			case err != nil || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "":
+				return nil, fmt.Errorf("invalid resolver %q: bad DoH URL", entry)
+			case u.Path != "/dns-query":
+				return nil, fmt.Errorf("invalid resolver %q: DoH path must be /dns-query", entry)
+			case !dohHostAllowed(u.Hostname()):
+				return nil, fmt.Errorf("invalid resolver %q: DoH server may not be this host or link-local", entry)
+			}
+			if !containsString(servers, entry) {
+				servers = append(servers, entry)
+			}
+			continue
+		}
+		if resolverTransport(entry) == "dot" {
+			address, serverName := dotEndpoint(entry)
+			host, port, _ := net.SplitHostPort(address)
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("invalid resolver %q: bad port", entry)
+			}
+			if !domainRe.MatchString(host) && net.ParseIP(host) == nil || !domainRe.MatchString(serverName) && net.ParseIP(serverName) == nil {
+				return nil, fmt.Errorf("invalid resolver %q: bad DoT server", entry)
+			}
+			server := "tls://" + address
+			if serverName != host {
+				server += "#" + serverName
+			}
+			if !containsString(servers, server) {
+				servers = append(servers, server)
+			}
+			continue
+		}
+		server, err := parseResolverAddress(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !containsString(servers, server) {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("resolvers is empty")
+	}
+	if len(servers) > maxJobResolvers {
+		return nil, fmt.Errorf("at most %d resolvers may be given", maxJobResolvers)
+	}
+	return servers, nil
+}
+
+// dohHostAllowed reports whether a resolvers= DoH URL may name host: not
+// localhost, nor a loopback, link-local, multicast or unspecified address,
+// which would turn the scan's queries into requests to the server itself
+// or its cloud metadata endpoint
+func dohHostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return !blockedDoHAddress(ip)
+	}
+	return true
+}
+
+// blockedDoHAddress reports whether a resolvers= DoH server may not be
+// reached at ip
+func blockedDoHAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// parseResolverAddress validates a plain IP[:port] server, defaulting the
+// port to 53
+func parseResolverAddress(entry string) (string, error) {
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil {
+		host, port = strings.Trim(entry, "[]"), "53"
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid resolver %q: must be an IP address with optional port", entry)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid resolver %q: bad port", entry)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// ServerCheck is the outcome of asking one server directly
+type ServerCheck struct {
+	Server    string `json:"server"`
+	Transport string `json:"transport"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CheckEach asks every server for the A record of name. A server is OK if
+// it answers with NOERROR.
+func (dr *DNSResolver) CheckEach(ctx context.Context, name string) []ServerCheck {
+	results := make([]ServerCheck, len(dr.servers))
+	var wg sync.WaitGroup
+	for i := range dr.servers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = ServerCheck{Server: dr.servers[i], Transport: dr.transports[i]}
+			response, err := dr.exchange(ctx, i, name, dns.TypeA)
+			switch {
+			case err != nil:
+				results[i].Error = errors.Unwrap(err).Error()
+			case response.Rcode != dns.RcodeSuccess:
+				results[i].Error = dns.RcodeToString[response.Rcode]
+			default:
+				results[i].OK = true
+			}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// checkServers asks every server for the SOA of name and fails unless all
+// of them answer. Any rcode counts as an answer.
+func (dr *DNSResolver) checkServers(ctx context.Context, name string) error {
+	failures := make([]string, len(dr.servers))
+	var wg sync.WaitGroup
+	for i := range dr.servers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := dr.exchange(ctx, i, name, dns.TypeSOA); err != nil {
+				failures[i] = fmt.Sprintf("%s (%v)", dr.servers[i], errors.Unwrap(err))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, failure := range failures {
+		if failure != "" {
+			failed = append(failed, failure)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", errResolverNoAnswer, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// scanResolver returns the resolver a scan request should use: the global
+// pool, or a dedicated one built from resolvers= once every server in it
+// has answered for the target. Either waits the DNS.Timeout of ctx's
+// config, so a job's dns_timeout= applies.
+func scanResolver(ctx context.Context, options url.Values, target string) (*DNSResolver, error) {
+	cfg := configFrom(ctx)
+	list := options.Get("resolvers")
+	if list == "" {
+		return defaultResolver().withTimeout(cfg.DNS.Timeout), nil
+	}
+	servers, err := parseResolvers(list)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := newDNSResolver(servers, cfg.DNS.Timeout)
+	resolver.restrictDoHDials()
+	ctx, cancel := context.WithTimeout(ctx, cfg.DNS.Timeout+time.Second)
+	defer cancel()
+	if err := resolver.checkServers(ctx, target); err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+// writeResolverError reports a scanResolver failure: a bad list is the
+// client's fault, a server that does not answer is an upstream failure
+func writeResolverError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, errResolverNoAnswer) {
+		status = http.StatusBadGateway
+	}
+	http.Error(w, err.Error(), status)
+}
+
+type resolverContextKey struct{}
+
+// withResolver attaches the resolver lookups made under ctx should use
+func withResolver(ctx context.Context, dr *DNSResolver) context.Context {
+	return context.WithValue(ctx, resolverContextKey{}, dr)
+}
+
+// resolverFrom returns the resolver attached to ctx, or the global pool
+func resolverFrom(ctx context.Context) *DNSResolver {
+	if dr, ok := ctx.Value(resolverContextKey{}).(*DNSResolver); ok && dr != nil {
+		return dr
+	}
+	return defaultResolver()
+}
+
+// Enhanced SSE headers with better caching control
+func sseHeader(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
+}
+
+// underDomain reports whether host is domain or one of its subdomains
+func underDomain(host, domain string) bool {
+	host, domain = strings.ToLower(host), strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// apexResult is the synthetic first row of every job: the bare target with
+// its own resolution, whether or not any source reports it. It is counted in
+// unique_hosts but not unique_subdomains.
+func apexResult(ctx context.Context, target string) Result {
+	result := Result{
+		Host:      strings.ToLower(target),
+		Source:    "apex",
+		Status:    "resolved",
+		Timestamp: time.Now(),
+	}
+
+	if isPassive(ctx) {
+		result.Status = "discovered"
+		return result
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, configFrom(ctx).DNS.Timeout)
+	defer cancel()
+	ips, err := resolverFrom(ctx).LookupHostAll(lookupCtx, target)
+	if err != nil {
+		result.Status = "unresolved"
+		result.Error = err.Error()
+		return result
+	}
+	result.IPs = resultIPs(ips)
+	return result
+}
+
+// createJob registers a running job and returns it with its context, a
+// child of ctx that aborting the job cancels. The job's work must run
+// under that context; it is released when the job finishes. A passive
+// profile= keeps that work off the target, and dns_qps= paces its queries.
+func createJob(ctx context.Context, target string, sources []string, options url.Values) (*Job, context.Context) {
+	if passiveProfile(options) {
+		ctx = withPassive(ctx)
+	}
+	qps, _ := strconv.Atoi(options.Get("dns_qps"))
+	if qps > 0 {
+		ctx = withJobQPS(ctx, qps)
+	}
+	ctx, cancel := context.WithCancel(withMaintenancePause(ctx))
+	job := &Job{
+		Target:    target,
+		Sources:   sources,
+		StartTime: time.Now(),
+		Status:    "running",
+		Results:   make(map[string][]Result),
+		Cancel:    cancel,
+		release:   cancel,
+		events:    newJobEventLog(),
+
+		SourceTimings: make(map[string]*SourceTiming),
+		Progress:      newJobProgress(sources),
+		Options:       make(map[string]string),
+		Settings:      newJobSettings(configFrom(ctx), qps, options),
+		PassiveOnly:   isPassive(ctx),
+
+		egressRecorded: make(chan struct{}),
+	}
+	job.pausedAtStart = maintenance.pausedFor()
+	for _, source := range sources {
+		job.SourceTimings[source] = newSourceTiming(job.StartTime)
+	}
+	for key, values := range options {
+		switch key {
+		case "target", "steal":
+			// A takeover applies to the request, not to reruns of the job
+		case "rerun_of":
+			// Reruns compare against the job they were derived from
+			job.ParentID = values[0]
+			job.BaselineID = values[0]
+		case "rerun_changes":
+			job.Changes = strings.Split(values[0], ",")
+		case "debug":
+			// Recording every candidate is expensive, so it is opt-in
+			if values[0] == "true" {
+				job.candidates = newCandidateLog(currentConfig().DNS)
+			}
+			job.Options[key] = values[0]
+		default:
+			job.Options[key] = values[0]
+		}
+	}
+
+	registerJob(job, target)
+	hostIndex.StartJob(target, job.ID)
+
+	atomic.AddInt64(&stats.ActiveJobs, 1)
+	go job.recordEgress()
+	return job, ctx
+}
+
+// registerJob gives job an ID made of prefix and its start time and adds
+// it to the job manager
+func registerJob(job *Job, prefix string) {
+	// Several sources for the same target can start within one second
+	jobManager.mu.Lock()
+	defer jobManager.mu.Unlock()
+	jobID := fmt.Sprintf("%s_%d", prefix, job.StartTime.Unix())
+	for n := 2; jobManager.jobs[jobID] != nil; n++ {
+		jobID = fmt.Sprintf("%s_%d_%d", prefix, job.StartTime.Unix(), n)
+	}
+	job.ID = jobID
+	jobManager.jobs[jobID] = job
+}
+
+// recordEgress stores the scanner's egress IPs on the job at start time.
+// The cached ones will do: they are at most Egress.CacheTTL old.
+func (j *Job) recordEgress() {
+	defer close(j.egressRecorded)
+	ips := egressTracker.Get()
+
+	j.mu.Lock()
+	j.EgressIPs = ips
+	j.mu.Unlock()
+}
+
+// awaitEgress waits until the job's starting egress IPs are recorded, so
+// its sources send nothing before they are, or until ctx is done. Jobs
+// that never record them, such as restored ones, don't wait.
+func (j *Job) awaitEgress(ctx context.Context) {
+	if j.egressRecorded == nil {
+		return
+	}
+	select {
+	case <-j.egressRecorded:
+	case <-ctx.Done():
+	}
+}
+
+// verifyEgress re-checks the egress IPs at completion and warns when they
+// changed mid-job (e.g. NAT failover). The check bypasses the cache, which
+// could still hold the IPs recorded at the start.
+func (j *Job) verifyEgress() {
+	j.awaitEgress(context.Background())
+	ips := egressTracker.Refresh()
+
+	j.mu.Lock()
+	j.EgressIPsAtEnd = ips
+	j.EgressChanged = len(j.EgressIPs) > 0 && !sameIPSet(j.EgressIPs, ips)
+	changed, before := j.EgressChanged, j.EgressIPs
+	j.mu.Unlock()
+	persistJob(j)
+
+	if changed {
+		log.Printf("⚠️  Egress IP changed during job %s: %v -> %v", j.ID, before, ips)
+	}
+}
+
+// AddLookalike records a look-alike domain, reporting false for one
+// already recorded
+func (j *Job) AddLookalike(result Result) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if slices.ContainsFunc(j.Lookalikes, func(r Result) bool { return r.Host == result.Host }) {
+		return false
+	}
+	j.Lookalikes = append(j.Lookalikes, result)
+	return true
+}
+
+// RecordProbe keeps what a probe of one of the job's hosts found for the
+// job's summaries: status, favicon hash, body hash, security headers and
+// page class
+func (j *Job) RecordProbe(host string, response ProbeResponse) {
+	host = strings.ToLower(host)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if response.Error != "no-dns" {
+		if j.ProbeStatuses == nil {
+			j.ProbeStatuses = make(map[string]string)
+		}
+		j.ProbeStatuses[host] = response.Status
+	}
+	if response.FaviconHash != nil {
+		if j.Favicons == nil {
+			j.Favicons = make(map[string]int32)
+		}
+		j.Favicons[host] = *response.FaviconHash
+	}
+	if response.BodySHA256 != "" {
+		if j.BodyHashes == nil {
+			j.BodyHashes = make(map[string]string)
+		}
+		j.BodyHashes[host] = response.BodySHA256
+	}
+	if response.SecurityHeaders != nil {
+		if j.SecurityHeaders == nil {
+			j.SecurityHeaders = make(map[string]*SecurityHeaders)
+		}
+		j.SecurityHeaders[host] = response.SecurityHeaders
+	}
+	if response.Availability != nil {
+		if j.Availability == nil {
+			j.Availability = make(map[string]*AvailabilitySample)
+		}
+		j.Availability[host] = response.Availability
+	}
+	if response.PageClass != "" {
+		if j.PageClasses == nil {
+			j.PageClasses = make(map[string]string)
+		}
+		j.PageClasses[host] = response.PageClass
+	}
+	if response.WildcardResponse {
+		if j.WildcardHosts == nil {
+			j.WildcardHosts = make(map[string]bool)
+		}
+		j.WildcardHosts[host] = true
+	}
+	persistJob(j)
+}
+
+// WildcardBaseline is what a random name under a job's target answered on
+// one scheme and port. A catch-all front end answers every name alike, so
+// probes matching it say nothing about the host.
+type WildcardBaseline struct {
+	URL           string `json:"url"`
+	Status        string `json:"status"`
+	BodySHA256    string `json:"body_sha256,omitempty"`
+	ContentLength int    `json:"content_length"`
+}
+
+// probeBaseline is the baseline of one scheme and port, probed until a
+// probe of it ends in an answer or a definite failure
+type probeBaseline struct {
+	mu       sync.Mutex
+	done     bool
+	baseline *WildcardBaseline
+}
+
+// WildcardBaseline returns the baseline for the scheme and port of
+// targetURL, probing a random name under the job's target on first use.
+// Nil when that name got no answer. The probe outlives ctx's cancellation,
+// as the baseline is the job's and not the request's; one that times out
+// or is reset is tried again by the next caller.
+func (j *Job) WildcardBaseline(ctx context.Context, targetURL string) *WildcardBaseline {
+	parsed, err := url.Parse(targetURL)
+	if err != nil {
+		return nil
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = "80"
+		if parsed.Scheme == "https" {
+			port = "443"
+		}
+	}
+	key := parsed.Scheme + ":" + port
+
+	j.mu.Lock()
+	entry, ok := j.baselines[key]
+	if !ok {
+		if j.baselines == nil {
+			j.baselines = make(map[string]*probeBaseline)
+		}
+		entry = &probeBaseline{}
+		j.baselines[key] = entry
+	}
+	target := j.Target
+	j.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.done {
+		return entry.baseline
+	}
+	ctx = context.WithoutCancel(ctx)
+	host := strings.ToLower(rand.Text())[:12] + "." + target
+	baselineURL := parsed.Scheme + "://" + net.JoinHostPort(host, port) + "/"
+	response := probeURL(ctx, probeHTTPClient(ctx), baselineURL)
+	if response.err != nil && transientProbeError(response.err) {
+		return nil
+	}
+	if response.Status != "0" {
+		entry.baseline = &WildcardBaseline{
+			URL:           baselineURL,
+			Status:        response.Status,
+			BodySHA256:    response.BodySHA256,
+			ContentLength: response.ContentLength,
+		}
+	}
+	entry.done = true
+	j.mu.Lock()
+	if j.WildcardBaselines == nil {
+		j.WildcardBaselines = make(map[string]*WildcardBaseline)
+	}
+	j.WildcardBaselines[key] = entry.baseline
+	j.mu.Unlock()
+	return entry.baseline
+}
+
+// matches reports whether response looks like the baseline: the same
+// status, and the same body or one of the same length (a nonce or a
+// timestamp in the page changes only the hash)
+func (b *WildcardBaseline) matches(response ProbeResponse) bool {
+	if b == nil || response.Status != b.Status {
+		return false
+	}
+	return response.BodySHA256 != "" && response.BodySHA256 == b.BodySHA256 ||
+		response.ContentLength == b.ContentLength
+}
+
+// JobHost is one of a job's hosts merged across the sources that found it
+type JobHost struct {
+	Host      string    `json:"host"`
+	Sources   []string  `json:"sources"`
+	FirstSeen time.Time `json:"first_seen"`
+
+	// The target whose scan found the host, in a multi-target scan
+	Target string `json:"target,omitempty"`
+
+	// Status of the host's latest probe for the job, if it was probed, and
+	// its availability if the probe took samples
+	ProbeStatus  string              `json:"probe_status,omitempty"`
+	Availability *AvailabilitySample `json:"availability,omitempty"`
+}
+
+// MergedHosts returns the job's distinct hosts, lower-cased and sorted
+func (j *Job) MergedHosts() []JobHost {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	merged := make(map[string]*JobHost)
+	for source, results := range j.Results {
+		for _, result := range results {
+			name := strings.ToLower(result.Host)
+			host, ok := merged[name]
+			if !ok {
+				host = &JobHost{Host: name, FirstSeen: result.Timestamp, ProbeStatus: j.ProbeStatuses[name], Availability: j.Availability[name]}
+				merged[name] = host
+			}
+			if !slices.Contains(host.Sources, source) {
+				host.Sources = append(host.Sources, source)
+			}
+			if result.Timestamp.Before(host.FirstSeen) {
+				host.FirstSeen = result.Timestamp
+			}
+		}
+	}
+
+	hosts := make([]JobHost, 0, len(merged))
+	for _, name := range slices.Sorted(maps.Keys(merged)) {
+		host := merged[name]
+		slices.Sort(host.Sources)
+		hosts = append(hosts, *host)
+	}
+	return hosts
+}
+
+// jobHostsHandler serves /api/jobs/<id>/hosts, or with plain=1 just the
+// host names, one per line
+func jobHostsHandler(w http.ResponseWriter, r *http.Request, job *Job) {
+	target := r.URL.Query().Get("target")
+	if target != "" {
+		target = targetKey(target)
+	}
+
+	// A multi-target parent lists its children's hosts, each under the
+	// target it was found for
+	job.mu.RLock()
+	children := slices.Clone(job.Children)
+	parent := job.Targets != nil
+	job.mu.RUnlock()
+	var hosts []JobHost
+	if parent {
+		for _, id := range children {
+			child, ok := lookupJob(id)
+			if !ok || target != "" && child.Target != target {
+				continue
+			}
+			for _, host := range child.MergedHosts() {
+				host.Target = child.Target
+				hosts = append(hosts, host)
+			}
+		}
+		slices.SortFunc(hosts, func(a, b JobHost) int {
+			return cmp.Or(strings.Compare(a.Host, b.Host), strings.Compare(a.Target, b.Target))
+		})
+	} else if target == "" || job.Target == target {
+		hosts = job.MergedHosts()
+	}
+	if hosts == nil {
+		hosts = []JobHost{}
+	}
+	if r.URL.Query().Get("plain") == "1" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, host := range hosts {
+			bw.WriteString(host.Host + "\n")
+		}
+		bw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"job":   job.ID,
+		"count": len(hosts),
+		"hosts": hosts,
+	})
+}
+
+// JobDiff is what changed between two scans of a target: the hosts only
+// the later one found, the hosts it no longer found, and the hosts both
+// probed whose probe status differs. Hosts are compared lower-cased.
+type JobDiff struct {
+	Target    string        `json:"target"`
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Added     []string      `json:"added"`
+	Removed   []string      `json:"removed"`
+	Changed   []ProbeChange `json:"probe_changed"`
+	Unchanged int           `json:"unchanged"`
+}
+
+// ProbeChange is a host whose probe status differs between two scans
+type ProbeChange struct {
+	Host string `json:"host"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Empty reports whether nothing changed, for notifications that should
+// only go out when something did
+func (d JobDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// diffJobs compares the distinct hosts of two jobs, from the earlier to
+// the later one
+func diffJobs(from, to *Job) JobDiff {
+	fromHosts, toHosts := from.MergedHosts(), to.MergedHosts()
+	before := make(map[string]struct{}, len(fromHosts))
+	status := make(map[string]string, len(fromHosts))
+	for _, host := range fromHosts {
+		before[host.Host] = struct{}{}
+		status[host.Host] = host.ProbeStatus
+	}
+	after := make(map[string]struct{}, len(toHosts))
+	for _, host := range toHosts {
+		after[host.Host] = struct{}{}
+	}
+
+	comparison := compareRuns(before, after)
+	diff := JobDiff{
+		Target:    to.Target,
+		From:      from.ID,
+		To:        to.ID,
+		Added:     comparison.OnlyB,
+		Removed:   comparison.OnlyA,
+		Changed:   []ProbeChange{},
+		Unchanged: comparison.Shared,
+	}
+	// Hosts come sorted, so the changes are too
+	for _, host := range toHosts {
+		previous, ok := status[host.Host]
+		if !ok || previous == "" || host.ProbeStatus == "" || previous == host.ProbeStatus {
+			continue
+		}
+		diff.Changed = append(diff.Changed, ProbeChange{Host: host.Host, From: previous, To: host.ProbeStatus})
+		diff.Unchanged--
+	}
+	return diff
+}
+
+// managedJobs returns the jobs in memory, taken under the manager's lock
+// so that work on them, such as ending them, can go on without it
+func managedJobs() []*Job {
+	jobManager.mu.RLock()
+	defer jobManager.mu.RUnlock()
+	return slices.Collect(maps.Values(jobManager.jobs))
+}
+
+// completedJobs returns the completed jobs of target, oldest first
+func completedJobs(target string) []*Job {
+	jobManager.mu.RLock()
+	var jobs []*Job
+	for _, job := range jobManager.jobs {
+		job.mu.RLock()
+		if job.Status == "completed" && targetKey(job.Target) == target {
+			jobs = append(jobs, job)
+		}
+		job.mu.RUnlock()
+	}
+	jobManager.mu.RUnlock()
+
+	slices.SortFunc(jobs, func(a, b *Job) int {
+		return cmp.Or(a.StartTime.Compare(b.StartTime), strings.Compare(a.ID, b.ID))
+	})
+	return jobs
+}
+
+// diffHandler compares two scans of a target (GET /api/diff). from= and
+// to= name the jobs; without them it takes the two most recent completed
+// jobs of target=, or the latest one before to= or after from=.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+
+	var from, to *Job
+	for _, side := range []struct {
+		name string
+		job  **Job
+	}{{"from", &from}, {"to", &to}} {
+		id := query.Get(side.name)
+		if id == "" {
+			continue
+		}
+		job, ok := lookupJob(id)
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s job %s not found", side.name, id), http.StatusNotFound)
+			return
+		}
+		*side.job = job
+	}
+
+	target := query.Get("target")
+	switch {
+	case target != "":
+		target = targetKey(target)
+	case from != nil:
+		target = targetKey(from.Target)
+	case to != nil:
+		target = targetKey(to.Target)
+	default:
+		http.Error(w, "missing target parameter", http.StatusBadRequest)
+		return
+	}
+	for _, job := range []*Job{from, to} {
+		if job != nil && targetKey(job.Target) != target {
+			http.Error(w, fmt.Sprintf("job %s is a scan of %s, not %s", job.ID, job.Target, target), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if from == nil || to == nil {
+		jobs := completedJobs(target)
+		switch {
+		case from == nil && to == nil:
+			if len(jobs) >= 2 {
+				from, to = jobs[len(jobs)-2], jobs[len(jobs)-1]
+			}
+		case from == nil:
+			for _, job := range jobs {
+				if job.StartTime.Before(to.StartTime) {
+					from = job
+				}
+			}
+		default:
+			for _, job := range slices.Backward(jobs) {
+				if job.StartTime.After(from.StartTime) {
+					to = job
+					break
+				}
+			}
+		}
+		if from == nil || to == nil {
+			http.Error(w, fmt.Sprintf("not enough completed scans of %s to compare", target), http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diffJobs(from, to))
+}
+
+// FaviconGroup is a set of the job's hosts serving the same favicon
+type FaviconGroup struct {
+	Hash  int32    `json:"hash"`
+	Count int      `json:"count"`
+	Hosts []string `json:"hosts"`
+}
+
+// FaviconGroups groups the job's hosts by favicon hash, largest group first
+func (j *Job) FaviconGroups() []FaviconGroup {
+	j.mu.RLock()
+	byHash := make(map[int32][]string)
+	for host, hash := range j.Favicons {
+		byHash[hash] = append(byHash[hash], host)
+	}
+	j.mu.RUnlock()
+
+	groups := make([]FaviconGroup, 0, len(byHash))
+	for hash, hosts := range byHash {
+		slices.Sort(hosts)
+		groups = append(groups, FaviconGroup{Hash: hash, Count: len(hosts), Hosts: hosts})
+	}
+	slices.SortFunc(groups, func(a, b FaviconGroup) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Hash, b.Hash))
+	})
+	return groups
+}
+
+// BodyGroup is a set of the job's hosts serving the same response body
+type BodyGroup struct {
+	SHA256 string   `json:"body_sha256"`
+	Count  int      `json:"count"`
+	Hosts  []string `json:"hosts"`
+}
+
+// BodyGroups groups the job's hosts by body hash, largest group first, so
+// hosts serving one default page collapse into a single entry
+func (j *Job) BodyGroups() []BodyGroup {
+	j.mu.RLock()
+	byHash := make(map[string][]string)
+	for host, hash := range j.BodyHashes {
+		byHash[hash] = append(byHash[hash], host)
+	}
+	j.mu.RUnlock()
+
+	groups := make([]BodyGroup, 0, len(byHash))
+	for hash, hosts := range byHash {
+		slices.Sort(hosts)
+		groups = append(groups, BodyGroup{SHA256: hash, Count: len(hosts), Hosts: hosts})
+	}
+	slices.SortFunc(groups, func(a, b BodyGroup) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.SHA256, b.SHA256))
+	})
+	return groups
+}
+
+// SecuritySummary counts the job's probed hosts lacking each security
+// header, and those whose plain-HTTP site doesn't redirect to HTTPS
+type SecuritySummary struct {
+	Hosts           int                 `json:"hosts"`
+	Missing         map[string]int      `json:"missing"`
+	MissingHosts    map[string][]string `json:"missing_hosts"`
+	NoHTTPSRedirect []string            `json:"no_https_redirect"`
+}
+
+func (j *Job) SecuritySummary() SecuritySummary {
+	summary := SecuritySummary{
+		Missing:         make(map[string]int),
+		MissingHosts:    make(map[string][]string),
+		NoHTTPSRedirect: []string{},
+	}
+	for _, name := range securityHeaderNames {
+		summary.Missing[name] = 0
+		summary.MissingHosts[name] = []string{}
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	for _, host := range slices.Sorted(maps.Keys(j.SecurityHeaders)) {
+		report := j.SecurityHeaders[host]
+		summary.Hosts++
+		for name, header := range report.byName() {
+			if !header.Present {
+				summary.Missing[name]++
+				summary.MissingHosts[name] = append(summary.MissingHosts[name], host)
+			}
+		}
+		if report.HTTPRedirectsToHTTPS != nil && !*report.HTTPRedirectsToHTTPS {
+			summary.NoHTTPSRedirect = append(summary.NoHTTPSRedirect, host)
+		}
+	}
+	return summary
+}
+
+// AddResult stores result and queues it for the post-processors, whose
+// tags reach the stored result once its batch is through. It returns the
+// stored result as it is now.
+func (j *Job) AddResult(source string, result Result) Result {
+	if processors.Active() && !j.shadow {
+		j.queueProcessing(source, result)
+	}
+
+	result.setCloudProvider()
+	if !j.shadow {
+		hostIndex.Record(j.Target, j.ID, source, result)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.Results[source] == nil {
+		j.Results[source] = make([]Result, 0)
+	}
+	j.Results[source] = append(j.Results[source], result)
+	j.resultsSorted = false
+	if source != "apex" && !j.shadow {
+		atomic.AddInt64(&stats.TotalSubdomains, 1)
+	}
+	switch result.Status {
+	case "dangling-cname", "unbound-ip":
+		if j.Dangling == nil {
+			j.Dangling = make(map[string]int)
+		}
+		j.Dangling[result.Status]++
+	}
+
+	if j.hosts == nil {
+		j.hosts = make(map[string]struct{})
+	}
+	if _, seen := j.hosts[result.Host]; !seen {
+		j.hosts[result.Host] = struct{}{}
+		j.UniqueHosts++
+		if !strings.EqualFold(result.Host, j.Target) {
+			j.UniqueSubdomains++
+		}
+	}
+
+	// A host counts once, under the first provider seen for it, moving out
+	// of "other" if a later answer puts it in a known range; one only seen
+	// passively so far counts when it first comes with addresses
+	if len(result.IPs) > 0 {
+		if j.hostProviders == nil {
+			j.hostProviders = make(map[string]string)
+			j.HostsByProvider = make(map[string]int)
+		}
+		provider := result.CloudProvider
+		if provider == "" {
+			provider = "other"
+		}
+		if previous, seen := j.hostProviders[result.Host]; !seen || previous == "other" && provider != "other" {
+			if seen {
+				if j.HostsByProvider[previous]--; j.HostsByProvider[previous] == 0 {
+					delete(j.HostsByProvider, previous)
+				}
+			}
+			j.hostProviders[result.Host] = provider
+			j.HostsByProvider[provider]++
+		}
+	}
+	return result
+}
+
+// Hosts returns the job's deduplicated host set across all sources
+func (j *Job) Hosts() map[string]struct{} {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	hosts := make(map[string]struct{})
+	for _, results := range j.Results {
+		for _, result := range results {
+			hosts[result.Host] = struct{}{}
+		}
+	}
+	return hosts
+}
+
+// UseResolver records the resolver the job's lookups go to
+func (j *Job) UseResolver(dr *DNSResolver) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.resolver = dr
+	j.Resolvers = dr.Servers()
+	if dr.base != nil {
+		dr = dr.base
+	}
+	j.CustomResolvers = dr != defaultResolver()
+}
+
+// Resolver returns the resolver the job's lookups go to, so follow-up
+// lookups for the job see the same view of DNS as the scan did
+func (j *Job) Resolver() *DNSResolver {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if j.resolver == nil {
+		return defaultResolver()
+	}
+	return j.resolver
+}
+
+// elapsed returns how long the job has run, or ran, less the time
+// maintenance held it paused. Callers must hold j.mu.
+func (j *Job) elapsed() time.Duration {
+	if !j.EndTime.IsZero() {
+		return j.Duration
+	}
+	return time.Since(j.StartTime) - (maintenance.pausedFor() - j.pausedAtStart)
+}
+
+// finish records the end of the job exactly once; later calls are no-ops.
+// Callers must hold j.mu.
+func (j *Job) finish() {
+	if !j.EndTime.IsZero() {
+		return
+	}
+
+	j.EndTime = time.Now()
+	paused := maintenance.pausedFor()
+	j.Paused = paused - j.pausedAtStart
+	j.Duration = j.EndTime.Sub(j.StartTime) - j.Paused
+	for _, timing := range j.SourceTimings {
+		if timing.End.IsZero() {
+			timing.stop(j.EndTime, paused)
+		}
+		if timing.Outcome == "" {
+			timing.Outcome = "complete"
+		}
+	}
+	if j.candidates != nil {
+		j.candidates.close()
+	}
+	j.Progress.settle()
+	if j.events != nil {
+		j.lastEvent = j.events.close()
+		j.events = nil
+	}
+	if j.orphaned != nil {
+		j.orphaned.Stop()
+	}
+	if j.CustomResolvers {
+		j.ResolverHealth = j.resolver.Health()
+	}
+	if j.release != nil {
+		j.release()
+	}
+}
+
+// InterceptionAdvisory is sent as an "interception" event when the job's
+// DNS path answers for names that cannot exist
+type InterceptionAdvisory struct {
+	IPs     []string `json:"ips"`
+	Message string   `json:"message"`
+}
+
+// Interception checks the job's DNS path for interception the first time a
+// source asks, warning that source's client and marking the job tainted
+// when it is found. Returns the interception addresses, if any.
+func (j *Job) Interception(ctx context.Context) map[string]bool {
+	if j == nil {
+		return nil
+	}
+	j.interception.Do(func() {
+		ips, intercepted := resolverFrom(ctx).DetectInterception(ctx)
+		if !intercepted {
+			return
+		}
+		addresses := make([]string, len(ips))
+		poison := make(map[string]bool, len(ips))
+		for i, ip := range ips {
+			addresses[i] = ip.String()
+			poison[addresses[i]] = true
+		}
+
+		j.mu.Lock()
+		j.Tainted = true
+		j.InterceptionIPs = addresses
+		j.poison = poison
+		j.mu.Unlock()
+
+		message := fmt.Sprintf("Your DNS path appears to be intercepted by %s: names that cannot exist resolve there. "+
+			"Results are unreliable; consider resolvers= with servers you trust, or DoH.", strings.Join(addresses, ", "))
+		log.Printf("Job %s: %s", j.ID, message)
+		run := sourceRunFrom(ctx)
+		run.Event("interception", InterceptionAdvisory{IPs: addresses, Message: message})
+		run.Notice("warning", "%s", message)
+	})
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.poison
+}
+
+// FinishSource records how one of the job's sources ended (complete,
+// partial, cancelled, ...). The job itself may still be running others.
+func (j *Job) FinishSource(source, outcome string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	timing, ok := j.SourceTimings[source]
+	if !ok {
+		return
+	}
+	if timing.End.IsZero() {
+		timing.stop(time.Now(), maintenance.pausedFor())
+	}
+	timing.Outcome = outcome
+	j.Progress.Finish(source, outcome)
+}
+
+// end finishes the job with status and reports true, or reports false if
+// it had already finished. A job leaves ActiveJobs here, exactly once,
+// however many of Complete, Fail and abortJob reach it.
+func (j *Job) end(status string) bool {
+	j.drainProcessing()
+
+	j.mu.Lock()
+	if !j.EndTime.IsZero() {
+		j.mu.Unlock()
+		return false
+	}
+	j.Status = status
+	j.finish()
+	parent := j.Targets != nil
+	j.mu.Unlock()
+
+	// Only the children of a multi-target scan count as active
+	if !parent {
+		atomic.AddInt64(&stats.ActiveJobs, -1)
+	}
+	persistJob(j)
+	return true
+}
+
+// Complete marks the job completed unless it already ended, say aborted
+func (j *Job) Complete() {
+	if j.end("completed") {
+		atomic.AddInt64(&stats.CompletedJobs, 1)
+		go j.verifyEgress()
+	}
+}
+
+func (j *Job) Fail(err error) {
+	if j.end(fmt.Sprintf("failed: %v", err)) {
+		atomic.AddInt64(&stats.FailedJobs, 1)
+		go j.verifyEgress()
+	}
+}
+
+// Egress IP discovery with a short-lived cache to avoid per-job lookups
+type EgressTracker struct {
+	ips       []string
+	fetchedAt time.Time
+	mu        sync.Mutex
+}
+
+var egressTracker = &EgressTracker{}
+
+// Get returns the cached egress IPs, refreshing them once the cache expires
+func (et *EgressTracker) Get() []string {
+	cfg := currentConfig()
+	et.mu.Lock()
+	defer et.mu.Unlock()
+
+	if et.ips != nil && time.Since(et.fetchedAt) < cfg.Egress.CacheTTL {
+		return et.ips
+	}
+	return et.fetch(cfg)
+}
+
+// Refresh looks the egress IPs up again whatever the cache holds, and
+// caches the answer
+func (et *EgressTracker) Refresh() []string {
+	cfg := currentConfig()
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	return et.fetch(cfg)
+}
+
+// fetch does the lookup for Get and Refresh, which hold et.mu
+func (et *EgressTracker) fetch(cfg *Config) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Egress.Timeout)
+	defer cancel()
+
+	et.ips = lookupEgressIPs(ctx)
+	et.fetchedAt = time.Now()
+	return et.ips
+}
+
+// lookupEgressIPs asks the configured echo endpoints for our public
+// addresses, one per address family: each family takes the first endpoint
+// that answers over it. Falls back to the local interface addresses when
+// no endpoint answers over either.
+func lookupEgressIPs(ctx context.Context) []string {
+	cfg := configFrom(ctx)
+	var ips, failures []string
+	for _, family := range []string{"4", "6"} {
+		for _, endpoint := range cfg.Egress.EchoEndpoints {
+			endpoint = strings.TrimSpace(endpoint)
+			if endpoint == "" {
+				continue
+			}
+
+			var ip net.IP
+			var err error
+			if strings.HasPrefix(endpoint, "stun:") {
+				ip, err = stunEgressIP(ctx, "udp"+family, strings.TrimPrefix(endpoint, "stun:"))
+			} else {
+				ip, err = httpEgressIP(ctx, "tcp"+family, endpoint)
+			}
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("Egress lookup via %s over IPv%s failed: %v", endpoint, family, err))
+				continue
+			}
+			if !containsString(ips, ip.String()) {
+				ips = append(ips, ip.String())
+			}
+			break
+		}
+	}
+	if len(ips) > 0 {
+		return ips
+	}
+
+	// A host without one of the families fails every lookup over it, so
+	// the failures are only worth reporting when both came up empty
+	for _, failure := range failures {
+		log.Print(failure)
+	}
+	return localInterfaceIPs()
+}
+
+// httpEgressIP queries a plain-text ip-echo service over network (tcp4 or
+// tcp6) through the outbound proxy, so the proxy's exit IP is reported
+// when one is set.
+func httpEgressIP(ctx context.Context, network, endpoint string) (net.IP, error) {
+	cfg := configFrom(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+
+	client := &http.Client{Timeout: cfg.Egress.Timeout, Transport: egressTransport(network)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP in response: %q", strings.TrimSpace(string(body)))
+	}
+	return ip, nil
+}
+
+var (
+	egressTransports     = make(map[string]*http.Transport)
+	egressTransportsLock sync.Mutex
+)
+
+// egressTransport is the outbound transport whose connections are made
+// over network only
+func egressTransport(network string) *http.Transport {
+	egressTransportsLock.Lock()
+	defer egressTransportsLock.Unlock()
+	if transport, ok := egressTransports[network]; ok {
+		return transport
+	}
+	var dialer net.Dialer
+	transport := newOutboundTransport()
+	transport.DialContext = func(ctx context.Context, _, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+	egressTransports[network] = transport
+	return transport
+}
+
+// stunEgressIP sends a STUN binding request (RFC 5389) over network (udp4
+// or udp6) and decodes the XOR-MAPPED-ADDRESS attribute from the response
+func stunEgressIP(ctx context.Context, network, server string) (net.IP, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	magicCookie := []byte{0x21, 0x12, 0xA4, 0x42}
+	request := make([]byte, 20)
+	request[1] = 0x01 // binding request
+	copy(request[4:8], magicCookie)
+	if _, err := rand.Read(request[8:20]); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, 512)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, err
+	}
+	if n < 20 || response[0] != 0x01 || response[1] != 0x01 {
+		return nil, fmt.Errorf("unexpected STUN response")
+	}
+
+	// Walk the attributes looking for XOR-MAPPED-ADDRESS (0x0020)
+	for offset := 20; offset+4 <= n; {
+		attrType := int(response[offset])<<8 | int(response[offset+1])
+		attrLen := int(response[offset+2])<<8 | int(response[offset+3])
+		value := offset + 4
+		if value+attrLen > n {
+			break
+		}
+
+		// An IPv4 address is XORed with the magic cookie, an IPv6 one with
+		// the cookie and the transaction ID
+		if attrType == 0x0020 && attrLen >= 8 {
+			size := 0
+			switch response[value+1] {
+			case 0x01:
+				size = net.IPv4len
+			case 0x02:
+				size = net.IPv6len
+			}
+			if size > 0 && attrLen >= 4+size {
+				key := append(slices.Clone(magicCookie), request[8:20]...)
+				ip := make(net.IP, size)
+				for i := range ip {
+					ip[i] = response[value+4+i] ^ key[i]
+				}
+				return ip, nil
+			}
+		}
+
+		// Attributes are padded to 4-byte boundaries
+		offset = value + (attrLen+3)&^3
+	}
+
+	return nil, fmt.Errorf("no XOR-MAPPED-ADDRESS in STUN response")
+}
+
+// localInterfaceIPs lists global unicast addresses of the local interfaces
+func localInterfaceIPs() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Printf("Failed to list interface addresses: %v", err)
+		return []string{}
+	}
+
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			ips = append(ips, ipNet.IP.String())
+		}
+	}
+	return ips
+}
+
+func sameIPSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]struct{}, len(a))
+	for _, ip := range a {
+		set[ip] = struct{}{}
+	}
+	for _, ip := range b {
+		if _, ok := set[ip]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Results go through the post-processors in batches of up to
+// processBatchSize, each taking what arrived within processBatchWait of
+// its first result. A job ending waits up to processDrainWait for the
+// batches still queued.
+const (
+	processBatchSize = 100
+	processBatchWait = 500 * time.Millisecond
+	processDrainWait = 30 * time.Second
+)
+
+// processingQueue feeds a job's results to the post-processors off the
+// goroutines adding them, so a slow stage holds back tags, not sources.
+// closing is closed once the job ends, and done once the queue is drained.
+type processingQueue struct {
+	mu      sync.Mutex
+	pending []queuedResult
+	wake    chan struct{}
+	closing chan struct{}
+	closed  bool
+	done    chan struct{}
+}
+
+type queuedResult struct {
+	source string
+	result Result
+}
+
+// queueProcessing queues result for the post-processors, starting the
+// job's queue with its first result. Results arriving after the job ended
+// are not processed.
+func (j *Job) queueProcessing(source string, result Result) {
+	j.mu.Lock()
+	queue := j.processing
+	if queue == nil {
+		queue = &processingQueue{
+			wake:    make(chan struct{}, 1),
+			closing: make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		j.processing = queue
+		go j.runProcessing(queue)
+	}
+	j.mu.Unlock()
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.closed {
+		return
+	}
+	queue.pending = append(queue.pending, queuedResult{source: source, result: result})
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runProcessing applies the pipeline to the queue batch by batch and merges
+// the tags and severity it adds into the stored results, until the queue
+// is closed and empty
+func (j *Job) runProcessing(queue *processingQueue) {
+	defer close(queue.done)
+	for {
+		queue.mu.Lock()
+		if len(queue.pending) == 0 {
+			closed := queue.closed
+			queue.mu.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case <-queue.wake:
+			case <-queue.closing:
+			}
+			continue
+		}
+		if len(queue.pending) < processBatchSize && !queue.closed {
+			queue.mu.Unlock()
+			timer := time.NewTimer(processBatchWait)
+			select {
+			case <-timer.C:
+			case <-queue.closing:
+			}
+			timer.Stop()
+			queue.mu.Lock()
+		}
+		batch := queue.pending[:min(len(queue.pending), processBatchSize)]
+		queue.pending = queue.pending[len(batch):]
+		queue.mu.Unlock()
+
+		results := make([]Result, len(batch))
+		for i, queued := range batch {
+			results[i] = queued.result
+		}
+		j.mergeProcessed(batch, processors.Apply(withResolver(context.Background(), j.Resolver()), results))
+	}
+}
+
+// mergeProcessed adds what the post-processors gave each result of batch
+// to the job's stored copies of it and to the host index
+func (j *Job) mergeProcessed(batch []queuedResult, processed []Result) {
+	var tagged []Result
+	j.mu.Lock()
+	for i, queued := range batch {
+		result := processed[i]
+		if len(result.Tags) == len(queued.result.Tags) && result.Severity == queued.result.Severity {
+			continue
+		}
+		stored := j.Results[queued.source]
+		for k := range stored {
+			if stored[k].Host != result.Host {
+				continue
+			}
+			addTags(&stored[k], result.Tags...)
+			if result.Severity != "" {
+				stored[k].Severity = result.Severity
+			}
+		}
+		tagged = append(tagged, result)
+	}
+	ended := !j.EndTime.IsZero()
+	j.mu.Unlock()
+
+	for _, result := range tagged {
+		hostIndex.Tag(j.Target, result.Host, result.Tags)
+	}
+	// Batches that missed processDrainWait still reach the store
+	if ended && len(tagged) > 0 {
+		persistJob(j)
+	}
+}
+
+// drainProcessing closes the job's processing queue and waits, up to
+// processDrainWait, for the results still in it, so the job ends with
+// their tags
+func (j *Job) drainProcessing() {
+	j.mu.RLock()
+	queue := j.processing
+	j.mu.RUnlock()
+	if queue == nil {
+		return
+	}
+
+	queue.mu.Lock()
+	if !queue.closed {
+		queue.closed = true
+		close(queue.closing)
+	}
+	queue.mu.Unlock()
+
+	select {
+	case <-queue.done:
+	case <-time.After(processDrainWait):
+		log.Printf("Job %s: post-processing still running after %v, ending without waiting", j.ID, processDrainWait)
+	}
+}
+
+// Result post-processing: an ordered pipeline of processors that tag and
+// classify each committed Result. Processors are configured from a JSON
+// rules file and each one runs under its own deadline.
+type ResultProcessor interface {
+	Name() string
+	Process(ctx context.Context, results []Result) error
+}
+
+type processorStage struct {
+	processor ResultProcessor
+	enabled   bool
+	timeout   time.Duration
+}
+
+type ProcessorPipeline struct {
+	stages []processorStage
+}
+
+// Rules file format: {"processors": [{"type": "regex", ...}, ...]}
+type processorRules struct {
+	Processors []processorRule `json:"processors"`
+}
+
+type processorRule struct {
+	Type     string         `json:"type"`
+	Name     string         `json:"name"`
+	Enabled  *bool          `json:"enabled"`
+	Timeout  string         `json:"timeout"`
+	Pattern  string         `json:"pattern"`
+	CIDRs    []string       `json:"cidrs"`
+	Tags     []string       `json:"tags"`
+	URL      string         `json:"url"`
+	Severity []severityRule `json:"severity"`
+}
+
+type severityRule struct {
+	Tag      string `json:"tag"`
+	Pattern  string `json:"pattern"`
+	Severity string `json:"severity"`
+}
+
+func initializeProcessors() {
+	cfg := currentConfig()
+	processors = &ProcessorPipeline{}
+	if cfg.Processing.RulesFile == "" {
+		return
+	}
+
+	pipeline, err := loadProcessorPipeline(cfg.Processing.RulesFile)
+	if err != nil {
+		log.Printf("Failed to load post-processing rules from %s: %v", cfg.Processing.RulesFile, err)
+		return
+	}
+	processors = pipeline
+	log.Printf("Loaded %d result post-processors from %s", len(pipeline.stages), cfg.Processing.RulesFile)
+}
+
+func loadProcessorPipeline(path string) (*ProcessorPipeline, error) {
+	cfg := currentConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules processorRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file: %w", err)
+	}
+
+	pipeline := &ProcessorPipeline{}
+	for i, rule := range rules.Processors {
+		processor, err := newResultProcessor(rule)
+		if err != nil {
+			return nil, fmt.Errorf("processor %d (%s): %w", i, rule.Type, err)
+		}
+
+		stage := processorStage{
+			processor: processor,
+			enabled:   rule.Enabled == nil || *rule.Enabled,
+			timeout:   cfg.Processing.DefaultTimeout,
+		}
+		if rule.Timeout != "" {
+			timeout, err := time.ParseDuration(rule.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("processor %d (%s): invalid timeout: %w", i, rule.Type, err)
+			}
+			stage.timeout = timeout
+		}
+		pipeline.stages = append(pipeline.stages, stage)
+	}
+	return pipeline, nil
+}
+
+func newResultProcessor(rule processorRule) (ResultProcessor, error) {
+	name := rule.Name
+	if name == "" {
+		name = rule.Type
+	}
+
+	switch rule.Type {
+	case "regex":
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &regexTagger{name: name, pattern: pattern, tags: rule.Tags}, nil
+	case "ip_range":
+		tagger := &ipRangeTagger{name: name, ranges: &prefixTrie[struct{}]{}, tags: rule.Tags}
+		for _, cidr := range rule.CIDRs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			tagger.ranges.Insert(network, struct{}{})
+		}
+		return tagger, nil
+	case "severity":
+		assigner := &severityAssigner{name: name}
+		for _, sr := range rule.Severity {
+			compiled := compiledSeverityRule{tag: sr.Tag, severity: sr.Severity}
+			if sr.Pattern != "" {
+				pattern, err := regexp.Compile(sr.Pattern)
+				if err != nil {
+					return nil, err
+				}
+				compiled.pattern = pattern
+			}
+			assigner.rules = append(assigner.rules, compiled)
+		}
+		return assigner, nil
+	case "hook":
+		if _, err := url.ParseRequestURI(rule.URL); err != nil {
+			return nil, fmt.Errorf("invalid hook url: %w", err)
+		}
+		return &hookProcessor{name: name, url: rule.URL}, nil
+	}
+	return nil, fmt.Errorf("unknown processor type %q", rule.Type)
+}
+
+// Active reports whether any stage is enabled
+func (p *ProcessorPipeline) Active() bool {
+	if p == nil {
+		return false
+	}
+	for _, stage := range p.stages {
+		if stage.enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply runs every enabled stage in order under base, which carries the
+// job's resolver. A stage that errors or misses its deadline is skipped and
+// the results from the previous stage are kept.
+func (p *ProcessorPipeline) Apply(base context.Context, results []Result) []Result {
+	for _, stage := range p.stages {
+		if !stage.enabled {
+			continue
+		}
+
+		working := make([]Result, len(results))
+		for i, result := range results {
+			working[i] = result
+			working[i].Tags = append([]string(nil), result.Tags...)
+		}
+
+		ctx, cancel := context.WithTimeout(base, stage.timeout)
+		done := make(chan error, 1)
+		go func() { done <- stage.processor.Process(ctx, working) }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("Post-processor %s failed: %v", stage.processor.Name(), err)
+			} else {
+				results = working
+			}
+		case <-ctx.Done():
+			log.Printf("Post-processor %s timed out after %v", stage.processor.Name(), stage.timeout)
+		}
+		cancel()
+	}
+	return results
+}
+
+func (p *ProcessorPipeline) Describe() []map[string]interface{} {
+	described := make([]map[string]interface{}, 0, len(p.stages))
+	for _, stage := range p.stages {
+		described = append(described, map[string]interface{}{
+			"name":    stage.processor.Name(),
+			"enabled": stage.enabled,
+			"timeout": stage.timeout.String(),
+		})
+	}
+	return described
+}
+
+func addTags(result *Result, tags ...string) {
+	for _, tag := range tags {
+		exists := false
+		for _, existing := range result.Tags {
+			if existing == tag {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			result.Tags = append(result.Tags, tag)
+		}
+	}
+}
+
+// regexTagger tags hosts whose name matches a pattern
+type regexTagger struct {
+	name    string
+	pattern *regexp.Regexp
+	tags    []string
+}
+
+func (t *regexTagger) Name() string { return t.name }
+
+func (t *regexTagger) Process(ctx context.Context, results []Result) error {
+	for i := range results {
+		if t.pattern.MatchString(results[i].Host) {
+			addTags(&results[i], t.tags...)
+		}
+	}
+	return nil
+}
+
+// ipRangeTagger tags hosts resolving into any of the configured networks
+type ipRangeTagger struct {
+	name   string
+	ranges *prefixTrie[struct{}]
+	tags   []string
+}
+
+func (t *ipRangeTagger) Name() string { return t.name }
+
+// ipRangeLookups bounds the lookups an ipRangeTagger runs at once
+const ipRangeLookups = 10
+
+// Process uses the addresses a result came with, and looks up each other
+// host of the batch once
+func (t *ipRangeTagger) Process(ctx context.Context, results []Result) error {
+	resolved := make(map[string][]net.IP)
+	var lookups []string
+	for _, result := range results {
+		host := strings.ToLower(result.Host)
+		if _, ok := resolved[host]; ok {
+			continue
+		}
+		if len(result.IPs) == 0 {
+			resolved[host] = nil
+			lookups = append(lookups, host)
+			continue
+		}
+		ips := make([]net.IP, 0, len(result.IPs))
+		for _, address := range result.IPs {
+			if ip := net.ParseIP(address.Address); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		resolved[host] = ips
+	}
+
+	var mu sync.Mutex
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(ipRangeLookups)
+	for _, host := range lookups {
+		g.Go(func() error {
+			ips, err := resolverFrom(gctx).LookupHost(gctx, host)
+			if err != nil {
+				return gctx.Err()
+			}
+			mu.Lock()
+			resolved[host] = ips
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	for i := range results {
+		if t.matches(resolved[strings.ToLower(results[i].Host)]) {
+			addTags(&results[i], t.tags...)
+		}
+	}
+	return nil
+}
+
+func (t *ipRangeTagger) matches(ips []net.IP) bool {
+	for _, ip := range ips {
+		if _, ok := t.ranges.Lookup(ip); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// severityAssigner sets Result.Severity from the first matching tag or host pattern
+type severityAssigner struct {
+	name  string
+	rules []compiledSeverityRule
+}
+
+type compiledSeverityRule struct {
+	tag      string
+	pattern  *regexp.Regexp
+	severity string
+}
+
+func (a *severityAssigner) Name() string { return a.name }
+
+func (a *severityAssigner) Process(ctx context.Context, results []Result) error {
+	for i := range results {
+		for _, rule := range a.rules {
+			if rule.matches(results[i]) {
+				results[i].Severity = rule.severity
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (r compiledSeverityRule) matches(result Result) bool {
+	if r.pattern != nil && r.pattern.MatchString(result.Host) {
+		return true
+	}
+	if r.tag != "" {
+		for _, tag := range result.Tags {
+			if tag == r.tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hookProcessor POSTs the result batch to an external URL and merges the
+// tags it returns: [{"host": "...", "tags": ["..."]}]
+type hookProcessor struct {
+	name string
+	url  string
+}
+
+type hookTagResponse struct {
+	Host     string   `json:"host"`
+	Tags     []string `json:"tags"`
+	Severity string   `json:"severity"`
+}
+
+func (h *hookProcessor) Name() string { return h.name }
+
+func (h *hookProcessor) Process(ctx context.Context, results []Result) error {
+	cfg := configFrom(ctx)
+	payload, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, strings.NewReader(string(payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+
+	// The stage's timeout bounds the call too; HTTP.Timeout covers a rule
+	// whose timeout is longer than any hook should take
+	client := &http.Client{Timeout: cfg.HTTP.Timeout, Transport: sharedOutboundTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("hook returned status %d", resp.StatusCode)
+	}
+
+	var tagged []hookTagResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, cfg.HTTP.MaxBodySize)).Decode(&tagged); err != nil {
+		return fmt.Errorf("invalid hook response: %w", err)
+	}
+
+	byHost := make(map[string]hookTagResponse, len(tagged))
+	for _, entry := range tagged {
+		byHost[strings.ToLower(entry.Host)] = entry
+	}
+	for i := range results {
+		if entry, ok := byHost[results[i].Host]; ok {
+			addTags(&results[i], entry.Tags...)
+			if entry.Severity != "" {
+				results[i].Severity = entry.Severity
+			}
+		}
+	}
+	return nil
+}
+
+// Enhanced probe handler with better error handling and caching
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	if passiveOnly {
+		http.Error(w, errPassiveOnly.Error(), http.StatusForbidden)
+		return
+	}
+	cfg := configFrom(r.Context())
+	if noProbeCache(r) {
+		r = r.WithContext(withoutProbeCache(r.Context()))
+	}
+	if r.URL.Query().Get("mode") == "vhost" {
+		probeVHostHandler(w, r)
+		return
+	}
+	targetURL := r.URL.Query().Get("url")
+	if targetURL == "" {
+		http.Error(w, "missing url parameter", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Has("ports") {
+		probePortsHandler(w, r, targetURL)
+		return
+	}
+	// A bare host is probed over https, then http if that can't connect
+	bare := !strings.Contains(targetURL, "://")
+	if bare {
+		targetURL = "https://" + bracketIPv6(targetURL)
+	}
+
+	parsedURL, err := url.Parse(targetURL)
+	if err != nil {
+		writeProbeError(w, "invalid URL", err)
+		return
+	}
+
+	// Validate domain if restrictions are set
+	if !probeAllowed(cfg, parsedURL.Hostname()) {
+		writeProbeError(w, "domain not allowed", fmt.Errorf("domain %s not in allowed list", parsedURL.Hostname()))
+		return
+	}
+
+	startTime := time.Now()
+	var result ProbeResponse
+	if bare {
+		result, targetURL = probeWithFallback(r.Context(), targetURL)
+	} else {
+		result = cachedProbe(r.Context(), targetURL)
+	}
+	result.ProbeTime = time.Since(startTime).Milliseconds()
+	hostIndex.RecordProbe(parsedURL.Hostname(), targetURL, result)
+
+	atomic.AddInt64(&stats.TotalProbes, 1)
+	if result.Status != "0" && result.Error == "" {
+		atomic.AddInt64(&stats.SuccessfulProbes, 1)
+
+		// Sample live hosts a few more times to tell stable from flapping
+		if samples, _ := strconv.Atoi(r.URL.Query().Get("samples")); samples > 1 {
+			result.Availability = sampleAvailability(r.Context(), targetURL, result, samples)
+		}
+		if r.URL.Query().Get("favicon") == "true" {
+			if hash, ok := faviconHash(r.Context(), targetURL); ok {
+				result.FaviconHash = &hash
+			}
+		}
+	}
+
+	// Optionally feed certificate SANs and header hosts back into a job as
+	// new results
+	if jobID := r.URL.Query().Get("job"); jobID != "" {
+		if job, ok := lookupJob(jobID); ok {
+			recordJobProbe(job, parsedURL.Hostname(), targetURL, result)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// probePortsHandler serves /api/probe with ports=: the host of targetURL
+// is probed on every port, and the ports that answered come back as an
+// array of probe events in port order
+func probePortsHandler(w http.ResponseWriter, r *http.Request, targetURL string) {
+	cfg := configFrom(r.Context())
+	ports, err := parsePorts(r.URL.Query().Get("ports"))
+	if err == nil && len(ports) == 0 {
+		err = errors.New("empty ports parameter")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ports) > cfg.HTTP.ProbeMaxTargets {
+		http.Error(w, fmt.Sprintf("more than %d ports", cfg.HTTP.ProbeMaxTargets), http.StatusBadRequest)
+		return
+	}
+	tasks, err := probeTasks([]string{targetURL}, ports)
+	if err != nil {
+		writeProbeError(w, "invalid URL", err)
+		return
+	}
+	if !probeAllowed(cfg, tasks[0].host) {
+		writeProbeError(w, "domain not allowed", fmt.Errorf("domain %s not in allowed list", tasks[0].host))
+		return
+	}
+
+	var job *Job
+	if jobID := r.URL.Query().Get("job"); jobID != "" {
+		job, _ = lookupJob(jobID)
+	}
+	results := []ProbeEvent{}
+	runProbes(r.Context(), job, tasks, probeOptionsFrom(r), func(event ProbeEvent) {
+		if event.Status != "0" {
+			results = append(results, event)
+		}
+	})
+	slices.SortFunc(results, func(a, b ProbeEvent) int { return cmp.Compare(a.Port, b.Port) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// probeVHostHandler serves /api/probe with mode=vhost: host is probed at
+// ip, over https with an http fallback, with the Host header and TLS SNI
+// naming host. It reaches sites whose DNS record is gone but whose
+// address is known, e.g. from passive sources.
+func probeVHostHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := configFrom(r.Context())
+	record := VHostRecord{Host: r.URL.Query().Get("host"), IP: r.URL.Query().Get("ip")}
+	if record.Host == "" || record.IP == "" {
+		http.Error(w, "vhost mode needs host and ip parameters", http.StatusBadRequest)
+		return
+	}
+	tasks, err := vhostTasks([]VHostRecord{record}, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !probeAllowed(cfg, tasks[0].host) {
+		writeProbeError(w, "domain not allowed", fmt.Errorf("domain %s not in allowed list", tasks[0].host))
+		return
+	}
+
+	var job *Job
+	if jobID := r.URL.Query().Get("job"); jobID != "" {
+		job, _ = lookupJob(jobID)
+	}
+	result := ProbeResponse{Status: "0", Title: "Cancelled", Error: "request cancelled"}
+	runProbes(r.Context(), job, tasks, probeOptionsFrom(r), func(event ProbeEvent) {
+		result = event.ProbeResponse
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ProbeEvent is one finished probe on /api/probe/stream
+type ProbeEvent struct {
+	Host string `json:"host"`
+	URL  string `json:"url"`
+	ProbeResponse
+
+	// The hosts the probe added to the job, from its certificate SANs and
+	// headers
+	Discovered []Result `json:"discovered,omitempty"`
+}
+
+// ProbeSummary ends a probe stream
+type ProbeSummary struct {
+	Probed     int   `json:"probed"`
+	Live       int   `json:"live"`
+	Failed     int   `json:"failed"`
+	NoDNS      int   `json:"no_dns"`
+	Skipped    int   `json:"skipped,omitempty"` // over HTTP_PROBE_MAX_TARGETS
+	Cancelled  bool  `json:"cancelled,omitempty"`
+	DurationMS int64 `json:"duration_ms"`
+
+	// Request timeout the no-dns hosts would have run into, less the
+	// lookups that found them dead
+	TimeSavedMS int64 `json:"time_saved_ms"`
+}
+
+// maxProbeBatchBody bounds the JSON body of a POST to /api/probe/stream
+const maxProbeBatchBody = 1 << 20
+
+// probeDNSBudget bounds the lookup that spares a name which no longer
+// resolves a full HTTP timeout
+const probeDNSBudget = 2 * time.Second
+
+// probeStreamHandler probes the hosts of a job (job=) or a list of hosts
+// and URLs (targets=), sending a probe event as each one finishes and a
+// complete event with a summary. Hosts without a scheme are probed over
+// https with a fallback to http. Closing the connection or /api/abort for
+// the target stops it.
+func probeStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if passiveOnly {
+		http.Error(w, errPassiveOnly.Error(), http.StatusForbidden)
+		return
+	}
+	cfg := configFrom(r.Context())
+	if noProbeCache(r) {
+		r = r.WithContext(withoutProbeCache(r.Context()))
+	}
+
+	// A POST body can carry the targets and headers for every probe
+	var batch struct {
+		Targets []string          `json:"targets"`
+		Records []VHostRecord     `json:"records"`
+		Headers map[string]string `json:"headers"`
+	}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxProbeBatchBody)).Decode(&batch); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	headers, err := parseProbeHeaders(batch.Headers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var job *Job
+	var entries []string
+	run := &probeRun{}
+	if id := r.URL.Query().Get("job"); id != "" {
+		var ok bool
+		if job, ok = lookupJob(id); !ok {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		entries = slices.Sorted(maps.Keys(job.Hosts()))
+		run.targets = []string{job.Target}
+	} else if list := r.URL.Query().Get("targets"); list != "" {
+		entries = splitList(list)
+	} else if len(batch.Targets) > 0 || len(batch.Records) > 0 {
+		entries = batch.Targets
+	} else {
+		http.Error(w, "missing job or targets parameter", http.StatusBadRequest)
+		return
+	}
+
+	ports, err := parsePorts(r.URL.Query().Get("ports"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tasks, err := probeTasks(entries, ports)
+	if err == nil && job == nil {
+		var records []probeTask
+		records, err = vhostTasks(batch.Records, ports)
+		tasks = append(tasks, records...)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, task := range tasks {
+		if !probeAllowed(cfg, task.host) {
+			http.Error(w, fmt.Sprintf("domain %s not in allowed list", task.host), http.StatusForbidden)
+			return
+		}
+		if job == nil && !slices.Contains(run.targets, task.host) {
+			run.targets = append(run.targets, task.host)
+		}
+	}
+	var summary ProbeSummary
+	if len(tasks) > cfg.HTTP.ProbeMaxTargets {
+		summary.Skipped = len(tasks) - cfg.HTTP.ProbeMaxTargets
+		tasks = tasks[:cfg.HTTP.ProbeMaxTargets]
+	}
+
+	options := probeOptionsFrom(r)
+	if options.wildcard && job == nil {
+		http.Error(w, "wildcard=true needs job=", http.StatusBadRequest)
+		return
+	}
+
+	sseHeader(w)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	stream := &sseWriter{w: w, flusher: flusher}
+	if summary.Skipped > 0 {
+		stream.sendJSON("notice", map[string]string{"source": "probe", "kind": "truncated",
+			"message": fmt.Sprintf("Probing the first %d of %d URLs (HTTP_PROBE_MAX_TARGETS)", len(tasks), len(tasks)+summary.Skipped)})
+	}
+
+	ctx, cancel := context.WithCancel(withProbeHeaders(r.Context(), headers))
+	defer cancel()
+	run.cancel = cancel
+	probeRuns.add(run)
+	defer probeRuns.remove(run)
+
+	start := time.Now()
+	runProbes(ctx, job, tasks, options, func(event ProbeEvent) {
+		summary.Probed++
+		switch {
+		case event.Error == "no-dns":
+			summary.NoDNS++
+			summary.TimeSavedMS += max(cfg.HTTP.Timeout.Milliseconds()-event.ProbeTime, 0)
+		case event.Status != "0" && event.Error == "":
+			summary.Live++
+		default:
+			summary.Failed++
+		}
+		// Only the ports that answered are worth an event
+		if len(ports) > 0 && event.Status == "0" {
+			return
+		}
+		stream.sendJSON("probe", event)
+	})
+
+	summary.Cancelled = ctx.Err() != nil
+	summary.DurationMS = time.Since(start).Milliseconds()
+	if r.Context().Err() == nil {
+		stream.sendJSON("complete", summary)
+	}
+}
+
+// probeTask is one URL a batch probe visits
+type probeTask struct {
+	host     string
+	url      string
+	fallback bool   // try the other scheme when this one can't connect
+	port     int    // set when the port came from ports=
+	ip       string // connect here instead of resolving host (vhost)
+}
+
+// httpsPorts are the ports probed over https first; any other port starts
+// with http
+var httpsPorts = []int{443, 4443, 8443, 9443}
+
+// probeTasks expands hosts and URLs into the URLs to probe. Without ports
+// every entry is probed as given, bare hosts over https with a fallback;
+// with ports each entry's host is probed on every port.
+func probeTasks(entries []string, ports []int) ([]probeTask, error) {
+	var tasks []probeTask
+	for _, entry := range entries {
+		bare := !strings.Contains(entry, "://")
+		if bare {
+			entry = "https://" + bracketIPv6(entry)
+		}
+		parsed, err := url.Parse(entry)
+		if err != nil || parsed.Hostname() == "" {
+			return nil, fmt.Errorf("invalid target %q", entry)
+		}
+		host := strings.ToLower(parsed.Hostname())
+		if len(ports) == 0 {
+			tasks = append(tasks, probeTask{host: host, url: entry, fallback: bare})
+			continue
+		}
+		for _, port := range ports {
+			scheme := "http"
+			if slices.Contains(httpsPorts, port) {
+				scheme = "https"
+			}
+			tasks = append(tasks, probeTask{
+				host:     host,
+				url:      scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)),
+				fallback: true,
+				port:     port,
+			})
+		}
+	}
+	return tasks, nil
+}
+
+// VHostRecord is a host to probe at a known address
+type VHostRecord struct {
+	Host string `json:"host"`
+	IP   string `json:"ip"`
+}
+
+// vhostTasks expands records like probeTasks does hosts, each task
+// connecting to the record's address
+func vhostTasks(records []VHostRecord, ports []int) ([]probeTask, error) {
+	var tasks []probeTask
+	for _, record := range records {
+		host := strings.ToLower(strings.TrimSuffix(record.Host, "."))
+		if !domainRe.MatchString(host) {
+			return nil, fmt.Errorf("invalid vhost host %q", record.Host)
+		}
+		if net.ParseIP(record.IP) == nil {
+			return nil, fmt.Errorf("invalid vhost ip %q", record.IP)
+		}
+		expanded, err := probeTasks([]string{host}, ports)
+		if err != nil {
+			return nil, err
+		}
+		for i := range expanded {
+			expanded[i].ip = record.IP
+		}
+		tasks = append(tasks, expanded...)
+	}
+	return tasks, nil
+}
+
+// parsePorts reads a ports= list; an empty value means no ports
+func parsePorts(value string) ([]int, error) {
+	var ports []int
+	for _, field := range splitList(value) {
+		port, err := strconv.Atoi(field)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", field)
+		}
+		if !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// probeOptions are the extras a batch probe can ask for
+type probeOptions struct {
+	favicon  bool // hash the favicon of live hosts
+	wildcard bool // compare live hosts with the job's catch-all baselines
+	samples  int  // probes of each live host for its availability
+}
+
+func probeOptionsFrom(r *http.Request) probeOptions {
+	samples, _ := strconv.Atoi(r.URL.Query().Get("samples"))
+	return probeOptions{
+		favicon:  r.URL.Query().Get("favicon") == "true",
+		wildcard: r.URL.Query().Get("wildcard") == "true",
+		samples:  samples,
+	}
+}
+
+// runProbes probes tasks HTTP.ProbeConcurrency at a time, recording each
+// result and feeding it back into job when one is given, and hands every
+// probe that finished before ctx ended to emit, one call at a time. Names
+// that no longer resolve are reported without an HTTP attempt unless
+// HTTP.ProbeSkipDead is off.
+func runProbes(ctx context.Context, job *Job, tasks []probeTask, options probeOptions, emit func(ProbeEvent)) {
+	cfg := configFrom(ctx)
+	var mu sync.Mutex
+	semaphore := make(chan struct{}, cfg.HTTP.ProbeConcurrency)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			targetURL := task.url
+			probeCtx := ctx
+			if task.ip != "" {
+				probeCtx = withVHost(ctx, task.host, task.ip)
+			}
+			probeStart := time.Now()
+			var response ProbeResponse
+			switch {
+			case cfg.HTTP.ProbeSkipDead && task.ip == "" && !viaProxy(ctx, task.url) && !probeResolves(ctx, task.host):
+				response = ProbeResponse{Status: "0", Title: "Name does not resolve", Error: "no-dns"}
+			case task.fallback:
+				response, targetURL = probeWithFallback(probeCtx, targetURL)
+			default:
+				response = cachedProbe(probeCtx, targetURL)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			event := ProbeEvent{Host: task.host, URL: targetURL, ProbeResponse: response}
+			event.ProbeTime = time.Since(probeStart).Milliseconds()
+			event.Port = task.port
+			event.ConnectedIP = task.ip
+
+			live := event.Status != "0" && event.Error == ""
+			if live && options.samples > 1 {
+				event.Availability = sampleAvailability(probeCtx, targetURL, event.ProbeResponse, options.samples)
+			}
+			if live && options.favicon {
+				if hash, ok := faviconHash(probeCtx, targetURL); ok {
+					event.FaviconHash = &hash
+				}
+			}
+			if event.Error != "no-dns" {
+				hostIndex.RecordProbe(task.host, targetURL, event.ProbeResponse)
+				atomic.AddInt64(&stats.TotalProbes, 1)
+				if live {
+					atomic.AddInt64(&stats.SuccessfulProbes, 1)
+				}
+			}
+			if live && options.wildcard && job != nil && task.ip == "" {
+				event.WildcardResponse = job.WildcardBaseline(probeCtx, targetURL).matches(event.ProbeResponse)
+			}
+			if job != nil {
+				event.Discovered = recordJobProbe(job, task.host, targetURL, event.ProbeResponse)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			emit(event)
+		}()
+	}
+	wg.Wait()
+}
+
+// viaProxy reports whether requests to rawURL go through a proxy, which
+// then resolves the name itself
+func viaProxy(ctx context.Context, rawURL string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return false
+	}
+	proxyURL, err := outboundProxy(req)
+	return err == nil && proxyURL != nil
+}
+
+// probeResolves reports whether host, unless it is an address, resolves
+// within probeDNSBudget
+func probeResolves(ctx context.Context, host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeDNSBudget)
+	defer cancel()
+	// The dialer connects over either family whatever DNS_QUERY_TYPES
+	// says, so an AAAA-only name is alive too
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		if ips, err := defaultResolver().lookupAddrs(ctx, host, qtype); err == nil && len(ips) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// probeAllowed applies ALLOWED_DOMAINS, when set, to a probed host. An IP
+// literal, bracketed or not, only matches an entry that is the same
+// address or a CIDR containing it; names match entries by suffix.
+func probeAllowed(cfg *Config, host string) bool {
+	if len(cfg.Security.AllowedDomains) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	address, _, _ := strings.Cut(host, "%") // zone of a link-local address
+	ip := net.ParseIP(address)
+	for _, entry := range cfg.Security.AllowedDomains {
+		entry = strings.ToLower(strings.Trim(entry, "[]"))
+		if ip == nil {
+			if strings.HasSuffix(host, entry) {
+				return true
+			}
+			continue
+		}
+		if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// bracketIPv6 puts an IPv6 literal in the brackets a URL needs; any other
+// host comes back unchanged
+func bracketIPv6(host string) string {
+	if strings.Contains(host, ":") && net.ParseIP(host) != nil {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// probeRun is a running probe stream and the targets /api/abort can stop
+// it by: the job's target, or each probed host
+type probeRun struct {
+	targets []string
+	cancel  context.CancelFunc
+}
+
+// covers reports whether aborting target should stop the run
+func (p *probeRun) covers(target string) bool {
+	for _, t := range p.targets {
+		if t == target || strings.HasSuffix(t, "."+target) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeRunSet holds the running probe streams
+type probeRunSet struct {
+	mu   sync.Mutex
+	runs map[*probeRun]struct{}
+}
+
+var probeRuns = &probeRunSet{runs: make(map[*probeRun]struct{})}
+
+func (s *probeRunSet) add(run *probeRun) {
+	s.mu.Lock()
+	s.runs[run] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *probeRunSet) remove(run *probeRun) {
+	s.mu.Lock()
+	delete(s.runs, run)
+	s.mu.Unlock()
+}
+
+// abort cancels the runs covering target and returns how many it stopped
+func (s *probeRunSet) abort(target string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stopped := 0
+	for run := range s.runs {
+		if run.covers(target) {
+			run.cancel()
+			stopped++
+		}
+	}
+	return stopped
+}
+
+// recordJobProbe records a probe of host for job, feeding the certificate
+// SANs ("tls-san") and header hosts ("headers") it found back into the job,
+// and returns the results that added. Every probe path with a job goes
+// through here.
+func recordJobProbe(job *Job, host, probedURL string, response ProbeResponse) []Result {
+	added := addDiscoveredHosts(job, "tls-san", response.DiscoveredSANs, probedURL)
+	added = append(added, addDiscoveredHosts(job, "headers", response.HeaderHosts, probedURL)...)
+	job.RecordProbe(host, response)
+	return added
+}
+
+// addDiscoveredHosts records hosts found while probing that the job doesn't
+// know yet and returns them as recorded
+func addDiscoveredHosts(job *Job, source string, hosts []string, probedURL string) []Result {
+	known := job.Hosts()
+	var added []Result
+	for _, host := range hosts {
+		if host != job.Target && !strings.HasSuffix(host, "."+job.Target) {
+			continue
+		}
+		if _, dup := known[host]; dup {
+			continue
+		}
+		known[host] = struct{}{}
+
+		added = append(added, job.AddResult(source, Result{
+			Host:      host,
+			Source:    source,
+			Status:    "discovered",
+			URL:       probedURL,
+			Timestamp: time.Now(),
+		}))
+	}
+	return added
+}
+
+// lookupJob finds a job in memory or, failing that, in the job store
+func lookupJob(id string) (*Job, bool) {
+	jobManager.mu.RLock()
+	job, ok := jobManager.jobs[id]
+	jobManager.mu.RUnlock()
+	if ok || jobStore == nil {
+		return job, ok
+	}
+
+	job, err := jobStore.Load(id)
+	if err != nil {
+		log.Printf("Warning: cannot load stored job %s: %v", id, err)
+	}
+	return job, job != nil
+}
+
+// probeURL fetches targetURL through client, normally probeHTTPClient(ctx)
+func probeURL(ctx context.Context, client *http.Client, targetURL string) ProbeResponse {
+	cfg := configFrom(ctx)
+	trace := &probeTrace{}
+	ctx = context.WithValue(ctx, probeTraceKey{}, trace)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { trace.remote = info.Conn.RemoteAddr() },
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+	if err != nil {
+		return ProbeResponse{
+			Status: "0",
+			Title:  "Request creation failed",
+			Error:  err.Error(),
+		}
+	}
+
+	req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	// Accept-Encoding is left to the transport, which only decompresses
+	// responses to encodings it asked for itself
+	req.Header.Set("DNT", "1")
+	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("Upgrade-Insecure-Requests", "1")
+	applyProbeHeaders(ctx, req)
+
+	resp, err := client.Do(req)
+	var offWeb *offWebRedirectError
+	if errors.As(err, &offWeb) && resp != nil {
+		// The host answered; it just sends browsers somewhere a probe
+		// can't follow
+		return ProbeResponse{
+			Status:      fmt.Sprintf("%d", resp.StatusCode),
+			Title:       "Redirects off-web",
+			Outcome:     "redirects off-web",
+			Location:    offWeb.location,
+			FinalURL:    resp.Request.URL.String(),
+			Redirects:   trace.hops,
+			HeaderHosts: harvestHeaderHosts(trace.headers, req.URL.Hostname()),
+			TLS:         probeTLSDetails(resp.TLS, resp.Request.URL.Hostname()),
+		}
+	}
+	if err != nil {
+		return ProbeResponse{
+			Status: "0",
+			Title:  "Connection failed",
+			Error:  err.Error(),
+			err:    err,
+		}
+	}
+	defer resp.Body.Close()
+
+	body, info, err := readProbeBody(resp, cfg.HTTP.MaxBodySize)
+	if err != nil {
+		return ProbeResponse{
+			Status: fmt.Sprintf("%d", resp.StatusCode),
+			Title:  "Failed to read response",
+			Error:  err.Error(),
+			Body:   info,
+			err:    err,
+		}
+	}
+
+	title := extractTitle(string(body))
+	if info.Truncated == "declared-length" {
+		title = "Body not downloaded"
+	}
+	hops := trace.hops
+	if len(hops) > 0 {
+		hops = append(hops, ProbeHop{URL: resp.Request.URL.String(), Status: resp.StatusCode})
+	}
+	var bodySum string
+	if info.Truncated != "declared-length" {
+		sum := sha256.Sum256(body)
+		bodySum = hex.EncodeToString(sum[:])
+	}
+	var outcome string
+	if plainToTLSPort(resp, body) {
+		outcome = "http to https port"
+	}
+	pageTitle := title
+	if info.Truncated == "declared-length" {
+		pageTitle = ""
+	}
+	pageClass, pageSignature := classifyPage(resp.StatusCode, pageTitle, body)
+	var remoteIP, family string
+	if tcp, ok := trace.remote.(*net.TCPAddr); ok && !viaProxy(ctx, resp.Request.URL.String()) {
+		remoteIP, family = tcp.IP.String(), ipFamily(tcp.IP)
+	}
+	return ProbeResponse{
+		RemoteIP:       remoteIP,
+		AddressFamily:  family,
+		Outcome:        outcome,
+		PageClass:      pageClass,
+		PageSignature:  pageSignature,
+		ContentLength:  len(body),
+		WordCount:      len(bytes.Fields(body)),
+		LineCount:      lineCount(body),
+		BodySHA256:     bodySum,
+		FinalURL:       resp.Request.URL.String(),
+		Redirects:      hops,
+		Status:         fmt.Sprintf("%d", resp.StatusCode),
+		Title:          title,
+		Error:          "",
+		Body:           info,
+		DiscoveredSANs: harvestSANs(resp.TLS, req.URL.Hostname()),
+		HeaderHosts:    harvestHeaderHosts(append(trace.headers, resp.Header), req.URL.Hostname()),
+		Technologies:   detectTechnologies(resp.Header, body),
+		TLS:            probeTLSDetails(resp.TLS, resp.Request.URL.Hostname()),
+		SecurityHeaders: &SecurityHeaders{
+			StrictTransportSecurity: securityHeader(resp.Header, "Strict-Transport-Security"),
+			ContentSecurityPolicy:   securityHeader(resp.Header, "Content-Security-Policy"),
+			XFrameOptions:           securityHeader(resp.Header, "X-Frame-Options"),
+			XContentTypeOptions:     securityHeader(resp.Header, "X-Content-Type-Options"),
+			ReferrerPolicy:          securityHeader(resp.Header, "Referrer-Policy"),
+			HTTPRedirectsToHTTPS:    httpRedirectsToHTTPS(ctx, client, req.URL, resp.Request.URL),
+		},
+	}
+}
+
+// SecurityHeaders reports the security headers of a probed site
+type SecurityHeaders struct {
+	StrictTransportSecurity SecurityHeader `json:"strict_transport_security"`
+	ContentSecurityPolicy   SecurityHeader `json:"content_security_policy"`
+	XFrameOptions           SecurityHeader `json:"x_frame_options"`
+	XContentTypeOptions     SecurityHeader `json:"x_content_type_options"`
+	ReferrerPolicy          SecurityHeader `json:"referrer_policy"`
+
+	// Whether http:// on the default port ends up on https; absent when
+	// that couldn't be checked
+	HTTPRedirectsToHTTPS *bool `json:"http_redirects_to_https,omitempty"`
+}
+
+// SecurityHeader is one header's presence and, when sent more than once,
+// its first value
+type SecurityHeader struct {
+	Present bool   `json:"present"`
+	Value   string `json:"value,omitempty"`
+}
+
+// securityHeaderNames are the SecurityHeaders JSON names, in order
+var securityHeaderNames = []string{
+	"strict_transport_security", "content_security_policy", "x_frame_options",
+	"x_content_type_options", "referrer_policy",
+}
+
+func (s *SecurityHeaders) byName() map[string]SecurityHeader {
+	return map[string]SecurityHeader{
+		"strict_transport_security": s.StrictTransportSecurity,
+		"content_security_policy":   s.ContentSecurityPolicy,
+		"x_frame_options":           s.XFrameOptions,
+		"x_content_type_options":    s.XContentTypeOptions,
+		"referrer_policy":           s.ReferrerPolicy,
+	}
+}
+
+func securityHeader(header http.Header, name string) SecurityHeader {
+	values := header.Values(name)
+	if len(values) == 0 {
+		return SecurityHeader{}
+	}
+	return SecurityHeader{Present: true, Value: strings.TrimSpace(values[0])}
+}
+
+// httpRedirectsToHTTPS reports whether the plain-HTTP site of a probe
+// sends visitors to https. An http probe answers that from where it ended;
+// an https probe on the default port asks http:// of the same host once.
+func httpRedirectsToHTTPS(ctx context.Context, client *http.Client, probed, final *url.URL) *bool {
+	redirects := final.Scheme == "https"
+	if probed.Scheme == "http" {
+		return &redirects
+	}
+	if probed.Port() != "" && probed.Port() != "443" {
+		return nil
+	}
+	plain := &url.URL{Scheme: "http", Host: bracketIPv6(probed.Hostname()), Path: "/"}
+	// A trace of its own keeps these hops out of the probe's redirect chain
+	ctx = context.WithValue(ctx, probeTraceKey{}, &probeTrace{})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, plain.String(), nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", configFrom(ctx).HTTP.UserAgent)
+	applyProbeHeaders(ctx, req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+	redirects = resp.Request.URL.Scheme == "https"
+	return &redirects
+}
+
+// tlsPortRefusals are what servers answer plain HTTP with on a TLS port
+var tlsPortRefusals = []string{
+	"Client sent an HTTP request to an HTTPS server", // Go
+	"The plain HTTP request was sent to HTTPS port",  // nginx
+	"speaking plain HTTP to an SSL-enabled server",   // Apache
+}
+
+// plainToTLSPort reports whether resp refuses a plain HTTP request sent
+// to a port that expects TLS
+func plainToTLSPort(resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusBadRequest || resp.Request.URL.Scheme != "http" {
+		return false
+	}
+	for _, refusal := range tlsPortRefusals {
+		if bytes.Contains(body, []byte(refusal)) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineCount counts the lines of body, a last line without a newline
+// included
+func lineCount(body []byte) int {
+	if len(body) == 0 {
+		return 0
+	}
+	lines := bytes.Count(body, []byte("\n"))
+	if body[len(body)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// hopByHopHeaders describe the connection, which the transport manages,
+// so probes refuse them as custom headers
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer",
+	"Transfer-Encoding", "Upgrade", "Content-Length",
+}
+
+// sensitiveHeaders have their values left out of DEBUG logs
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// parseProbeHeaders validates custom probe headers
+func parseProbeHeaders(headers map[string]string) (http.Header, error) {
+	parsed := make(http.Header, len(headers))
+	for name, value := range headers {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("invalid header %q", name)
+		}
+		if slices.Contains(hopByHopHeaders, name) {
+			return nil, fmt.Errorf("hop-by-hop header %s can't be set on probes", name)
+		}
+		parsed.Set(name, value)
+	}
+	return parsed, nil
+}
+
+// parseExtraHeaders reads HTTP_EXTRA_HEADERS, a JSON object of header
+// names and values
+func parseExtraHeaders(raw string) http.Header {
+	if raw == "" {
+		return nil
+	}
+	var headers map[string]string
+	err := json.Unmarshal([]byte(raw), &headers)
+	var parsed http.Header
+	if err == nil {
+		parsed, err = parseProbeHeaders(headers)
+	}
+	if err != nil {
+		log.Printf("Warning: ignoring HTTP_EXTRA_HEADERS: %v", err)
+		return nil
+	}
+	return parsed
+}
+
+type probeHeadersKey struct{}
+
+// withProbeHeaders attaches a batch's custom headers to ctx
+func withProbeHeaders(ctx context.Context, headers http.Header) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, probeHeadersKey{}, headers)
+}
+
+// applyProbeHeaders sets HTTP_EXTRA_HEADERS and then the headers attached
+// to ctx on a probe request, replacing the defaults they name. A Host
+// header becomes the request's Host.
+func applyProbeHeaders(ctx context.Context, req *http.Request) {
+	cfg := configFrom(ctx)
+	batch, _ := ctx.Value(probeHeadersKey{}).(http.Header)
+	for _, headers := range []http.Header{cfg.HTTP.ExtraHeaders, batch} {
+		for name, values := range headers {
+			if name == "Host" {
+				req.Host = values[0]
+				continue
+			}
+			req.Header[name] = values
+		}
+	}
+
+	if cfg.LogLevel == "DEBUG" {
+		var effective []string
+		for _, name := range slices.Sorted(maps.Keys(req.Header)) {
+			value := strings.Join(req.Header[name], ", ")
+			if slices.Contains(sensitiveHeaders, name) {
+				value = fmt.Sprintf("<%d bytes>", len(value))
+			}
+			effective = append(effective, name+": "+value)
+		}
+		if req.Host != "" {
+			effective = append(effective, "Host: "+req.Host)
+		}
+		log.Printf("Probe %s headers: %s", req.URL, strings.Join(effective, "; "))
+	}
+}
+
+// ProbeHop is one response in a probe's redirect chain
+type ProbeHop struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// probeWithFallback probes a URL whose scheme was guessed and, when that
+// fails to connect or complete the TLS handshake, or plain HTTP reached a
+// TLS port, the same URL over the other scheme. It returns the result and
+// the URL it came from.
+func probeWithFallback(ctx context.Context, targetURL string) (ProbeResponse, string) {
+	result := cachedProbe(ctx, targetURL)
+	failed := result.Status == "0" && result.Title == "Connection failed"
+	if ctx.Err() != nil || !failed && result.Outcome != "http to https port" {
+		return result, targetURL
+	}
+	other := "http://" + strings.TrimPrefix(targetURL, "https://")
+	if rest, ok := strings.CutPrefix(targetURL, "http://"); ok {
+		other = "https://" + rest
+	}
+	return cachedProbe(ctx, other), other
+}
+
+// cachedProbe is probeURL through the probe cache. Results are kept by
+// URL, and by address for vhost probes; probes sending batch headers are
+// never cached, and those made with nocache=1 (withoutProbeCache) go to
+// the host and refresh the cache. Only answers are kept, so a host that
+// failed is tried again next time.
+func cachedProbe(ctx context.Context, targetURL string) ProbeResponse {
+	cfg := configFrom(ctx)
+	key, cacheable := probeCacheKey(ctx, targetURL)
+	if !cacheable || cfg.HTTP.ProbeCacheTTL <= 0 {
+		return retryingProbe(ctx, targetURL)
+	}
+	if ctx.Value(probeNoCacheKey{}) == nil {
+		if response, ok := probeResults.get(key, cfg.HTTP.ProbeCacheTTL); ok {
+			atomic.AddInt64(&stats.ProbeCacheHits, 1)
+			return response
+		}
+		atomic.AddInt64(&stats.ProbeCacheMisses, 1)
+	}
+
+	response := retryingProbe(ctx, targetURL)
+	if response.Status != "0" && response.err == nil && ctx.Err() == nil {
+		probeResults.put(key, response, cfg.HTTP.ProbeCacheSize)
+	}
+	return response
+}
+
+// errProbe5xx marks a probe answered with a server error, which is only
+// retried with HTTP_PROBE_RETRY_5XX
+var errProbe5xx = errors.New("server error")
+
+// retryingProbe is probeURL retried as probeRetryPolicy says; the result
+// is the last attempt's, with the number of attempts
+func retryingProbe(ctx context.Context, targetURL string) ProbeResponse {
+	var response ProbeResponse
+	retry(ctx, probeRetryPolicy(configFrom(ctx)), func(attemptCtx context.Context, attempt int) error {
+		response = probeURL(attemptCtx, probeHTTPClient(attemptCtx), targetURL)
+		response.Attempts = attempt + 1
+		switch {
+		case response.err != nil && transientProbeError(response.err):
+			return response.err
+		case strings.HasPrefix(response.Status, "5"):
+			return errProbe5xx
+		}
+		return nil
+	})
+	return response
+}
+
+// transientProbeError reports whether a probe failed in a way another try
+// may not: a reset connection, a connection closed early, or a timeout
+// (a TLS handshake's included). Refused connections, names that don't
+// resolve and certificate errors are answers.
+func transientProbeError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, syscall.ECONNRESET) || isTruncation(err) ||
+		errors.As(err, &netErr) && netErr.Timeout()
+}
+
+type probeNoCacheKey struct{}
+
+// noProbeCache reports whether a probe request asked to bypass the probe
+// cache with nocache=1
+func noProbeCache(r *http.Request) bool {
+	nocache, _ := strconv.ParseBool(r.URL.Query().Get("nocache"))
+	return nocache
+}
+
+// withoutProbeCache makes the probes made with ctx ignore cached results
+func withoutProbeCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, probeNoCacheKey{}, true)
+}
+
+// probeCacheKey returns what a probe of targetURL with ctx is cached
+// under, or false when it mustn't be cached
+func probeCacheKey(ctx context.Context, targetURL string) (string, bool) {
+	if ctx.Value(probeHeadersKey{}) != nil {
+		return "", false
+	}
+	if target, ok := ctx.Value(vhostKey{}).(vhostTarget); ok {
+		return targetURL + " @" + target.ip, true
+	}
+	return targetURL, true
+}
+
+// probeCache keeps probe results for a while, least recently used first
+// out once it holds more than the configured size
+type probeCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // of *probeCacheEntry, most recently used in front
+	dirty   bool       // changed since it was last saved
+}
+
+type probeCacheEntry struct {
+	Key      string        `json:"key"`
+	Response ProbeResponse `json:"response"`
+	ProbedAt time.Time     `json:"probed_at"`
+}
+
+var probeResults = newProbeCache()
+
+func newProbeCache() *probeCache {
+	return &probeCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the result cached under key, marked as cached, if it is
+// younger than ttl
+func (c *probeCache) get(key string, ttl time.Duration) (ProbeResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return ProbeResponse{}, false
+	}
+	entry := element.Value.(*probeCacheEntry)
+	if time.Since(entry.ProbedAt) > ttl {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		c.dirty = true
+		return ProbeResponse{}, false
+	}
+	c.order.MoveToFront(element)
+	response := entry.Response
+	response.Cached = true
+	probedAt := entry.ProbedAt
+	response.ProbedAt = &probedAt
+	return response, true
+}
+
+// put stores response under key, evicting the least recently used
+// entries past size
+func (c *probeCache) put(key string, response ProbeResponse, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.insert(&probeCacheEntry{Key: key, Response: response, ProbedAt: time.Now()}, size)
+	c.dirty = true
+}
+
+func (c *probeCache) insert(entry *probeCacheEntry, size int) {
+	if element, ok := c.entries[entry.Key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.Key] = c.order.PushFront(entry)
+	for c.order.Len() > max(size, 0) {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*probeCacheEntry).Key)
+	}
+}
+
+// Len returns the number of cached results
+func (c *probeCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// probeCachePersistInterval is how often a changed persisted probe cache
+// is written out
+const probeCachePersistInterval = time.Minute
+
+func probeCachePath() string {
+	return filepath.Join(currentConfig().DataDir, "probe-cache.json")
+}
+
+// initializeProbeCache loads the persisted probe cache and keeps saving
+// it, when PROBE_CACHE_PERSIST and DATA_DIR are set
+func initializeProbeCache() {
+	cfg := currentConfig()
+	if !cfg.HTTP.ProbeCachePersist || cfg.DataDir == "" {
+		return
+	}
+	if err := probeResults.load(probeCachePath(), cfg.HTTP.ProbeCacheTTL, cfg.HTTP.ProbeCacheSize); err != nil {
+		log.Printf("Warning: cannot load probe cache: %v", err)
+	}
+	go func() {
+		for range time.Tick(probeCachePersistInterval) {
+			cfg := currentConfig()
+			if !cfg.HTTP.ProbeCachePersist || cfg.DataDir == "" {
+				continue
+			}
+			if err := probeResults.save(probeCachePath()); err != nil {
+				log.Printf("Warning: cannot save probe cache: %v", err)
+			}
+		}
+	}()
+}
+
+// load fills the cache from path, skipping entries older than ttl. A
+// missing file is an empty cache.
+func (c *probeCache) load(path string, ttl time.Duration, size int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var entries []*probeCacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Saved most recent first; inserting oldest first keeps that order
+	for _, entry := range slices.Backward(entries) {
+		if time.Since(entry.ProbedAt) <= ttl {
+			c.insert(entry, size)
+		}
+	}
+	return nil
+}
+
+// save writes the cache to path, most recently used first, if it changed
+// since the last save
+func (c *probeCache) save(path string) error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	entries := make([]*probeCacheEntry, 0, c.order.Len())
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		entries = append(entries, element.Value.(*probeCacheEntry))
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	err := writeProbeCache(path, entries)
+	if err != nil {
+		// Try again at the next save
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+	}
+	return err
+}
+
+func writeProbeCache(path string, entries []*probeCacheEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// faviconHash fetches /favicon.ico of the probed site and hashes it the
+// way Shodan's http.favicon.hash does: murmur3 of the MIME base64 of the
+// body. A missing, non-200, empty or oversized favicon gives no hash.
+func faviconHash(ctx context.Context, targetURL string) (int32, bool) {
+	cfg := configFrom(ctx)
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		return 0, false
+	}
+	faviconURL := base.ResolveReference(&url.URL{Path: "/favicon.ico"})
+
+	req, err := http.NewRequestWithContext(ctx, "GET", faviconURL.String(), nil)
+	if err != nil {
+		return 0, false
+	}
+	req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+	applyProbeHeaders(ctx, req)
+
+	resp, err := probeHTTPClient(ctx).Do(req)
+	if err != nil {
+		return 0, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false
+	}
+	body, info, err := readProbeBody(resp, cfg.HTTP.MaxBodySize)
+	if err != nil || info.Truncated != "" || len(body) == 0 {
+		return 0, false
+	}
+	return int32(murmur3(mimeBase64(body), 0)), true
+}
+
+// mimeBase64 encodes data as Python's base64.encodebytes does: lines of
+// 76 characters, each ending in a newline
+func mimeBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	out := make([]byte, 0, len(encoded)+len(encoded)/76+1)
+	for len(encoded) > 76 {
+		out = append(out, encoded[:76]...)
+		out = append(out, '\n')
+		encoded = encoded[76:]
+	}
+	out = append(out, encoded...)
+	return append(out, '\n')
+}
+
+// murmur3 is MurmurHash3 x86_32
+func murmur3(data []byte, seed uint32) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	h := seed
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	switch tail := data[n:]; len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// offWebRedirectError stops a probe at a redirect to a non-HTTP location,
+// e.g. ftp:// or javascript:
+type offWebRedirectError struct {
+	location string
+}
+
+func (e *offWebRedirectError) Error() string {
+	return "redirect to non-HTTP location " + e.location
+}
+
+// ProbeBody is how much of a response body a probe read, and why it
+// stopped short if it did
+type ProbeBody struct {
+	DeclaredLength int64  `json:"declared_length,omitempty"` // Content-Length, when sent
+	Read           int64  `json:"read"`
+	Truncated      string `json:"truncated,omitempty"` // declared-length, streaming or limit
+}
+
+// probeSniffBytes is how much of a streaming response is read
+const probeSniffBytes = 4096
+
+// streamingContentTypes never end on their own, so a probe reads only the
+// first probeSniffBytes of them
+var streamingContentTypes = []string{
+	"text/event-stream",
+	"multipart/x-mixed-replace",
+	"application/x-ndjson",
+	"application/octet-stream",
+	"video/",
+	"audio/",
+}
+
+// readProbeBody reads a probe response body up to limit bytes. A body
+// whose declared length exceeds limit is not read at all, and a streaming
+// one only for a sniff. A compressed body the transport left alone is
+// decoded, and limit applies to the decoded bytes.
+func readProbeBody(resp *http.Response, limit int64) ([]byte, *ProbeBody, error) {
+	info := &ProbeBody{}
+	if resp.ContentLength > 0 {
+		info.DeclaredLength = resp.ContentLength
+	}
+	if resp.ContentLength > limit {
+		info.Truncated = "declared-length"
+		return nil, info, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	for _, streaming := range streamingContentTypes {
+		if strings.HasPrefix(mediaType, streaming) {
+			if probeSniffBytes < limit {
+				limit = probeSniffBytes
+				info.Truncated = "streaming"
+			}
+			break
+		}
+	}
+
+	var reader io.Reader = resp.Body
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, info, err
+		}
+		defer gz.Close()
+		reader = gz
+	case "deflate":
+		fl := flate.NewReader(resp.Body)
+		defer fl.Close()
+		reader = fl
+	}
+
+	// One byte past the limit tells a body of exactly limit bytes from a
+	// longer one
+	body, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if int64(len(body)) > limit {
+		body = body[:limit]
+		if info.Truncated == "" {
+			info.Truncated = "limit"
+		}
+	} else if info.Truncated == "streaming" && err == nil {
+		// It ended after all
+		info.Truncated = ""
+	}
+	info.Read = int64(len(body))
+	return body, info, err
+}
+
+var (
+	discoveryPool, probePool *httpPool
+	httpPoolsOnce            sync.Once
+)
+
+// httpPool bounds one class of outbound requests: a total concurrency cap
+// and, optionally, a per-host cap with a minimum spacing between request
+// starts to the same host. A request holds its slots until its body is
+// closed.
+type httpPool struct {
+	name      string
+	slots     chan struct{}
+	perHost   int
+	hostDelay time.Duration
+	waiting   int64
+
+	hosts map[string]*poolHost
+	mu    sync.Mutex
+}
+
+// maxPoolHosts is how many per-host entries a pool keeps before pruning
+const maxPoolHosts = 1024
+
+type poolHost struct {
+	slots chan struct{}
+	next  time.Time // earliest start of the next request
+	users int
+}
+
+// HTTPPoolStats is the /api/stats view of an httpPool
+type HTTPPoolStats struct {
+	Limit    int   `json:"limit"`
+	InFlight int   `json:"in_flight"`
+	Waiting  int64 `json:"waiting"`
+}
+
+func newHTTPPool(name string, concurrency, perHost int, hostDelay time.Duration) *httpPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &httpPool{
+		name:      name,
+		slots:     make(chan struct{}, concurrency),
+		perHost:   perHost,
+		hostDelay: hostDelay,
+		hosts:     make(map[string]*poolHost),
+	}
+}
+
+// httpPools returns the discovery and probe pools, created on first use
+func httpPools() (discovery, probe *httpPool) {
+	httpPoolsOnce.Do(func() {
+		cfg := currentConfig()
+		discoveryPool = newHTTPPool("discovery", cfg.HTTP.DiscoveryConcurrency, 0, 0)
+		probePool = newHTTPPool("probe", cfg.HTTP.ProbeConcurrency, cfg.HTTP.ProbePerHost, cfg.HTTP.ProbeHostDelay)
+	})
+	return discoveryPool, probePool
+}
+
+// newOutboundTransport returns a transport with the defaults of Go's own,
+// routed through outboundProxy. Every outbound HTTP client starts from one.
+func newOutboundTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = outboundProxy
+	return transport
+}
+
+var (
+	outboundTransport     *http.Transport
+	outboundTransportOnce sync.Once
+)
+
+// sharedOutboundTransport is the connection pool of upstream requests
+// that need no transport settings of their own
+func sharedOutboundTransport() *http.Transport {
+	outboundTransportOnce.Do(func() {
+		outboundTransport = newOutboundTransport()
+	})
+	return outboundTransport
+}
+
+// proxySelector caches the proxy function for one config snapshot
+type proxySelector struct {
+	cfg   *Config
+	proxy func(*url.URL) (*url.URL, error)
+}
+
+var currentProxySelector atomic.Pointer[proxySelector]
+
+// outboundProxy picks the proxy for a request from the config it runs
+// under, so a reload takes effect without rebuilding transports. A proxy
+// URL that can't be used fails the request rather than going direct.
+func outboundProxy(req *http.Request) (*url.URL, error) {
+	cfg := configFrom(req.Context())
+	selector := currentProxySelector.Load()
+	if selector == nil || selector.cfg != cfg {
+		selector = &proxySelector{cfg: cfg, proxy: proxyFunc(cfg)}
+		currentProxySelector.Store(selector)
+	}
+	return selector.proxy(req.URL)
+}
+
+func proxyFunc(cfg *Config) func(*url.URL) (*url.URL, error) {
+	if cfg.HTTP.ProxyURL == "" {
+		return httpproxy.FromEnvironment().ProxyFunc()
+	}
+	if _, err := parseProxyURL(cfg.HTTP.ProxyURL); err != nil {
+		log.Printf("Warning: %v; outbound HTTP requests will fail", err)
+		return func(*url.URL) (*url.URL, error) { return nil, err }
+	}
+	return (&httpproxy.Config{
+		HTTPProxy:  cfg.HTTP.ProxyURL,
+		HTTPSProxy: cfg.HTTP.ProxyURL,
+		NoProxy:    strings.Join(cfg.HTTP.NoProxy, ","),
+	}).ProxyFunc()
+}
+
+// parseProxyURL checks HTTP_PROXY_URL
+func parseProxyURL(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_URL: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid HTTP_PROXY_URL: unsupported scheme %q", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, errors.New("invalid HTTP_PROXY_URL: missing host")
+	}
+	return proxyURL, nil
+}
+
+// proxyStatus describes the outbound proxy for /api/config, without any
+// credentials in its URL
+func proxyStatus(cfg *Config) map[string]interface{} {
+	status := map[string]interface{}{"active": false}
+	raw, origin := cfg.HTTP.ProxyURL, "HTTP_PROXY_URL"
+	if raw == "" {
+		environment := httpproxy.FromEnvironment()
+		raw, origin = cmp.Or(environment.HTTPSProxy, environment.HTTPProxy), "environment"
+	}
+	if raw == "" {
+		return status
+	}
+	proxyURL, err := parseProxyURL(raw)
+	if err != nil {
+		status["error"] = err.Error()
+		return status
+	}
+	status["active"] = true
+	status["origin"] = origin
+	status["url"] = proxyURL.Scheme + "://" + proxyURL.Host
+	status["authenticated"] = proxyURL.User != nil
+	if origin == "HTTP_PROXY_URL" {
+		status["no_proxy"] = cfg.HTTP.NoProxy
+	}
+	return status
+}
+
+// discoveryTransport is the transport for upstream source APIs. next may be
+// nil for the default transport.
+func discoveryTransport(next http.RoundTripper) http.RoundTripper {
+	pool, _ := httpPools()
+	return pool.wrap(next)
+}
+
+// probeRoundTripper is the transport for requests to scanned hosts
+func probeRoundTripper() http.RoundTripper {
+	if passiveOnly {
+		return passiveRoundTripper{}
+	}
+	_, pool := httpPools()
+	return pool.wrap(sharedProbeTransport())
+}
+
+// passiveRoundTripper refuses every request, for probe clients built
+// while passive-only
+type passiveRoundTripper struct{}
+
+func (passiveRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errPassiveOnly
+}
+
+func (p *httpPool) wrap(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = sharedOutboundTransport()
+	}
+	return &pooledTransport{pool: p, next: next}
+}
+
+// acquire waits for a pool slot and, for per-host pools, a host slot and
+// the host's spacing first, so requests queued behind one busy host do not
+// hold pool slots other hosts could use. The returned release must be
+// called exactly once.
+func (p *httpPool) acquire(ctx context.Context, host string) (func(), error) {
+	atomic.AddInt64(&p.waiting, 1)
+	defer atomic.AddInt64(&p.waiting, -1)
+
+	releaseHost := func() {}
+	if p.perHost > 0 {
+		var err error
+		if releaseHost, err = p.acquireHost(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		releaseHost()
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-p.slots
+		releaseHost()
+	}, nil
+}
+
+// acquireHost waits for one of host's slots and its spacing
+func (p *httpPool) acquireHost(ctx context.Context, host string) (func(), error) {
+	p.mu.Lock()
+	h, ok := p.hosts[host]
+	if !ok {
+		// Entries whose spacing ran out while idle are only dropped here
+		if len(p.hosts) >= maxPoolHosts {
+			now := time.Now()
+			for name, idle := range p.hosts {
+				if idle.users == 0 && !now.Before(idle.next) {
+					delete(p.hosts, name)
+				}
+			}
+		}
+		h = &poolHost{slots: make(chan struct{}, p.perHost)}
+		p.hosts[host] = h
+	}
+	h.users++
+	p.mu.Unlock()
+
+	leave := func() {
+		p.mu.Lock()
+		h.users--
+		// Keep the entry until its spacing has passed so it still applies
+		if h.users == 0 && !time.Now().Before(h.next) {
+			delete(p.hosts, host)
+		}
+		p.mu.Unlock()
+	}
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		leave()
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	start := h.next
+	if now := time.Now(); start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(p.hostDelay)
+	p.mu.Unlock()
+
+	release := func() {
+		<-h.slots
+		leave()
+	}
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// httpPoolStats reports both outbound pools for /api/stats
+func httpPoolStats() map[string]HTTPPoolStats {
+	discovery, probe := httpPools()
+	return map[string]HTTPPoolStats{
+		discovery.name: discovery.Stats(),
+		probe.name:     probe.Stats(),
+	}
+}
+
+func (p *httpPool) Stats() HTTPPoolStats {
+	return HTTPPoolStats{
+		Limit:    cap(p.slots),
+		InFlight: len(p.slots),
+		Waiting:  atomic.LoadInt64(&p.waiting),
+	}
+}
+
+// pooledTransport holds a pool slot for the life of each request, which
+// waits out a maintenance pause of the job it is for first
+type pooledTransport struct {
+	pool *httpPool
+	next http.RoundTripper
+}
+
+func (t *pooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := maintenance.awaitResume(req.Context()); err != nil {
+		return nil, err
+	}
+	release, err := t.pool.acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody returns a request's pool slots when its body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// probeClient is the HTTP client every probe goes through, so repeated
+// probes of a host reuse connections. It is built from the HTTP config at
+// startup and rebuilt when a reload changes the settings it was made with.
+type probeClient struct {
+	client    *http.Client
+	transport *http.Transport
+
+	// For vhost probes: dials the address attached to the request context
+	// instead of resolving the host, and never reuses a connection, so
+	// one host probed at different addresses can't share one
+	vhost *http.Client
+
+	timeout      time.Duration
+	maxRedirects int
+	skipVerify   bool
+}
+
+var sharedProbe atomic.Pointer[probeClient]
+
+// probeTrace collects what a probe's redirects showed; probeURL attaches
+// one to each request's context for the shared client's CheckRedirect
+type probeTrace struct {
+	headers []http.Header // of every response in the chain, scanned for hostnames
+	hops    []ProbeHop
+	remote  net.Addr // of the connection the last response came over
+}
+
+type probeTraceKey struct{}
+
+func newProbeClient(cfg *Config) *probeClient {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := newOutboundTransport()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.HTTP.SkipTLSVerify}
+	transport.DialContext = probeDialer(dialer)
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 10
+	transport.IdleConnTimeout = 90 * time.Second
+
+	pc := &probeClient{
+		transport:    transport,
+		timeout:      cfg.HTTP.Timeout,
+		maxRedirects: cfg.HTTP.MaxRedirects,
+		skipVerify:   cfg.HTTP.SkipTLSVerify,
+	}
+
+	// A proxy would resolve the host itself, so vhost probes go direct
+	vhostTransport := transport.Clone()
+	vhostTransport.Proxy = nil
+	vhostTransport.DisableKeepAlives = true
+	dial := probeDialer(dialer)
+	vhostTransport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if target, ok := ctx.Value(vhostKey{}).(vhostTarget); ok {
+			if host, port, err := net.SplitHostPort(addr); err == nil && strings.EqualFold(host, target.host) {
+				addr = net.JoinHostPort(target.ip, port)
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+
+	var roundTripper, vhostRoundTripper http.RoundTripper = passiveRoundTripper{}, passiveRoundTripper{}
+	if !passiveOnly {
+		_, pool := httpPools()
+		roundTripper = pool.wrap(pc.transport)
+		vhostRoundTripper = pool.wrap(vhostTransport)
+	}
+	pc.client = &http.Client{
+		Timeout:       pc.timeout,
+		Transport:     roundTripper,
+		CheckRedirect: pc.checkRedirect,
+	}
+	pc.vhost = &http.Client{
+		Timeout:       pc.timeout,
+		Transport:     vhostRoundTripper,
+		CheckRedirect: pc.checkRedirect,
+	}
+	return pc
+}
+
+// probeDialer connects probes through dialer, applying the HTTP config of
+// the probe's context: with ProbeViaResolvers a name is looked up through
+// the scan's resolvers, its addresses tried in turn, and ProbeHTTPPort and
+// ProbeHTTPSPort stand in for 80 and 443
+func probeDialer(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		cfg := configFrom(ctx)
+		switch {
+		case port == "80" && cfg.HTTP.ProbeHTTPPort > 0:
+			port = strconv.Itoa(cfg.HTTP.ProbeHTTPPort)
+		case port == "443" && cfg.HTTP.ProbeHTTPSPort > 0:
+			port = strconv.Itoa(cfg.HTTP.ProbeHTTPSPort)
+		}
+		if !cfg.HTTP.ProbeViaResolvers || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, net.JoinHostPort(host, port))
+		}
+
+		ips, err := resolverFrom(ctx).LookupHostAll(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// checkRedirect records each redirect in the request's probeTrace and
+// stops at the redirect limit or a non-HTTP location
+func (pc *probeClient) checkRedirect(req *http.Request, via []*http.Request) error {
+	trace, _ := req.Context().Value(probeTraceKey{}).(*probeTrace)
+	if trace != nil && req.Response != nil {
+		trace.headers = append(trace.headers, req.Response.Header)
+		trace.hops = append(trace.hops, ProbeHop{URL: req.Response.Request.URL.String(), Status: req.Response.StatusCode})
+	}
+	if len(via) >= pc.maxRedirects {
+		return fmt.Errorf("too many redirects (%d)", len(via))
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return &offWebRedirectError{location: req.URL.String()}
+	}
+	return nil
+}
+
+func initializeProbeClient() {
+	sharedProbe.Store(newProbeClient(currentConfig()))
+}
+
+// currentProbeClient returns the shared probe client, building it for
+// paths that start without initialize (the test target, CLI modes)
+func currentProbeClient() *probeClient {
+	if pc := sharedProbe.Load(); pc != nil {
+		return pc
+	}
+	sharedProbe.CompareAndSwap(nil, newProbeClient(currentConfig()))
+	return sharedProbe.Load()
+}
+
+// probeHTTPClient returns the client for probes run under ctx: the vhost
+// client when withVHost attached an address, the shared one otherwise.
+// Either gives up after the HTTP.Timeout of ctx's config, which a job's
+// probe_timeout= may have changed.
+func probeHTTPClient(ctx context.Context) *http.Client {
+	pc := currentProbeClient()
+	client := pc.client
+	if _, ok := ctx.Value(vhostKey{}).(vhostTarget); ok {
+		client = pc.vhost
+	}
+	if timeout := configFrom(ctx).HTTP.Timeout; timeout != pc.timeout {
+		scoped := *client
+		scoped.Timeout = timeout
+		return &scoped
+	}
+	return client
+}
+
+// vhostTarget sends connections for host to ip, for probing a name whose
+// DNS record is gone at an address known from elsewhere
+type vhostTarget struct {
+	host string
+	ip   string
+}
+
+type vhostKey struct{}
+
+func withVHost(ctx context.Context, host, ip string) context.Context {
+	return context.WithValue(ctx, vhostKey{}, vhostTarget{host: host, ip: ip})
+}
+
+// sharedProbeTransport returns the connection pool used by all probes
+func sharedProbeTransport() *http.Transport {
+	return currentProbeClient().transport
+}
+
+// reloadProbeClient swaps in a probe client for cfg if the timeout,
+// redirect limit or TLS verification changed. Probes in flight finish on
+// the old one, whose idle connections are closed.
+func reloadProbeClient(cfg *Config) {
+	previous := sharedProbe.Load()
+	if previous == nil || (previous.timeout == cfg.HTTP.Timeout &&
+		previous.maxRedirects == cfg.HTTP.MaxRedirects &&
+		previous.skipVerify == cfg.HTTP.SkipTLSVerify) {
+		return
+	}
+	sharedProbe.Store(newProbeClient(cfg))
+	previous.transport.CloseIdleConnections()
+	log.Printf("Probe client rebuilt for the new HTTP settings")
+}
+
+// AvailabilitySample aggregates repeated probes of one URL
+type AvailabilitySample struct {
+	Requested int   `json:"requested"`
+	Taken     int   `json:"taken"`
+	Failures  int   `json:"failures"`
+	MinMS     int64 `json:"min_ms"`
+	MedianMS  int64 `json:"median_ms"`
+	MaxMS     int64 `json:"max_ms"`
+	Flapping  bool  `json:"flapping"`
+}
+
+// sampleAvailability probes targetURL until `samples` probes (including the
+// first one) are taken, spaced SampleInterval apart. A sample is skipped
+// rather than stretching the probe past SampleBudget when the slowest one
+// so far would not fit in what is left of it, and none may outlast it.
+func sampleAvailability(ctx context.Context, targetURL string, first ProbeResponse, samples int) *AvailabilitySample {
+	cfg := configFrom(ctx)
+	if samples > cfg.HTTP.MaxSamples {
+		samples = cfg.HTTP.MaxSamples
+	}
+	sample := &AvailabilitySample{Requested: samples}
+	var durations []int64
+	record := func(result ProbeResponse) {
+		sample.Taken++
+		if result.Status == "0" || result.Error != "" {
+			sample.Failures++
+			return
+		}
+		durations = append(durations, result.ProbeTime)
+	}
+	record(first)
+
+	deadline := time.Now().Add(cfg.HTTP.SampleBudget)
+	slowest := time.Duration(first.ProbeTime) * time.Millisecond
+	for sample.Taken < samples {
+		if time.Now().Add(cfg.HTTP.SampleInterval + slowest).After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(cfg.HTTP.SampleInterval):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		start := time.Now()
+		probeCtx, cancel := context.WithDeadline(ctx, deadline)
+		result := probeURL(probeCtx, probeHTTPClient(ctx), targetURL)
+		cut := probeCtx.Err() != nil && (result.Status == "0" || result.Error != "")
+		cancel()
+		if cut {
+			// Out of budget or cancelled, which says nothing of the host
+			break
+		}
+		elapsed := time.Since(start)
+		result.ProbeTime = elapsed.Milliseconds()
+		slowest = max(slowest, elapsed)
+		atomic.AddInt64(&stats.TotalProbes, 1)
+		record(result)
+	}
+
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		sample.MinMS = durations[0]
+		sample.MedianMS = durations[len(durations)/2]
+		sample.MaxMS = durations[len(durations)-1]
+	}
+	sample.Flapping = sample.Failures > 0 && sample.Failures < sample.Taken
+	return sample
+}
+
+func extractTitle(html string) string {
+	matches := titleRe.FindStringSubmatch(html)
+	if len(matches) < 2 {
+		return "No title"
+	}
+
+	title := strings.TrimSpace(matches[1])
+	title = strings.ReplaceAll(title, "\n", " ")
+	title = strings.ReplaceAll(title, "\r", " ")
+	title = regexp.MustCompile(`\s+`).ReplaceAllString(title, " ")
+
+	if len(title) > 100 {
+		title = title[:100] + "..."
+	}
+
+	return title
+}
+
+type ProbeResponse struct {
+	Status         string   `json:"status"`
+	Title          string   `json:"title"`
+	Error          string   `json:"error"`
+	ProbeTime      int64    `json:"probe_time_ms,omitempty"`
+	DiscoveredSANs []string `json:"discovered_sans,omitempty"`
+	HeaderHosts    []string `json:"header_hosts,omitempty"`
+	Technologies   []string `json:"technologies,omitempty"`
+
+	// What the page is: "app", or "default", "parked" or "error" with the
+	// pageclasses.json signature that matched
+	PageClass     string `json:"page_class,omitempty"`
+	PageSignature string `json:"page_signature,omitempty"`
+
+	// Measures of the (decoded) body as read, for clustering identical
+	// responses; absent when the body was not downloaded
+	ContentLength int    `json:"content_length,omitempty"`
+	WordCount     int    `json:"word_count,omitempty"`
+	LineCount     int    `json:"line_count,omitempty"`
+	BodySHA256    string `json:"body_sha256,omitempty"`
+
+	// Security headers of the final response, for posture triage
+	SecurityHeaders *SecurityHeaders `json:"security_headers,omitempty"`
+
+	// The port probed, when it came from ports=
+	Port int `json:"port,omitempty"`
+
+	// The address a vhost probe connected to in place of resolving the host
+	ConnectedIP string `json:"connected_ip,omitempty"`
+
+	// The address that served the response and its family (ipv4 or ipv6);
+	// absent when the probe went through a proxy
+	RemoteIP      string `json:"remote_ip,omitempty"`
+	AddressFamily string `json:"address_family,omitempty"`
+
+	// Shodan-style mmh3 hash of /favicon.ico, with favicon=true
+	FaviconHash *int32 `json:"favicon_hash,omitempty"`
+
+	// Where the probe ended up, and every response on the way there when
+	// it was redirected
+	FinalURL  string     `json:"final_url,omitempty"`
+	Redirects []ProbeHop `json:"redirect_chain,omitempty"`
+
+	// Set when the probe stopped without a page: "redirects off-web" for a
+	// redirect to Location that isn't http(s), "http to https port" when
+	// the server refused plain HTTP on a TLS port
+	Outcome  string     `json:"outcome,omitempty"`
+	Location string     `json:"location,omitempty"`
+	Body     *ProbeBody `json:"body,omitempty"`
+
+	// The certificate the host presented, for https probes only
+	TLS *ProbeTLS `json:"tls,omitempty"`
+
+	Availability *AvailabilitySample `json:"availability,omitempty"`
+
+	// Set when the page matches what a random name under the job's target
+	// answers on the same scheme and port (wildcard=true)
+	WildcardResponse bool `json:"wildcard_response,omitempty"`
+
+	// Set when the result came from the probe cache, with when the host
+	// was actually probed
+	Cached   bool       `json:"cached,omitempty"`
+	ProbedAt *time.Time `json:"probed_at,omitempty"`
+
+	// Tries it took, HTTP_PROBE_RETRIES included
+	Attempts int `json:"attempts,omitempty"`
+
+	// What made the probe fail, for deciding whether to retry it
+	err error
+}
+
+// ProbeTLS describes the leaf certificate of an https probe. Probes may
+// skip verification (HTTP_SKIP_TLS_VERIFY), so the chain is checked
+// separately against the system roots for Validated.
+type ProbeTLS struct {
+	Issuer      string    `json:"issuer"`
+	SubjectCN   string    `json:"subject_cn"`
+	SANs        []string  `json:"sans,omitempty"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Validated   bool      `json:"validated"`
+	VerifyError string    `json:"verify_error,omitempty"`
+	Expired     bool      `json:"expired"`
+	SelfSigned  bool      `json:"self_signed"`
+}
+
+// probeTLSDetails returns the certificate details of a TLS connection to
+// host, or nil for a plain-HTTP response
+func probeTLSDetails(state *tls.ConnectionState, host string) *ProbeTLS {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := state.PeerCertificates[0]
+	details := &ProbeTLS{
+		Issuer:    leaf.Issuer.String(),
+		SubjectCN: leaf.Subject.CommonName,
+		SANs:      leaf.DNSNames,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		Expired:   time.Now().After(leaf.NotAfter),
+		// CheckSignatureFrom would insist on a CA, which most self-signed
+		// server certificates are not
+		SelfSigned: bytes.Equal(leaf.RawIssuer, leaf.RawSubject) &&
+			leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature) == nil,
+	}
+
+	if len(state.VerifiedChains) > 0 {
+		details.Validated = true
+		return details
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates})
+	if err != nil {
+		details.VerifyError = err.Error()
+	} else {
+		details.Validated = true
+	}
+	return details
+}
+
+// Upper bound on SANs taken from a single certificate; CDN certificates can
+// carry hundreds of unrelated names
+const maxDiscoveredSANs = 100
+
+// harvestSANs returns the deduplicated DNS SANs of the peer certificate that
+// fall under the probed host's registered domain
+func harvestSANs(state *tls.ConnectionState, host string) []string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	registered, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(host))
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]struct{})
+	var sans []string
+	for _, name := range state.PeerCertificates[0].DNSNames {
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "*.")
+		if name != registered && !strings.HasSuffix(name, "."+registered) {
+			continue
+		}
+		if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+		sans = append(sans, name)
+		if len(sans) >= maxDiscoveredSANs {
+			break
+		}
+	}
+	return sans
+}
+
+// Response headers that commonly name other hosts of the same organisation
+var hostBearingHeaders = []string{
+	"Content-Security-Policy",
+	"Content-Security-Policy-Report-Only",
+	"Access-Control-Allow-Origin",
+	"Location",
+	"Link",
+}
+
+// harvestHeaderHosts returns the deduplicated hostnames named in CSP source
+// lists, CORS origins, Location and Link headers that fall under the probed
+// host's registered domain
+func harvestHeaderHosts(headers []http.Header, host string) []string {
+	registered, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(host))
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]struct{})
+	var hosts []string
+	add := func(candidate string) {
+		name := headerSourceHost(candidate)
+		if name == "" || name == strings.ToLower(host) {
+			return
+		}
+		if name != registered && !strings.HasSuffix(name, "."+registered) {
+			return
+		}
+		if _, dup := seen[name]; dup {
+			return
+		}
+		seen[name] = struct{}{}
+		hosts = append(hosts, name)
+	}
+
+	for _, header := range headers {
+		for _, key := range hostBearingHeaders {
+			for _, value := range header.Values(key) {
+				switch key {
+				case "Content-Security-Policy", "Content-Security-Policy-Report-Only":
+					// directive source source; directive source ...
+					for _, directive := range strings.Split(value, ";") {
+						fields := strings.Fields(directive)
+						if len(fields) < 2 {
+							continue
+						}
+						for _, source := range fields[1:] {
+							add(source)
+						}
+					}
+				case "Link":
+					// <uri>; rel=preconnect, <uri>; rel=preload
+					for _, link := range strings.Split(value, ",") {
+						link = strings.TrimSpace(link)
+						if strings.HasPrefix(link, "<") {
+							if end := strings.Index(link, ">"); end > 0 {
+								add(link[1:end])
+							}
+						}
+					}
+				default:
+					for _, origin := range strings.Fields(strings.ReplaceAll(value, ",", " ")) {
+						add(origin)
+					}
+				}
+			}
+		}
+	}
+	if len(hosts) > maxDiscoveredSANs {
+		hosts = hosts[:maxDiscoveredSANs]
+	}
+	return hosts
+}
+
+// headerSourceHost reduces a CSP source expression, origin or URL to its
+// hostname. Keywords ('self', 'nonce-...'), scheme-only sources and relative
+// URLs yield "". Wildcard sources like *.cdn.example.com yield the suffix.
+func headerSourceHost(source string) string {
+	source = strings.ToLower(strings.TrimSpace(source))
+	if source == "" || strings.HasPrefix(source, "'") || strings.HasPrefix(source, "/") {
+		return ""
+	}
+	if i := strings.Index(source, "://"); i >= 0 {
+		source = source[i+3:]
+	} else if strings.HasSuffix(source, ":") {
+		return ""
+	}
+	if i := strings.IndexAny(source, "/?#"); i >= 0 {
+		source = source[:i]
+	}
+	if i := strings.LastIndex(source, "@"); i >= 0 {
+		source = source[i+1:]
+	}
+	if i := strings.Index(source, ":"); i >= 0 {
+		source = source[:i]
+	}
+	source = strings.TrimSuffix(strings.TrimPrefix(source, "*."), ".")
+	if !domainRe.MatchString(source) {
+		return ""
+	}
+	return source
+}
+
+func writeProbeError(w http.ResponseWriter, message string, err error) {
+	response := ProbeResponse{
+		Status: "0",
+		Title:  "Connection failed",
+		Error:  fmt.Sprintf("%s: %v", message, err),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Enhanced statistics handler
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := configFrom(r.Context())
+	stats.mu.RLock()
+	defer stats.mu.RUnlock()
+
+	uptime := time.Since(stats.StartTime)
+
+	response := map[string]interface{}{
+		"uptime_seconds":    uptime.Seconds(),
+		"total_requests":    atomic.LoadInt64(&stats.TotalRequests),
+		"active_jobs":       atomic.LoadInt64(&stats.ActiveJobs),
+		"completed_jobs":    atomic.LoadInt64(&stats.CompletedJobs),
+		"failed_jobs":       atomic.LoadInt64(&stats.FailedJobs),
+		"total_subdomains":  atomic.LoadInt64(&stats.TotalSubdomains),
+		"total_probes":      atomic.LoadInt64(&stats.TotalProbes),
+		"successful_probes": atomic.LoadInt64(&stats.SuccessfulProbes),
+		"dns_queries":       atomic.LoadInt64(&stats.DNSQueries),
+		"dns_retries":       atomic.LoadInt64(&stats.DNSRetries),
+		"dns_tcp_fallbacks": atomic.LoadInt64(&stats.DNSTCPFallbacks),
+		"dns_timeouts":      atomic.LoadInt64(&stats.DNSTimeouts),
+		"dns_rcodes":        dnsRcodeCounts(),
+		"dns_cache":         dnsCacheStats(),
+		"probe_cache":       probeCacheStats(),
+		"dns_qps": map[string]interface{}{
+			"max_qps":            cfg.DNS.MaxQPS,
+			"max_qps_per_server": cfg.DNS.MaxQPSPerServer,
+			"waiting":            atomic.LoadInt64(&stats.DNSQPSWaiting),
+			"delayed":            atomic.LoadInt64(&stats.DNSQPSDelayed),
+		},
+		"dangling": map[string]int64{
+			"dangling-cname": atomic.LoadInt64(&stats.DanglingCNAMEs),
+			"unbound-ip":     atomic.LoadInt64(&stats.UnboundIPs),
+		},
+		"evicted_jobs": map[string]int64{
+			"expired":    atomic.LoadInt64(&stats.ExpiredJobs),
+			"over_limit": atomic.LoadInt64(&stats.OverLimitJobs),
+			"over_size":  atomic.LoadInt64(&stats.OversizeJobs),
+			"deleted":    atomic.LoadInt64(&stats.DeletedJobs),
+		},
+		"job_store":     jobStoreStats(),
+		"retries":       retryCounts(),
+		"last_activity": stats.LastActivity,
+		"source_stats":  stats.SourceStats,
+		"memory_usage":  getMemoryUsage(),
+		"dns_servers":   defaultResolver().Health(),
+		"http_pools":    httpPoolStats(),
+		"rate_limit":    fmt.Sprintf("%d/s", cfg.RateLimit.RequestsPerSecond),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Health check handlers
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"status":           "healthy",
+		"timestamp":        time.Now().Format(time.RFC3339),
+		"version":          "2.0.0",
+		"metrics_listener": metricsListener.Status(),
+	}
+	if state := maintenance.Get(); state.Enabled {
+		response["status"] = "maintenance"
+		response["maintenance"] = state
+	}
+	if passiveOnly {
+		response["passive_only"] = true
+		response["disabled_sources"] = disabledSources
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func readinessHandler(w http.ResponseWriter, r *http.Request) {
+	// Check if critical services are ready
+	ready := true
+	checks := make(map[string]bool)
+
+	// Drain traffic away while an operator has scanning switched off
+	checks["maintenance"] = !maintenance.Enabled()
+	if maintenance.Enabled() {
+		ready = false
+	}
+
+	// Prometheus scrapes nothing if the dedicated listener never came up
+	checks["metrics_listener"] = metricsListener.Healthy()
+	if !metricsListener.Healthy() {
+		ready = false
+	}
+
+	// Check DNS resolver, unless passive-only mode keeps it idle
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// Each server is asked directly, so one that is blocked (port 53
+	// filtered, DoH endpoint down) shows up even while the others answer
+	var servers []ServerCheck
+	if !passiveOnly {
+		servers = defaultResolver().CheckEach(ctx, "google.com")
+		checks["dns"] = false
+		for _, server := range servers {
+			if server.OK {
+				checks["dns"] = true
+			}
+		}
+		if !checks["dns"] {
+			ready = false
+		}
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ready":        ready,
+		"checks":       checks,
+		"dns_servers":  servers,
+		"passive_only": passiveOnly,
+	})
+}
+
+// Enhanced configuration handler
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := configFrom(r.Context())
+	if r.Method == http.MethodGet {
+		timeouts := make(map[string]string)
+		var sources []map[string]interface{}
+		for _, entry := range sourceRegistry {
+			name := entry.source.Name()
+			timeouts[name] = entry.timeout(cfg.Timeouts).String()
+			source := map[string]interface{}{
+				"name":          name,
+				"endpoint":      "/api/" + name + "/stream",
+				"needs_api_key": entry.requiresKey,
+				"enrichment":    entry.enrichment,
+			}
+			if entry.apiKey != nil {
+				source["api_key_configured"] = entry.apiKey(cfg.APIKeys) != ""
+			}
+			sources = append(sources, source)
+		}
+		profiles := make([]ScanProfile, len(scanProfiles))
+		for i, profile := range scanProfiles {
+			if profile.Sources == nil {
+				for _, entry := range sourceRegistry {
+					if !entry.enrichment {
+						profile.Sources = append(profile.Sources, entry.source.Name())
+					}
+				}
+			}
+			profiles[i] = profile
+		}
+
+		// Return current configuration (sanitized)
+		signing := map[string]interface{}{"enabled": evidenceSigner != nil}
+		if evidenceSigner != nil {
+			signing["key_fingerprint"] = evidenceSigner.fingerprint
+		}
+		sanitizedConfig := map[string]interface{}{
+			"passive_only":     passiveOnly,
+			"disabled_sources": disabledSources,
+			"timeouts":         timeouts,
+			"sources":          sources,
+			"profiles":         profiles,
+			"signing":          signing,
+			"proxy":            proxyStatus(cfg),
+			"dns": map[string]interface{}{
+				"servers":     cfg.DNS.Servers,
+				"transports":  dnsServerTransports(cfg.DNS.Servers),
+				"concurrency": cfg.DNS.Concurrency,
+				"timeout":     cfg.DNS.Timeout.String(),
+				"tls_timeout": cfg.DNS.TLSTimeout.String(),
+			},
+			"rate_limit": map[string]interface{}{
+				"requests_per_second": cfg.RateLimit.RequestsPerSecond,
+				"burst_size":          cfg.RateLimit.BurstSize,
+			},
+			// The most a job may ask for with its overrides
+			"job_limits": map[string]interface{}{
+				"concurrency":   cfg.Security.MaxJobDNSConcurrency,
+				"dns_timeout":   cfg.Security.MaxJobDNSTimeout.String(),
+				"probe_timeout": cfg.Security.MaxJobProbeTimeout.String(),
+				"dns_qps":       cfg.Security.MaxJobDNSQPS,
+			},
+			"wordlist_categories":  getWordlistCategories(),
+			"permutation_patterns": describePermutationPatterns(),
+			"cloud_ranges":         cloudRangeStatus(),
+			"post_processors":      processors.Describe(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sanitizedConfig)
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+func getWordlistCategories() map[string]int {
+	categories := make(map[string]int)
+	for category, words := range commonSubdomains {
+		categories[category] = len(words)
+	}
+	return categories
+}
+
+// Wordlist is a named list of brute-force labels. The built-in categories
+// stay in memory; external lists are compiled to a compact sorted file once
+// and streamed from disk by every scan that uses them, so they cost nothing
+// while idle.
+type Wordlist struct {
+	Name string
+
+	words []string // resident lists
+
+	source     string // text file a disk-backed list is compiled from
+	path       string // compiled file
+	uploaded   bool   // added through the API, which may also delete it
+	count      int
+	diskBytes  int64
+	compiledAt time.Time
+	mu         sync.Mutex // serializes recompiles
+}
+
+// WordlistInfo is what /api/wordlists reports for one list
+type WordlistInfo struct {
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"` // builtin, file or upload
+	Words       int       `json:"words"`
+	MemoryBytes int64     `json:"memory_bytes"`
+	DiskBytes   int64     `json:"disk_bytes"`
+	Source      string    `json:"source,omitempty"`
+	CompiledAt  time.Time `json:"compiled_at,omitzero"`
+}
+
+// errWordlistUnavailable marks a disk-backed list whose compiled file and
+// source are both gone
+var errWordlistUnavailable = errors.New("wordlist unavailable")
+
+// wordlistMagic starts every compiled wordlist file. The header continues
+// with the label count as a uvarint; each label follows as one length byte
+// and its bytes, sorted and without duplicates.
+const wordlistMagic = "SEWL\x01"
+
+var (
+	wordlistsMu sync.RWMutex
+	wordlists   = make(map[string]*Wordlist)
+)
+
+// wordlistDir holds compiled wordlists and, under uploads, the sources of
+// uploaded ones: DATA_DIR/wordlists, or a temporary directory without
+// DATA_DIR
+func wordlistDir(cfg *Config) string {
+	if cfg.DataDir != "" {
+		return filepath.Join(cfg.DataDir, "wordlists")
+	}
+	return filepath.Join(os.TempDir(), "subdomain-enum-wordlists")
+}
+
+//go:embed wordlist.txt
+var embeddedWordlist string
+
+// extendedCategory is the built-in category of the embedded wordlist. It
+// is too big for a quick scan, so scans only use it when named.
+const extendedCategory = "extended"
+
+// loadExtendedWordlist fills the extended category from the embedded list
+// and DNS_WORDLIST_FILE. Labels are deduplicated as they stream in; those
+// from the embedded list stay slices of it, so they cost no copy.
+func loadExtendedWordlist(cfg *Config) {
+	var words []string
+	seen := make(map[string]struct{})
+	add := func(label string) {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label == "" || strings.HasPrefix(label, "#") || len(label) > 253 || !wordRe.MatchString(label) {
+			return
+		}
+		if _, dup := seen[label]; dup {
+			return
+		}
+		seen[label] = struct{}{}
+		words = append(words, label)
+	}
+
+	if cfg.DNS.WordlistFile == "" || cfg.DNS.WordlistMode != "replace" {
+		for line := range strings.Lines(embeddedWordlist) {
+			add(line)
+		}
+	}
+	if cfg.DNS.WordlistFile != "" {
+		f, err := os.Open(cfg.DNS.WordlistFile)
+		if err == nil {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				add(scanner.Text())
+			}
+			err = scanner.Err()
+			f.Close()
+		}
+		if err != nil {
+			log.Printf("Warning: DNS_WORDLIST_FILE %s: %v", cfg.DNS.WordlistFile, err)
+		}
+	}
+
+	commonSubdomains[extendedCategory] = slices.Clip(words)
+	log.Printf("📚 Extended wordlist: %d words", len(words))
+}
+
+// initializeWordlists loads the extended category, compiles the lists
+// named in DNS_WORDLISTS into wordlistDir and loads the lists uploaded
+// earlier. Compiled files newer than their source are reused.
+func initializeWordlists() {
+	cfg := currentConfig()
+	loadExtendedWordlist(cfg)
+
+	dir := wordlistDir(cfg)
+	uploads, _ := filepath.Glob(filepath.Join(dir, "uploads", "*.txt"))
+	if len(cfg.DNS.Wordlists) == 0 && len(uploads) == 0 {
+		return
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Printf("Warning: cannot create wordlist directory %s: %v", dir, err)
+		return
+	}
+
+	for _, spec := range cfg.DNS.Wordlists {
+		name, path, ok := strings.Cut(spec, "=")
+		if !ok {
+			path = name
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, builtin := commonSubdomains[name]; builtin || name == "" {
+			log.Printf("Warning: wordlist %s: name %q is empty or taken by a built-in category", path, name)
+			continue
+		}
+
+		wl := &Wordlist{Name: name, source: path, path: filepath.Join(dir, name+".wl")}
+		if err := wl.load(); err != nil {
+			log.Printf("Warning: wordlist %s: %v", name, err)
+			continue
+		}
+		wordlistsMu.Lock()
+		wordlists[name] = wl
+		wordlistsMu.Unlock()
+		log.Printf("📚 Wordlist %s: %d words, %d bytes on disk", name, wl.count, wl.diskBytes)
+	}
+
+	// A configured list keeps its name over an upload left from before
+	for _, source := range uploads {
+		name := strings.TrimSuffix(filepath.Base(source), ".txt")
+		wordlistsMu.Lock()
+		_, taken := wordlists[name]
+		wordlistsMu.Unlock()
+		if _, builtin := commonSubdomains[name]; builtin || taken || !wordlistNameRe.MatchString(name) {
+			log.Printf("Warning: skipping uploaded wordlist %s: name %q is invalid or taken", source, name)
+			continue
+		}
+		wl := &Wordlist{Name: name, source: source, path: filepath.Join(dir, name+".wl"), uploaded: true}
+		if err := wl.load(); err != nil {
+			log.Printf("Warning: uploaded wordlist %s: %v", name, err)
+			continue
+		}
+		wordlistsMu.Lock()
+		wordlists[name] = wl
+		wordlistsMu.Unlock()
+	}
+
+	// Compiling held every label at once; hand that memory back now
+	debug.FreeOSMemory()
+}
+
+// lookupWordlist returns the built-in category or external list called name
+func lookupWordlist(name string) (*Wordlist, bool) {
+	if words, ok := commonSubdomains[name]; ok {
+		return &Wordlist{Name: name, words: words, count: len(words)}, true
+	}
+	wordlistsMu.RLock()
+	defer wordlistsMu.RUnlock()
+	wl, ok := wordlists[name]
+	return wl, ok
+}
+
+// load reuses the compiled file when it is newer than the source, and
+// compiles the source otherwise
+func (wl *Wordlist) load() error {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	src, srcErr := os.Stat(wl.source)
+	if compiled, err := os.Stat(wl.path); err == nil && (srcErr != nil || compiled.ModTime().After(src.ModTime())) {
+		if count, err := readWordlistHeader(wl.path); err == nil {
+			wl.count, wl.diskBytes, wl.compiledAt = count, compiled.Size(), compiled.ModTime()
+			return nil
+		}
+	}
+	if srcErr != nil {
+		return fmt.Errorf("%w: %v", errWordlistUnavailable, srcErr)
+	}
+	return wl.compile()
+}
+
+// compile reads the source text (one label per line, # comments), keeps
+// valid labels, sorts and dedupes them and writes the compiled file
+// atomically, so a scan streaming the old file keeps its copy
+func (wl *Wordlist) compile() error {
+	f, err := os.Open(wl.source)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errWordlistUnavailable, err)
+	}
+	defer f.Close()
+
+	var labels []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		label := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if label == "" || strings.HasPrefix(label, "#") {
+			continue
+		}
+		if len(label) > 253 || !wordRe.MatchString(label) {
+			continue
+		}
+		labels = append(labels, label)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", wl.source, err)
+	}
+	slices.Sort(labels)
+	labels = slices.Compact(labels)
+
+	tmp, err := os.CreateTemp(filepath.Dir(wl.path), wl.Name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriterSize(tmp, 64*1024)
+	w.WriteString(wordlistMagic)
+	w.Write(binary.AppendUvarint(nil, uint64(len(labels))))
+	for _, label := range labels {
+		w.WriteByte(byte(len(label)))
+		w.WriteString(label)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), wl.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(wl.path)
+	if err != nil {
+		return err
+	}
+	wl.count, wl.diskBytes, wl.compiledAt = len(labels), info.Size(), info.ModTime()
+	return nil
+}
+
+// readWordlistHeader checks a compiled file's magic and returns its count
+func readWordlistHeader(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	_, count, err := wordlistReader(f)
+	return count, err
+}
+
+// wordlistReader checks the header of a compiled wordlist and returns a
+// reader positioned at the first label
+func wordlistReader(f *os.File) (*bufio.Reader, int, error) {
+	r := bufio.NewReaderSize(f, 64*1024)
+	magic := make([]byte, len(wordlistMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != wordlistMagic {
+		return nil, 0, fmt.Errorf("%s is not a compiled wordlist", f.Name())
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: bad header: %w", f.Name(), err)
+	}
+	return r, int(count), nil
+}
+
+// Each calls fn with every label in order until fn returns false. A
+// disk-backed list whose compiled file has been deleted is recompiled from
+// its source first; once opened, the file is read to the end even if it is
+// removed or replaced meanwhile. A file cut short is reported as an error
+// after the labels that could be read.
+func (wl *Wordlist) Each(fn func(label string) bool) error {
+	if wl.path == "" {
+		for _, word := range wl.words {
+			if !fn(word) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(wl.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Compiled wordlist %s is missing, recompiling from %s", wl.Name, wl.source)
+		if err := wl.load(); err != nil {
+			return err
+		}
+		f, err = os.Open(wl.path)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errWordlistUnavailable, err)
+	}
+	defer f.Close()
+
+	r, count, err := wordlistReader(f)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 255)
+	for i := 0; i < count; i++ {
+		n, err := r.ReadByte()
+		if err == nil {
+			_, err = io.ReadFull(r, buf[:n])
+		}
+		if err != nil {
+			return fmt.Errorf("wordlist %s ended after %d of %d labels: %w", wl.Name, i, count, err)
+		}
+		if !fn(string(buf[:n])) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Contains reports whether label is in the list. Compiled files are
+// sorted, so the scan stops once it has passed where label would be.
+func (wl *Wordlist) Contains(label string) bool {
+	found := false
+	wl.Each(func(word string) bool {
+		if word == label {
+			found = true
+		}
+		return !found && (wl.path == "" || word < label)
+	})
+	return found
+}
+
+// Info reports the list's size and where it lives. Resident lists count
+// their string data and headers; disk-backed lists only keep metadata.
+func (wl *Wordlist) Info() WordlistInfo {
+	info := WordlistInfo{Name: wl.Name}
+	if wl.path == "" {
+		info.Kind = "builtin"
+		info.Words = len(wl.words)
+		info.MemoryBytes = sliceHeaderBytes + int64(len(wl.words))*stringHeaderBytes
+		for _, word := range wl.words {
+			info.MemoryBytes += int64(len(word))
+		}
+		return info
+	}
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	info.Kind = "file"
+	if wl.uploaded {
+		info.Kind = "upload"
+	}
+	info.Words = wl.count
+	info.DiskBytes = wl.diskBytes
+	info.Source = wl.source
+	info.CompiledAt = wl.compiledAt
+	return info
+}
+
+// Header sizes on 64-bit platforms, for resident footprints
+const (
+	sliceHeaderBytes  = 24
+	stringHeaderBytes = 16
+)
+
+// wordlistNameRe is what uploaded wordlists may be called
+var wordlistNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// wordlistsHandler lists the built-in categories and external wordlists
+// with their memory and disk footprint (GET), takes uploads (POST) and
+// deletes uploaded lists (DELETE /api/wordlists/{name})
+func wordlistsHandler(w http.ResponseWriter, r *http.Request) {
+	if name, ok := strings.CutPrefix(r.URL.Path, "/api/wordlists/"); ok {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		deleteWordlist(w, name)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		uploadWordlist(w, r)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var infos []WordlistInfo
+	for name := range commonSubdomains {
+		wl, _ := lookupWordlist(name)
+		infos = append(infos, wl.Info())
+	}
+	wordlistsMu.RLock()
+	for _, wl := range wordlists {
+		infos = append(infos, wl.Info())
+	}
+	wordlistsMu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"wordlists": infos})
+}
+
+// uploadWordlist stores a plaintext body (one label per line, # comments)
+// as the wordlist ?name=, replacing an earlier upload of that name. Labels
+// are single DNS labels; the list is sorted and deduplicated, then compiled
+// like a configured one.
+func uploadWordlist(w http.ResponseWriter, r *http.Request) {
+	cfg := configFrom(r.Context())
+	name := strings.ToLower(r.URL.Query().Get("name"))
+	if !wordlistNameRe.MatchString(name) {
+		http.Error(w, "name must be 1-64 lowercase letters, digits, - or _", http.StatusBadRequest)
+		return
+	}
+	if _, builtin := commonSubdomains[name]; builtin {
+		http.Error(w, "name is taken by a built-in category", http.StatusConflict)
+		return
+	}
+	wordlistsMu.RLock()
+	existing := wordlists[name]
+	wordlistsMu.RUnlock()
+	if existing != nil && !existing.uploaded {
+		http.Error(w, "name is taken by a configured wordlist", http.StatusConflict)
+		return
+	}
+
+	// A label takes at most 64 bytes with its newline; leave room for
+	// comments and CRLF line ends
+	maxBytes := int64(cfg.DNS.UploadMaxWords) * 128
+	body := io.LimitReader(r.Body, maxBytes+1)
+	var labels []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(body)
+	for line := 1; scanner.Scan(); line++ {
+		label := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if label == "" || strings.HasPrefix(label, "#") {
+			continue
+		}
+		if strings.Contains(label, ".") || !wordRe.MatchString(label) {
+			http.Error(w, fmt.Sprintf("line %d: invalid label %q: use one label per line of letters, digits, - and _", line, label), http.StatusBadRequest)
+			return
+		}
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		labels = append(labels, label)
+		if len(labels) > cfg.DNS.UploadMaxWords {
+			http.Error(w, fmt.Sprintf("wordlist has more than %d labels", cfg.DNS.UploadMaxWords), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, fmt.Sprintf("reading wordlist: %v", err), http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength > maxBytes || body.(*io.LimitedReader).N == 0 {
+		http.Error(w, fmt.Sprintf("wordlist is larger than %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(labels) == 0 {
+		http.Error(w, "wordlist has no labels", http.StatusBadRequest)
+		return
+	}
+	slices.Sort(labels)
+
+	dir := wordlistDir(cfg)
+	wl := &Wordlist{
+		Name:     name,
+		source:   filepath.Join(dir, "uploads", name+".txt"),
+		path:     filepath.Join(dir, name+".wl"),
+		uploaded: true,
+	}
+	if err := writeWordlistSource(wl.source, labels); err != nil {
+		log.Printf("Failed to store wordlist %s: %v", name, err)
+		http.Error(w, "failed to store wordlist", http.StatusInternalServerError)
+		return
+	}
+	wl.mu.Lock()
+	err := wl.compile()
+	wl.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to compile wordlist %s: %v", name, err)
+		http.Error(w, "failed to store wordlist", http.StatusInternalServerError)
+		return
+	}
+
+	wordlistsMu.Lock()
+	wordlists[name] = wl
+	wordlistsMu.Unlock()
+	log.Printf("📚 Wordlist %s uploaded: %d words", name, wl.count)
+
+	status := http.StatusCreated
+	if existing != nil {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(wl.Info())
+}
+
+// writeWordlistSource writes labels one per line, replacing path atomically
+func writeWordlistSource(path string, labels []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriterSize(tmp, 64*1024)
+	for _, label := range labels {
+		w.WriteString(label)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// deleteWordlist removes an uploaded list and its files. Scans already
+// streaming it read their open file to the end.
+func deleteWordlist(w http.ResponseWriter, name string) {
+	wordlistsMu.Lock()
+	wl, ok := wordlists[name]
+	if ok && wl.uploaded {
+		delete(wordlists, name)
+	}
+	wordlistsMu.Unlock()
+
+	_, builtin := commonSubdomains[name]
+	switch {
+	case builtin || ok && !wl.uploaded:
+		http.Error(w, "only uploaded wordlists can be deleted", http.StatusConflict)
+		return
+	case !ok:
+		http.Error(w, "wordlist not found", http.StatusNotFound)
+		return
+	}
+
+	for _, path := range []string{wl.source, wl.path} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to remove %s: %v", path, err)
+		}
+	}
+	log.Printf("📚 Wordlist %s deleted", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Utility functions for environment variable parsing
+func getEnvString(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvStringSlice(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		return strings.Split(value, ",")
+	}
+	return defaultValue
+}
+
+// Memory usage monitoring
+func getMemoryUsage() map[string]interface{} {
+	// This is a simplified version - you might want to use runtime.MemStats
+	return map[string]interface{}{
+		"goroutines": "runtime.NumGoroutine() would go here",
+		"note":       "Implement with runtime.MemStats for production",
+	}
+}
+
+// metricsLandingPage is served at / on the dedicated metrics listener
+var metricsLandingPage = htmltemplate.Must(htmltemplate.New("metrics").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <title>{{call .T "metrics.title"}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; margin: 40px; background: #f5f5f5; }
+        .container { background: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+        .header { color: #333; border-bottom: 2px solid #4ade80; padding-bottom: 10px; }
+        .metrics-link { 
+            display: inline-block; 
+            background: #4ade80; 
+            color: white; 
+            text-decoration: none; 
+            padding: 10px 20px; 
+            border-radius: 5px; 
+            margin: 10px 0;
+        }
+        .metrics-link:hover { background: #22c55e; }
+        .info { background: #f0f9ff; padding: 15px; border-radius: 5px; margin: 20px 0; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1 class="header">{{call .T "metrics.heading"}}</h1>
+        <div class="info">
+            <p><strong>{{call .T "metrics.main_app"}}:</strong> <a href="{{.MainURL}}">{{.MainURL}}</a></p>
+            <p><strong>{{call .T "metrics.listener"}}:</strong> {{.MetricsAddr}}</p>
+            <p><strong>{{call .T "metrics.endpoint"}}:</strong> <a href="/metrics" class="metrics-link">{{call .T "metrics.view"}}</a></p>
+            <p><strong>{{call .T "metrics.health"}}:</strong> <a href="{{.MainURL}}/health">{{.MainURL}}/health</a></p>
+        </div>
+        <h3>{{call .T "metrics.available"}}:</h3>
+        <ul>
+            <li><a href="/metrics">/metrics</a> - {{call .T "metrics.prometheus_format"}}</li>
+            <li><a href="{{.MainURL}}/api/stats">{{.MainURL}}/api/stats</a> - {{call .T "metrics.json_stats"}}</li>
+        </ul>
+        <p>{{call .T "metrics.requests"}}: {{.Requests}}<br>{{.Generated}}</p>
+    </div>
+</body>
+</html>`))
+
+// Metrics listener states
+const (
+	metricsDisabled = "disabled"
+	metricsMainPort = "main-port"
+	metricsStarting = "starting"
+	metricsRunning  = "running"
+	metricsFallback = "fallback-to-main"
+)
+
+// MetricsListener tracks the dedicated metrics server so /health and /ready
+// can tell when Prometheus would be scraping nothing
+type MetricsListener struct {
+	State     string    `json:"state"`
+	Port      string    `json:"port,omitempty"`
+	Addr      string    `json:"addr,omitempty"`
+	Attempts  int       `json:"attempts,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Since     time.Time `json:"since"`
+
+	server *http.Server
+	mu     sync.RWMutex
+}
+
+var metricsListener = &MetricsListener{State: metricsDisabled, Since: time.Now()}
+
+// Status returns a copy of the listener state
+func (ml *MetricsListener) Status() MetricsListener {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+	return MetricsListener{
+		State:     ml.State,
+		Port:      ml.Port,
+		Addr:      ml.Addr,
+		Attempts:  ml.Attempts,
+		LastError: ml.LastError,
+		Since:     ml.Since,
+	}
+}
+
+// Healthy reports whether metrics are being served where configured
+func (ml *MetricsListener) Healthy() bool {
+	state := ml.Status().State
+	return state != metricsFallback && state != metricsStarting
+}
+
+func (ml *MetricsListener) setState(state, port, lastError string, attempts int) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.State = state
+	ml.Port = port
+	ml.LastError = lastError
+	ml.Attempts = attempts
+	ml.Since = time.Now()
+	if state != metricsRunning {
+		ml.Addr = ""
+	}
+}
+
+// startMetricsServer binds the dedicated metrics listener, retrying with
+// backoff while the port is taken. After the last attempt it falls back to
+// serving metrics from the main server only.
+func startMetricsServer() {
+	cfg := currentConfig()
+	if !cfg.Monitoring.EnableMetrics {
+		metricsListener.setState(metricsDisabled, "", "", 0)
+		return
+	}
+
+	// Don't start separate server if using same port as main server
+	if cfg.Monitoring.MetricsPort == cfg.Port {
+		log.Printf("Metrics server using main server port %s", cfg.Port)
+		metricsListener.setState(metricsMainPort, cfg.Port, "", 0)
+		return
+	}
+
+	port := cfg.Monitoring.MetricsPort
+	metricsListener.setState(metricsStarting, port, "", 0)
+
+	var listener net.Listener
+	policy := metricsBindRetryPolicy(cfg)
+	err := retry(context.Background(), policy, func(ctx context.Context, attempt int) error {
+		var err error
+		listener, err = net.Listen("tcp", ":"+port)
+		if err != nil {
+			metricsListener.setState(metricsStarting, port, err.Error(), attempt+1)
+			log.Printf("Metrics server bind attempt %d/%d on port %s failed: %v", attempt+1, policy.Attempts, port, err)
+		}
+		return err
+	})
+	if err != nil {
+		metricsListener.setState(metricsFallback, port, err.Error(), policy.Attempts)
+		log.Printf("Metrics are still available on main server: http://localhost:%s/metrics", cfg.Port)
+		return
+	}
+
+	mainURL := "http://localhost:" + cfg.Port
+	metricsAddr := listener.Addr().String()
+	metricsMux := http.NewServeMux()
+	metricsMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		locale := negotiateLocale(r)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Language", locale)
+		metricsLandingPage.Execute(w, map[string]interface{}{
+			"Lang":        locale,
+			"MainURL":     mainURL,
+			"MetricsAddr": metricsAddr,
+			"Requests":    formatNumber(locale, atomic.LoadInt64(&stats.TotalRequests)),
+			"Generated":   translate(locale, "metrics.generated", map[string]string{"time": formatTime(locale, time.Now())}),
+			"T":           func(key string) string { return translate(locale, key, nil) },
+		})
+	})
+	metricsMux.HandleFunc("/metrics", metricsHandler)
+	metricsMux.HandleFunc("/health", healthHandler)
+
+	server := &http.Server{
+		Handler:      metricsMux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	metricsListener.mu.Lock()
+	metricsListener.State = metricsRunning
+	metricsListener.Port = port
+	metricsListener.Addr = metricsAddr
+	metricsListener.LastError = ""
+	metricsListener.Since = time.Now()
+	metricsListener.server = server
+	metricsListener.mu.Unlock()
+
+	log.Printf("Starting dedicated metrics server on %s", metricsAddr)
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		log.Printf("Metrics server error on %s: %v", metricsAddr, err)
+		metricsListener.setState(metricsFallback, port, err.Error(), 0)
+		log.Printf("Metrics are still available on main server: http://localhost:%s/metrics", cfg.Port)
+	}
+}
+
+// rebindMetricsServer moves the dedicated metrics listener after a config
+// reload changed METRICS_PORT or ENABLE_METRICS
+func rebindMetricsServer(previous *Config) {
+	cfg := currentConfig()
+	if previous.Monitoring.MetricsPort == cfg.Monitoring.MetricsPort &&
+		previous.Monitoring.EnableMetrics == cfg.Monitoring.EnableMetrics &&
+		metricsListener.Healthy() {
+		return
+	}
+
+	metricsListener.mu.Lock()
+	server := metricsListener.server
+	metricsListener.server = nil
+	metricsListener.mu.Unlock()
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		server.Shutdown(ctx)
+		cancel()
+	}
+
+	log.Printf("Rebinding metrics server from port %s to %s", previous.Monitoring.MetricsPort, cfg.Monitoring.MetricsPort)
+	go startMetricsServer()
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	// Prometheus metrics format
 	metrics := fmt.Sprintf(`# HELP subdomain_scanner_requests_total Total number of requests
 # TYPE subdomain_scanner_requests_total counter
 subdomain_scanner_requests_total %d
 
-# HELP subdomain_scanner_active_jobs Current number of active jobs
-# TYPE subdomain_scanner_active_jobs gauge
-subdomain_scanner_active_jobs %d
+# HELP subdomain_scanner_active_jobs Current number of active jobs
+# TYPE subdomain_scanner_active_jobs gauge
+subdomain_scanner_active_jobs %d
+
+# HELP subdomain_scanner_subdomains_total Total number of subdomains discovered
+# TYPE subdomain_scanner_subdomains_total counter
+subdomain_scanner_subdomains_total %d
+
+# HELP subdomain_scanner_dns_queries_total Total number of DNS queries
+# TYPE subdomain_scanner_dns_queries_total counter
+subdomain_scanner_dns_queries_total %d
+
+# HELP subdomain_scanner_dns_retries_total DNS queries retried after a timeout, SERVFAIL or REFUSED
+# TYPE subdomain_scanner_dns_retries_total counter
+subdomain_scanner_dns_retries_total %d
+
+# HELP subdomain_scanner_dns_tcp_fallbacks_total Truncated UDP answers repeated over TCP
+# TYPE subdomain_scanner_dns_tcp_fallbacks_total counter
+subdomain_scanner_dns_tcp_fallbacks_total %d
+
+# HELP subdomain_scanner_uptime_seconds Uptime in seconds
+# TYPE subdomain_scanner_uptime_seconds counter
+subdomain_scanner_uptime_seconds %f
+`,
+		atomic.LoadInt64(&stats.TotalRequests),
+		atomic.LoadInt64(&stats.ActiveJobs),
+		atomic.LoadInt64(&stats.TotalSubdomains),
+		atomic.LoadInt64(&stats.DNSQueries),
+		atomic.LoadInt64(&stats.DNSRetries),
+		atomic.LoadInt64(&stats.DNSTCPFallbacks),
+		time.Since(stats.StartTime).Seconds(),
+	)
+
+	var servers strings.Builder
+	servers.WriteString("\n# HELP subdomain_scanner_dns_server_failures_total Failed queries per DNS server\n")
+	servers.WriteString("# TYPE subdomain_scanner_dns_server_failures_total counter\n")
+	for _, health := range defaultResolver().Health() {
+		fmt.Fprintf(&servers, "subdomain_scanner_dns_server_failures_total{server=%q} %d\n", health.Server, health.Failures)
+	}
+	metrics += servers.String()
+
+	var rcodes strings.Builder
+	rcodes.WriteString("\n# HELP subdomain_scanner_dns_responses_total DNS responses by rcode\n")
+	rcodes.WriteString("# TYPE subdomain_scanner_dns_responses_total counter\n")
+	counts := dnsRcodeCounts()
+	for _, rcode := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Fprintf(&rcodes, "subdomain_scanner_dns_responses_total{rcode=%q} %d\n", rcode, counts[rcode])
+	}
+	rcodes.WriteString("\n# HELP subdomain_scanner_dns_timeouts_total DNS queries that timed out\n")
+	rcodes.WriteString("# TYPE subdomain_scanner_dns_timeouts_total counter\n")
+	fmt.Fprintf(&rcodes, "subdomain_scanner_dns_timeouts_total %d\n", atomic.LoadInt64(&stats.DNSTimeouts))
+	rcodes.WriteString("\n# HELP subdomain_scanner_dns_cache_hits_total DNS lookups answered from the cache\n")
+	rcodes.WriteString("# TYPE subdomain_scanner_dns_cache_hits_total counter\n")
+	fmt.Fprintf(&rcodes, "subdomain_scanner_dns_cache_hits_total %d\n", atomic.LoadInt64(&stats.DNSCacheHits))
+	rcodes.WriteString("\n# HELP subdomain_scanner_dns_cache_misses_total DNS lookups the cache could not answer\n")
+	rcodes.WriteString("# TYPE subdomain_scanner_dns_cache_misses_total counter\n")
+	fmt.Fprintf(&rcodes, "subdomain_scanner_dns_cache_misses_total %d\n", atomic.LoadInt64(&stats.DNSCacheMisses))
+	rcodes.WriteString("\n# HELP subdomain_scanner_probe_cache_hits_total Probes answered from the probe cache\n")
+	rcodes.WriteString("# TYPE subdomain_scanner_probe_cache_hits_total counter\n")
+	fmt.Fprintf(&rcodes, "subdomain_scanner_probe_cache_hits_total %d\n", atomic.LoadInt64(&stats.ProbeCacheHits))
+	rcodes.WriteString("\n# HELP subdomain_scanner_probe_cache_misses_total Cacheable probes that went to the host\n")
+	rcodes.WriteString("# TYPE subdomain_scanner_probe_cache_misses_total counter\n")
+	fmt.Fprintf(&rcodes, "subdomain_scanner_probe_cache_misses_total %d\n", atomic.LoadInt64(&stats.ProbeCacheMisses))
+	rcodes.WriteString("\n# HELP subdomain_scanner_dns_qps_waiting DNS queries waiting for a rate limit token\n")
+	rcodes.WriteString("# TYPE subdomain_scanner_dns_qps_waiting gauge\n")
+	fmt.Fprintf(&rcodes, "subdomain_scanner_dns_qps_waiting %d\n", atomic.LoadInt64(&stats.DNSQPSWaiting))
+	rcodes.WriteString("\n# HELP subdomain_scanner_dns_qps_delayed_total DNS queries held back by DNS_MAX_QPS or DNS_MAX_QPS_PER_SERVER\n")
+	rcodes.WriteString("# TYPE subdomain_scanner_dns_qps_delayed_total counter\n")
+	fmt.Fprintf(&rcodes, "subdomain_scanner_dns_qps_delayed_total %d\n", atomic.LoadInt64(&stats.DNSQPSDelayed))
+	metrics += rcodes.String()
+
+	var retries strings.Builder
+	retries.WriteString("\n# HELP subdomain_scanner_retries_total Retried operations by subsystem\n")
+	retries.WriteString("# TYPE subdomain_scanner_retries_total counter\n")
+	retryTotals := retryCounts()
+	for _, subsystem := range slices.Sorted(maps.Keys(retryTotals)) {
+		fmt.Fprintf(&retries, "subdomain_scanner_retries_total{subsystem=%q} %d\n", subsystem, retryTotals[subsystem])
+	}
+	metrics += retries.String()
+
+	var dangling strings.Builder
+	dangling.WriteString("\n# HELP subdomain_scanner_dangling_records_total Records pointing at names or addresses nobody holds\n")
+	dangling.WriteString("# TYPE subdomain_scanner_dangling_records_total counter\n")
+	fmt.Fprintf(&dangling, "subdomain_scanner_dangling_records_total{kind=\"dangling-cname\"} %d\n", atomic.LoadInt64(&stats.DanglingCNAMEs))
+	fmt.Fprintf(&dangling, "subdomain_scanner_dangling_records_total{kind=\"unbound-ip\"} %d\n", atomic.LoadInt64(&stats.UnboundIPs))
+	metrics += dangling.String()
+
+	var evicted strings.Builder
+	evicted.WriteString("\n# HELP subdomain_scanner_evicted_jobs_total Jobs removed by retention or deletion\n")
+	evicted.WriteString("# TYPE subdomain_scanner_evicted_jobs_total counter\n")
+	fmt.Fprintf(&evicted, "subdomain_scanner_evicted_jobs_total{reason=\"expired\"} %d\n", atomic.LoadInt64(&stats.ExpiredJobs))
+	fmt.Fprintf(&evicted, "subdomain_scanner_evicted_jobs_total{reason=\"over_limit\"} %d\n", atomic.LoadInt64(&stats.OverLimitJobs))
+	fmt.Fprintf(&evicted, "subdomain_scanner_evicted_jobs_total{reason=\"over_size\"} %d\n", atomic.LoadInt64(&stats.OversizeJobs))
+	fmt.Fprintf(&evicted, "subdomain_scanner_evicted_jobs_total{reason=\"deleted\"} %d\n", atomic.LoadInt64(&stats.DeletedJobs))
+	metrics += evicted.String()
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(metrics))
+}
+
+// dnsCacheStats reports hits, misses, the hit rate and the global
+// resolver's cache size
+func dnsCacheStats() map[string]interface{} {
+	hits := atomic.LoadInt64(&stats.DNSCacheHits)
+	misses := atomic.LoadInt64(&stats.DNSCacheMisses)
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	return map[string]interface{}{
+		"enabled":  defaultResolver().cache != nil,
+		"hits":     hits,
+		"misses":   misses,
+		"hit_rate": hitRate,
+		"entries":  defaultResolver().cache.Len(),
+	}
+}
+
+// probeCacheStats reports hits, misses, the hit rate and the size of the
+// probe cache
+func probeCacheStats() map[string]interface{} {
+	hits := atomic.LoadInt64(&stats.ProbeCacheHits)
+	misses := atomic.LoadInt64(&stats.ProbeCacheMisses)
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	return map[string]interface{}{
+		"enabled":  currentConfig().HTTP.ProbeCacheTTL > 0,
+		"hits":     hits,
+		"misses":   misses,
+		"hit_rate": hitRate,
+		"entries":  probeResults.Len(),
+	}
+}
+
+// dnsRcodeCounts returns the responses seen so far by rcode name
+func dnsRcodeCounts() map[string]int64 {
+	counts := make(map[string]int64)
+	for rcode := range stats.DNSRcodes {
+		if n := atomic.LoadInt64(&stats.DNSRcodes[rcode]); n > 0 {
+			name, ok := dns.RcodeToString[rcode]
+			if !ok {
+				name = fmt.Sprintf("RCODE%d", rcode)
+			}
+			counts[name] = n
+		}
+	}
+	return counts
+}
+
+// Source is a subdomain discovery method. Enumerate sends what it finds on
+// out and returns once it is done; the HTTP layer takes care of dedup, job
+// bookkeeping and the SSE framing. Enumerate must not send on out after it
+// returns.
+type Source interface {
+	Name() string
+	Enumerate(ctx context.Context, target string, out chan<- Result) error
+}
+
+// optionChecker is implemented by sources whose options can be rejected
+// before a scan starts, so the client gets a 400 instead of a failed stream
+type optionChecker interface {
+	CheckOptions(ctx context.Context, options url.Values) error
+}
+
+// checkSourceOptions runs every selected source's option checks
+func checkSourceOptions(ctx context.Context, entries []sourceEntry, options url.Values) error {
+	for _, entry := range entries {
+		if checker, ok := entry.source.(optionChecker); ok {
+			if err := checker.CheckOptions(ctx, options); err != nil {
+				return fmt.Errorf("%s: %w", entry.source.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// sourceEntry is a registered source plus what the HTTP layer needs to
+// serve it
+type sourceEntry struct {
+	source      Source
+	label       string // used in completion messages
+	timeout     func(TimeoutConfig) time.Duration
+	apiKey      func(APIKeyConfig) string // nil when the source takes no key
+	requiresKey bool
+	active      bool // sends traffic to the target's own infrastructure
+	resolves    bool // looks up the target's names, reaching its nameservers through the resolvers
+	enrichment  bool // checks hosts earlier jobs found instead of discovering any; only run when named
+}
+
+// Registered sources in listing order. Each one is served at
+// /api/{name}/stream.
+var sourceRegistry = []sourceEntry{
+	{source: waybackSource{}, label: "Wayback scan", timeout: func(t TimeoutConfig) time.Duration { return t.Wayback }},
+	{source: crtshSource{}, label: "Certificate transparency scan", timeout: func(t TimeoutConfig) time.Duration { return t.CrtSh }},
+	{source: dnsBruteSource{}, label: "DNS brute force scan", timeout: func(t TimeoutConfig) time.Duration { return t.DNS }, active: true},
+	{source: searchSource{}, label: "Search engine scan", timeout: func(t TimeoutConfig) time.Duration { return t.Search }},
+	{source: permuteSource{}, label: "Permutation scan", timeout: func(t TimeoutConfig) time.Duration { return t.Permute }, active: true},
+	{source: feedbackSource{}, label: "Feedback permutation scan", timeout: func(t TimeoutConfig) time.Duration { return t.Feedback }, active: true},
+	{source: zoneSource{}, label: "Zone transfer scan", timeout: func(t TimeoutConfig) time.Duration { return t.Zone }, active: true},
+	{
+		source:  leakixSource{},
+		label:   "LeakIX scan",
+		timeout: func(t TimeoutConfig) time.Duration { return t.LeakIX },
+		apiKey:  func(k APIKeyConfig) string { return k.LeakIX },
+	},
+	{source: spfSource{}, label: "SPF/TXT scan", timeout: func(t TimeoutConfig) time.Duration { return t.SPF }, resolves: true},
+	{source: recordsSource{}, label: "DNS record scan", timeout: func(t TimeoutConfig) time.Duration { return t.Records }, resolves: true},
+	{source: ptrSource{}, label: "PTR sweep", timeout: func(t TimeoutConfig) time.Duration { return t.PTR }, active: true},
+	{source: asnSource{}, label: "ASN scan", timeout: func(t TimeoutConfig) time.Duration { return t.ASN }, active: true},
+	{source: jsScrapeSource{}, label: "JavaScript scan", timeout: func(t TimeoutConfig) time.Duration { return t.JSScrape }, active: true},
+	{source: srvSource{}, label: "SRV scan", timeout: func(t TimeoutConfig) time.Duration { return t.SRV }, active: true},
+	{source: takeoverSource{}, label: "Takeover check", timeout: func(t TimeoutConfig) time.Duration { return t.Takeover }, active: true, enrichment: true},
+}
+
+// passiveOnly is set at startup from PASSIVE_ONLY or a passive build. It
+// removes every source that is active or resolves from sourceRegistry,
+// and the DNS, zone transfer and probe paths refuse to open sockets.
+var passiveOnly bool
+
+// disabledSources lists the sources passive-only mode removed
+var disabledSources []string
+
+// errPassiveOnly is returned by every path that would reach the target
+var errPassiveOnly = errors.New("passive-only mode: no traffic to the target or its nameservers")
+
+type passiveContextKey struct{}
+
+// withPassive keeps the work under ctx off the target and its nameservers,
+// as passive-only mode does for the whole server
+func withPassive(ctx context.Context) context.Context {
+	return context.WithValue(ctx, passiveContextKey{}, true)
+}
+
+// isPassive reports whether the work under ctx must not reach the target
+func isPassive(ctx context.Context) bool {
+	return passiveOnly || ctx.Value(passiveContextKey{}) != nil
+}
+
+func initializePassiveMode() {
+	passiveOnly = passiveBuild == "true" || getEnvBool("PASSIVE_ONLY", false)
+	if !passiveOnly {
+		return
+	}
+	var kept []sourceEntry
+	for _, entry := range sourceRegistry {
+		if entry.active || entry.resolves {
+			disabledSources = append(disabledSources, entry.source.Name())
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	sourceRegistry = kept
+	log.Printf("🔒 PASSIVE-ONLY MODE: disabled %s; DNS, zone transfers and probing are refused",
+		strings.Join(disabledSources, ", "))
+}
+
+// writeUnknownSource rejects a source name missing from the registry,
+// naming passive-only mode when that is what removed it
+func writeUnknownSource(w http.ResponseWriter, name string) {
+	if containsString(disabledSources, name) {
+		http.Error(w, fmt.Sprintf("source %q is disabled in passive-only mode", name), http.StatusForbidden)
+		return
+	}
+	http.Error(w, fmt.Sprintf("unknown source %q", name), http.StatusBadRequest)
+}
+
+func lookupSource(name string) (sourceEntry, bool) {
+	for _, entry := range sourceRegistry {
+		if entry.source.Name() == name {
+			return entry, true
+		}
+	}
+	return sourceEntry{}, false
+}
+
+// errSourceUnavailable marks failures to reach a source's upstream, as
+// opposed to errors in what the upstream returned
+var errSourceUnavailable = errors.New("upstream unavailable")
+
+// sourceRun is what a Source can reach through its context besides the
+// result channel: the job it runs under, the request options, and notices
+// for the client that are not results
+type sourceRun struct {
+	source  string
+	job     *Job
+	options url.Values
+	notify  func(kind, message string)
+	event   func(name string, data interface{})
+
+	// Passive results of a combined scan, for the feedback source
+	feedback *feedbackQueue
+
+	mu      sync.Mutex
+	partial bool
+}
+
+type sourceRunContextKey struct{}
+
+// sourceRunFrom returns the run attached to ctx, or a detached one that
+// discards notices
+func sourceRunFrom(ctx context.Context) *sourceRun {
+	if run, ok := ctx.Value(sourceRunContextKey{}).(*sourceRun); ok {
+		return run
+	}
+	return &sourceRun{
+		options: url.Values{},
+		notify:  func(kind, message string) {},
+		event:   func(name string, data interface{}) {},
+	}
+}
+
+// Job returns the job the source runs under, nil for a detached run
+func (run *sourceRun) Job() *Job {
+	return run.job
+}
+
+func (run *sourceRun) Option(key string) string {
+	return run.options.Get(key)
+}
+
+// Notice sends a "kind: message" line such as an info or warning
+func (run *sourceRun) Notice(kind, format string, args ...interface{}) {
+	run.notify(kind, fmt.Sprintf(format, args...))
+}
+
+// Progress sends a progress notice, which the job keeps as the source's
+// latest progress message
+func (run *sourceRun) Progress(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	run.progress().Message(run.source, message)
+	run.notify("progress", message)
+}
+
+// Expect adds n candidates to the work the source reports progress against
+func (run *sourceRun) Expect(n int) {
+	run.progress().Expect(run.source, n)
+}
+
+// Advance counts n candidates as done
+func (run *sourceRun) Advance(n int) {
+	run.progress().Advance(run.source, n)
+}
+
+// progress returns the job's progress, nil for a detached run
+func (run *sourceRun) progress() *JobProgress {
+	if run.job == nil {
+		return nil
+	}
+	return run.job.Progress
+}
+
+// Event sends a named event with a JSON payload, for notices that clients
+// are expected to parse
+func (run *sourceRun) Event(name string, data interface{}) {
+	run.event(name, data)
+}
+
+// Partial marks the run's results as incomplete, e.g. after a truncated
+// upstream response
+func (run *sourceRun) Partial() {
+	run.mu.Lock()
+	run.partial = true
+	run.mu.Unlock()
+}
+
+// outcome classifies how the run ended, in the terms used for
+// SourceTiming.Outcome
+func (run *sourceRun) outcome(ctx context.Context, err error) string {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	switch {
+	case ctx.Err() != nil:
+		return "cancelled"
+	case errors.Is(err, errSourceUnavailable):
+		return "unavailable"
+	case err != nil:
+		return "error"
+	case run.partial:
+		return "partial"
+	}
+	return "complete"
+}
+
+// emit sends result on out unless ctx is done first. A source is held
+// here while maintenance pauses its job.
+func emit(ctx context.Context, out chan<- Result, result Result) bool {
+	if maintenance.awaitResume(ctx) != nil {
+		return false
+	}
+	select {
+	case out <- result:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sourceHooks receives what runSources produces. Hooks are called from
+// several goroutines at once.
+type sourceHooks struct {
+	// Each host the first time any source reports it
+	result func(result Result)
+	// Source notices, with kind "progress" for progress events
+	notice func(source, kind, message string)
+	event  func(source, name string, data interface{})
+	done   func(source, outcome string, hosts int)
+	// The synthetic apex row, before any source starts; optional
+	apex func(result Result)
+}
+
+// runSources runs entries concurrently for target under job, each with its
+// own timeout. Output is merged through one dedup set, while the job still
+// records each host against every source that reported it. A failing
+// source does not stop the others. Returns the number of unique hosts.
+func runSources(ctx context.Context, job *Job, target string, entries []sourceEntry, options url.Values, hooks sourceHooks) int {
+	cfg := configFrom(ctx)
+	job.awaitEgress(ctx)
+	maintenance.awaitResume(ctx)
+
+	apex := apexResult(ctx, target)
+	job.AddResult(apex.Source, apex)
+	if hooks.apex != nil {
+		hooks.apex(apex)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]struct{})
+
+	// What passive sources find feeds the feedback source, if it runs,
+	// until the last of them is done
+	var feedback *feedbackQueue
+	if slices.ContainsFunc(entries, func(entry sourceEntry) bool { return entry.source.Name() == "feedback" }) {
+		passive := 0
+		for _, entry := range entries {
+			if !entry.active {
+				passive++
+			}
+		}
+		if passive > 0 {
+			feedback = newFeedbackQueue(passive)
+		}
+	}
+
+	var g errgroup.Group
+	for _, entry := range entries {
+		g.Go(func() error {
+			name := entry.source.Name()
+			sctx, cancel := withPausableTimeout(ctx, entry.timeout(cfg.Timeouts))
+			defer cancel()
+
+			// A passive source feeds the queue until it returns
+			var feeds *feedbackQueue
+			if !entry.active {
+				feeds = feedback
+			}
+			defer feeds.producerDone()
+
+			run := &sourceRun{
+				source:   name,
+				job:      job,
+				options:  options,
+				notify:   func(kind, message string) { hooks.notice(name, kind, message) },
+				event:    func(event string, data interface{}) { hooks.event(name, event, data) },
+				feedback: feedback,
+			}
+			sctx = context.WithValue(sctx, sourceRunContextKey{}, run)
+
+			out := make(chan Result)
+			errc := make(chan error, 1)
+			go func() {
+				defer close(out)
+				errc <- entry.source.Enumerate(sctx, target, out)
+			}()
+
+			found := make(map[string]struct{})
+			for result := range out {
+				result.Host, result.Display = hostForms(result.Host)
+				if _, dup := found[result.Host]; dup {
+					continue
+				}
+				found[result.Host] = struct{}{}
+				job.Progress.Found(name)
+
+				if result.Source == "" {
+					result.Source = name
+				}
+				if result.Status == "" {
+					result.Status = "discovered"
+				}
+				if result.Timestamp.IsZero() {
+					result.Timestamp = time.Now()
+				}
+				result.EvidenceAge = evidenceAge(result.EvidenceTime, result.Timestamp)
+
+				// Look-alikes are other domains: shown, but not counted
+				if result.Status == "lookalike" {
+					if job.AddLookalike(result) {
+						hooks.result(result)
+					}
+					continue
+				}
+
+				result = job.AddResult(result.Source, result)
+				feeds.push(result)
+
+				mu.Lock()
+				_, dup := seen[result.Host]
+				seen[result.Host] = struct{}{}
+				mu.Unlock()
+				if !dup {
+					hooks.result(result)
+				}
+			}
+
+			err := <-errc
+			outcome := run.outcome(sctx, err)
+			switch outcome {
+			case "cancelled":
+				log.Printf("%s cancelled for %s", entry.label, target)
+			case "unavailable", "error":
+				log.Printf("%s for %s failed: %v", entry.label, target, err)
+			default:
+				log.Printf("%s found %d unique hosts for %s (%s)", entry.label, len(found), target, outcome)
+			}
+			job.FinishSource(name, outcome)
+			hooks.done(name, outcome, len(found))
+			return nil
+		})
+	}
+	g.Wait()
+
+	return len(seen)
+}
+
+// errInvalidDomain rejects a target that is not a valid domain name
+var errInvalidDomain = errors.New("invalid domain format")
+
+// parseTarget validates a target given in Unicode or punycode and returns
+// its lowercase ASCII form, the only one lookups and upstream queries see.
+// Labels with symbols, or mixing scripts as homographs do, are rejected.
+func parseTarget(raw string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(raw, "."))
+	if err != nil || !domainRe.MatchString(ascii) {
+		return "", errInvalidDomain
+	}
+	name, err := idna.Lookup.ToUnicode(ascii)
+	if err != nil {
+		return "", errInvalidDomain
+	}
+	for _, label := range strings.Split(name, ".") {
+		// The lookup profile still admits symbols such as emoji, which
+		// IDNA2008 disallows
+		symbol := func(r rune) bool { return r >= utf8.RuneSelf && !unicode.In(r, unicode.L, unicode.M, unicode.N) }
+		if strings.ContainsFunc(label, symbol) {
+			return "", fmt.Errorf("%w: label %q has symbols", errInvalidDomain, label)
+		}
+		if !singleScript(label) {
+			return "", fmt.Errorf("%w: label %q mixes scripts", errInvalidDomain, label)
+		}
+	}
+	return ascii, nil
+}
+
+// targetKey is the form of a target that jobs and locks are kept under,
+// for lookups by a target given in either form
+func targetKey(raw string) string {
+	if ascii, err := parseTarget(raw); err == nil {
+		return ascii
+	}
+	return strings.ToLower(raw)
+}
+
+// scriptSets are the combinations of scripts a label may mix, as in the
+// Unicode "highly restrictive" profile; any other label uses one script
+var scriptSets = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// singleScript reports whether the letters of a label come from one script
+// or one of the scriptSets. Digits, hyphens and marks belong to any script.
+func singleScript(label string) bool {
+	var scripts []string
+	for _, r := range label {
+		if r < utf8.RuneSelf && !unicode.IsLetter(r) {
+			continue
+		}
+		script := runeScript(r)
+		if script != "" && script != "Common" && script != "Inherited" && !slices.Contains(scripts, script) {
+			scripts = append(scripts, script)
+		}
+	}
+	if len(scripts) <= 1 {
+		return true
+	}
+	for _, set := range scriptSets {
+		if !slices.ContainsFunc(scripts, func(script string) bool { return !slices.Contains(set, script) }) {
+			return true
+		}
+	}
+	return false
+}
+
+func runeScript(r rune) string {
+	if r < utf8.RuneSelf {
+		return "Latin"
+	}
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// hostForms returns a discovered host in lowercase ASCII and, when it is
+// internationalized, its Unicode form for display
+func hostForms(host string) (string, string) {
+	host = strings.ToLower(host)
+	ascii := strings.IndexFunc(host, func(r rune) bool { return r >= utf8.RuneSelf }) < 0
+	if ascii && !strings.Contains(host, "xn--") {
+		return host, ""
+	}
+	name, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return host, ""
+	}
+	display, err := idna.Lookup.ToUnicode(name)
+	if err != nil || display == name {
+		return name, ""
+	}
+	return name, display
+}
+
+// sseWriter serializes writes to an SSE response from concurrent senders
+type sseWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (s *sseWriter) send(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, format, args...)
+	s.flusher.Flush()
+}
+
+// sendJSON writes v as the data of an event, or of a plain message when
+// event is empty
+func (s *sseWriter) sendJSON(event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event, err)
+		return
+	}
+	s.sendData(event, data)
+}
+
+// sendData writes already encoded JSON like sendJSON
+func (s *sseWriter) sendData(event string, data []byte) {
+	s.sendEvent("", event, data)
+}
+
+// sendEvent writes data like sendData with an id line, unless id is empty.
+// An EventSource that reconnects sends the last one back as Last-Event-ID.
+func (s *sseWriter) sendEvent(id, event string, data []byte) {
+	if id != "" {
+		id = "id: " + id + "\n"
+	}
+	if event == "" {
+		s.send("%sdata: %s\n\n", id, data)
+		return
+	}
+	s.send("%sevent: %s\ndata: %s\n\n", id, event, data)
+}
+
+// webSocketGUID is appended to the client's key in the RFC 6455 handshake
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes and the close codes the server sends (RFC 6455 5.2
+// and 7.4.1)
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+
+	wsCloseNormal      = 1000
+	wsCloseGoingAway   = 1001
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+	wsCloseTooBig      = 1009
+)
+
+const (
+	wsPingInterval = 30 * time.Second // keepalive, well inside proxy idle timeouts
+	wsPongWait     = 60 * time.Second // silence after which the client is gone
+	wsWriteWait    = 10 * time.Second
+	wsCloseWait    = 5 * time.Second // for the client to answer the server's close
+	wsMaxMessage   = 64 << 10        // client messages are short commands
+)
+
+// wsConn is the server side of a WebSocket connection. Writes may come
+// from several goroutines; reads belong to one.
+type wsConn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	mu     sync.Mutex
+	closed bool // a close frame was sent
+}
+
+// wsCloseError is a client error that ends the connection with code
+type wsCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket: %s (%d)", e.reason, e.code)
+}
+
+// wsEvent is an SSE event as a WebSocket message; results, which have no
+// event name on the SSE side, are "message" events
+type wsEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// checkWebSocketHandshake answers a request that cannot be upgraded and
+// returns false. Without ENABLE_CORS a browser may only connect from the
+// server's own origin.
+func checkWebSocketHandshake(w http.ResponseWriter, r *http.Request) bool {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "WebSocket handshake required", http.StatusUpgradeRequired)
+		return false
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return false
+	}
+	if key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key")); err != nil || len(key) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !configFrom(r.Context()).Security.EnableCORS {
+		if parsed, err := url.Parse(origin); err != nil || !strings.EqualFold(parsed.Host, r.Host) {
+			http.Error(w, "cross-origin WebSocket refused", http.StatusForbidden)
+			return false
+		}
+	}
+	if _, ok := w.(http.Hijacker); !ok {
+		http.Error(w, "WebSocket not supported on this connection", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// headerHasToken reports whether the comma-separated header name lists
+// token, ignoring case
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket takes over the connection of a request that passed
+// checkWebSocketHandshake and completes the handshake
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// Drop the server's read and write timeouts; the connection keeps its own
+	conn.SetDeadline(time.Time{})
+
+	accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + webSocketGUID))
+	conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(accept[:]))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// writeFrame sends payload as a single unmasked frame. Nothing is sent
+// after a close frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = opcode == wsClose
+
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= math.MaxUint16:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(c.conn)
+	return err
+}
+
+// sendEvent sends an encoded event as a text message
+func (c *wsConn) sendEvent(event string, data []byte) error {
+	if event == "" {
+		event = "message"
+	}
+	message, err := json.Marshal(wsEvent{Event: event, Data: data})
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, message)
+}
+
+// sendClose starts the closing handshake with code and reason
+func (c *wsConn) sendClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.writeFrame(wsClose, append(payload, reason...))
+}
+
+// readFrame reads one client frame and unmasks its payload
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, &wsCloseError{wsCloseProtocol, "reserved bits set"}
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, &wsCloseError{wsCloseProtocol, "client frames must be masked"}
+	}
+
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var size [2]byte
+		if _, err := io.ReadFull(c.br, size[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(size[:]))
+	case 127:
+		var size [8]byte
+		if _, err := io.ReadFull(c.br, size[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(size[:])
+	}
+	if opcode >= wsClose && (n > 125 || !fin) {
+		return false, 0, nil, &wsCloseError{wsCloseProtocol, "invalid control frame"}
+	}
+	if n > wsMaxMessage {
+		return false, 0, nil, &wsCloseError{wsCloseTooBig, "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// readMessage returns the client's next message, reassembled from its
+// fragments, answering pings on the way. Every frame pushes the read
+// deadline back, so pongs to the keepalive pings keep a quiet client
+// connected. A close from the client is answered and reported as io.EOF.
+func (c *wsConn) readMessage() (opcode byte, message []byte, err error) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		fin, frameType, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameType {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			// Echo the client's code; an empty close carries none
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.sendClose(code, "")
+			return 0, nil, io.EOF
+		case wsText, wsBinary:
+			if opcode != 0 {
+				return 0, nil, &wsCloseError{wsCloseProtocol, "message interleaved with a fragmented one"}
+			}
+			opcode, message = frameType, payload
+		case wsContinuation:
+			if opcode == 0 {
+				return 0, nil, &wsCloseError{wsCloseProtocol, "continuation without a message"}
+			}
+			if len(message)+len(payload) > wsMaxMessage {
+				return 0, nil, &wsCloseError{wsCloseTooBig, "message too big"}
+			}
+			message = append(message, payload...)
+		default:
+			return 0, nil, &wsCloseError{wsCloseProtocol, fmt.Sprintf("unknown opcode %d", frameType)}
+		}
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// jobEventBuffer caps the events a job's log keeps; past it the oldest
+// ones are dropped, which followers that fall that far behind and clients
+// resuming from before them miss
+const jobEventBuffer = 10000
+
+// jobEvent is one event of a combined scan, encoded once for every
+// follower. key is set for results (see streamKey); host marks the ones
+// that are newly found hosts.
+type jobEvent struct {
+	id    int64
+	event string
+	data  []byte
+	key   string
+	host  bool
+}
+
+// targetEvent is a child job's event as the parent of a multi-target scan
+// streams it
+type targetEvent struct {
+	Target string      `json:"target"`
+	Job    string      `json:"job"`
+	Data   interface{} `json:"data"`
+}
+
+// jobEventLog keeps a running job's events for followers that connect
+// after it started. wake is closed and replaced on every publish.
+type jobEventLog struct {
+	mu     sync.Mutex
+	events []jobEvent
+	nextID int64
+	wake   chan struct{}
+	closed bool
+}
+
+func newJobEventLog() *jobEventLog {
+	return &jobEventLog{wake: make(chan struct{})}
+}
+
+// publish encodes v and appends it as event unless the log is closed. It
+// returns the event's ID, 0 once the log is closed, and the encoding, nil
+// when v cannot be encoded.
+func (l *jobEventLog) publish(event string, v interface{}) (int64, []byte) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event, err)
+		return 0, nil
+	}
+	key, host := "", false
+	switch v := v.(type) {
+	case Result:
+		key, host = streamKey(v), event == "" && v.Source != "takeover"
+	case targetEvent:
+		if result, ok := v.Data.(Result); ok {
+			key, host = v.Target+"|"+streamKey(result), event == "" && result.Source != "takeover"
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, data
+	}
+	l.nextID++
+	l.events = append(l.events, jobEvent{id: l.nextID, event: event, data: data, key: key, host: host})
+	if len(l.events) > jobEventBuffer {
+		l.events = slices.Delete(l.events, 0, len(l.events)-jobEventBuffer)
+	}
+	close(l.wake)
+	l.wake = make(chan struct{})
+	return l.nextID, data
+}
+
+// last returns the ID of the latest event, 0 before the first one
+func (l *jobEventLog) last() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nextID
+}
+
+// since returns the events after id, a channel closed on the next publish
+// and whether the log is closed, in which case no more events follow
+func (l *jobEventLog) since(id int64) ([]jobEvent, <-chan struct{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	first, _ := slices.BinarySearchFunc(l.events, id+1, func(e jobEvent, id int64) int { return cmp.Compare(e.id, id) })
+	return slices.Clone(l.events[first:]), l.wake, l.closed
+}
+
+// close ends the log and returns its last event
+func (l *jobEventLog) close() jobEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.wake)
+	}
+	if len(l.events) == 0 {
+		return jobEvent{}
+	}
+	return l.events[len(l.events)-1]
+}
+
+// streamKey identifies a host on a combined scan's stream, which sends each
+// host once except for the apex row and the takeover pass's findings
+func streamKey(result Result) string {
+	host := strings.ToLower(result.Host)
+	switch result.Source {
+	case "apex", "takeover":
+		return result.Source + "|" + host
+	}
+	return host
+}
+
+// sourceStreamHandler serves a registered source as an SSE stream of newly
+// discovered hosts, each sent as its stored Result (or as a bare hostname
+// with format=plain)
+func sourceStreamHandler(entry sourceEntry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := entry.source.Name()
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "missing target parameter", http.StatusBadRequest)
+			return
+		}
+
+		target, err := parseTarget(target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := checkSourceOptions(r.Context(), []sourceEntry{entry}, r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cfg, err := jobConfig(r.Context(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r = r.WithContext(withConfig(r.Context(), cfg))
+
+		resolver, err := scanResolver(r.Context(), r.URL.Query(), target)
+		if err != nil {
+			writeResolverError(w, err)
+			return
+		}
+
+		attachLock, ok := lockTarget(w, r, target, []sourceEntry{entry})
+		if !ok {
+			return
+		}
+
+		sseHeader(w)
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		job, ctx := createJob(withResolver(r.Context(), resolver), target, []string{name}, r.URL.Query())
+		job.UseResolver(resolver)
+		defer job.Complete()
+		defer attachLock(job)()
+
+		// format=plain keeps the original one-hostname-per-message stream
+		plain := r.URL.Query().Get("format") == "plain"
+
+		// Followers of the job get the events in enumerate/stream's format
+		stream := &sseWriter{w: w, flusher: flusher}
+		events := job.events
+		outcome := ""
+		found := runSources(ctx, job, target, []sourceEntry{entry}, r.URL.Query(), sourceHooks{
+			result: func(result Result) {
+				events.publish("", result)
+				if plain {
+					stream.send("data: %s\n\n", cmp.Or(result.Display, result.Host))
+					return
+				}
+				stream.sendJSON("", result)
+			},
+			notice: func(source, kind, message string) {
+				event := "notice"
+				if kind == "progress" {
+					event = "progress"
+				}
+				_, data := events.publish(event, map[string]string{
+					"source":  source,
+					"kind":    kind,
+					"message": message,
+				})
+				if !plain {
+					if data != nil {
+						stream.sendData(event, data)
+					}
+					return
+				}
+				if kind == "progress" {
+					stream.send("event: progress\ndata: %s\n\n", message)
+					return
+				}
+				stream.send("data: %s: %s\n\n", kind, message)
+			},
+			event: func(source, name string, data interface{}) {
+				stream.sendJSON(name, data)
+			},
+			done: func(source, result string, hosts int) {
+				outcome = result
+				events.publish("source-complete", map[string]interface{}{
+					"source":  source,
+					"outcome": result,
+					"hosts":   hosts,
+				})
+			},
+		})
+
+		switch outcome {
+		case "cancelled":
+			stream.send("event: complete\ndata: %s cancelled\n\n", entry.label)
+		case "unavailable":
+			stream.send("event: complete\ndata: %s completed - API unavailable\n\n", entry.label)
+		case "error":
+			stream.send("event: complete\ndata: %s completed with errors\n\n", entry.label)
+		case "partial":
+			stream.send("event: complete\ndata: %s completed with partial results - found %d hosts\n\n", entry.label, found)
+		default:
+			stream.send("event: complete\ndata: %s completed - found %d hosts\n\n", entry.label, found)
+		}
+	}
+}
+
+// ScanProfile is a named preset for a combined scan (profile=): the sources
+// to run and option defaults. Whatever the request sets itself wins.
+type ScanProfile struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Sources     []string          `json:"sources"` // nil for every discovery source
+	Options     map[string]string `json:"options,omitempty"`
+	// Passive scans never reach the target or its nameservers, not even
+	// for the apex lookup, as if the server ran passive-only
+	Passive bool `json:"passive"`
+}
+
+// passiveSources are the sources that only ask third parties
+var passiveSources = []string{"wayback", "crtsh", "search", "leakix"}
+
+// scanProfiles are the profiles profile= accepts
+var scanProfiles = []ScanProfile{
+	{
+		Name:        "passive",
+		Description: "Archives, certificate logs and search engines only; nothing reaches the target",
+		Sources:     passiveSources,
+		Passive:     true,
+	},
+	{
+		Name:        "quick",
+		Description: "The passive sources plus a brute force of the common wordlist",
+		Sources:     append(slices.Clip(passiveSources), "dns"),
+		Options:     map[string]string{"categories": "common", "concurrency": "25"},
+	},
+	{
+		Name:        "full",
+		Description: "Every discovery source, then takeover checks and HTTP probes of what was found",
+		Options:     map[string]string{"takeover": "true", "probe": "true"},
+	},
+}
+
+func lookupScanProfile(name string) (ScanProfile, bool) {
+	for _, profile := range scanProfiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return ScanProfile{}, false
+}
+
+// passiveProfile reports whether options select a passive profile
+func passiveProfile(options url.Values) bool {
+	profile, ok := lookupScanProfile(options.Get("profile"))
+	return ok && profile.Passive
+}
+
+// applyScanProfile fills in the sources and options query leaves to
+// profile=, if it names one
+func applyScanProfile(query url.Values) error {
+	name := query.Get("profile")
+	if name == "" {
+		return nil
+	}
+	profile, ok := lookupScanProfile(name)
+	if !ok {
+		valid := make([]string, len(scanProfiles))
+		for i, profile := range scanProfiles {
+			valid[i] = profile.Name
+		}
+		return fmt.Errorf("unknown profile %q, valid profiles: %s", name, strings.Join(valid, ", "))
+	}
+	if query.Get("sources") == "" && profile.Sources != nil {
+		query.Set("sources", strings.Join(profile.Sources, ","))
+	}
+	for key, value := range profile.Options {
+		if !query.Has(key) {
+			query.Set(key, value)
+		}
+	}
+	return nil
+}
+
+// jobConfig is the config snapshot a job runs under: the request's, with
+// the overrides in options applied. concurrency=, dns_timeout= and
+// probe_timeout= replace DNS.Concurrency, DNS.Timeout and HTTP.Timeout up
+// to the Security.MaxJob bounds; dns_qps= is checked here, and createJob
+// gives the job its own query rate limit from it.
+func jobConfig(ctx context.Context, options url.Values) (*Config, error) {
+	cfg := configFrom(ctx)
+	limits := cfg.Security
+	scoped := *cfg
+	if value := options.Get("concurrency"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > limits.MaxJobDNSConcurrency {
+			return nil, fmt.Errorf("concurrency must be between 1 and %d", limits.MaxJobDNSConcurrency)
+		}
+		scoped.DNS.Concurrency = n
+	}
+	if value := options.Get("dns_timeout"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > limits.MaxJobDNSTimeout {
+			return nil, fmt.Errorf("dns_timeout must be a duration up to %s", limits.MaxJobDNSTimeout)
+		}
+		scoped.DNS.Timeout = d
+	}
+	if value := options.Get("probe_timeout"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > limits.MaxJobProbeTimeout {
+			return nil, fmt.Errorf("probe_timeout must be a duration up to %s", limits.MaxJobProbeTimeout)
+		}
+		scoped.HTTP.Timeout = d
+	}
+	if value := options.Get("dns_qps"); value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 1 || n > limits.MaxJobDNSQPS {
+			return nil, fmt.Errorf("dns_qps must be between 1 and %d", limits.MaxJobDNSQPS)
+		}
+	}
+	return &scoped, nil
+}
+
+// jobOverrides are the options jobConfig reads
+var jobOverrides = []string{"concurrency", "dns_timeout", "probe_timeout", "dns_qps"}
+
+// JobSettings are the lookup and probe settings a job ran with, recorded
+// so a rerun elsewhere can match them
+type JobSettings struct {
+	DNSConcurrency     int      `json:"dns_concurrency"`
+	DNSTimeout         string   `json:"dns_timeout"`
+	ProbeTimeout       string   `json:"probe_timeout"`
+	DNSQPS             int      `json:"dns_qps,omitempty"` // the job's own cap
+	DNSMaxQPS          int      `json:"dns_max_qps,omitempty"`
+	DNSMaxQPSPerServer int      `json:"dns_max_qps_per_server,omitempty"`
+	Overrides          []string `json:"overrides,omitempty"` // the settings the request changed
+}
+
+func newJobSettings(cfg *Config, qps int, options url.Values) *JobSettings {
+	settings := &JobSettings{
+		DNSConcurrency:     cfg.DNS.Concurrency,
+		DNSTimeout:         cfg.DNS.Timeout.String(),
+		ProbeTimeout:       cfg.HTTP.Timeout.String(),
+		DNSQPS:             qps,
+		DNSMaxQPS:          cfg.DNS.MaxQPS,
+		DNSMaxQPSPerServer: cfg.DNS.MaxQPSPerServer,
+	}
+	for _, key := range jobOverrides {
+		if options.Has(key) {
+			settings.Overrides = append(settings.Overrides, key)
+		}
+	}
+	return settings
+}
+
+// preparedScan is a validated combined scan: the target, the sources to run
+// and the resolvers their lookups go to
+type preparedScan struct {
+	target   string
+	entries  []sourceEntry
+	names    []string
+	resolver *DNSResolver
+	shadow   *shadowPlan
+	options  url.Values
+	config   *Config
+}
+
+// context attaches the scan's config snapshot and resolvers to ctx
+func (s *preparedScan) context(ctx context.Context) context.Context {
+	return withResolver(withConfig(ctx, s.config), s.resolver)
+}
+
+// prepareScan validates the query of a combined scan. It answers the
+// request itself and returns false when the scan cannot run.
+func prepareScan(w http.ResponseWriter, r *http.Request) (*preparedScan, bool) {
+	query := r.URL.Query()
+	target := query.Get("target")
+	if target == "" {
+		http.Error(w, "missing target parameter", http.StatusBadRequest)
+		return nil, false
+	}
+
+	target, err := parseTarget(target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := applyScanProfile(query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	// All registered sources unless a subset is asked for
+	var entries []sourceEntry
+	var names []string
+	if list := query.Get("sources"); list != "" {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || containsString(names, name) {
+				continue
+			}
+			entry, ok := lookupSource(name)
+			if !ok {
+				writeUnknownSource(w, name)
+				return nil, false
+			}
+			entries = append(entries, entry)
+			names = append(names, name)
+		}
+	} else {
+		for _, entry := range sourceRegistry {
+			if entry.enrichment {
+				continue
+			}
+			entries = append(entries, entry)
+			names = append(names, entry.source.Name())
+		}
+	}
+	if len(entries) == 0 {
+		http.Error(w, "no sources selected", http.StatusBadRequest)
+		return nil, false
+	}
+	if passiveProfile(query) {
+		for _, entry := range entries {
+			if entry.active || entry.resolves || entry.enrichment {
+				http.Error(w, fmt.Sprintf("source %q reaches the target, which profile %s does not", entry.source.Name(), query.Get("profile")), http.StatusBadRequest)
+				return nil, false
+			}
+		}
+	}
+	if err := checkSourceOptions(r.Context(), entries, query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	cfg, err := jobConfig(r.Context(), query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	ctx := withConfig(r.Context(), cfg)
+
+	resolver, err := scanResolver(ctx, query, target)
+	if err != nil {
+		writeResolverError(w, err)
+		return nil, false
+	}
+
+	shadow, err := planShadowRun(ctx, query, target, resolver, names)
+	if err != nil {
+		writeResolverError(w, err)
+		return nil, false
+	}
+
+	return &preparedScan{
+		target:   target,
+		entries:  entries,
+		names:    names,
+		resolver: resolver,
+		shadow:   shadow,
+		options:  query,
+		config:   cfg,
+	}, true
+}
+
+// enumerateStream runs several sources as one job over a single SSE
+// connection. Hosts arrive as JSON messages, each source reports a
+// source-complete event when it finishes, and a final complete event
+// carries the total number of unique hosts. Every event has an ID of the
+// form <job>:<n>, n counting the job's events; a client that reconnects
+// with one in Last-Event-ID resumes that job (see resumeStream).
+func enumerateStream(w http.ResponseWriter, r *http.Request) {
+	if job, cursor, ok := resumableJob(r); ok {
+		resumeStream(w, r, job, cursor)
+		return
+	}
+
+	scan, ok := prepareScan(w, r)
+	if !ok {
+		return
+	}
+
+	attachLock, ok := lockTarget(w, r, scan.target, scan.entries)
+	if !ok {
+		return
+	}
+
+	sseHeader(w)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Aborting the job cancels every source at once. It is not tied to the
+	// request: attachStream cancels it once the client is gone for good.
+	job, ctx := createJob(scan.context(context.WithoutCancel(r.Context())), scan.target, scan.names, scan.options)
+	job.UseResolver(scan.resolver)
+	job.mu.Lock()
+	job.Owner = scanOwner(r)
+	job.mu.Unlock()
+	defer job.Complete()
+	defer attachLock(job)()
+	job.attachStream(r.Context())
+
+	stream := &sseWriter{w: w, flusher: flusher}
+	runScan(ctx, job, scan, publishTo(job, func(id int64, event string, data []byte) {
+		stream.sendEvent(job.eventID(id), event, data)
+	}))
+}
+
+// publishTo returns the emit for runScan that records each event in job's
+// log, for /api/jobs/<id>/stream, and hands it to send with its ID (0
+// when the log is closed) as encoded there
+func publishTo(job *Job, send func(id int64, event string, data []byte)) func(event string, v interface{}) {
+	events := job.events
+	return func(event string, v interface{}) {
+		if id, data := events.publish(event, v); data != nil {
+			send(id, event, data)
+		}
+	}
+}
+
+// eventID is the SSE ID of the job's event id, empty for 0
+func (j *Job) eventID(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", j.ID, id)
+}
+
+// resumableJob returns the job and event an EventSource reconnecting to
+// enumerate/stream names in its Last-Event-ID, provided the job is a scan
+// of the requested target started by the same owner. Anyone else naming
+// the job gets a scan of their own.
+func resumableJob(r *http.Request) (*Job, int64, bool) {
+	last := r.Header.Get("Last-Event-ID")
+	i := strings.LastIndexByte(last, ':')
+	if i < 0 {
+		return nil, 0, false
+	}
+	cursor, err := strconv.ParseInt(last[i+1:], 10, 64)
+	if err != nil || cursor < 0 {
+		return nil, 0, false
+	}
+	job, ok := lookupJob(last[:i])
+	if !ok {
+		return nil, 0, false
+	}
+	target, err := parseTarget(r.URL.Query().Get("target"))
+	if err != nil {
+		return nil, 0, false
+	}
+
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	if job.Target != target || job.Targets != nil || job.Owner != scanOwner(r) {
+		return nil, 0, false
+	}
+	return job, cursor, true
+}
+
+// resumeStream continues enumerate/stream for a client that reconnected
+// after event cursor of job: the buffered events after it, then the job's
+// next ones as they come, with a notice first when some of them already
+// left the buffer. A job that finished in the meantime sends just its
+// complete event, or answers 204, which stops the EventSource from
+// reconnecting, when the client already has it.
+func resumeStream(w http.ResponseWriter, r *http.Request, job *Job, cursor int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	job.mu.RLock()
+	events, last := job.events, job.lastEvent
+	job.mu.RUnlock()
+	if events == nil && cursor >= last.id {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	sseHeader(w)
+	stream := &sseWriter{w: w, flusher: flusher}
+	if events != nil {
+		job.attachStream(r.Context())
+	}
+	for events != nil {
+		batch, wake, closed := events.since(cursor)
+		if len(batch) > 0 && batch[0].id > cursor+1 {
+			stream.sendJSON("notice", map[string]string{
+				"source": "stream",
+				"kind":   "warning",
+				"message": fmt.Sprintf("%d events were dropped from the job's buffer before the stream resumed; /api/jobs/%s/hosts has every host",
+					batch[0].id-cursor-1, job.ID),
+			})
+		}
+		for _, event := range batch {
+			cursor = event.id
+			stream.sendEvent(job.eventID(event.id), event.event, event.data)
+			if event.event == "complete" {
+				return
+			}
+		}
+		if closed {
+			break
+		}
+		select {
+		case <-wake:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	job.mu.RLock()
+	last = job.lastEvent
+	cancelled := strings.HasPrefix(job.Status, "cancelled") || job.Status == "interrupted"
+	hosts := job.UniqueSubdomains
+	job.mu.RUnlock()
+	if last.event == "complete" && cursor < last.id {
+		stream.sendEvent(job.eventID(last.id), last.event, last.data)
+		return
+	}
+	data, err := json.Marshal(job.completeEvent(hosts, cancelled))
+	if err != nil {
+		log.Printf("Failed to encode complete event: %v", err)
+		return
+	}
+	stream.sendEvent(job.eventID(last.id), "complete", data)
+}
+
+// attachStream counts a client streaming the job until ctx, its request's,
+// ends. Once the last one has gone the job is cancelled, unless another
+// resumes it within Jobs.ResumeWait.
+func (j *Job) attachStream(ctx context.Context) {
+	j.mu.Lock()
+	j.streams++
+	if j.orphaned != nil {
+		j.orphaned.Stop()
+		j.orphaned = nil
+	}
+	j.mu.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.streams--
+		if j.streams > 0 || !j.EndTime.IsZero() {
+			return
+		}
+		var orphaned *time.Timer
+		orphaned = time.AfterFunc(currentConfig().Jobs.ResumeWait, func() {
+			j.mu.RLock()
+			cancel, gone := j.Cancel, j.orphaned == orphaned
+			j.mu.RUnlock()
+			if gone {
+				log.Printf("Job %s: no client resumed its stream, cancelling", j.ID)
+				cancel()
+			}
+		})
+		j.orphaned = orphaned
+	})
+}
+
+// enumerateWebSocket serves enumerate/stream over a WebSocket
+// (/ws/enumerate): the same parameters, and the same events as text
+// messages of the form {"event": ..., "data": ...}. The client may send
+// {"action":"abort"} to abort the job. The server pings every
+// wsPingInterval and drops clients silent for wsPongWait, which stops the
+// scan at once, with no resuming; when the scan is over it closes with
+// 1000, or 1001 when maintenance aborted the job.
+func enumerateWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !checkWebSocketHandshake(w, r) {
+		return
+	}
+	scan, ok := prepareScan(w, r)
+	if !ok {
+		return
+	}
+
+	attachLock, ok := lockTarget(w, r, scan.target, scan.entries)
+	if !ok {
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer ws.conn.Close()
+
+	// The hijacked connection no longer cancels the request context, so
+	// the reader and keepalive cancel the scan when the client is gone
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	job, ctx := createJob(scan.context(ctx), scan.target, scan.names, scan.options)
+	job.UseResolver(scan.resolver)
+	detachLock := attachLock(job)
+
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		defer cancel()
+		for {
+			opcode, message, err := ws.readMessage()
+			var closeErr *wsCloseError
+			if errors.As(err, &closeErr) {
+				ws.sendClose(closeErr.code, closeErr.reason)
+			}
+			if err != nil {
+				return
+			}
+			if opcode != wsText {
+				ws.sendClose(wsCloseUnsupported, "only text messages are accepted")
+				return
+			}
+
+			var command struct {
+				Action string `json:"action"`
+			}
+			if err := json.Unmarshal(message, &command); err != nil || command.Action != "abort" {
+				data, _ := json.Marshal(map[string]string{"message": `unknown command; {"action":"abort"} aborts the job`})
+				ws.sendEvent("error", data)
+				continue
+			}
+			if abortJob(job, scanOwner(r)) {
+				log.Printf("Cancelled job %s", job.ID)
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if ws.writeFrame(wsPing, nil) != nil {
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	runScan(ctx, job, scan, publishTo(job, func(_ int64, event string, data []byte) {
+		if ws.sendEvent(event, data) != nil {
+			cancel()
+		}
+	}))
+	job.Complete()
+	detachLock()
+
+	job.mu.RLock()
+	abortedBy := job.AbortedBy
+	job.mu.RUnlock()
+	switch abortedBy {
+	case "":
+		ws.sendClose(wsCloseNormal, "scan complete")
+	case "maintenance":
+		ws.sendClose(wsCloseGoingAway, "server maintenance")
+	default:
+		ws.sendClose(wsCloseNormal, "job aborted")
+	}
+	select {
+	case <-readerDone:
+	case <-time.After(wsCloseWait):
+	}
+}
+
+// runScan runs a prepared scan under job, sending what happens to emit as
+// enumerate/stream events: start, a message per new host, notices, one
+// source-complete per source (takeover and probe included when asked for,
+// the latter with a probe event per URL) and a final complete. emit may
+// be called from several goroutines at once. Returns the number of unique
+// hosts.
+func runScan(ctx context.Context, job *Job, scan *preparedScan, emit func(event string, v interface{})) int {
+	primaryDone := func() {}
+	if scan.shadow != nil {
+		primaryDone = scan.shadow.start(ctx, job)
+	}
+
+	emit("start", map[string]interface{}{
+		"job":       job.ID,
+		"target":    scan.target,
+		"sources":   scan.names,
+		"resolvers": scan.resolver.Servers(),
+	})
+
+	stopProgress := reportProgress(job, emit)
+	total := runSources(ctx, job, scan.target, scan.entries, scan.options, sourceHooks{
+		apex: func(result Result) {
+			emit("apex", result)
+		},
+		result: func(result Result) {
+			emit("", result)
+		},
+		notice: func(source, kind, message string) {
+			event := "notice"
+			if kind == "progress" {
+				event = "progress"
+			}
+			emit(event, map[string]string{
+				"source":  source,
+				"kind":    kind,
+				"message": message,
+			})
+		},
+		event: func(source, name string, data interface{}) {
+			emit(name, map[string]interface{}{
+				"source": source,
+				"data":   data,
+			})
+		},
+		done: func(source, outcome string, hosts int) {
+			emit("source-complete", map[string]interface{}{
+				"source":  source,
+				"outcome": outcome,
+				"hosts":   hosts,
+			})
+		},
+	})
+	if scan.options.Get("takeover") == "true" {
+		runTakeoverPass(ctx, job, emit)
+	}
+	if scan.options.Get("probe") == "true" {
+		runProbePass(ctx, job, emit)
+	}
+	stopProgress()
+	primaryDone()
+
+	emit("complete", job.completeEvent(total, ctx.Err() != nil))
+	return total
+}
+
+// ScanRequest is the body of POST /api/scan. Options are the query
+// parameters enumerate/stream takes besides target, sources and profile.
+// Targets scans several targets at once, as a parent job with a child per
+// target.
+type ScanRequest struct {
+	Target  string            `json:"target"`
+	Targets []string          `json:"targets"`
+	Sources []string          `json:"sources"`
+	Profile string            `json:"profile"`
+	Options map[string]string `json:"options"`
+}
+
+// maxScanTargets caps the targets of one multi-target scan
+const maxScanTargets = 500
+
+// scanSubmissions serializes the MaxConcurrentJobs check with the job
+// creation it allows
+var scanSubmissions sync.Mutex
+
+// scanHandler starts a combined scan in the background (POST /api/scan) and
+// answers at once with its job ID. The scan runs like enumerate/stream but
+// under its own context, so it outlives the request; follow it with
+// /api/jobs/<id>/stream and stop it with /api/jobs/<id>/abort. A text/plain
+// body lists targets one per line, with the options in the query string.
+func scanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	targets, query, ok := readScanRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(targets) > 1 {
+		startMultiScan(w, r, targets, query)
+		return
+	}
+
+	// Validate and lock as if the scan came in as enumerate/stream
+	query.Set("target", targets[0])
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = query.Encode()
+	scan, ok := prepareScan(w, r)
+	if !ok {
+		return
+	}
+	job, ok := startScan(w, r, scan)
+	if !ok {
+		return
+	}
+
+	log.Printf("Started background scan %s for %s with %v", job.ID, scan.target, scan.names)
+	auditLog(r, "scan_submitted", map[string]interface{}{
+		"job":     job.ID,
+		"target":  scan.target,
+		"sources": scan.names,
+	})
+	writeScanAccepted(w, job, map[string]interface{}{
+		"target":  scan.target,
+		"sources": scan.names,
+	})
+}
+
+// startScan runs a prepared scan as a background job, once MaxConcurrentJobs
+// and the target's lock allow. It answers the request itself and returns
+// false when the scan cannot start.
+func startScan(w http.ResponseWriter, r *http.Request, scan *preparedScan) (*Job, bool) {
+	cfg := configFrom(r.Context())
+	scanSubmissions.Lock()
+	if active := atomic.LoadInt64(&stats.ActiveJobs); active >= int64(cfg.Security.MaxConcurrentJobs) {
+		scanSubmissions.Unlock()
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, fmt.Sprintf("%d jobs are already running", active), http.StatusTooManyRequests)
+		return nil, false
+	}
+	attachLock, ok := lockTarget(w, r, scan.target, scan.entries)
+	if !ok {
+		scanSubmissions.Unlock()
+		return nil, false
+	}
+	job, ctx := createJob(scan.context(context.WithoutCancel(r.Context())), scan.target, scan.names, scan.options)
+	scanSubmissions.Unlock()
+	job.UseResolver(scan.resolver)
+	detachLock := attachLock(job)
+
+	events := job.events
+	go func() {
+		defer job.Complete()
+		defer detachLock()
+		runScan(ctx, job, scan, func(event string, v interface{}) {
+			events.publish(event, v)
+		})
+	}()
+	return job, true
+}
+
+// readScanRequest reads the targets and options of a scan submission. The
+// targets are validated and deduplicated; the options come back without
+// target and with the sources joined as enumerate/stream takes them.
+func readScanRequest(w http.ResponseWriter, r *http.Request) ([]string, url.Values, bool) {
+	var request ScanRequest
+	query := url.Values{}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/plain" {
+		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid scan request: %v", err), http.StatusBadRequest)
+			return nil, nil, false
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				request.Targets = append(request.Targets, line)
+			}
+		}
+		query = r.URL.Query()
+	} else {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid scan request: %v", err), http.StatusBadRequest)
+			return nil, nil, false
+		}
+		for key, value := range request.Options {
+			query.Set(key, value)
+		}
+		query.Del("sources")
+		if len(request.Sources) > 0 {
+			query.Set("sources", strings.Join(request.Sources, ","))
+		}
+		if request.Profile != "" {
+			query.Set("profile", request.Profile)
+		}
+	}
+	query.Del("target")
+
+	var targets []string
+	for _, raw := range append([]string{request.Target}, request.Targets...) {
+		if raw == "" {
+			continue
+		}
+		target, err := parseTarget(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("target %q: %v", raw, err), http.StatusBadRequest)
+			return nil, nil, false
+		}
+		if !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	switch {
+	case len(targets) == 0:
+		http.Error(w, "missing target", http.StatusBadRequest)
+		return nil, nil, false
+	case len(targets) > maxScanTargets:
+		http.Error(w, fmt.Sprintf("%d targets given, at most %d are scanned at once", len(targets), maxScanTargets), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	return targets, query, true
+}
+
+// writeScanAccepted answers a scan submission with the job's ID and links
+func writeScanAccepted(w http.ResponseWriter, job *Job, details map[string]interface{}) {
+	jobURL := "/api/jobs/" + url.PathEscape(job.ID)
+	details["job_id"] = job.ID
+	details["job_url"] = jobURL
+	details["stream_url"] = jobURL + "/stream"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", jobURL)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(details)
+}
+
+// jobSlotPoll is how often a queued target of a multi-target scan checks
+// for a free MaxConcurrentJobs slot
+const jobSlotPoll = time.Second
+
+// waitForJobSlot calls start once fewer than limit jobs are running. It
+// reports false if ctx ends first.
+func waitForJobSlot(ctx context.Context, limit int, start func()) bool {
+	ticker := time.NewTicker(jobSlotPoll)
+	defer ticker.Stop()
+	for {
+		scanSubmissions.Lock()
+		if atomic.LoadInt64(&stats.ActiveJobs) < int64(limit) {
+			start()
+			scanSubmissions.Unlock()
+			return true
+		}
+		scanSubmissions.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// startMultiScan scans several targets as one parent job with a child job
+// per target. Every target is validated before any starts; they then run
+// as MaxConcurrentJobs allows, each with its own dedup set. The parent's
+// stream carries the children's events wrapped in targetEvents, with start
+// and complete renamed target-start and target-complete.
+func startMultiScan(w http.ResponseWriter, r *http.Request, targets []string, query url.Values) {
+	type targetScan struct {
+		scan    *preparedScan
+		request *http.Request
+	}
+	scans := make([]targetScan, 0, len(targets))
+	for _, target := range targets {
+		query.Set("target", target)
+		request := r.Clone(context.WithoutCancel(r.Context()))
+		request.URL.RawQuery = query.Encode()
+		scan, ok := prepareScan(w, request)
+		if !ok {
+			return
+		}
+		scans = append(scans, targetScan{scan, request})
+	}
+	query.Del("target")
+
+	names := scans[0].scan.names
+	qps, _ := strconv.Atoi(query.Get("dns_qps"))
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	parent := &Job{
+		Targets:       targets,
+		Sources:       names,
+		StartTime:     time.Now(),
+		Status:        "running",
+		Results:       make(map[string][]Result),
+		Cancel:        cancel,
+		release:       cancel,
+		events:        newJobEventLog(),
+		SourceTimings: make(map[string]*SourceTiming),
+		Options:       make(map[string]string),
+		Settings:      newJobSettings(scans[0].scan.config, qps, query),
+		PassiveOnly:   passiveOnly || passiveProfile(query),
+		pausedAtStart: maintenance.pausedFor(),
+	}
+	for key := range query {
+		parent.Options[key] = query.Get(key)
+	}
+	registerJob(parent, "multi")
+
+	cfg := configFrom(r.Context())
+	events := parent.events
+	go func() {
+		events.publish("start", map[string]interface{}{
+			"job":     parent.ID,
+			"targets": targets,
+			"sources": names,
+		})
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		hosts := 0
+		for _, ts := range scans {
+			scan := ts.scan
+			var child *Job
+			var childCtx context.Context
+			var detachLock func()
+			started := waitForJobSlot(ctx, cfg.Security.MaxConcurrentJobs, func() {
+				// Nobody is waiting for a 423, so the conflict becomes a notice
+				attachLock, conflict := acquireTargetLock(ts.request, scan.target, scan.entries)
+				if conflict != nil {
+					events.publish("notice", targetEvent{Target: scan.target, Data: map[string]string{
+						"kind":    "error",
+						"message": "not scanned: " + lockConflictMessage(*conflict),
+					}})
+					return
+				}
+				child, childCtx = createJob(scan.context(ctx), scan.target, scan.names, scan.options)
+				detachLock = attachLock(child)
+			})
+			if !started {
+				break
+			}
+			if child == nil {
+				continue
+			}
+
+			child.UseResolver(scan.resolver)
+			child.mu.Lock()
+			child.ParentID = parent.ID
+			child.mu.Unlock()
+			parent.mu.Lock()
+			parent.Children = append(parent.Children, child.ID)
+			parent.mu.Unlock()
+
+			childEvents := child.events
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer child.Complete()
+				defer detachLock()
+				found := runScan(childCtx, child, scan, func(event string, v interface{}) {
+					childEvents.publish(event, v)
+					switch event {
+					case "start", "complete":
+						event = "target-" + event
+					}
+					events.publish(event, targetEvent{Target: scan.target, Job: child.ID, Data: v})
+				})
+				if ctx.Err() != nil {
+					abortJob(child, "parent job "+parent.ID)
+				}
+				mu.Lock()
+				hosts += found
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		events.publish("complete", parent.parentCompleteEvent(hosts, ctx.Err() != nil))
+		parent.end("completed")
+	}()
+
+	log.Printf("Started background scan %s of %d targets with %v", parent.ID, len(targets), names)
+	auditLog(r, "scan_submitted", map[string]interface{}{
+		"job":     parent.ID,
+		"targets": targets,
+		"sources": names,
+	})
+	writeScanAccepted(w, parent, map[string]interface{}{
+		"targets":  targets,
+		"sources":  names,
+		"jobs_url": "/api/jobs?parent=" + url.QueryEscape(parent.ID),
+	})
+}
+
+// parentCompleteEvent is the data of a multi-target scan's final complete
+// event, with a summary of each child
+func (j *Job) parentCompleteEvent(hosts int, cancelled bool) map[string]interface{} {
+	j.mu.RLock()
+	targets, children := slices.Clone(j.Targets), slices.Clone(j.Children)
+	j.mu.RUnlock()
+
+	summaries := make([]JobSummary, 0, len(children))
+	for _, id := range children {
+		if child, ok := lookupJob(id); ok {
+			summaries = append(summaries, child.Summary())
+		}
+	}
+	return map[string]interface{}{
+		"job":       j.ID,
+		"targets":   targets,
+		"hosts":     hosts,
+		"children":  summaries,
+		"cancelled": cancelled,
+	}
+}
+
+// jobStreamHandler follows a job over SSE (GET /api/jobs/<id>/stream). The
+// hosts found so far are replayed first, then the job's events follow as
+// enumerate/stream sends them. The stream ends with the complete event,
+// right after the replay for a finished job. A multi-target parent replays
+// its children's hosts as targetEvents.
+func jobStreamHandler(w http.ResponseWriter, r *http.Request, job *Job) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Events up to the cursor are covered by the snapshot; later ones may
+	// repeat hosts in it, which are skipped
+	job.mu.RLock()
+	events := job.events
+	var cursor int64
+	if events != nil {
+		cursor = events.last()
+	}
+	start := map[string]interface{}{
+		"job":     job.ID,
+		"target":  job.Target,
+		"sources": slices.Clone(job.Sources),
+		"status":  job.Status,
+	}
+	parent := job.Targets != nil
+	replayed := []*Job{job}
+	if parent {
+		start["targets"] = slices.Clone(job.Targets)
+		replayed = nil
+		for _, id := range job.Children {
+			if child, ok := lookupJob(id); ok {
+				replayed = append(replayed, child)
+			}
+		}
+	}
+	job.mu.RUnlock()
+
+	sseHeader(w)
+	stream := &sseWriter{w: w, flusher: flusher}
+	stream.sendJSON("start", start)
+
+	seen := make(map[string]struct{})
+	hosts := 0
+	for _, replay := range replayed {
+		replay.mu.RLock()
+		var snapshot []Result
+		for _, results := range replay.Results {
+			snapshot = append(snapshot, results...)
+		}
+		replay.mu.RUnlock()
+		slices.SortFunc(snapshot, func(a, b Result) int {
+			return orderOf(a.Source, a).compare(orderOf(b.Source, b))
+		})
+
+		for _, result := range snapshot {
+			key := streamKey(result)
+			if parent {
+				key = replay.Target + "|" + key
+			}
+			if _, dup := seen[key]; dup {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			event := ""
+			switch result.Source {
+			case "apex":
+				event = "apex"
+			case "takeover":
+			default:
+				hosts++
+			}
+			if parent {
+				stream.sendJSON(event, targetEvent{Target: replay.Target, Job: replay.ID, Data: result})
+			} else {
+				stream.sendJSON(event, result)
+			}
+		}
+	}
+
+	for events != nil {
+		batch, wake, closed := events.since(cursor)
+		for _, event := range batch {
+			cursor = event.id
+			if event.key != "" {
+				if _, dup := seen[event.key]; dup {
+					continue
+				}
+				seen[event.key] = struct{}{}
+				if event.host {
+					hosts++
+				}
+			}
+			if event.event == "start" {
+				continue
+			}
+			stream.sendData(event.event, event.data)
+			if event.event == "complete" {
+				return
+			}
+		}
+		if closed {
+			break
+		}
+		select {
+		case <-wake:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	job.mu.RLock()
+	cancelled := strings.HasPrefix(job.Status, "cancelled") || job.Status == "interrupted"
+	job.mu.RUnlock()
+	if parent {
+		stream.sendJSON("complete", job.parentCompleteEvent(hosts, cancelled))
+		return
+	}
+	stream.sendJSON("complete", job.completeEvent(hosts, cancelled))
+}
+
+// How often a running scan's progress is checked, and when it is sent as
+// a progress event: after progressStep percent of change, or at least every
+// progressInterval
+const (
+	progressPoll     = 250 * time.Millisecond
+	progressStep     = 1.0
+	progressInterval = 2 * time.Second
+)
+
+// reportProgress emits the job's ProgressReport as progress events until
+// the returned function is called, which sends a last one
+func reportProgress(job *Job, emit func(event string, v interface{})) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(progressPoll)
+		defer ticker.Stop()
+		lastPercent, lastSent := -progressStep, time.Time{}
+		for {
+			select {
+			case <-stop:
+				emit("progress", job.Progress.Report())
+				return
+			case now := <-ticker.C:
+				report := job.Progress.Report()
+				if math.Abs(report.Percent-lastPercent) < progressStep && now.Sub(lastSent) < progressInterval {
+					continue
+				}
+				emit("progress", report)
+				lastPercent, lastSent = report.Percent, now
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// completeEvent is the data of a combined scan's final complete event;
+// hosts is the number of unique hosts its sources found
+func (j *Job) completeEvent(hosts int, cancelled bool) map[string]interface{} {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return map[string]interface{}{
+		"job":               j.ID,
+		"target":            j.Target,
+		"hosts":             hosts,
+		"unique_hosts":      j.UniqueHosts,
+		"unique_subdomains": j.UniqueSubdomains,
+		"tainted":           j.Tainted,
+		"hosts_by_provider": maps.Clone(j.HostsByProvider),
+		"cancelled":         cancelled,
+	}
+}
+
+// shadowRuns counts the shadow runs in progress, against Shadow.MaxRuns
+var shadowRuns atomic.Int64
+
+// shadowPlan is a candidate configuration to run silently next to a scan:
+// another resolver pool (shadow_resolvers=) and/or source set
+// (shadow_sources=)
+type shadowPlan struct {
+	target   string
+	resolver *DNSResolver
+	entries  []sourceEntry
+	options  url.Values
+	changes  []string
+	primary  []string // the scan's own sources
+}
+
+// planShadowRun reads the shadow options of a scan. It returns nil when
+// there are none; candidate resolvers must answer like resolvers= ones.
+func planShadowRun(ctx context.Context, options url.Values, target string, primary *DNSResolver, sources []string) (*shadowPlan, error) {
+	resolverList, sourceList := options.Get("shadow_resolvers"), options.Get("shadow_sources")
+	if resolverList == "" && sourceList == "" {
+		return nil, nil
+	}
+
+	plan := &shadowPlan{target: target, resolver: primary, primary: sources, options: url.Values{}}
+	for key, values := range options {
+		switch key {
+		case "shadow_resolvers", "shadow_sources", "resolvers", "takeover", "debug":
+			// The shadow side gets its own resolvers and skips the extras
+		default:
+			plan.options[key] = values
+		}
+	}
+
+	if resolverList != "" {
+		resolver, err := scanResolver(ctx, url.Values{"resolvers": {resolverList}}, target)
+		if err != nil {
+			return nil, err
+		}
+		plan.resolver = resolver
+		plan.changes = append(plan.changes, "resolvers="+strings.Join(resolver.Servers(), ","))
+	}
+
+	names := sources
+	if sourceList != "" {
+		names = nil
+		for _, name := range strings.Split(sourceList, ",") {
+			if name = strings.TrimSpace(name); name != "" && !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			if !containsString(sources, name) {
+				plan.changes = append(plan.changes, "+"+name)
+			}
+		}
+		for _, name := range sources {
+			if !containsString(names, name) {
+				plan.changes = append(plan.changes, "-"+name)
+			}
+		}
+	}
+	for _, name := range names {
+		entry, ok := lookupSource(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown shadow source %q", name)
+		}
+		plan.entries = append(plan.entries, entry)
+	}
+	if len(plan.entries) == 0 {
+		return nil, errors.New("shadow run has no sources")
+	}
+	return plan, nil
+}
+
+// ShadowReport compares a scan with its shadow run. The shadow run's hosts
+// never become results of the job.
+type ShadowReport struct {
+	Label   string     `json:"label"`
+	Status  string     `json:"status"` // running, complete, cancelled or skipped
+	Reason  string     `json:"reason,omitempty"`
+	Changes []string   `json:"changes"`
+	Primary ShadowSide `json:"primary"`
+	Shadow  ShadowSide `json:"shadow"`
+	// Shadow minus primary
+	Delta ShadowDelta `json:"delta"`
+	RunComparison
+}
+
+// ShadowDelta is how far the shadow side is from the primary one
+type ShadowDelta struct {
+	Hosts      int     `json:"hosts"`
+	DurationMS int64   `json:"duration_ms"`
+	ErrorRate  float64 `json:"error_rate"`
+}
+
+// ShadowSide is one side of a shadow comparison
+type ShadowSide struct {
+	Sources       []string         `json:"sources"`
+	Resolvers     []string         `json:"resolvers"`
+	Hosts         int              `json:"hosts"`
+	DurationMS    int64            `json:"duration_ms"`
+	SourceLatency map[string]int64 `json:"source_latency_ms"`
+	SourceErrors  int              `json:"source_errors"`
+	ErrorRate     float64          `json:"error_rate"`
+}
+
+// start runs the plan next to job and returns the function to call once
+// the primary scan is done. The comparison is attached to the job when
+// both sides are; aborting the job cancels the shadow run too.
+func (p *shadowPlan) start(ctx context.Context, job *Job) func() {
+	cfg := *configFrom(ctx)
+	report := &ShadowReport{
+		Label:   "shadow run: compared only, not part of this job's results",
+		Status:  "running",
+		Changes: p.changes,
+	}
+	job.mu.Lock()
+	job.Shadow = report
+	job.mu.Unlock()
+
+	if int(shadowRuns.Add(1)) > cfg.Shadow.MaxRuns {
+		shadowRuns.Add(-1)
+		job.mu.Lock()
+		report.Status = "skipped"
+		report.Reason = fmt.Sprintf("%d shadow runs are already in progress", cfg.Shadow.MaxRuns)
+		job.mu.Unlock()
+		return func() {}
+	}
+
+	// The shadow side has its own, smaller budget and outlives the
+	// primary's stream if it has to, up to Shadow.Timeout
+	cfg.DNS.Concurrency = max(min(cfg.Shadow.DNSConcurrency, cfg.DNS.Concurrency), 1)
+	shadowCtx, cancel := withPausableTimeout(withConfig(context.WithoutCancel(ctx), &cfg), cfg.Shadow.Timeout)
+	shadowCtx = withResolver(shadowCtx, p.resolver)
+	job.mu.Lock()
+	primaryCancel := job.Cancel
+	job.Cancel = func() {
+		cancel()
+		if primaryCancel != nil {
+			primaryCancel()
+		}
+	}
+	job.mu.Unlock()
+
+	names := make([]string, len(p.entries))
+	for i, entry := range p.entries {
+		names[i] = entry.source.Name()
+	}
+	shadow := &Job{
+		ID:            job.ID + "#shadow",
+		Target:        job.Target,
+		Sources:       names,
+		StartTime:     time.Now(),
+		Status:        "running",
+		Results:       make(map[string][]Result),
+		SourceTimings: make(map[string]*SourceTiming),
+		Options:       make(map[string]string),
+		shadow:        true,
+		pausedAtStart: maintenance.pausedFor(),
+	}
+	for _, name := range names {
+		shadow.SourceTimings[name] = newSourceTiming(shadow.StartTime)
+	}
+	shadow.UseResolver(p.resolver)
+
+	primaryDone := make(chan struct{})
+	go func() {
+		defer shadowRuns.Add(-1)
+		defer cancel()
+
+		runSources(shadowCtx, shadow, p.target, p.entries, p.options, sourceHooks{
+			result: func(Result) {},
+			notice: func(string, string, string) {},
+			event:  func(string, string, interface{}) {},
+			done:   func(string, string, int) {},
+		})
+		shadow.mu.Lock()
+		shadow.finish()
+		shadow.mu.Unlock()
+
+		<-primaryDone
+		status := "complete"
+		if shadowCtx.Err() != nil {
+			status = "cancelled"
+		}
+		primary := shadowSide(job, job.Resolver())
+		candidate := shadowSide(shadow, p.resolver)
+		comparison := compareRuns(job.Hosts(), shadow.Hosts())
+
+		job.mu.Lock()
+		report.Status = status
+		report.Primary, report.Shadow = primary, candidate
+		report.Delta = ShadowDelta{
+			Hosts:      candidate.Hosts - primary.Hosts,
+			DurationMS: candidate.DurationMS - primary.DurationMS,
+			ErrorRate:  candidate.ErrorRate - primary.ErrorRate,
+		}
+		report.RunComparison = comparison
+		job.mu.Unlock()
+		log.Printf("Shadow run for job %s (%s): %d hosts only in primary, %d only in shadow",
+			job.ID, strings.Join(p.changes, " "), len(comparison.OnlyA), len(comparison.OnlyB))
+	}()
+	return func() { close(primaryDone) }
+}
+
+// shadowSide summarizes a finished run for a shadow comparison. Sources
+// that ended in error or unavailable count towards the error rate.
+func shadowSide(job *Job, resolver *DNSResolver) ShadowSide {
+	hosts := job.Hosts()
+
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	side := ShadowSide{
+		Sources:       slices.Clone(job.Sources),
+		Resolvers:     resolver.Servers(),
+		Hosts:         len(hosts),
+		SourceLatency: make(map[string]int64, len(job.SourceTimings)),
+	}
+	side.DurationMS = job.elapsed().Milliseconds()
+	for source, timing := range job.SourceTimings {
+		side.SourceLatency[source] = timing.Duration.Milliseconds()
+		if timing.Outcome == "error" || timing.Outcome == "unavailable" {
+			side.SourceErrors++
+		}
+	}
+	if len(job.SourceTimings) > 0 {
+		side.ErrorRate = float64(side.SourceErrors) / float64(len(job.SourceTimings))
+	}
+	return side
+}
+
+// RunComparison is how the hosts of two runs of a target differ. It is
+// meant for any side-by-side view of runs, shadow reports among them.
+type RunComparison struct {
+	OnlyA  []string `json:"only_primary"`
+	OnlyB  []string `json:"only_shadow"`
+	Shared int      `json:"shared"`
+}
+
+// compareRuns returns the hosts only a or only b found, sorted, and how
+// many both found
+func compareRuns(a, b map[string]struct{}) RunComparison {
+	comparison := RunComparison{OnlyA: []string{}, OnlyB: []string{}}
+	for host := range a {
+		if _, ok := b[host]; ok {
+			comparison.Shared++
+		} else {
+			comparison.OnlyA = append(comparison.OnlyA, host)
+		}
+	}
+	for host := range b {
+		if _, ok := a[host]; !ok {
+			comparison.OnlyB = append(comparison.OnlyB, host)
+		}
+	}
+	sort.Strings(comparison.OnlyA)
+	sort.Strings(comparison.OnlyB)
+	return comparison
+}
+
+// Wayback Machine CDX index of archived URLs
+type waybackSource struct{}
+
+func (waybackSource) Name() string { return "wayback" }
+
+func (waybackSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+
+	// Create API URL for Wayback Machine
+	apiURL := fmt.Sprintf(
+		"%s/cdx/search/cdx?url=*.%s/*&output=text&fl=timestamp,original&collapse=urlkey",
+		strings.TrimSuffix(cfg.Upstreams.Wayback, "/"), target,
+	)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return err
+	}
+
+	transport := newOutboundTransport()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.HTTP.SkipTLSVerify}
+	client := &http.Client{Timeout: cfg.HTTP.Timeout, Transport: discoveryTransport(transport)}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errSourceUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	reader, err := upstreamBody("wayback", resp)
+	if err != nil {
+		return err
+	}
+
+	// An early-terminated stream keeps whatever complete lines arrived
+	truncated := false
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		if !isTruncation(err) || len(body) == 0 {
+			return err
+		}
+		truncated = true
+		atomic.AddInt64(&sourceStats("wayback").TruncatedResponses, 1)
+		if cut := strings.LastIndex(string(body), "\n"); cut >= 0 {
+			body = body[:cut]
+		}
+	}
+
+	// Lines are "<capture timestamp> <original URL>"; a host's evidence is
+	// its most recent capture
+	var hosts []string
+	captured := make(map[string]time.Time)
+	for _, line := range strings.Split(string(body), "\n") {
+		if matches := hostRe.FindStringSubmatch(line); matches != nil {
+			host := strings.ToLower(matches[1])
+			if !strings.HasSuffix(host, "."+target) {
+				continue
+			}
+			last, known := captured[host]
+			if !known {
+				hosts = append(hosts, host)
+			}
+			if fields := strings.Fields(line); len(fields) == 2 {
+				if at, err := time.Parse("20060102150405", fields[0]); err == nil && at.After(last) {
+					last = at
+				}
+			}
+			captured[host] = last
+		}
+	}
+
+	for _, host := range hosts {
+		if !emit(ctx, out, Result{Host: host, EvidenceTime: captured[host]}) {
+			return ctx.Err()
+		}
+	}
+
+	if truncated {
+		run := sourceRunFrom(ctx)
+		run.Notice("warning", "Wayback response was truncated, results are partial")
+		run.Partial()
+	}
+	return nil
+}
+
+// upstreamBody returns a reader over the decoded response body. Upstreams
+// occasionally compress bodies we never asked to be compressed, which the
+// transport then leaves untouched.
+func upstreamBody(source string, resp *http.Response) (io.Reader, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		atomic.AddInt64(&sourceStats(source).UnrequestedEncoding, 1)
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		atomic.AddInt64(&sourceStats(source).UnrequestedEncoding, 1)
+		return flate.NewReader(resp.Body), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// isTruncation reports whether err means the upstream stream ended early
+func isTruncation(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// Certificate transparency logs via crt.sh
+type crtshSource struct{}
+
+func (crtshSource) Name() string { return "crtsh" }
+
+func (crtshSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+
+	apiURL := fmt.Sprintf("%s/?q=%%25.%s&output=json", strings.TrimSuffix(cfg.Upstreams.CrtSh, "/"), target)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+
+	client := &http.Client{Timeout: cfg.HTTP.Timeout, Transport: discoveryTransport(nil)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errSourceUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	reader, err := upstreamBody("crtsh", resp)
+	if err != nil {
+		return err
+	}
+
+	// Decode entries one at a time so a truncated array still yields the
+	// entries that arrived intact
+	truncated := false
+	var entries []map[string]interface{}
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("JSON decode error: %w", err)
+	}
+	for decoder.More() {
+		var entry map[string]interface{}
+		if err := decoder.Decode(&entry); err != nil {
+			if !isTruncation(err) {
+				return fmt.Errorf("JSON decode error: %w", err)
+			}
+			truncated = true
+			atomic.AddInt64(&sourceStats("crtsh").TruncatedResponses, 1)
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if !truncated {
+		// A missing closing bracket also means the body was cut short
+		if _, err := decoder.Token(); err != nil {
+			truncated = true
+			atomic.AddInt64(&sourceStats("crtsh").TruncatedResponses, 1)
+		}
+	}
+
+	// A host's evidence is the newest certificate naming it: its validity
+	// start, or when it was logged if that is missing
+	var hosts []string
+	issued := make(map[string]time.Time)
+	for _, entry := range entries {
+		nameValue, ok := entry["name_value"].(string)
+		if !ok {
+			continue
+		}
+		var at time.Time
+		for _, field := range []string{"not_before", "entry_timestamp"} {
+			if value, ok := entry[field].(string); ok {
+				if parsed, err := time.Parse("2006-01-02T15:04:05", value); err == nil {
+					at = parsed
+					break
+				}
+			}
+		}
+
+		for _, name := range strings.Split(nameValue, "\n") {
+			host := strings.ToLower(strings.TrimSpace(name))
+			host = strings.TrimPrefix(host, "*.")
+
+			if strings.HasSuffix(host, "."+target) && host != target {
+				last, known := issued[host]
+				if !known {
+					hosts = append(hosts, host)
+				}
+				if at.After(last) {
+					issued[host] = at
+				} else {
+					issued[host] = last
+				}
+			}
+		}
+	}
+
+	for _, host := range hosts {
+		if !emit(ctx, out, Result{Host: host, EvidenceTime: issued[host]}) {
+			return ctx.Err()
+		}
+	}
+
+	if truncated {
+		run := sourceRunFrom(ctx)
+		run.Notice("warning", "Certificate transparency response was truncated, results are partial")
+		run.Partial()
+	}
+	return nil
+}
+
+// Brute force over the built-in categories, or the labels chosen with
+// categories=, words= and wordlist=
+type dnsBruteSource struct{}
+
+func (dnsBruteSource) Name() string { return "dns" }
+
+func (dnsBruteSource) CheckOptions(ctx context.Context, options url.Values) error {
+	if _, err := parseBruteLabels(options); err != nil {
+		return err
+	}
+	_, err := bruteDepth(ctx, options)
+	return err
+}
+
+// bruteDepth is the number of levels depth= asks for
+func bruteDepth(ctx context.Context, options url.Values) (int, error) {
+	value := options.Get("depth")
+	if value == "" {
+		return 1, nil
+	}
+	limit := configFrom(ctx).DNS.MaxBruteDepth
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > limit {
+		return 0, fmt.Errorf("depth must be between 1 and %d", limit)
+	}
+	return n, nil
+}
+
+// bruteLabels are the labels a brute force tries under each parent: the
+// words= labels, then the categories= ones, then the wordlist= list, with
+// every built-in category but extended when none is chosen
+type bruteLabels struct {
+	words    []string  // resident labels, deduplicated
+	wordlist *Wordlist // streamed after words, nil for none
+	limit    int       // labels tried per parent, 0 for all
+}
+
+func parseBruteLabels(options url.Values) (*bruteLabels, error) {
+	labels := &bruteLabels{}
+	seen := make(map[string]bool)
+	add := func(word string) {
+		if !seen[word] {
+			seen[word] = true
+			labels.words = append(labels.words, word)
+		}
+	}
+
+	for _, word := range splitList(options.Get("words")) {
+		word = strings.ToLower(word)
+		if len(word) > 253 || !wordRe.MatchString(word) {
+			return nil, fmt.Errorf("invalid word %q", word)
+		}
+		add(word)
+	}
+
+	categories := splitList(options.Get("categories"))
+	for _, category := range categories {
+		words, ok := commonSubdomains[category]
+		if !ok {
+			valid := slices.Sorted(maps.Keys(commonSubdomains))
+			return nil, fmt.Errorf("unknown category %q, valid categories: %s", category, strings.Join(valid, ", "))
+		}
+		for _, word := range words {
+			add(word)
+		}
+	}
+
+	if name := options.Get("wordlist"); name != "" {
+		wl, ok := lookupWordlist(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown wordlist %q", name)
+		}
+		labels.wordlist = wl
+	}
+
+	if len(labels.words) == 0 && labels.wordlist == nil {
+		for _, category := range slices.Sorted(maps.Keys(commonSubdomains)) {
+			if category == extendedCategory {
+				continue
+			}
+			for _, word := range commonSubdomains[category] {
+				add(word)
+			}
+		}
+	}
+
+	if value := options.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("limit must be a positive number")
+		}
+		labels.limit = n
+	}
+	return labels, nil
+}
+
+// Each calls fn with each label, up to the limit, until fn returns false.
+// Errors come from reading the wordlist.
+func (b *bruteLabels) Each(fn func(label string) bool) error {
+	n := 0
+	more := func(label string) bool {
+		if b.limit > 0 && n >= b.limit {
+			return false
+		}
+		n++
+		return fn(label)
+	}
+	for _, word := range b.words {
+		if !more(word) {
+			return nil
+		}
+	}
+	if b.wordlist == nil {
+		return nil
+	}
+	stopped := false
+	err := b.wordlist.Each(func(label string) bool {
+		if slices.Contains(b.words, label) {
+			return true
+		}
+		stopped = !more(label)
+		return !stopped
+	})
+	if stopped {
+		return nil
+	}
+	return err
+}
+
+// Count returns the number of labels tried per parent
+func (b *bruteLabels) Count() (int, error) {
+	n := 0
+	err := b.Each(func(string) bool {
+		n++
+		return true
+	})
+	return n, err
+}
+
+// Contains reports whether label is one of the labels tried
+func (b *bruteLabels) Contains(label string) bool {
+	if b.limit == 0 {
+		return slices.Contains(b.words, label) || b.wordlist != nil && b.wordlist.Contains(label)
+	}
+	found := false
+	b.Each(func(word string) bool {
+		found = word == label
+		return !found
+	})
+	return found
+}
+
+func (dnsBruteSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	run := sourceRunFrom(ctx)
+	depth, err := bruteDepth(ctx, run.options)
+	if err != nil {
+		return err
+	}
+	labels, err := parseBruteLabels(run.options)
+	if err != nil {
+		return err
+	}
+
+	var readErr error
+	candidates := func(parents []string) iter.Seq[string] {
+		return func(yield func(string) bool) {
+			for _, parent := range parents {
+				stopped := false
+				err := labels.Each(func(label string) bool {
+					stopped = !yield(label + "." + parent)
+					return !stopped
+				})
+				if err != nil {
+					readErr = err
+					return
+				}
+				if stopped {
+					return
+				}
+			}
+		}
+	}
+
+	// Levels past the first add to the total as their parents are known.
+	// A wordlist that cannot be read is reported by the brute force itself.
+	count, _ := labels.Count()
+
+	// Each level brute forces the names the previous one found, sharing
+	// the lookup slots, wildcard filter and seen names
+	brute := newBruteForce(ctx, "dns", target)
+	parents := []string{target}
+	for level := 1; level <= depth && len(parents) > 0; level++ {
+		run.Expect(count * len(parents))
+		if depth > 1 {
+			run.Progress("Level %d of %d: brute forcing %d domain(s)", level, depth, len(parents))
+		}
+		var next []string
+		var mu sync.Mutex
+		collect := func(result Result) {
+			// A wildcard parent would only yield wildcard matches
+			if level < depth && !brute.wildcards.zone(ctx, result.Host).wildcard {
+				mu.Lock()
+				next = append(next, result.Host)
+				mu.Unlock()
+			}
+		}
+		err := brute.resolve(candidates(parents), out, collect)
+		if readErr != nil {
+			if errors.Is(readErr, errWordlistUnavailable) {
+				return readErr
+			}
+			run.Notice("warning", "Wordlist %s could not be read to the end (%v), results are partial", labels.wordlist.Name, readErr)
+			run.Partial()
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		slices.Sort(next)
+		parents = next
+	}
+	return nil
+}
+
+// wildcardZone is the wildcard state of one parent domain, detected once
+type wildcardZone struct {
+	once     sync.Once
+	ips      map[string]bool
+	wildcard bool
+}
+
+// wildcardFilter detects wildcard DNS per parent domain, so a wildcard at
+// *.dev.example.com is caught as well as one at *.example.com
+type wildcardFilter struct {
+	zones  map[string]*wildcardZone
+	poison map[string]bool // interception addresses, never a wildcard
+	mu     sync.Mutex
+}
+
+// zone returns the wildcard state of domain, detecting it on first use and
+// telling the client when a wildcard is found
+func (wf *wildcardFilter) zone(ctx context.Context, domain string) *wildcardZone {
+	wf.mu.Lock()
+	zone, ok := wf.zones[domain]
+	if !ok {
+		zone = &wildcardZone{}
+		wf.zones[domain] = zone
+	}
+	wf.mu.Unlock()
+
+	zone.once.Do(func() {
+		ips, _ := resolverFrom(ctx).DetectWildcard(ctx, domain)
+		ips = slices.DeleteFunc(ips, func(ip net.IP) bool { return wf.poison[ip.String()] })
+		if len(ips) == 0 {
+			return
+		}
+		zone.wildcard = true
+		zone.ips = make(map[string]bool, len(ips))
+		addresses := make([]string, len(ips))
+		for i, ip := range ips {
+			zone.ips[ip.String()] = true
+			addresses[i] = ip.String()
+		}
+
+		action := "suppressed"
+		if sourceRunFrom(ctx).Option("wildcard") == "flag" {
+			action = "tagged \"wildcard\""
+		}
+		sourceRunFrom(ctx).Notice("info", "Wildcard DNS detected for *.%s (%s); names resolving only to these addresses are %s",
+			domain, strings.Join(addresses, ", "), action)
+	})
+	return zone
+}
+
+// matches reports whether host resolved only to its parent's wildcard IPs
+func (wf *wildcardFilter) matches(ctx context.Context, host string, ips []net.IP) bool {
+	_, parent, ok := strings.Cut(host, ".")
+	if !ok {
+		return false
+	}
+	zone := wf.zone(ctx, parent)
+	if !zone.wildcard {
+		return false
+	}
+	for _, ip := range ips {
+		if !zone.ips[ip.String()] {
+			return false
+		}
+	}
+	return true
+}
+
+// onlyAddresses reports whether every ip is in set; false for an empty set
+func onlyAddresses(ips []net.IP, set map[string]bool) bool {
+	if len(set) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !set[ip.String()] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveCandidates looks up every candidate name under target, following
+// CNAMEs, and emits the ones that resolve. Names matching a wildcard are
+// dropped, or tagged with wildcard=flag. Names left when ctx ends are
+// recorded as not attempted.
+func resolveCandidates(ctx context.Context, source, target string, candidates iter.Seq[string], out chan<- Result) error {
+	return newBruteForce(ctx, source, target).resolve(candidates, out, nil)
+}
+
+// bruteForce is the state one brute-force scan shares across passes over
+// candidates: the lookup slots, the wildcard and interception filters and
+// the names already looked up
+type bruteForce struct {
+	ctx    context.Context
+	source string
+
+	poison     map[string]bool
+	wildcards  *wildcardFilter
+	registered string
+	semaphore  chan struct{}
+
+	seen map[string]bool
+	mu   sync.Mutex
+}
+
+func newBruteForce(ctx context.Context, source, target string) *bruteForce {
+	// An intercepting DNS path answers every name, so check for it before
+	// wildcards, which would otherwise be reported for every parent
+	poison := sourceRunFrom(ctx).Job().Interception(ctx)
+
+	// Detect a wildcard on the target itself before the brute force starts;
+	// deeper parents are checked as candidates under them come up
+	wildcards := &wildcardFilter{zones: make(map[string]*wildcardZone), poison: poison}
+	wildcards.zone(ctx, target)
+
+	// CNAMEs leaving the target's registered domain are flagged
+	registered, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(target))
+	if err != nil {
+		registered = target
+	}
+
+	return &bruteForce{
+		ctx:        ctx,
+		source:     source,
+		poison:     poison,
+		wildcards:  wildcards,
+		registered: registered,
+		semaphore:  make(chan struct{}, configFrom(ctx).DNS.Concurrency),
+		seen:       make(map[string]bool),
+	}
+}
+
+// resolve looks up the candidates not seen before. found, if set, gets each
+// name that resolved and passed the wildcard and interception filters.
+func (b *bruteForce) resolve(candidates iter.Seq[string], out chan<- Result, found func(Result)) error {
+	ctx, source, semaphore := b.ctx, b.source, b.semaphore
+	poison, wildcards, registered := b.poison, b.wildcards, b.registered
+	cfg := configFrom(ctx)
+	run := sourceRunFrom(ctx)
+	job := run.Job()
+	flagWildcards := run.Option("wildcard") == "flag"
+	includeErrors := run.Option("include_errors") == "true"
+	run.progress().Phase(run.source, "resolving")
+
+	var wg sync.WaitGroup
+
+	for candidate := range candidates {
+		b.mu.Lock()
+		seen := b.seen[candidate]
+		b.seen[candidate] = true
+		b.mu.Unlock()
+		if seen {
+			run.Advance(1)
+			continue
+		}
+
+		if ctx.Err() != nil {
+			// The rest only matters to the debug log
+			if job == nil || job.candidates == nil {
+				break
+			}
+			job.RecordCandidate(source, candidate, "not-attempted", "scan cancelled or timed out", nil)
+			continue
+		}
+
+		// Take the slot before starting the lookup, so a streamed wordlist
+		// is read at the resolver pool's pace instead of parking one
+		// goroutine per candidate
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			job.RecordCandidate(source, candidate, "not-attempted", "scan cancelled or timed out", nil)
+			continue
+		}
+
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			defer run.Advance(1)
+
+			chain, ips, err := resolverFrom(ctx).ResolveChain(ctx, host, cfg.DNS.CNAMEMaxDepth)
+			if err != nil || len(ips) == 0 {
+				job.RecordLookup(source, host, ips, err)
+				if result, ok := danglingCNAME(host, chain, err); ok {
+					atomic.AddInt64(&stats.DanglingCNAMEs, 1)
+					emit(ctx, out, result)
+					return
+				}
+				// SERVFAIL or REFUSED on one name, after retries, often
+				// means a broken delegation under it
+				var rcodeErr *RcodeError
+				if includeErrors && errors.As(err, &rcodeErr) && retryableRcode(rcodeErr.Rcode) {
+					rcode := dns.RcodeToString[rcodeErr.Rcode]
+					emit(ctx, out, Result{Host: host, Status: "dns-error", Title: rcode, Rcode: rcode, Error: err.Error()})
+				}
+				return
+			}
+			result := Result{Host: host, IPs: resultIPs(ips)}
+			if len(chain) > 0 {
+				result.CNAME = chain[len(chain)-1]
+				result.CNAMEChain = chain
+				if !underDomain(result.CNAME, registered) {
+					result.Status = "cname-external"
+				}
+			}
+			if onlyAddresses(ips, poison) {
+				job.RecordCandidate(source, host, "intercepted", "resolves only to interception addresses", ips)
+				if flagWildcards {
+					result.Tags = []string{"intercepted"}
+					emit(ctx, out, result)
+				}
+				return
+			}
+			if wildcards.matches(ctx, host, ips) {
+				job.RecordCandidate(source, host, "wildcard", "resolves only to wildcard addresses", ips)
+				if flagWildcards {
+					result.Tags = []string{"wildcard"}
+					emit(ctx, out, result)
+				}
+				return
+			}
+			job.RecordLookup(source, host, ips, err)
+			if detail, ok := unboundIP(ctx, result.IPs); ok {
+				result.Status = "unbound-ip"
+				result.Error = detail
+				atomic.AddInt64(&stats.UnboundIPs, 1)
+			}
+			if found != nil {
+				found(result)
+			}
+			emit(ctx, out, result)
+		}(candidate)
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// danglingCNAME reports a name whose CNAME chain ends at a name that does
+// not exist. Whoever registers that name, or claims it at its provider,
+// serves the host.
+func danglingCNAME(host string, chain []string, err error) (Result, bool) {
+	if len(chain) == 0 || !errors.Is(err, errNXDomain) {
+		return Result{}, false
+	}
+	end := chain[len(chain)-1]
+	return Result{
+		Host:       host,
+		Status:     "dangling-cname",
+		CNAME:      end,
+		CNAMEChain: chain,
+		Error:      fmt.Sprintf("CNAME target %s does not exist (NXDOMAIN)", end),
+	}, true
+}
+
+// Cloud providers that hand released addresses to other customers, so a
+// record left pointing at one may end up at someone else's machine.
+// Anycast CDNs answer on all of their addresses and are not checked.
+var unboundIPProviders = []string{"aws", "gcp", "azure"}
+
+// unboundIP reports whether every address of a result lies in one of the
+// unboundIPProviders and none of the IPv4 ones answers on port 80 or 443
+// before the timeout. A refused connection is an answer: something holds
+// the address. Any other failure, such as no route from here, leaves the
+// question open and the address is not flagged.
+func unboundIP(ctx context.Context, ips []ResultIP) (string, bool) {
+	cfg := configFrom(ctx)
+	if !cfg.DNS.UnboundIPCheck || passiveOnly || len(ips) == 0 {
+		return "", false
+	}
+	for _, ip := range ips {
+		if !containsString(unboundIPProviders, ip.Provider) {
+			return "", false
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.DNS.UnboundIPTimeout}
+	var addresses []string
+	for _, ip := range ips {
+		if ip.Family != "ipv4" {
+			continue
+		}
+		for _, port := range []string{"80", "443"} {
+			if maintenance.awaitResume(ctx) != nil {
+				return "", false
+			}
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.Address, port))
+			if err == nil {
+				conn.Close()
+				return "", false
+			}
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() || ctx.Err() != nil {
+				return "", false
+			}
+		}
+		addresses = append(addresses, ip.Address)
+	}
+	if len(addresses) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("%s (%s) did not answer on ports 80 or 443", strings.Join(addresses, ", "), ips[0].Provider), true
+}
+
+// Search engine results for site:target
+type searchSource struct{}
+
+func (searchSource) Name() string { return "search" }
+
+func (searchSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+
+	// Simple Google search implementation
+	searchURL := fmt.Sprintf("%s/search?q=site:%s", strings.TrimSuffix(cfg.Upstreams.Search, "/"), target)
+	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+
+	client := &http.Client{Timeout: cfg.HTTP.Timeout, Transport: discoveryTransport(nil)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errSourceUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	urlPattern := regexp.MustCompile(`https?://([^/\s"'<>]+\.` + regexp.QuoteMeta(target) + `)`)
+	for _, match := range urlPattern.FindAllStringSubmatch(string(body), -1) {
+		if len(match) > 1 {
+			if !emit(ctx, out, Result{Host: match[1]}) {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// Permutations of the target's labels with common affixes, or with the
+// labels of the list named by wordlist=. mode=smart permutes the names
+// already found instead (see permutationEngine).
+type permuteSource struct{}
+
+func (permuteSource) Name() string { return "permute" }
+
+func (permuteSource) CheckOptions(ctx context.Context, options url.Values) error {
+	if mode := options.Get("mode"); mode != "" && mode != "static" && mode != "smart" && mode != "typo" {
+		return fmt.Errorf("mode must be static, smart or typo")
+	}
+	if _, err := selectPermutationPatterns(options.Get("patterns")); err != nil {
+		return err
+	}
+	if name := options.Get("wordlist"); name != "" {
+		if _, ok := lookupWordlist(name); !ok {
+			return fmt.Errorf("unknown wordlist %q", name)
+		}
+	}
+	return nil
+}
+
+func (permuteSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	run := sourceRunFrom(ctx)
+	switch run.Option("mode") {
+	case "smart":
+		return smartPermutations(ctx, target, out)
+	case "typo":
+		return typoPermutations(ctx, target, out)
+	}
+	patterns, err := selectPermutationPatterns(run.Option("patterns"))
+	if err != nil {
+		return err
+	}
+	var wl *Wordlist
+	name := run.Option("wordlist")
+	if name != "" {
+		var ok bool
+		if wl, ok = lookupWordlist(name); !ok {
+			return fmt.Errorf("unknown wordlist %q", name)
+		}
+	}
+
+	// Each selected group in turn; wordlist= takes the basic group's place
+	var readErr error
+	candidates := func(yield func(string) bool) {
+		for _, pattern := range patterns {
+			if pattern.name == "basic" && wl != nil {
+				stopped := false
+				readErr = wl.Each(func(label string) bool {
+					for _, candidate := range affixPermutations(target, label) {
+						if validCandidate(candidate) && !yield(candidate) {
+							stopped = true
+							return false
+						}
+					}
+					return true
+				})
+				if stopped || readErr != nil {
+					return
+				}
+				continue
+			}
+			for _, candidate := range pattern.generate(target, time.Now()) {
+				if validCandidate(candidate) && !yield(candidate) {
+					return
+				}
+			}
+		}
+	}
+	// Generating is cheap next to resolving, so count in a first pass
+	total := 0
+	for range candidates {
+		total++
+	}
+	run.Expect(total)
+	err = resolveCandidates(ctx, "permute", target, candidates, out)
+	if readErr != nil {
+		if errors.Is(readErr, errWordlistUnavailable) {
+			return readErr
+		}
+		run.Notice("warning", "Wordlist %s could not be read to the end (%v), results are partial", name, readErr)
+		run.Partial()
+	}
+	return err
+}
+
+// maxTypoCandidates caps the look-alikes one mode=typo scan checks
+const maxTypoCandidates = 3000
+
+// typoPermutations looks up typo and homoglyph variants of the target's
+// registered domain and reports the ones that resolve as "lookalike"
+// results, for spotting phishing domains
+func typoPermutations(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+	run := sourceRunFrom(ctx)
+	registered, err := publicsuffix.EffectiveTLDPlusOne(target)
+	if err != nil {
+		return fmt.Errorf("no registered domain for %s: %w", target, err)
+	}
+	candidates := typoCandidates(registered, maxTypoCandidates)
+	run.Notice("info", "Checking %d look-alikes of %s", len(candidates), registered)
+
+	semaphore := make(chan struct{}, cfg.DNS.Concurrency)
+	var wg sync.WaitGroup
+	for _, candidate := range candidates {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			ips, err := resolverFrom(ctx).LookupHost(ctx, candidate.name)
+			if err != nil || len(ips) == 0 {
+				return
+			}
+			emit(ctx, out, Result{
+				Host:   candidate.name,
+				Status: "lookalike",
+				Title:  fmt.Sprintf("Look-alike of %s (%s)", registered, candidate.technique),
+				Tags:   []string{"lookalike", candidate.technique},
+				IPs:    resultIPs(ips),
+			})
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// typoCandidate is a look-alike domain and how it was derived
+type typoCandidate struct {
+	name      string
+	technique string // homoglyph, omission, duplication, adjacent-key or bitflip
+}
+
+// homoglyphs are stand-ins for a character: ASCII ones and Cyrillic
+// letters drawn like Latin ones, whose names are sent as punycode
+var homoglyphs = map[rune][]string{
+	'a': {"а"}, 'b': {"6"}, 'c': {"с"}, 'd': {"cl"}, 'e': {"е"}, 'g': {"q"},
+	'i': {"1", "l", "і"}, 'j': {"ј"}, 'l': {"1", "i"}, 'm': {"rn"},
+	'o': {"0", "о"}, 'p': {"р"}, 'q': {"g"}, 's': {"5", "ѕ"}, 'w': {"vv"},
+	'x': {"х"}, 'y': {"у"}, '0': {"o"}, '1': {"l", "i"},
+}
+
+// adjacentKeys are the neighbours of each key on a QWERTY keyboard
+var adjacentKeys = map[byte]string{
+	'q': "wa", 'w': "qeas", 'e': "wrsd", 'r': "etdf", 't': "ryfg", 'y': "tugh",
+	'u': "yihj", 'i': "uojk", 'o': "ipkl", 'p': "ol", 'a': "qwsz", 's': "weadzx",
+	'd': "erfsxc", 'f': "rtdgcv", 'g': "tyfhvb", 'h': "yugjbn", 'j': "uihknm",
+	'k': "iojlm", 'l': "opk", 'z': "asx", 'x': "zsdc", 'c': "xdfv", 'v': "cfgb",
+	'b': "vghn", 'n': "bhjm", 'm': "njk",
+	'1': "2q", '2': "13qw", '3': "24we", '4': "35er", '5': "46rt", '6': "57ty",
+	'7': "68yu", '8': "79ui", '9': "80io", '0': "9op",
+}
+
+// typoCandidates derives look-alikes of a registered domain by changing its
+// first label: homoglyph swaps, omitted and doubled characters, adjacent
+// keys and single bitflips. The registered domain itself and invalid names
+// are left out; at most limit are returned.
+func typoCandidates(registered string, limit int) []typoCandidate {
+	label, suffix, _ := strings.Cut(registered, ".")
+	var candidates []typoCandidate
+	seen := map[string]bool{registered: true}
+	add := func(variant, technique string) {
+		if len(candidates) >= limit {
+			return
+		}
+		name, err := idna.Lookup.ToASCII(variant + "." + suffix)
+		if err != nil || seen[name] || !validCandidate(name) {
+			return
+		}
+		seen[name] = true
+		candidates = append(candidates, typoCandidate{name: name, technique: technique})
+	}
+
+	for i, r := range label {
+		for _, glyph := range homoglyphs[r] {
+			add(label[:i]+glyph+label[i+len(string(r)):], "homoglyph")
+		}
+	}
+	for _, pair := range [][2]string{{"rn", "m"}, {"vv", "w"}, {"cl", "d"}} {
+		for i := 0; ; {
+			j := strings.Index(label[i:], pair[0])
+			if j < 0 {
+				break
+			}
+			add(label[:i+j]+pair[1]+label[i+j+len(pair[0]):], "homoglyph")
+			i += j + 1
+		}
+	}
+	for i := range len(label) {
+		add(label[:i]+label[i+1:], "omission")
+		add(label[:i+1]+label[i:], "duplication")
+	}
+	for i := range len(label) {
+		for _, key := range []byte(adjacentKeys[label[i]]) {
+			add(label[:i]+string(key)+label[i+1:], "adjacent-key")
+		}
+	}
+	for i := range len(label) {
+		for bit := range 8 {
+			c := label[i] ^ 1<<bit
+			if 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' {
+				add(label[:i]+string(c)+label[i+1:], "bitflip")
+			}
+		}
+	}
+	return candidates
+}
+
+// permutationPattern is a group of static permutations patterns= can pick
+type permutationPattern struct {
+	name        string
+	description string
+	generate    func(target string, now time.Time) []string
+}
+
+// permutationPatterns are the groups in the order they run. Only basic
+// runs unless patterns= names others.
+var permutationPatterns = []permutationPattern{
+	{
+		name:        "basic",
+		description: "Common prefixes, suffixes and numbered hosts (www1, mail2)",
+		generate:    func(target string, now time.Time) []string { return generatePermutations(target) },
+	},
+	{
+		name:        "years",
+		description: "Service words with last three, this and next year (app-2024, 2025-portal, api2026)",
+		generate:    yearPermutations,
+	},
+	{
+		name:        "dates",
+		description: "Quarters and months of this and last year (q1-2026, 2026q1, jan2026, 2026-01)",
+		generate:    datePermutations,
+	},
+	{
+		name:        "cloud",
+		description: "Bucket-style names from the registered domain (example-com-prod, example-prod-s3, examplecorp-backup)",
+		generate:    cloudPermutations,
+	},
+}
+
+// selectPermutationPatterns resolves a patterns= list, basic when empty
+func selectPermutationPatterns(list string) ([]permutationPattern, error) {
+	names := splitList(list)
+	if len(names) == 0 {
+		names = []string{"basic"}
+	}
+	var selected []permutationPattern
+	for _, name := range names {
+		i := slices.IndexFunc(permutationPatterns, func(p permutationPattern) bool { return p.name == name })
+		if i < 0 {
+			var valid []string
+			for _, pattern := range permutationPatterns {
+				valid = append(valid, pattern.name)
+			}
+			return nil, fmt.Errorf("unknown pattern group %q, valid groups: %s", name, strings.Join(valid, ", "))
+		}
+		if !slices.ContainsFunc(selected, func(p permutationPattern) bool { return p.name == name }) {
+			selected = append(selected, permutationPatterns[i])
+		}
+	}
+	return selected, nil
+}
+
+// describePermutationPatterns reports each group with the candidates it
+// tries for example.com, for estimating a scan's size
+func describePermutationPatterns() []map[string]interface{} {
+	var groups []map[string]interface{}
+	for _, pattern := range permutationPatterns {
+		count := 0
+		for _, candidate := range pattern.generate("example.com", time.Now()) {
+			if validCandidate(candidate) {
+				count++
+			}
+		}
+		groups = append(groups, map[string]interface{}{
+			"name":                   pattern.name,
+			"description":            pattern.description,
+			"candidates_example_com": count,
+		})
+	}
+	return groups
+}
+
+// validCandidate reports whether a generated name is fit to query
+func validCandidate(name string) bool {
+	if len(name) > 253 || !domainRe.MatchString(name) {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+	}
+	return true
+}
+
+// datedServices are the words year and cloud patterns build on
+var datedServices = []string{"app", "api", "www", "portal", "dev", "test", "staging", "beta", "admin", "shop", "mail", "vpn"}
+
+// yearPermutations joins each of datedServices to the last three years,
+// this one and the next (word-YYYY, YYYY-word, wordYYYY), and tries the
+// years on their own: 12 words x 5 years x 3 + 5 = 185 names
+func yearPermutations(target string, now time.Time) []string {
+	var names []string
+	for year := now.Year() - 3; year <= now.Year()+1; year++ {
+		y := strconv.Itoa(year)
+		names = append(names, y+"."+target)
+		for _, word := range datedServices {
+			names = append(names, word+"-"+y+"."+target, y+"-"+word+"."+target, word+y+"."+target)
+		}
+	}
+	return names
+}
+
+// datePermutations tries the quarters (qN-YYYY, YYYYqN, YYYY-qN) and
+// months (monYYYY, mon-YYYY, YYYY-MM) of this and last year: 2 x (4 x 3 +
+// 12 x 3) = 96 names
+func datePermutations(target string, now time.Time) []string {
+	var names []string
+	for year := now.Year() - 1; year <= now.Year(); year++ {
+		y := strconv.Itoa(year)
+		for q := 1; q <= 4; q++ {
+			quarter := "q" + strconv.Itoa(q)
+			names = append(names, quarter+"-"+y+"."+target, y+quarter+"."+target, y+"-"+quarter+"."+target)
+		}
+		for m := time.January; m <= time.December; m++ {
+			month := strings.ToLower(m.String()[:3])
+			names = append(names, month+y+"."+target, month+"-"+y+"."+target, fmt.Sprintf("%s-%02d.%s", y, int(m), target))
+		}
+	}
+	return names
+}
+
+// cloudStorageWords are what buckets and storage hosts tend to be named after
+var cloudStorageWords = []string{
+	"prod", "dev", "staging", "test", "backup", "backups", "assets", "static",
+	"media", "logs", "data", "files", "uploads", "public", "private", "cdn",
+	"s3", "storage",
+}
+
+// cloudPermutations builds bucket-style names from the registered domain
+// with its dots dashed or dropped (example-com, examplecom), its first
+// label (example) and that plus corp (examplecorp): each joined to
+// cloudStorageWords with and without a dash, plus the first label with an
+// environment and a storage word (example-prod-s3): 4 x 18 x 2 + 3 x 4 =
+// 156 names for a two-label domain
+func cloudPermutations(target string, now time.Time) []string {
+	registered, err := publicsuffix.EffectiveTLDPlusOne(target)
+	if err != nil {
+		registered = target
+	}
+	label, _, _ := strings.Cut(registered, ".")
+	bases := []string{strings.ReplaceAll(registered, ".", "-"), strings.ReplaceAll(registered, ".", ""), label, label + "corp"}
+
+	var names []string
+	for _, base := range bases {
+		for _, word := range cloudStorageWords {
+			names = append(names, base+"-"+word+"."+target, base+word+"."+target)
+		}
+	}
+	for _, env := range []string{"prod", "dev", "staging"} {
+		for _, storage := range []string{"s3", "storage", "backup", "assets"} {
+			names = append(names, label+"-"+env+"-"+storage+"."+target)
+		}
+	}
+	return names
+}
+
+// Zone transfer (AXFR) against each of the target's nameservers
+type zoneSource struct{}
+
+func (zoneSource) Name() string { return "zone" }
+
+// AXFRAttempt is the outcome of a zone transfer request to one nameserver,
+// sent to clients as an "axfr" event
+type AXFRAttempt struct {
+	Nameserver string `json:"nameserver"`
+	Address    string `json:"address"`
+	Status     string `json:"status"` // transferred, refused, timeout, unreachable or error
+	Rcode      string `json:"rcode,omitempty"`
+	Records    int    `json:"records"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (zoneSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+	run := sourceRunFrom(ctx)
+
+	nameservers, err := resolverFrom(ctx).LookupNS(ctx, target)
+	if err != nil {
+		return fmt.Errorf("NS lookup failed: %w", err)
+	}
+	if len(nameservers) == 0 {
+		return fmt.Errorf("no NS records for %s", target)
+	}
+
+	run.Notice("info", "Found %d nameservers for %s", len(nameservers), target)
+
+	for _, ns := range nameservers {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		attempt := AXFRAttempt{Nameserver: ns, Address: net.JoinHostPort(ns, cfg.DNS.AXFRPort)}
+		if ips, err := resolverFrom(ctx).LookupHost(ctx, ns); err == nil {
+			attempt.Address = net.JoinHostPort(ips[0].String(), cfg.DNS.AXFRPort)
+		}
+
+		log.Printf("Attempting zone transfer from %s (%s) for %s", ns, attempt.Address, target)
+		err := transferZone(ctx, attempt.Address, target, func(rr dns.RR) bool {
+			attempt.Records++
+			switch rr.Header().Rrtype {
+			case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME:
+			default:
+				return true
+			}
+			host := strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(rr.Header().Name), "."), "*.")
+			if !strings.HasSuffix(host, "."+target) {
+				return true
+			}
+			return emit(ctx, out, Result{Host: host, Title: fmt.Sprintf("AXFR from %s", ns)})
+		})
+
+		var rcodeErr *axfrRcodeError
+		var netErr net.Error
+		switch {
+		case err == nil:
+			attempt.Status = "transferred"
+		case errors.As(err, &rcodeErr):
+			attempt.Rcode = dns.RcodeToString[rcodeErr.rcode]
+			attempt.Status = "error"
+			if rcodeErr.rcode == dns.RcodeRefused {
+				attempt.Status = "refused"
+			}
+		case errors.As(err, &netErr) && netErr.Timeout():
+			attempt.Status = "timeout"
+		case attempt.Records == 0 && isDialError(err):
+			attempt.Status = "unreachable"
+		default:
+			attempt.Status = "error"
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+			log.Printf("Zone transfer from %s for %s: %s: %v", ns, target, attempt.Status, err)
+		}
+		run.Event("axfr", attempt)
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// axfrRcodeError is a transfer the server answered with a non-success rcode
+type axfrRcodeError struct {
+	rcode int
+}
+
+func (e *axfrRcodeError) Error() string {
+	return fmt.Sprintf("transfer answered %s", dns.RcodeToString[e.rcode])
+}
+
+// transferZone requests an AXFR of zone from addr and calls record for each
+// RR received until it returns false. The connection is closed if ctx ends.
+func transferZone(ctx context.Context, addr, zone string, record func(dns.RR) bool) error {
+	if isPassive(ctx) {
+		return errPassiveOnly
+	}
+	if err := maintenance.awaitResume(ctx); err != nil {
+		return err
+	}
+	cfg := configFrom(ctx)
+
+	dialer := &net.Dialer{Timeout: cfg.DNS.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	msg := &dns.Msg{}
+	msg.SetAxfr(dns.Fqdn(zone))
+
+	transfer := &dns.Transfer{Conn: &dns.Conn{Conn: conn}, ReadTimeout: cfg.DNS.Timeout}
+	envelopes, err := transfer.In(msg, addr)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	atomic.AddInt64(&stats.DNSQueries, 1)
+
+	var transferErr error
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			transferErr = envelope.Error
+			var rcode int
+			if _, scanErr := fmt.Sscanf(envelope.Error.Error(), "dns: bad xfr rcode: %d", &rcode); scanErr == nil {
+				transferErr = &axfrRcodeError{rcode: rcode}
+			}
+			continue
+		}
+		for _, rr := range envelope.RR {
+			if transferErr == nil && !record(rr) {
+				transferErr = ctx.Err()
+				conn.Close()
+			}
+		}
+	}
+	if transferErr == nil {
+		transferErr = ctx.Err()
+	}
+	return transferErr
+}
+
+// isDialError reports whether err happened while connecting
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// LeakIX subdomain entry as returned by the /api/subdomains endpoint
+type leakixEntry struct {
+	Subdomain   string `json:"subdomain"`
+	DistinctIPs int    `json:"distinct_ips"`
+	LastSeen    string `json:"last_seen"`
+}
+
+// LeakIX source for hostnames of exposed services
+type leakixSource struct{}
+
+func (leakixSource) Name() string { return "leakix" }
+
+func (leakixSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+
+	// LeakIX only knows the lowercase ASCII form of a domain
+	target = targetKey(target)
+	apiURL := fmt.Sprintf("%s/api/subdomains/%s", strings.TrimSuffix(cfg.Upstreams.LeakIX, "/"), url.PathEscape(target))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+	if cfg.APIKeys.LeakIX != "" {
+		req.Header.Set("api-key", cfg.APIKeys.LeakIX)
+	}
+
+	client := &http.Client{Timeout: cfg.HTTP.Timeout, Transport: discoveryTransport(nil)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errSourceUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: API returned status %d", errSourceUnavailable, resp.StatusCode)
+	}
+
+	var entries []leakixEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return fmt.Errorf("JSON decode error: %w", err)
+	}
+
+	for _, entry := range entries {
+		host := strings.ToLower(strings.TrimSpace(entry.Subdomain))
+		host = strings.TrimSuffix(host, ".")
+		if !strings.HasSuffix(host, "."+target) {
+			continue
+		}
+
+		result := Result{
+			Host:  host,
+			Title: fmt.Sprintf("%d distinct IPs", entry.DistinctIPs),
+		}
+		if lastSeen, err := time.Parse(time.RFC3339, entry.LastSeen); err == nil {
+			result.EvidenceTime = lastSeen
+		}
+		if !emit(ctx, out, result) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// SPF and TXT record harvesting. SPF mechanisms and other TXT records
+// frequently name internal mail relays and service hosts.
+type spfSource struct{}
+
+func (spfSource) Name() string { return "spf" }
+
+func (spfSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	run := sourceRunFrom(ctx)
+
+	// Optionally also harvest TXT records of subdomains found by earlier jobs
+	names := []string{target}
+	if run.Option("subdomains") == "true" {
+		names = append(names, knownHostsForTarget(target)...)
+	}
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		records, err := resolverFrom(ctx).LookupTXT(ctx, name)
+		if err != nil {
+			continue
+		}
+
+		for _, record := range records {
+			for _, finding := range parseTXTHosts(record, target) {
+				if !emit(ctx, out, Result{Host: finding.host, Title: finding.mechanism}) {
+					return ctx.Err()
+				}
+			}
+
+			for _, network := range parseSPFNetworks(record) {
+				run.Notice("info", "%s lists network %s", name, network)
+			}
+		}
+	}
+	return nil
+}
+
+// MX/NS/SOA/CNAME derived host discovery. Hosts that only exist as record
+// targets (mail relays, internal nameservers) rarely show up in CT logs.
+type recordsSource struct{}
+
+func (recordsSource) Name() string { return "records" }
+
+func (recordsSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	run := sourceRunFrom(ctx)
+
+	// Seed hosts come from the given job, or every job for the target, and
+	// have their records checked along with everything this job already
+	// knows about, which alone counts as discovered
+	var seeds []string
+	if jobID := run.Option("job"); jobID != "" {
+		if source, ok := lookupJob(jobID); ok {
+			for host := range source.Hosts() {
+				seeds = append(seeds, host)
+			}
+		}
+	} else {
+		seeds = knownHostsForTarget(target)
+	}
+	known := make(map[string]struct{})
+	if job := run.Job(); job != nil {
+		known = job.Hosts()
+	}
+	queue := []string{target}
+	for host := range known {
+		queue = append(queue, host)
+	}
+	queue = append(queue, seeds...)
+
+	queried := make(map[string]struct{})
+	for len(queue) > 0 {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		name := queue[0]
+		queue = queue[1:]
+		if _, done := queried[name]; done {
+			continue
+		}
+		queried[name] = struct{}{}
+
+		for _, derived := range deriveRecordHosts(ctx, name) {
+			host := derived.host
+			if host == target || !strings.HasSuffix(host, "."+target) {
+				continue
+			}
+			if _, dup := known[host]; dup {
+				continue
+			}
+			known[host] = struct{}{}
+
+			result := Result{
+				Host:  host,
+				Title: fmt.Sprintf("%s of %s", derived.rrtype, name),
+			}
+			if !emit(ctx, out, result) {
+				return ctx.Err()
+			}
+
+			// Newly found hosts get their own records checked too
+			queue = append(queue, host)
+		}
+	}
+	return nil
+}
+
+// Well-known SRV services. Directory, VoIP, chat and mail services often
+// live on hosts that nothing else points at.
+var srvServices = []string{
+	"_ldap._tcp", "_ldap._tcp.dc._msdcs", "_ldaps._tcp", "_gc._tcp", "_gc._msdcs",
+	"_kerberos._tcp", "_kerberos._udp", "_kerberos-master._tcp", "_kerberos-adm._tcp", "_kpasswd._tcp",
+	"_kpasswd._udp", "_sip._tcp", "_sip._udp", "_sip._tls", "_sips._tcp",
+	"_sipfederationtls._tcp", "_sipinternaltls._tcp", "_h323cs._tcp", "_stun._udp", "_turn._udp",
+	"_xmpp-client._tcp", "_xmpp-server._tcp", "_jabber._tcp", "_autodiscover._tcp", "_submission._tcp",
+	"_submissions._tcp", "_imap._tcp", "_imaps._tcp", "_pop3._tcp", "_pop3s._tcp",
+	"_caldav._tcp", "_caldavs._tcp", "_carddav._tcp", "_carddavs._tcp", "_matrix._tcp",
+	"_minecraft._tcp", "_ntp._udp", "_vlmcs._tcp", "_http._tcp", "_https._tcp",
+	"_ftp._tcp", "_ssh._tcp", "_mongodb._tcp", "_rdp._tcp",
+}
+
+// SRVFinding is one SRV answer for the target, sent to clients as an "srv"
+// event. External targets are reported here but not added to the job.
+type SRVFinding struct {
+	Service string `json:"service"`
+	SRVTarget
+	External bool `json:"external"`
+}
+
+// SRV brute force: query each well-known _service._proto name under the
+// target and report the hosts and ports behind them
+type srvSource struct{}
+
+func (srvSource) Name() string { return "srv" }
+
+func (srvSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+	run := sourceRunFrom(ctx)
+	resolver := resolverFrom(ctx)
+
+	answers := make([][]SRVTarget, len(srvServices))
+	semaphore := make(chan struct{}, cfg.DNS.Concurrency)
+	var wg sync.WaitGroup
+	for i, service := range srvServices {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			targets, err := resolver.LookupSRV(ctx, name)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("SRV lookup for %s failed: %v", name, err)
+			}
+			answers[i] = targets
+		}(i, service+"."+target)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Group by host in service order, so a domain controller answering
+	// for LDAP and Kerberos becomes one result naming both
+	var hosts []string
+	services := make(map[string][]string)
+	external := 0
+	for i, targets := range answers {
+		for _, srv := range targets {
+			finding := SRVFinding{Service: srvServices[i], SRVTarget: srv, External: !underDomain(srv.Target, target)}
+			run.Event("srv", finding)
+			if finding.External {
+				external++
+				continue
+			}
+			if _, seen := services[srv.Target]; !seen {
+				hosts = append(hosts, srv.Target)
+			}
+			services[srv.Target] = append(services[srv.Target], fmt.Sprintf("%s:%d", srvServices[i], srv.Port))
+		}
+	}
+	if external > 0 {
+		run.Notice("info", "%d SRV records point outside %s; see the srv events", external, target)
+	}
+
+	for _, host := range hosts {
+		result := Result{Host: host, Title: "SRV " + strings.Join(services[host], ", ")}
+		if ips, err := resolver.LookupHostAll(ctx, host); err == nil {
+			result.IPs = resultIPs(ips)
+		}
+		if !emit(ctx, out, result) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Reverse IP sweep: expand the IPs behind a job's hosts to their enclosing
+// prefix and look for PTR names under the target on neighbouring addresses
+type ptrSource struct{}
+
+func (ptrSource) Name() string { return "ptr" }
+
+func (ptrSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+	run := sourceRunFrom(ctx)
+
+	// Seed hosts come from the given job, or every job for the target
+	var seeds []string
+	if jobID := run.Option("job"); jobID != "" {
+		if source, ok := lookupJob(jobID); ok {
+			for host := range source.Hosts() {
+				seeds = append(seeds, host)
+			}
+		}
+	} else {
+		seeds = knownHostsForTarget(target)
+	}
+	seeds = append(seeds, target)
+
+	semaphore := make(chan struct{}, cfg.DNS.Concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	// Resolve seeds and collect the prefixes they live in
+	prefixes := make(map[string]*net.IPNet)
+	for _, host := range seeds {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			ips, err := resolverFrom(ctx).LookupHost(ctx, host)
+			if err != nil {
+				return
+			}
+			for _, ip := range ips {
+				network := &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(cfg.DNS.PTRPrefixLength, 32)}
+				if network.IP == nil {
+					continue
+				}
+				network.IP = network.IP.Mask(network.Mask)
+				mu.Lock()
+				prefixes[network.String()] = network
+				mu.Unlock()
+			}
+		}(host)
+	}
+	wg.Wait()
+
+	addresses := expandPrefixes(prefixes, cfg.DNS.PTRMaxIPs)
+	run.Notice("info", "Sweeping %d addresses across %d prefixes", len(addresses), len(prefixes))
+
+	var swept int64
+	for _, ip := range addresses {
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(ip net.IP) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			names, err := resolverFrom(ctx).LookupPTR(ctx, ip)
+			if done := atomic.AddInt64(&swept, 1); done%256 == 0 {
+				run.Progress("%d/%d addresses swept", done, len(addresses))
+			}
+			if err != nil {
+				return
+			}
+
+			for _, name := range names {
+				if !strings.HasSuffix(name, "."+target) {
+					continue
+				}
+				if !emit(ctx, out, Result{Host: name, Title: fmt.Sprintf("PTR of %s", ip)}) {
+					return
+				}
+			}
+		}(ip)
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// ASN-based enumeration: map the target's known IPs to their origin ASNs,
+// collect the prefixes those ASNs announce, and run a bounded PTR sweep
+// over them
+type asnSource struct{}
+
+func (asnSource) Name() string { return "asn" }
+
+func (asnSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+	run := sourceRunFrom(ctx)
+
+	seeds := append(knownHostsForTarget(target), target)
+
+	// Map every resolvable seed IP to its origin ASN
+	asnPrefixes := make(map[string]map[string]struct{})
+	for _, host := range seeds {
+		ips, err := resolverFrom(ctx).LookupHost(ctx, host)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			infos, err := resolverFrom(ctx).LookupASN(ctx, ip)
+			if err != nil {
+				continue
+			}
+			hostIndex.RecordASN(ip.String(), infos)
+			for _, info := range infos {
+				if asnPrefixes[info.ASN] == nil {
+					asnPrefixes[info.ASN] = make(map[string]struct{})
+				}
+				asnPrefixes[info.ASN][info.Prefix] = struct{}{}
+			}
+		}
+	}
+
+	// Add any further prefixes the ASNs are known to announce
+	for asn := range asnPrefixes {
+		name, _ := resolverFrom(ctx).LookupASNName(ctx, asn)
+		run.Notice("info", "AS%s %s", asn, name)
+
+		if announced, err := fetchAnnouncedPrefixes(ctx, asn); err == nil {
+			for _, prefix := range announced {
+				asnPrefixes[asn][prefix] = struct{}{}
+			}
+		}
+	}
+
+	var prefixes []string
+	for _, set := range asnPrefixes {
+		for prefix := range set {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+
+	budget := cfg.DNS.ASNMaxQueries
+	semaphore := make(chan struct{}, cfg.DNS.Concurrency)
+
+	for i, prefix := range prefixes {
+		if budget <= 0 {
+			run.Notice("warning", "PTR query cap of %d reached, remaining prefixes skipped", cfg.DNS.ASNMaxQueries)
+			break
+		}
+
+		_, network, err := net.ParseCIDR(prefix)
+		if err != nil || network.IP.To4() == nil {
+			continue
+		}
+		addresses := expandPrefixes(map[string]*net.IPNet{prefix: network}, budget)
+		budget -= len(addresses)
+
+		run.Progress("prefix %d/%d %s (%d addresses)", i+1, len(prefixes), prefix, len(addresses))
+
+		var wg sync.WaitGroup
+		for _, ip := range addresses {
+			if ctx.Err() != nil {
+				break
+			}
+
+			wg.Add(1)
+			go func(ip net.IP) {
+				defer wg.Done()
+
+				semaphore <- struct{}{}
+				defer func() { <-semaphore }()
+
+				names, err := resolverFrom(ctx).LookupPTR(ctx, ip)
+				if err != nil {
+					return
+				}
+
+				for _, name := range names {
+					if !strings.HasSuffix(name, "."+target) {
+						continue
+					}
+					result := Result{
+						Host:  name,
+						Title: fmt.Sprintf("PTR of %s in %s", ip, prefix),
+					}
+					if !emit(ctx, out, result) {
+						return
+					}
+				}
+			}(ip)
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	log.Printf("ASN enumeration for %s covered %d prefixes", target, len(prefixes))
+	return nil
+}
+
+// fetchAnnouncedPrefixes asks RIPEstat for the prefixes asn currently
+// announces. Team Cymru's DNS interface only maps addresses to origins.
+func fetchAnnouncedPrefixes(ctx context.Context, asn string) ([]string, error) {
+	cfg := configFrom(ctx)
+	apiURL := fmt.Sprintf("%s/data/announced-prefixes/data.json?resource=AS%s", strings.TrimSuffix(cfg.Upstreams.RIPEstat, "/"), url.QueryEscape(asn))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+
+	client := &http.Client{Timeout: cfg.HTTP.Timeout, Transport: discoveryTransport(nil)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("RIPEstat returned status %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		Data struct {
+			Prefixes []struct {
+				Prefix string `json:"prefix"`
+			} `json:"prefixes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, cfg.HTTP.MaxBodySize)).Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	prefixes := make([]string, 0, len(payload.Data.Prefixes))
+	for _, entry := range payload.Data.Prefixes {
+		prefixes = append(prefixes, entry.Prefix)
+	}
+	return prefixes, nil
+}
+
+// expandPrefixes lists every address in the given IPv4 networks, stopping
+// once limit addresses have been produced
+func expandPrefixes(prefixes map[string]*net.IPNet, limit int) []net.IP {
+	keys := make([]string, 0, len(prefixes))
+	for key := range prefixes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var addresses []net.IP
+	for _, key := range keys {
+		network := prefixes[key]
+		for ip := cloneIP(network.IP); network.Contains(ip); incrementIP(ip) {
+			if len(addresses) >= limit {
+				return addresses
+			}
+			addresses = append(addresses, cloneIP(ip))
+		}
+	}
+	return addresses
+}
+
+func cloneIP(ip net.IP) net.IP {
+	clone := make(net.IP, len(ip))
+	copy(clone, ip)
+	return clone
+}
+
+func incrementIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
+}
+
+var scriptSrcRe = regexp.MustCompile(`(?is)<script\b[^>]*?\bsrc\s*=\s*["']?([^"'\s>]+)`)
+
+// jsScrapeSource fetches the pages of live hosts, follows their <script src>
+// references on the same registered domain and scans the JavaScript for
+// subdomains of the target
+type jsScrapeSource struct{}
+
+func (jsScrapeSource) Name() string { return "jsscrape" }
+
+func (jsScrapeSource) CheckOptions(ctx context.Context, options url.Values) error {
+	list := options.Get("hosts")
+	if list == "" {
+		return nil
+	}
+	// A bad target is the handler's to report; Enumerate checks again
+	target, err := parseTarget(options.Get("target"))
+	if err != nil {
+		return nil
+	}
+	_, err = jsScrapeHosts(configFrom(ctx), target, list)
+	return err
+}
+
+// jsScrapeHosts parses hosts=: bare hostnames, each the target or one of
+// its subdomains and allowed by ALLOWED_DOMAINS
+func jsScrapeHosts(cfg *Config, target, list string) ([]string, error) {
+	var hosts []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if net.ParseIP(strings.Trim(entry, "[]")) != nil {
+			return nil, fmt.Errorf("hosts: %s is an IP address, not a hostname", entry)
+		}
+		host, err := parseTarget(entry)
+		if err != nil {
+			return nil, fmt.Errorf("hosts: %s is not a bare hostname", entry)
+		}
+		if !underDomain(host, target) {
+			return nil, fmt.Errorf("hosts: %s is not %s or one of its subdomains", host, target)
+		}
+		if !probeAllowed(cfg, host) {
+			return nil, fmt.Errorf("hosts: domain %s not in allowed list", host)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func (jsScrapeSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+	run := sourceRunFrom(ctx)
+
+	// Pages come from an explicit host list, the given job, or every job
+	// for the target
+	var pages []string
+	if list := run.Option("hosts"); list != "" {
+		hosts, err := jsScrapeHosts(cfg, target, list)
+		if err != nil {
+			return err
+		}
+		pages = hosts
+	} else if jobID := run.Option("job"); jobID != "" {
+		if source, ok := lookupJob(jobID); ok {
+			for host := range source.Hosts() {
+				pages = append(pages, host)
+			}
+		}
+		pages = append(pages, target)
+	} else {
+		pages = append(knownHostsForTarget(target), target)
+	}
+	pages = slices.DeleteFunc(pages, func(host string) bool { return !probeAllowed(cfg, host) })
+	sort.Strings(pages)
+
+	client := &http.Client{
+		Timeout:   cfg.HTTP.Timeout,
+		Transport: probeRoundTripper(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= cfg.HTTP.MaxRedirects {
+				return fmt.Errorf("too many redirects (%d)", len(via))
+			}
+			if !probeAllowed(cfg, req.URL.Hostname()) {
+				return fmt.Errorf("redirect to %s, not in allowed list", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+	fetch := func(rawURL string, limit int64) ([]byte, *url.URL, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, nil, fmt.Errorf("status %d", resp.StatusCode)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+		return body, resp.Request.URL, err
+	}
+
+	hostRe := regexp.MustCompile(`(?i)\b((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+` + regexp.QuoteMeta(target) + `)\b`)
+	registered, err := publicsuffix.EffectiveTLDPlusOne(target)
+	if err != nil {
+		registered = target
+	}
+
+	seen := make(map[string]struct{})
+	for _, host := range pages {
+		seen[host] = struct{}{}
+	}
+	scripts := make(map[string]struct{})
+	var fetchedBytes int64
+	var fetchedFiles int
+	budgetHit := false
+
+	for i, host := range pages {
+		if ctx.Err() != nil || budgetHit {
+			break
+		}
+
+		var page []byte
+		var pageURL *url.URL
+		for _, scheme := range []string{"https", "http"} {
+			if page, pageURL, err = fetch(scheme+"://"+host+"/", cfg.HTTP.MaxBodySize); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			continue
+		}
+
+		for _, match := range scriptSrcRe.FindAllSubmatch(page, -1) {
+			src, err := pageURL.Parse(html.UnescapeString(string(match[1])))
+			if err != nil || (src.Scheme != "http" && src.Scheme != "https") {
+				continue
+			}
+			srcHost := strings.ToLower(src.Hostname())
+			if (srcHost != registered && !strings.HasSuffix(srcHost, "."+registered)) || !probeAllowed(cfg, srcHost) {
+				continue
+			}
+			src.Fragment = ""
+			if _, dup := scripts[src.String()]; dup {
+				continue
+			}
+			scripts[src.String()] = struct{}{}
+
+			if fetchedFiles >= cfg.HTTP.JSMaxFiles || fetchedBytes >= cfg.HTTP.JSMaxBytes {
+				budgetHit = true
+				break
+			}
+			limit := cfg.HTTP.MaxBodySize
+			if remaining := cfg.HTTP.JSMaxBytes - fetchedBytes; remaining < limit {
+				limit = remaining
+			}
+			script, _, err := fetch(src.String(), limit)
+			fetchedFiles++
+			fetchedBytes += int64(len(script))
+			if err != nil {
+				continue
+			}
+
+			for _, name := range hostRe.FindAllString(string(script), -1) {
+				name = strings.ToLower(name)
+				if _, dup := seen[name]; dup {
+					continue
+				}
+				seen[name] = struct{}{}
+
+				if !emit(ctx, out, Result{Host: name, Source: "js", URL: src.String()}) {
+					return ctx.Err()
+				}
+			}
+		}
+
+		run.Progress("%d/%d pages, %d scripts, %d bytes", i+1, len(pages), fetchedFiles, fetchedBytes)
+	}
+
+	if budgetHit {
+		run.Notice("warning", "JavaScript budget of %d files / %d bytes reached, remaining scripts skipped", cfg.HTTP.JSMaxFiles, cfg.HTTP.JSMaxBytes)
+	}
+
+	log.Printf("JS scrape for %s fetched %d scripts (%d bytes)", target, fetchedFiles, fetchedBytes)
+	return ctx.Err()
+}
+
+type derivedHost struct {
+	host   string
+	rrtype string
+}
+
+// deriveRecordHosts collects the hostnames referenced by name's MX, NS,
+// SOA and CNAME records
+func deriveRecordHosts(ctx context.Context, name string) []derivedHost {
+	var derived []derivedHost
+
+	if hosts, err := resolverFrom(ctx).LookupMX(ctx, name); err == nil {
+		for _, host := range hosts {
+			derived = append(derived, derivedHost{host: host, rrtype: "MX"})
+		}
+	}
+	if hosts, err := resolverFrom(ctx).LookupNS(ctx, name); err == nil {
+		for _, host := range hosts {
+			derived = append(derived, derivedHost{host: host, rrtype: "NS"})
+		}
+	}
+	if host, err := resolverFrom(ctx).LookupSOA(ctx, name); err == nil && host != "" {
+		derived = append(derived, derivedHost{host: host, rrtype: "SOA"})
+	}
+	if host, err := resolverFrom(ctx).LookupCNAME(ctx, name); err == nil && host != "" {
+		derived = append(derived, derivedHost{host: host, rrtype: "CNAME"})
+	}
+
+	return derived
+}
+
+type txtFinding struct {
+	host      string
+	mechanism string
+}
+
+// parseTXTHosts extracts hostnames under target from SPF mechanisms
+// (include:, a:, mx:, exists:, redirect=) and from free-form TXT content
+func parseTXTHosts(record, target string) []txtFinding {
+	var findings []txtFinding
+
+	if strings.HasPrefix(strings.ToLower(record), "v=spf1") {
+		for _, term := range strings.Fields(record) {
+			mechanism := strings.TrimLeft(term, "+-~?")
+			lower := strings.ToLower(mechanism)
+
+			var value string
+			for _, prefix := range []string{"include:", "a:", "mx:", "exists:", "redirect=", "ptr:"} {
+				if strings.HasPrefix(lower, prefix) {
+					value = mechanism[len(prefix):]
+					break
+				}
+			}
+			if value == "" {
+				continue
+			}
+
+			// Strip CIDR lengths like a:host.example.com/24
+			if slash := strings.Index(value, "/"); slash >= 0 {
+				value = value[:slash]
+			}
+			host := strings.TrimSuffix(strings.ToLower(value), ".")
+			if strings.Contains(host, "%{") {
+				continue
+			}
+			if host == target || strings.HasSuffix(host, "."+target) {
+				findings = append(findings, txtFinding{host: host, mechanism: term})
+			}
+		}
+		return findings
+	}
+
+	hostPattern := regexp.MustCompile(`(?i)([a-z0-9_-]+\.)+` + regexp.QuoteMeta(target))
+	for _, match := range hostPattern.FindAllString(record, -1) {
+		host := strings.ToLower(match)
+		findings = append(findings, txtFinding{host: host, mechanism: "txt"})
+	}
+	return findings
+}
+
+// parseSPFNetworks returns the ip4:/ip6: networks listed in an SPF record
+func parseSPFNetworks(record string) []string {
+	if !strings.HasPrefix(strings.ToLower(record), "v=spf1") {
+		return nil
+	}
+
+	var networks []string
+	for _, term := range strings.Fields(record) {
+		mechanism := strings.ToLower(strings.TrimLeft(term, "+-~?"))
+		if strings.HasPrefix(mechanism, "ip4:") || strings.HasPrefix(mechanism, "ip6:") {
+			networks = append(networks, mechanism[4:])
+		}
+	}
+	return networks
+}
+
+// knownHostsForTarget returns every host previously recorded for target
+// across all jobs
+func knownHostsForTarget(target string) []string {
+	jobManager.mu.RLock()
+	jobs := make([]*Job, 0)
+	for _, job := range jobManager.jobs {
+		if job.Target == target {
+			jobs = append(jobs, job)
+		}
+	}
+	jobManager.mu.RUnlock()
+
+	seen := make(map[string]struct{})
+	hosts := make([]string, 0)
+	for _, job := range jobs {
+		job.mu.RLock()
+		for _, results := range job.Results {
+			for _, result := range results {
+				if _, dup := seen[result.Host]; !dup && result.Host != target {
+					seen[result.Host] = struct{}{}
+					hosts = append(hosts, result.Host)
+				}
+			}
+		}
+		job.mu.RUnlock()
+	}
+	return hosts
+}
+
+func generatePermutations(domain string) []string {
+	var permutations []string
+
+	prefixes := []string{"dev", "test", "stage", "staging", "prod", "production", "www", "api", "admin", "app", "mobile", "m"}
+	suffixes := []string{"dev", "test", "stage", "staging", "prod", "production", "api", "admin", "backup", "old", "new"}
+
+	// Add base subdomains
+	for _, prefix := range prefixes {
+		permutations = append(permutations, fmt.Sprintf("%s.%s", prefix, domain))
+	}
+
+	// Add permutations with suffixes
+	parts := strings.Split(domain, ".")
+	if len(parts) >= 2 {
+		baseDomain := parts[0]
+		tld := strings.Join(parts[1:], ".")
+
+		for _, suffix := range suffixes {
+			permutations = append(permutations, fmt.Sprintf("%s-%s.%s", baseDomain, suffix, tld))
+			permutations = append(permutations, fmt.Sprintf("%s%s.%s", baseDomain, suffix, tld))
+		}
+	}
+
+	// Add numbered variations
+	for i := 1; i <= 10; i++ {
+		permutations = append(permutations, fmt.Sprintf("www%d.%s", i, domain))
+		permutations = append(permutations, fmt.Sprintf("mail%d.%s", i, domain))
+		permutations = append(permutations, fmt.Sprintf("ftp%d.%s", i, domain))
+	}
+
+	return permutations
+}
+
+// smartPermutations resolves the permutationEngine's candidates seeded with
+// the names this job and the target's latest job found so far
+func smartPermutations(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+	run := sourceRunFrom(ctx)
+
+	seeds := make(map[string]struct{})
+	if job := run.Job(); job != nil {
+		maps.Copy(seeds, job.Hosts())
+		if latest := latestJob(target, job); latest != nil {
+			maps.Copy(seeds, latest.Hosts())
+		}
+	}
+	delete(seeds, target)
+	if len(seeds) == 0 {
+		run.Notice("warning", "No names found for %s yet to permute; scan it first", target)
+		return nil
+	}
+
+	words := alterationWords
+	if name := run.Option("wordlist"); name != "" {
+		wl, ok := lookupWordlist(name)
+		if !ok {
+			return fmt.Errorf("unknown wordlist %q", name)
+		}
+		words = nil
+		if err := wl.Each(func(label string) bool {
+			words = append(words, label)
+			return len(words) < maxAlterationWords
+		}); err != nil {
+			return err
+		}
+	}
+
+	engine := newPermutationEngine(target, words, cfg.DNS.PermuteMaxCandidates)
+	for _, seed := range slices.Sorted(maps.Keys(seeds)) {
+		engine.Seen(seed)
+	}
+	var candidates []string
+	for _, seed := range slices.Sorted(maps.Keys(seeds)) {
+		candidates = append(candidates, engine.Generate(seed)...)
+	}
+	run.Notice("info", "Permuting %d names into %d candidates", len(seeds), len(candidates))
+	if engine.Exhausted() {
+		run.Notice("warning", "Stopped at the limit of %d candidates", cfg.DNS.PermuteMaxCandidates)
+	}
+	run.Expect(len(candidates))
+	return resolveCandidates(ctx, "permute", target, slices.Values(candidates), out)
+}
+
+// alterationWords are what mode=smart joins to and inserts into the labels
+// of found names, unless wordlist= names other ones
+var alterationWords = []string{
+	"dev", "development", "stg", "stage", "staging", "prod", "production",
+	"test", "testing", "qa", "uat", "preprod", "pre", "demo", "sandbox", "beta",
+	"canary", "int", "internal", "ext", "external", "private", "corp", "admin",
+	"api", "app", "old", "new", "legacy", "backup", "bak", "tmp", "v1", "v2",
+	"01", "02", "1", "2", "2024", "2025", "eu", "us", "east", "west", "origin",
+	"cdn", "static", "mgmt", "ops", "vpn",
+}
+
+// maxAlterationWords caps how much of a wordlist= list mode=smart uses, as
+// every word multiplies the candidates of every name
+const maxAlterationWords = 1000
+
+// permutationEngine generates altdns-style candidates from found names:
+// alteration words inserted as new labels, joined to existing ones and
+// swapped for dash-separated tokens, numbers counted up and down, and
+// dashes and dots swapped. Candidates
+// are unique across every Generate call and capped at limit in total.
+type permutationEngine struct {
+	target string
+	words  []string
+	limit  int
+	count  int
+	seen   map[string]bool
+}
+
+func newPermutationEngine(target string, words []string, limit int) *permutationEngine {
+	return &permutationEngine{target: target, words: words, limit: limit, seen: make(map[string]bool)}
+}
+
+// Seen marks host as known, so no call generates it
+func (e *permutationEngine) Seen(host string) {
+	e.seen[host] = true
+}
+
+// Exhausted reports whether the engine has generated its limit
+func (e *permutationEngine) Exhausted() bool {
+	return e.count >= e.limit
+}
+
+// Generate returns the new candidates derived from host, a name under the
+// target; nothing once the limit is reached
+func (e *permutationEngine) Generate(host string) []string {
+	sub, ok := strings.CutSuffix(host, "."+e.target)
+	if !ok || e.Exhausted() {
+		return nil
+	}
+	labels := strings.Split(sub, ".")
+
+	var candidates []string
+	add := func(labels ...string) bool {
+		if e.Exhausted() {
+			return false
+		}
+		for _, label := range labels {
+			if !permutationLabelRe.MatchString(label) {
+				return true
+			}
+		}
+		name := strings.Join(labels, ".") + "." + e.target
+		if len(name) > 253 || e.seen[name] {
+			return true
+		}
+		e.seen[name] = true
+		e.count++
+		candidates = append(candidates, name)
+		return true
+	}
+	// with returns labels with labels[i] replaced by the given ones
+	with := func(i int, replacement ...string) []string {
+		return slices.Concat(labels[:i], replacement, labels[i+1:])
+	}
+
+	// Numbers one up and one down, keeping zero padding: api2 -> api1, api3
+	for i, label := range labels {
+		for _, loc := range digitsRe.FindAllStringIndex(label, -1) {
+			digits := label[loc[0]:loc[1]]
+			n, err := strconv.Atoi(digits)
+			if err != nil {
+				continue
+			}
+			for _, m := range []int{n - 1, n + 1} {
+				if m < 0 {
+					continue
+				}
+				number := strconv.Itoa(m)
+				if digits[0] == '0' && len(number) < len(digits) {
+					number = fmt.Sprintf("%0*d", len(digits), m)
+				}
+				add(with(i, label[:loc[0]]+number+label[loc[1]:])...)
+			}
+		}
+	}
+
+	// Dash-separated tokens swapped for each word or dropped, and what is
+	// left numbered: gitlab-staging -> gitlab-dev, gitlab, gitlab2
+	for i, label := range labels {
+		tokens := strings.Split(label, "-")
+		if len(tokens) < 2 {
+			continue
+		}
+		for j, token := range tokens {
+			for _, word := range e.words {
+				if word != token {
+					add(with(i, strings.Join(slices.Concat(tokens[:j], []string{word}, tokens[j+1:]), "-"))...)
+				}
+			}
+			rest := strings.Join(slices.Concat(tokens[:j], tokens[j+1:]), "-")
+			add(with(i, rest)...)
+			add(with(i, rest+"1")...)
+			add(with(i, rest+"2")...)
+		}
+	}
+
+	// Dashes to dots and dots to dashes, one at a time
+	for i, label := range labels {
+		for j := range len(label) {
+			if label[j] == '-' {
+				add(with(i, label[:j], label[j+1:])...)
+			}
+		}
+	}
+	for i := 0; i+1 < len(labels); i++ {
+		add(slices.Concat(labels[:i], []string{labels[i] + "-" + labels[i+1]}, labels[i+2:])...)
+	}
+
+	for _, word := range e.words {
+		// Inserted as a label of its own at every position
+		for i := 0; i <= len(labels); i++ {
+			add(slices.Concat(labels[:i], []string{word}, labels[i:])...)
+		}
+		// Joined to each label, with and without a dash
+		for i, label := range labels {
+			add(with(i, word+"-"+label)...)
+			add(with(i, label+"-"+word)...)
+			add(with(i, word+label)...)
+			if !add(with(i, label+word)...) {
+				return candidates
+			}
+		}
+	}
+	return candidates
+}
+
+// Permutations of names passive sources found, resolved while the combined
+// scan runs: finding gitlab-staging in CT logs queues gitlab-dev,
+// gitlab-prod, gitlab2 and so on. Results name the seed they came from.
+type feedbackSource struct{}
+
+func (feedbackSource) Name() string { return "feedback" }
+
+func (feedbackSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+	run := sourceRunFrom(ctx)
+	queue := run.feedback
+	if queue == nil {
+		run.Notice("warning", "Feedback permutations only run in a combined scan with passive sources")
+		return nil
+	}
+
+	engine := newPermutationEngine(target, alterationWords, cfg.DNS.FeedbackMaxCandidates)
+	brute := newBruteForce(ctx, "feedback", target)
+	var mu sync.Mutex
+	origins := make(map[string]Result) // candidate -> the result it came from
+	seeds := 0
+	candidates := func(yield func(string) bool) {
+		for {
+			seed, ok := queue.next(ctx)
+			if !ok {
+				return
+			}
+			engine.Seen(seed.Host)
+			// A name under a wildcard says nothing about what exists
+			if _, parent, _ := strings.Cut(seed.Host, "."); brute.wildcards.zone(ctx, parent).wildcard {
+				continue
+			}
+			generated := engine.Generate(seed.Host)
+			if len(generated) > 0 {
+				seeds++
+			}
+			for _, candidate := range generated {
+				mu.Lock()
+				origins[candidate] = seed
+				mu.Unlock()
+				if !yield(candidate) {
+					return
+				}
+			}
+			if engine.Exhausted() {
+				run.Notice("warning", "Stopped at the limit of %d feedback candidates", cfg.DNS.FeedbackMaxCandidates)
+				return
+			}
+		}
+	}
+
+	resolved := make(chan Result)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(resolved)
+		errc <- brute.resolve(candidates, resolved, nil)
+	}()
+	for result := range resolved {
+		mu.Lock()
+		origin := origins[result.Host]
+		mu.Unlock()
+		result.Seed, result.SeedSource = origin.Host, origin.Source
+		emit(ctx, out, result)
+	}
+	err := <-errc
+	run.Notice("info", "Tried %d candidates derived from %d names", engine.count, seeds)
+	return err
+}
+
+// feedbackQueue holds the passive results of a combined scan until the
+// feedback source takes them. It never blocks the sources adding to it,
+// and runs dry once every one of them is done. A nil queue takes nothing.
+type feedbackQueue struct {
+	mu        sync.Mutex
+	pending   []Result
+	producers int
+	wake      chan struct{}
+}
+
+func newFeedbackQueue(producers int) *feedbackQueue {
+	return &feedbackQueue{producers: producers, wake: make(chan struct{}, 1)}
+}
+
+func (q *feedbackQueue) push(result Result) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.pending = append(q.pending, result)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// producerDone records that one source will push nothing more
+func (q *feedbackQueue) producerDone() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.producers--
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *feedbackQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for a result, returning false once the queue is drained and
+// its producers are done, or ctx ends
+func (q *feedbackQueue) next(ctx context.Context) (Result, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			result := q.pending[0]
+			q.pending = q.pending[1:]
+			q.mu.Unlock()
+			return result, true
+		}
+		done := q.producers <= 0
+		q.mu.Unlock()
+		if done {
+			return Result{}, false
+		}
+		select {
+		case <-q.wake:
+		case <-ctx.Done():
+			return Result{}, false
+		}
+	}
+}
+
+var (
+	digitsRe           = regexp.MustCompile(`[0-9]+`)
+	permutationLabelRe = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9_])?$`)
+)
+
+// affixPermutations are the names generatePermutations builds around one
+// affix: affix.domain, base-affix.tld and baseaffix.tld
+func affixPermutations(domain, affix string) []string {
+	permutations := []string{affix + "." + domain}
+	if base, tld, ok := strings.Cut(domain, "."); ok {
+		permutations = append(permutations, base+"-"+affix+"."+tld, base+affix+"."+tld)
+	}
+	return permutations
+}
+
+// Subdomain takeover detection: hosts whose CNAME points at a hosting
+// provider that serves its "unclaimed" page for them, or that does not
+// know the name at all. On its own the source checks the hosts of the
+// target's latest job; takeover=true on a combined scan checks the scan's
+// own hosts once its sources are done.
+type takeoverSource struct{}
+
+func (takeoverSource) Name() string { return "takeover" }
+
+func (takeoverSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	run := sourceRunFrom(ctx)
+	latest := latestJob(target, run.Job())
+	if latest == nil {
+		run.Notice("warning", "No earlier job for %s to check; scan it first", target)
+		return nil
+	}
+	hosts := slices.Sorted(maps.Keys(latest.Hosts()))
+	run.Notice("info", "Checking %d hosts found by job %s", len(hosts), latest.ID)
+	return detectTakeovers(ctx, hosts, out)
+}
+
+// latestJob returns the most recently started job for target other than
+// exclude, or nil
+func latestJob(target string, exclude *Job) *Job {
+	jobManager.mu.RLock()
+	defer jobManager.mu.RUnlock()
+
+	var latest *Job
+	for _, job := range jobManager.jobs {
+		if job == exclude || !strings.EqualFold(job.Target, target) {
+			continue
+		}
+		if latest == nil || job.StartTime.After(latest.StartTime) {
+			latest = job
+		}
+	}
+	return latest
+}
+
+// runTakeoverPass checks a combined scan's hosts for takeovers after its
+// sources are done, as the job's "takeover" source
+func runTakeoverPass(ctx context.Context, job *Job, emit func(event string, v interface{})) {
+	notice := func(kind, message string) {
+		emit("notice", map[string]string{"source": "takeover", "kind": kind, "message": message})
+	}
+	if isPassive(ctx) {
+		notice("warning", errPassiveOnly.Error())
+		return
+	}
+
+	job.mu.Lock()
+	job.Sources = append(job.Sources, "takeover")
+	job.SourceTimings["takeover"] = newSourceTiming(time.Now())
+	job.mu.Unlock()
+	job.Progress.Start("takeover")
+
+	hosts := slices.Sorted(maps.Keys(job.Hosts()))
+	notice("info", fmt.Sprintf("Checking %d hosts for takeovers", len(hosts)))
+
+	out := make(chan Result)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(out)
+		errc <- detectTakeovers(ctx, hosts, out)
+	}()
+	found := 0
+	for result := range out {
+		result.Source = "takeover"
+		result.Timestamp = time.Now()
+		emit("", job.AddResult("takeover", result))
+		job.Progress.Found("takeover")
+		found++
+	}
+
+	outcome := "complete"
+	if <-errc != nil {
+		outcome = "cancelled"
+	}
+	job.FinishSource("takeover", outcome)
+	emit("source-complete", map[string]interface{}{
+		"source":  "takeover",
+		"outcome": outcome,
+		"hosts":   found,
+	})
+}
+
+// runProbePass probes a combined scan's hosts over HTTP after its sources
+// are done, as the job's "probe" source, sending each result as a probe
+// event. Its source-complete counts the live hosts.
+func runProbePass(ctx context.Context, job *Job, emit func(event string, v interface{})) {
+	notice := func(kind, message string) {
+		emit("notice", map[string]string{"source": "probe", "kind": kind, "message": message})
+	}
+	if isPassive(ctx) {
+		notice("warning", errPassiveOnly.Error())
+		return
+	}
+
+	cfg := configFrom(ctx)
+	var hosts []string
+	for _, host := range slices.Sorted(maps.Keys(job.Hosts())) {
+		if probeAllowed(cfg, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	tasks, err := probeTasks(hosts, nil)
+	if err != nil {
+		notice("error", err.Error())
+		return
+	}
+	if len(tasks) > cfg.HTTP.ProbeMaxTargets {
+		notice("truncated", fmt.Sprintf("Probing the first %d of %d hosts (HTTP_PROBE_MAX_TARGETS)", cfg.HTTP.ProbeMaxTargets, len(tasks)))
+		tasks = tasks[:cfg.HTTP.ProbeMaxTargets]
+	}
+
+	job.mu.Lock()
+	job.Sources = append(job.Sources, "probe")
+	job.SourceTimings["probe"] = newSourceTiming(time.Now())
+	job.mu.Unlock()
+	job.Progress.Start("probe")
+	job.Progress.Expect("probe", len(tasks))
+	notice("info", fmt.Sprintf("Probing %d hosts", len(tasks)))
+
+	live := 0
+	runProbes(ctx, job, tasks, probeOptions{}, func(event ProbeEvent) {
+		job.Progress.Advance("probe", 1)
+		if event.Status != "0" && event.Error == "" {
+			live++
+		}
+		emit("probe", event)
+		// What the probe added reaches the stream like any other host
+		for _, result := range event.Discovered {
+			emit("", result)
+		}
+	})
+
+	outcome := "complete"
+	if ctx.Err() != nil {
+		outcome = "cancelled"
+	}
+	job.FinishSource("probe", outcome)
+	emit("source-complete", map[string]interface{}{
+		"source":  "probe",
+		"outcome": outcome,
+		"hosts":   live,
+	})
+}
+
+// detectTakeovers checks hosts with DNS.Concurrency at a time and emits a
+// "possible-takeover" result for each one matching a fingerprint
+func detectTakeovers(ctx context.Context, hosts []string, out chan<- Result) error {
+	cfg := configFrom(ctx)
+	fingerprints := takeoverFingerprints
+	semaphore := make(chan struct{}, cfg.DNS.Concurrency)
+	var wg sync.WaitGroup
+
+	for _, host := range hosts {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			if result, ok := checkTakeover(ctx, fingerprints, host); ok {
+				emit(ctx, out, result)
+			}
+		}(host)
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// checkTakeover follows host's CNAMEs to a fingerprinted provider. For
+// providers whose unclaimed names do not exist the NXDOMAIN is the
+// evidence; for the others the host is fetched over HTTP, then HTTPS, and
+// the body searched for the provider's signatures.
+func checkTakeover(ctx context.Context, fingerprints []TakeoverFingerprint, host string) (Result, bool) {
+	chain, ips, err := resolverFrom(ctx).ResolveChain(ctx, host, configFrom(ctx).DNS.CNAMEMaxDepth)
+	fingerprint, cname, ok := matchTakeoverFingerprint(fingerprints, chain)
+	if !ok {
+		return Result{}, false
+	}
+
+	result := Result{
+		Host:       host,
+		Status:     "possible-takeover",
+		Title:      fingerprint.Provider,
+		CNAME:      chain[len(chain)-1],
+		CNAMEChain: chain,
+		IPs:        resultIPs(ips),
+	}
+	if fingerprint.NXDomain {
+		if len(ips) > 0 || !errors.Is(err, errNXDomain) {
+			return Result{}, false
+		}
+		result.Error = fmt.Sprintf("CNAME %s does not exist", cname)
+		return result, true
+	}
+	if len(ips) == 0 {
+		return Result{}, false
+	}
+	for _, scheme := range []string{"http", "https"} {
+		probed := scheme + "://" + host + "/"
+		if signature, ok := takeoverSignature(ctx, probed, fingerprint.Signatures); ok {
+			result.URL = probed
+			result.Error = fmt.Sprintf("CNAME %s; response contains %q", cname, signature)
+			return result, true
+		}
+	}
+	return Result{}, false
+}
+
+// matchTakeoverFingerprint returns the fingerprint of the first name in
+// the CNAME chain, from its end, under one of a provider's domains
+func matchTakeoverFingerprint(fingerprints []TakeoverFingerprint, chain []string) (TakeoverFingerprint, string, bool) {
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, fingerprint := range fingerprints {
+			for _, domain := range fingerprint.CNAME {
+				if underDomain(chain[i], domain) {
+					return fingerprint, chain[i], true
+				}
+			}
+		}
+	}
+	return TakeoverFingerprint{}, "", false
+}
+
+// takeoverSignature fetches probedURL without following redirects and
+// returns the first signature found in the body
+func takeoverSignature(ctx context.Context, probedURL string, signatures []string) (string, bool) {
+	cfg := configFrom(ctx)
+	client := &http.Client{
+		Timeout:   cfg.HTTP.Timeout,
+		Transport: probeRoundTripper(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probedURL, nil)
+	if err != nil {
+		return "", false
+	}
+	req.Header.Set("User-Agent", cfg.HTTP.UserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, cfg.HTTP.MaxBodySize))
+	for _, signature := range signatures {
+		if strings.Contains(string(body), signature) {
+			return signature, true
+		}
+	}
+	return "", false
+}
+
+// TakeoverFingerprint identifies an unclaimed name at one provider: CNAME
+// holds the provider's domains, Signatures text of its "unclaimed" page,
+// and NXDomain is set when unclaimed names do not resolve at all
+type TakeoverFingerprint struct {
+	Provider   string   `json:"provider"`
+	CNAME      []string `json:"cname"`
+	Signatures []string `json:"signatures,omitempty"`
+	NXDomain   bool     `json:"nxdomain,omitempty"`
+}
+
+//go:embed takeover.json
+var embeddedTakeoverFingerprints []byte
+
+var takeoverFingerprints []TakeoverFingerprint
+
+// initializeTakeoverFingerprints loads the built-in fingerprints and those
+// in TAKEOVER_FINGERPRINTS, which add providers or replace built-in ones
+// of the same name
+func initializeTakeoverFingerprints() {
+	fingerprints, err := parseTakeoverFingerprints(embeddedTakeoverFingerprints)
+	if err != nil {
+		log.Printf("Warning: embedded takeover fingerprints are invalid: %v", err)
+	}
+
+	if path := currentConfig().Processing.TakeoverFingerprints; path != "" {
+		data, err := os.ReadFile(path)
+		var extra []TakeoverFingerprint
+		if err == nil {
+			extra, err = parseTakeoverFingerprints(data)
+		}
+		if err != nil {
+			log.Printf("Warning: ignoring takeover fingerprints %s: %v", path, err)
+		} else {
+			for _, fingerprint := range extra {
+				fingerprints = slices.DeleteFunc(fingerprints, func(f TakeoverFingerprint) bool {
+					return strings.EqualFold(f.Provider, fingerprint.Provider)
+				})
+			}
+			fingerprints = append(fingerprints, extra...)
+			log.Printf("Loaded %d takeover fingerprints from %s", len(extra), path)
+		}
+	}
+	takeoverFingerprints = fingerprints
+}
+
+// parseTakeoverFingerprints reads {"fingerprints": [...]}. Every entry
+// needs a provider, a CNAME domain, and signatures or nxdomain.
+func parseTakeoverFingerprints(data []byte) ([]TakeoverFingerprint, error) {
+	var file struct {
+		Fingerprints []TakeoverFingerprint `json:"fingerprints"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for i, fingerprint := range file.Fingerprints {
+		if fingerprint.Provider == "" || len(fingerprint.CNAME) == 0 {
+			return nil, fmt.Errorf("fingerprint %d: provider and cname are required", i)
+		}
+		if len(fingerprint.Signatures) == 0 && !fingerprint.NXDomain {
+			return nil, fmt.Errorf("fingerprint %d (%s): needs signatures or nxdomain", i, fingerprint.Provider)
+		}
+		for j, domain := range fingerprint.CNAME {
+			file.Fingerprints[i].CNAME[j] = strings.Trim(strings.ToLower(domain), ".")
+		}
+	}
+	return file.Fingerprints, nil
+}
+
+// Response headers whose values probes report as technologies verbatim,
+// e.g. "nginx/1.24.0" or "PHP/8.2.7"
+var technologyHeaders = []string{"Server", "X-Powered-By", "X-Generator"}
+
+// TechFingerprint identifies a technology from a probe response: Headers
+// maps a header to a pattern its value must match (empty: present at all),
+// Cookies are patterns for Set-Cookie names and Body patterns for the page
+type TechFingerprint struct {
+	Name    string            `json:"name"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Cookies []string          `json:"cookies,omitempty"`
+	Body    []string          `json:"body,omitempty"`
+
+	headers map[string]*regexp.Regexp
+	cookies []*regexp.Regexp
+	body    []*regexp.Regexp
+}
+
+//go:embed technologies.json
+var embeddedTechFingerprints []byte
+
+var techFingerprints []*TechFingerprint
+
+func initializeTechFingerprints() {
+	fingerprints, err := parseTechFingerprints(embeddedTechFingerprints)
+	if err != nil {
+		log.Printf("Warning: embedded technology fingerprints are invalid: %v", err)
+	}
+	techFingerprints = fingerprints
+}
+
+// parseTechFingerprints reads {"fingerprints": [...]} and compiles the
+// patterns case-insensitively. Every entry needs a name and a pattern.
+func parseTechFingerprints(data []byte) ([]*TechFingerprint, error) {
+	var file struct {
+		Fingerprints []*TechFingerprint `json:"fingerprints"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	compile := func(i int, name, pattern string) (*regexp.Regexp, error) {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint %d (%s): %w", i, name, err)
+		}
+		return re, nil
+	}
+	for i, fingerprint := range file.Fingerprints {
+		if fingerprint.Name == "" {
+			return nil, fmt.Errorf("fingerprint %d: name is required", i)
+		}
+		if len(fingerprint.Headers)+len(fingerprint.Cookies)+len(fingerprint.Body) == 0 {
+			return nil, fmt.Errorf("fingerprint %d (%s): needs headers, cookies or body", i, fingerprint.Name)
+		}
+		fingerprint.headers = make(map[string]*regexp.Regexp, len(fingerprint.Headers))
+		for header, pattern := range fingerprint.Headers {
+			re, err := compile(i, fingerprint.Name, pattern)
+			if err != nil {
+				return nil, err
+			}
+			fingerprint.headers[http.CanonicalHeaderKey(header)] = re
+		}
+		for _, pattern := range fingerprint.Cookies {
+			re, err := compile(i, fingerprint.Name, pattern)
+			if err != nil {
+				return nil, err
+			}
+			fingerprint.cookies = append(fingerprint.cookies, re)
+		}
+		for _, pattern := range fingerprint.Body {
+			re, err := compile(i, fingerprint.Name, pattern)
+			if err != nil {
+				return nil, err
+			}
+			fingerprint.body = append(fingerprint.body, re)
+		}
+	}
+	return file.Fingerprints, nil
+}
+
+// matches reports whether any of the fingerprint's patterns hits
+func (f *TechFingerprint) matches(header http.Header, cookies []string, body []byte) bool {
+	for name, re := range f.headers {
+		for _, value := range header.Values(name) {
+			if re.MatchString(value) {
+				return true
+			}
+		}
+	}
+	for _, re := range f.cookies {
+		for _, cookie := range cookies {
+			if re.MatchString(cookie) {
+				return true
+			}
+		}
+	}
+	for _, re := range f.body {
+		if re.Match(body) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectTechnologies lists the technology headers of a response followed
+// by the fingerprints it matches, without duplicates
+func detectTechnologies(header http.Header, body []byte) []string {
+	var technologies []string
+	seen := make(map[string]struct{})
+	add := func(name string) {
+		key := strings.ToLower(name)
+		if _, dup := seen[key]; dup || name == "" {
+			return
+		}
+		seen[key] = struct{}{}
+		technologies = append(technologies, name)
+	}
+
+	for _, name := range technologyHeaders {
+		for _, value := range header.Values(name) {
+			add(strings.TrimSpace(value))
+		}
+	}
+	var cookies []string
+	for _, line := range header.Values("Set-Cookie") {
+		name, _, _ := strings.Cut(line, "=")
+		cookies = append(cookies, strings.TrimSpace(name))
+	}
+	for _, fingerprint := range techFingerprints {
+		if fingerprint.matches(header, cookies, body) {
+			add(fingerprint.Name)
+		}
+	}
+	return technologies
+}
+
+// Page classes of probed pages. Only pages that match no signature in
+// pageclasses.json are "app" (or "error" from a 5xx).
+const (
+	pageClassApp     = "app"
+	pageClassDefault = "default"
+	pageClassParked  = "parked"
+	pageClassError   = "error"
+)
+
+// PageSignature recognises a page that isn't an application by its title
+// or body
+type PageSignature struct {
+	Name  string   `json:"name"`
+	Class string   `json:"class"`
+	Title []string `json:"title,omitempty"`
+	Body  []string `json:"body,omitempty"`
+
+	title []*regexp.Regexp
+	body  []*regexp.Regexp
+}
+
+//go:embed pageclasses.json
+var embeddedPageSignatures []byte
+
+var pageSignatures []*PageSignature
+
+func initializePageSignatures() {
+	signatures, err := parsePageSignatures(embeddedPageSignatures)
+	if err != nil {
+		log.Printf("Warning: embedded page signatures are invalid: %v", err)
+	}
+	pageSignatures = signatures
+}
+
+// parsePageSignatures reads {"signatures": [...]} and compiles the
+// patterns case-insensitively. Every entry needs a name, a class other
+// than app and a pattern.
+func parsePageSignatures(data []byte) ([]*PageSignature, error) {
+	var file struct {
+		Signatures []*PageSignature `json:"signatures"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for i, signature := range file.Signatures {
+		if signature.Name == "" {
+			return nil, fmt.Errorf("signature %d: name is required", i)
+		}
+		switch signature.Class {
+		case pageClassDefault, pageClassParked, pageClassError:
+		default:
+			return nil, fmt.Errorf("signature %d (%s): unknown class %q", i, signature.Name, signature.Class)
+		}
+		if len(signature.Title)+len(signature.Body) == 0 {
+			return nil, fmt.Errorf("signature %d (%s): needs title or body", i, signature.Name)
+		}
+		for _, pattern := range signature.Title {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("signature %d (%s): %w", i, signature.Name, err)
+			}
+			signature.title = append(signature.title, re)
+		}
+		for _, pattern := range signature.Body {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("signature %d (%s): %w", i, signature.Name, err)
+			}
+			signature.body = append(signature.body, re)
+		}
+	}
+	return file.Signatures, nil
+}
+
+// matches reports whether any of the signature's patterns hits
+func (s *PageSignature) matches(title string, body []byte) bool {
+	for _, re := range s.title {
+		if re.MatchString(title) {
+			return true
+		}
+	}
+	for _, re := range s.body {
+		if re.Match(body) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyPage returns the class of a probed page and the name of the
+// signature that decided it, if one did
+func classifyPage(status int, title string, body []byte) (string, string) {
+	title = strings.TrimSpace(title)
+	for _, signature := range pageSignatures {
+		if signature.matches(title, body) {
+			return signature.Class, signature.Name
+		}
+	}
+	if status >= 500 {
+		return pageClassError, ""
+	}
+	return pageClassApp, ""
+}
+
+// Synthetic test target: an in-process authoritative DNS server and a few
+// web servers that let demos and integration tests run without touching
+// anything real. Configure points a scanner at it.
+const testTargetZone = "synthetic.test."
+
+// testTargetExternal are names outside the zone the DNS server answers as
+// a recursive resolver would: where the zone's CNAMEs lead
+var testTargetExternal = []string{
+	"synthetic-test.github.io. 300 IN A 127.0.0.1",
+}
+
+// testTargetLame stands in for a broken delegation: names under it get
+// SERVFAIL, as a recursive resolver would answer after failing to reach
+// the delegated servers
+const testTargetLame = "archive." + testTargetZone
+
+type TestTarget struct {
+	Zone      string
+	DNSAddr   string
+	AppURL    string // every name in the zone, over HTTP
+	SecureURL string // and over HTTPS
+
+	// Stands in for crt.sh and the Wayback Machine, which report names
+	// the zone does not have
+	UpstreamURL string
+
+	records    map[string][]dns.RR
+	names      []string
+	dnsServers []*dns.Server
+	webServers []*httptest.Server
+
+	// Traffic received, for checks that a scan left the target alone
+	dnsQueries      int64
+	httpConnections int64
+}
+
+// startTestTarget starts the fake DNS and web servers and returns once
+// they are accepting connections
+func startTestTarget(dnsAddr string) (*TestTarget, error) {
+	tt := &TestTarget{
+		Zone:    strings.TrimSuffix(testTargetZone, "."),
+		DNSAddr: dnsAddr,
+		records: make(map[string][]dns.RR),
+	}
+
+	countConnections := func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&tt.httpConnections, 1)
+		}
+	}
+	app := httptest.NewUnstartedServer(http.HandlerFunc(testTargetAppHandler))
+	app.Config.ConnState = countConnections
+	app.Start()
+	secure := httptest.NewUnstartedServer(http.HandlerFunc(testTargetAppHandler))
+	secure.Config.ConnState = countConnections
+	secure.StartTLS()
+	// The stand-in upstreams are third parties, not the target
+	upstream := httptest.NewServer(http.HandlerFunc(testTargetUpstreamHandler))
+	tt.webServers = []*httptest.Server{app, secure, upstream}
+	tt.AppURL = app.URL
+	tt.SecureURL = secure.URL
+	tt.UpstreamURL = upstream.URL
+
+	if err := tt.buildZone(); err != nil {
+		tt.Close()
+		return nil, err
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		started := make(chan error, 1)
+		server := &dns.Server{
+			Addr:              dnsAddr,
+			Net:               network,
+			Handler:           dns.HandlerFunc(tt.serveDNS),
+			NotifyStartedFunc: func() { started <- nil },
+		}
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				started <- err
+			}
+		}()
+		if err := <-started; err != nil {
+			tt.Close()
+			return nil, fmt.Errorf("failed to start %s DNS listener on %s: %w", network, dnsAddr, err)
+		}
+		tt.dnsServers = append(tt.dnsServers, server)
+	}
+
+	return tt, nil
+}
+
+func (tt *TestTarget) Close() {
+	for _, server := range tt.dnsServers {
+		server.Shutdown()
+	}
+	for _, server := range tt.webServers {
+		server.Close()
+	}
+}
+
+// buildZone populates the synthetic zone and links every owner name into
+// an NSEC chain in canonical order
+func (tt *TestTarget) buildZone() error {
+	z := testTargetZone
+	lines := []string{
+		z + " 300 IN SOA ns1." + z + " hostmaster." + z + " 1 7200 3600 1209600 300",
+		z + " 300 IN NS ns1." + z,
+		z + " 300 IN NS ns2." + z,
+		z + " 300 IN A 127.0.0.1",
+		z + " 300 IN MX 10 mail." + z,
+		z + ` 300 IN TXT "v=spf1 include:_spf.` + z + " a:relay." + z + ` ip4:127.0.0.0/8 -all"`,
+		"ns1." + z + " 300 IN A 127.0.0.1",
+		"ns2." + z + " 300 IN A 127.0.0.1",
+		"v6." + z + " 300 IN AAAA ::1",
+		"docs." + z + " 300 IN CNAME www." + z,
+		"legacy." + z + " 300 IN CNAME synthetic-test.github.io.",
+		"old." + z + " 300 IN CNAME retired." + z,
+		"*.wild." + z + " 300 IN A 127.0.0.2",
+		"_ldap._tcp." + z + " 300 IN SRV 0 5 389 dc1." + z,
+		"_kerberos._tcp." + z + " 300 IN SRV 0 5 88 dc1." + z,
+		"_autodiscover._tcp." + z + " 300 IN SRV 0 0 443 autodiscover.outlook.com.",
+	}
+
+	labels := []string{
+		"www", "mail", "relay", "api", "api2", "dev", "dev-api", "staging", "admin", "portal",
+		"vpn", "vpn.corp", "corp", "git", "jenkins", "grafana", "kibana", "ci", "static",
+		"assets", "blog", "shop", "status", "auth", "sso", "m", "mobile", "beta", "test",
+		"qa", "uat", "db", "redis", "backup", "ftp", "dc1",
+	}
+	for _, label := range labels {
+		lines = append(lines, fmt.Sprintf("%s.%s 300 IN A 127.0.0.1", label, z))
+	}
+	// Too many addresses for a 512-byte UDP answer, so lookups need TCP
+	for i := 1; i <= 40; i++ {
+		lines = append(lines, fmt.Sprintf("cdn.%s 300 IN A 127.0.1.%d", z, i))
+	}
+
+	for _, line := range append(lines, testTargetExternal...) {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			return fmt.Errorf("invalid synthetic record %q: %w", line, err)
+		}
+		name := strings.ToLower(rr.Header().Name)
+		tt.records[name] = append(tt.records[name], rr)
+	}
+
+	// Only the zone's own names are chained and transferred
+	for name := range tt.records {
+		if dns.IsSubDomain(testTargetZone, name) {
+			tt.names = append(tt.names, name)
+		}
+	}
+	sort.Slice(tt.names, func(i, j int) bool {
+		return canonicalNameLess(tt.names[i], tt.names[j])
+	})
+	for i, name := range tt.names {
+		next := tt.names[(i+1)%len(tt.names)]
+		nsec := &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+			NextDomain: next,
+		}
+		for _, rr := range tt.records[name] {
+			nsec.TypeBitMap = append(nsec.TypeBitMap, rr.Header().Rrtype)
+		}
+		nsec.TypeBitMap = append(nsec.TypeBitMap, dns.TypeNSEC)
+		sort.Slice(nsec.TypeBitMap, func(a, b int) bool { return nsec.TypeBitMap[a] < nsec.TypeBitMap[b] })
+		tt.records[name] = append(tt.records[name], nsec)
+	}
+
+	return nil
+}
+
+// canonicalNameLess orders names as DNSSEC does: label by label from the root
+func canonicalNameLess(a, b string) bool {
+	return canonicalNameCompare(a, b) < 0
+}
+
+// canonicalNameCompare is canonicalNameLess as a three-way comparison
+func canonicalNameCompare(a, b string) int {
+	la, lb := dns.SplitDomainName(a), dns.SplitDomainName(b)
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if la[i] != lb[j] {
+			return strings.Compare(la[i], lb[j])
+		}
+	}
+	return cmp.Compare(len(la), len(lb))
+}
+
+func (tt *TestTarget) serveDNS(w dns.ResponseWriter, req *dns.Msg) {
+	atomic.AddInt64(&tt.dnsQueries, 1)
+	if len(req.Question) == 0 {
+		return
+	}
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+	msg.Authoritative = true
+
+	if !dns.IsSubDomain(testTargetZone, name) {
+		msg.Authoritative = false
+		for _, rr := range tt.records[name] {
+			if rr.Header().Rrtype == q.Qtype {
+				msg.Answer = append(msg.Answer, dns.Copy(rr))
+			}
+		}
+		if len(msg.Answer) == 0 {
+			msg.SetRcode(req, dns.RcodeRefused)
+		}
+		w.WriteMsg(msg)
+		return
+	}
+
+	if dns.IsSubDomain(testTargetLame, name) {
+		msg.SetRcode(req, dns.RcodeServerFailure)
+		w.WriteMsg(msg)
+		return
+	}
+
+	// Every nameserver in the synthetic zone is AXFR-permissive
+	if q.Qtype == dns.TypeAXFR {
+		tt.serveAXFR(w, req)
+		return
+	}
+
+	records, exists := tt.records[name]
+	if !exists {
+		if parent := strings.SplitN(name, ".", 2); len(parent) == 2 && dns.IsSubDomain("wild."+testTargetZone, parent[1]) {
+			records, exists = tt.wildcardRecords(name)
+		}
+	}
+
+	if !exists {
+		msg.SetRcode(req, dns.RcodeNameError)
+		msg.Ns = append(msg.Ns, tt.soa())
+		if covering := tt.coveringNSEC(name); covering != nil {
+			msg.Ns = append(msg.Ns, covering)
+		}
+		w.WriteMsg(msg)
+		return
+	}
+
+	for _, rr := range records {
+		rrtype := rr.Header().Rrtype
+		if rrtype == q.Qtype || q.Qtype == dns.TypeANY || (rrtype == dns.TypeCNAME && q.Qtype != dns.TypeNSEC) {
+			msg.Answer = append(msg.Answer, dns.Copy(rr))
+		}
+		// The CNAME's target is answered too, as the resolver scanning the
+		// zone would from the zone or, for names out of it, by recursing
+		if cname, ok := rr.(*dns.CNAME); ok && q.Qtype != dns.TypeCNAME {
+			for _, target := range tt.records[strings.ToLower(cname.Target)] {
+				if target.Header().Rrtype == q.Qtype {
+					msg.Answer = append(msg.Answer, dns.Copy(target))
+				}
+			}
+		}
+	}
+	if len(msg.Answer) == 0 {
+		msg.Ns = append(msg.Ns, tt.soa())
+	}
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		msg.Truncate(size)
+	}
+	w.WriteMsg(msg)
+}
+
+func (tt *TestTarget) wildcardRecords(name string) ([]dns.RR, bool) {
+	wildcard, ok := tt.records["*.wild."+testTargetZone]
+	if !ok {
+		return nil, false
+	}
+	records := make([]dns.RR, 0, len(wildcard))
+	for _, rr := range wildcard {
+		if rr.Header().Rrtype == dns.TypeNSEC {
+			continue
+		}
+		synthesized := dns.Copy(rr)
+		synthesized.Header().Name = name
+		records = append(records, synthesized)
+	}
+	return records, true
+}
+
+func (tt *TestTarget) soa() dns.RR {
+	for _, rr := range tt.records[testTargetZone] {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			return dns.Copy(rr)
+		}
+	}
+	return nil
+}
+
+// coveringNSEC returns the NSEC record whose owner sorts immediately before name
+func (tt *TestTarget) coveringNSEC(name string) dns.RR {
+	owner := tt.names[len(tt.names)-1]
+	for _, candidate := range tt.names {
+		if !canonicalNameLess(candidate, name) {
+			break
+		}
+		owner = candidate
+	}
+	for _, rr := range tt.records[owner] {
+		if rr.Header().Rrtype == dns.TypeNSEC {
+			return dns.Copy(rr)
+		}
+	}
+	return nil
+}
+
+func (tt *TestTarget) serveAXFR(w dns.ResponseWriter, req *dns.Msg) {
+	soa := tt.soa()
+	records := []dns.RR{soa}
+	for _, name := range tt.names {
+		for _, rr := range tt.records[name] {
+			if rr.Header().Rrtype != dns.TypeSOA {
+				records = append(records, rr)
+			}
+		}
+	}
+	records = append(records, soa)
+
+	ch := make(chan *dns.Envelope, 1)
+	transfer := new(dns.Transfer)
+	go func() {
+		ch <- &dns.Envelope{RR: records}
+		close(ch)
+	}()
+	if err := transfer.Out(w, req, ch); err != nil {
+		log.Printf("Test target AXFR failed: %v", err)
+	}
+	w.Close()
+}
+
+// testTargetAppHandler serves name-based pages: titled apps, a redirect and
+// an unclaimed GitHub Pages site for takeover detection
+func testTargetAppHandler(w http.ResponseWriter, r *http.Request) {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	switch {
+	case strings.HasPrefix(host, "legacy."):
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<html><head><title>Site not found · GitHub Pages</title></head><body><h1>404</h1><p>There isn't a GitHub Pages site here.</p></body></html>")
+	case strings.HasPrefix(host, "admin.") && r.URL.Path != "/login":
+		http.Redirect(w, r, "/login", http.StatusFound)
+	case r.URL.Path == "/login":
+		fmt.Fprint(w, "<html><head><title>Admin Login</title></head><body><form></form></body></html>")
+	case r.URL.Path == "/redirect":
+		http.Redirect(w, r, "/", http.StatusMovedPermanently)
+	default:
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'self' static.synthetic.test *.cdn.synthetic.test; connect-src https://api-internal.synthetic.test:8443 wss://ws.synthetic.test")
+		w.Header().Set("Access-Control-Allow-Origin", "https://portal.synthetic.test")
+		fmt.Fprintf(w, "<html><head><title>Synthetic %s</title></head><body>ok</body></html>", host)
+	}
+}
+
+// testTargetUpstreamHandler answers as crt.sh and the Wayback Machine
+// would for the synthetic zone, with names that are gone from its DNS
+func testTargetUpstreamHandler(w http.ResponseWriter, r *http.Request) {
+	z := strings.TrimSuffix(testTargetZone, ".")
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]map[string]string{
+			{"name_value": "www." + z + "\nold-portal." + z, "not_before": "2024-03-01T00:00:00"},
+			{"name_value": "*.staging." + z, "not_before": "2025-01-15T00:00:00"},
+		})
+	case "/cdx/search/cdx":
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "20190405101112 http://intranet.%s/login\n20230102030405 https://blog.%s/\n", z, z)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Configure points cfg's DNS servers, zone transfers, probes and
+// certificate and archive sources at the test target. Probes need the
+// scan's resolvers and the web servers' ports to reach it, and TLS
+// verification off for the HTTPS server's certificate.
+func (tt *TestTarget) Configure(cfg *Config) error {
+	_, dnsPort, err := net.SplitHostPort(tt.DNSAddr)
+	if err != nil {
+		return err
+	}
+	cfg.DNS.Servers = []string{tt.DNSAddr}
+	cfg.DNS.AXFRPort = dnsPort
+
+	for _, server := range []struct {
+		url  string
+		port *int
+	}{{tt.AppURL, &cfg.HTTP.ProbeHTTPPort}, {tt.SecureURL, &cfg.HTTP.ProbeHTTPSPort}} {
+		parsed, err := url.Parse(server.url)
+		if err != nil {
+			return err
+		}
+		if *server.port, err = strconv.Atoi(parsed.Port()); err != nil {
+			return err
+		}
+	}
+	cfg.HTTP.ProbeViaResolvers = true
+	cfg.HTTP.SkipTLSVerify = true
+
+	cfg.Upstreams.CrtSh = tt.UpstreamURL
+	cfg.Upstreams.Wayback = tt.UpstreamURL
+	return nil
+}
+
+// runTestTarget starts the synthetic environment and blocks until interrupted
+func runTestTarget(dnsAddr string) error {
+	tt, err := startTestTarget(dnsAddr)
+	if err != nil {
+		return err
+	}
+	defer tt.Close()
+
+	var cfg Config
+	if err := tt.Configure(&cfg); err != nil {
+		return err
+	}
+	log.Printf("🧪 Synthetic test target for %s is running", tt.Zone)
+	log.Printf("   DNS (udp/tcp, AXFR allowed): %s", tt.DNSAddr)
+	log.Printf("   Web app:    %s", tt.AppURL)
+	log.Printf("   Web secure: %s", tt.SecureURL)
+	log.Printf("   Upstreams:  %s (crt.sh, Wayback)", tt.UpstreamURL)
+	log.Printf("   Scan target=%s with: DNS_SERVERS=%s AXFR_PORT=%s HTTP_PROBE_VIA_RESOLVERS=true HTTP_PROBE_HTTP_PORT=%d HTTP_PROBE_HTTPS_PORT=%d CRTSH_BASE_URL=%s WAYBACK_BASE_URL=%s",
+		tt.Zone, tt.DNSAddr, cfg.DNS.AXFRPort, cfg.HTTP.ProbeHTTPPort, cfg.HTTP.ProbeHTTPSPort, tt.UpstreamURL, tt.UpstreamURL)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	log.Printf("Shutting down synthetic test target")
+	return nil
+}
+
+// BenchOptions configures a --bench run
+type BenchOptions struct {
+	Duration    time.Duration
+	Concurrency int
+	Baseline    string
+	Output      string
+	Threshold   float64 // allowed regression in percent
+	DNSAddr     string
+}
+
+// BenchReport is what a benchmark run measures. A saved report is the
+// baseline for later runs.
+type BenchReport struct {
+	Version         string    `json:"version"`
+	Time            time.Time `json:"time"`
+	Duration        string    `json:"duration"`
+	Concurrency     int       `json:"concurrency"`
+	Requests        int64     `json:"requests"`
+	RequestsPerSec  float64   `json:"requests_per_sec"`
+	SSEEvents       int64     `json:"sse_events"`
+	SSEEventsPerSec float64   `json:"sse_events_per_sec"`
+	RateLimited     int64     `json:"rate_limited"`
+	Errors          int64     `json:"errors"`
+	ScanP95MS       float64   `json:"scan_p95_ms"`
+	PollP95MS       float64   `json:"poll_p95_ms"`
+	PeakGoroutines  int       `json:"peak_goroutines"`
+	PeakHeapBytes   uint64    `json:"peak_heap_bytes"`
+}
+
+// passiveCheckTimeout bounds the aggregate scan of a --verify-passive run;
+// the third-party sources are cancelled if they take longer
+const passiveCheckTimeout = 45 * time.Second
+
+// runPassiveCheck runs an aggregate scan passive-only against the
+// synthetic test target, tries each blocked path directly, and reports
+// whether the target's DNS and web listeners stayed untouched
+func runPassiveCheck(dnsAddr string) (bool, error) {
+	if !passiveOnly {
+		return false, errors.New("passive-only mode is not enabled")
+	}
+	tt, err := startTestTarget(dnsAddr)
+	if err != nil {
+		return false, err
+	}
+	defer tt.Close()
+
+	cfg := *currentConfig()
+	if err := tt.Configure(&cfg); err != nil {
+		return false, err
+	}
+	setConfig(&cfg)
+	initializeDNSResolver()
+
+	server := httptest.NewServer(newRouter(&cfg))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), passiveCheckTimeout)
+	defer cancel()
+
+	passed := true
+	check := func(name string, ok bool, detail string) {
+		mark := "✅"
+		if !ok {
+			mark = "❌"
+			passed = false
+		}
+		fmt.Printf("%s %-40s %s\n", mark, name, detail)
+	}
+
+	// get returns the status of a request and drains its body, so a scan
+	// stream runs to completion
+	get := func(path string) int {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			return 0
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+
+	zone := url.QueryEscape(tt.Zone)
+	fmt.Printf("Passive-only check against %s (disabled: %s)\n", tt.Zone, strings.Join(disabledSources, ", "))
+	status := get("/api/enumerate/stream?target=" + zone)
+	check("aggregate scan", status == http.StatusOK, fmt.Sprintf("HTTP %d", status))
+	for _, name := range disabledSources {
+		status := get("/api/" + name + "/stream?target=" + zone)
+		check("/api/"+name+"/stream refused", status == http.StatusForbidden, fmt.Sprintf("HTTP %d", status))
+	}
+	status = get("/api/enumerate/stream?target=" + zone + "&sources=" + strings.Join(disabledSources, ","))
+	check("enumerate with disabled sources refused", status == http.StatusForbidden, fmt.Sprintf("HTTP %d", status))
+	status = get("/api/probe?url=" + url.QueryEscape(tt.AppURL))
+	check("probe refused", status == http.StatusForbidden, fmt.Sprintf("HTTP %d", status))
+
+	// The guards below the handlers, for code paths no endpoint reaches
+	_, err = defaultResolver().LookupHost(ctx, "www."+tt.Zone)
+	check("DNS lookup refused", errors.Is(err, errPassiveOnly), fmt.Sprint(err))
+	err = transferZone(ctx, dnsAddr, tt.Zone, func(dns.RR) bool { return true })
+	check("zone transfer refused", errors.Is(err, errPassiveOnly), fmt.Sprint(err))
+	probe := probeURL(ctx, probeHTTPClient(ctx), tt.SecureURL)
+	check("HTTP probe refused", strings.Contains(probe.Error, errPassiveOnly.Error()), probe.Error)
+
+	queries := atomic.LoadInt64(&tt.dnsQueries)
+	connections := atomic.LoadInt64(&tt.httpConnections)
+	check("target DNS listener untouched", queries == 0, fmt.Sprintf("%d queries", queries))
+	check("target web listeners untouched", connections == 0, fmt.Sprintf("%d connections", connections))
+	return passed, nil
+}
+
+// Scan mix cycled through by every benchmark client: the combined stream
+// exercises the SSE fan-out, the others single sources
+var benchScans = []string{
+	"/api/enumerate/stream?target=synthetic.test&sources=dns,permute,records,zone",
+	"/api/dns/stream?target=synthetic.test",
+	"/api/records/stream?target=synthetic.test",
+	"/api/zone/stream?target=synthetic.test",
+}
+
+// runBench serves the API in-process against the synthetic test target,
+// generates scan and polling load, and reports whether the results are
+// within the threshold of the baseline
+func runBench(opts BenchOptions) (bool, error) {
+	tt, err := startTestTarget(opts.DNSAddr)
+	if err != nil {
+		return false, err
+	}
+	defer tt.Close()
+
+	cfg := *currentConfig()
+	if err := tt.Configure(&cfg); err != nil {
+		return false, err
+	}
+	setConfig(&cfg)
+	initializeDNSResolver()
+
+	log.Printf("Benchmarking for %s with %d clients against %s", opts.Duration, opts.Concurrency, tt.Zone)
+	logOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	server := httptest.NewServer(newRouter(&cfg))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Duration)
+	defer cancel()
+
+	var requests, events, limited, failures int64
+	var mu sync.Mutex
+	var scanLatencies, pollLatencies []time.Duration
+
+	// fetch issues one request and counts the SSE data lines it returns
+	client := &http.Client{}
+	fetch := func(path string) (time.Duration, bool) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			return 0, false
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				atomic.AddInt64(&failures, 1)
+			}
+			return 0, false
+		}
+		defer resp.Body.Close()
+
+		var lines int64
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "data:") {
+				lines++
+			}
+		}
+		if ctx.Err() != nil {
+			return 0, false
+		}
+
+		atomic.AddInt64(&requests, 1)
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			atomic.AddInt64(&limited, 1)
+			return 0, false
+		case resp.StatusCode != http.StatusOK:
+			atomic.AddInt64(&failures, 1)
+			return 0, false
+		}
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+			atomic.AddInt64(&events, lines)
+		}
+		return time.Since(start), true
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for n := worker; ctx.Err() == nil; n++ {
+				if latency, ok := fetch(benchScans[n%len(benchScans)]); ok {
+					mu.Lock()
+					scanLatencies = append(scanLatencies, latency)
+					mu.Unlock()
+				}
+			}
+		}(i)
+	}
+
+	// Stats and job list polling, as the web interface does while scanning
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for n := 0; ; n++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			path := "/api/stats"
+			if n%2 == 1 {
+				path = "/api/jobs"
+			}
+			if latency, ok := fetch(path); ok {
+				mu.Lock()
+				pollLatencies = append(pollLatencies, latency)
+				mu.Unlock()
+			}
+		}
+	}()
+
+	report := BenchReport{
+		Version:     version,
+		Time:        time.Now().UTC(),
+		Duration:    opts.Duration.String(),
+		Concurrency: opts.Concurrency,
+	}
+	ticker := time.NewTicker(250 * time.Millisecond)
+sampling:
+	for {
+		if n := runtime.NumGoroutine(); n > report.PeakGoroutines {
+			report.PeakGoroutines = n
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		if mem.HeapAlloc > report.PeakHeapBytes {
+			report.PeakHeapBytes = mem.HeapAlloc
+		}
+		select {
+		case <-ctx.Done():
+			break sampling
+		case <-ticker.C:
+		}
+	}
+	ticker.Stop()
+	wg.Wait()
+
+	// Let handlers still unwinding from the deadline finish quietly
+	server.Close()
+
+	seconds := opts.Duration.Seconds()
+	report.Requests = requests
+	report.RequestsPerSec = float64(requests) / seconds
+	report.SSEEvents = events
+	report.SSEEventsPerSec = float64(events) / seconds
+	report.RateLimited = limited
+	report.Errors = failures
+	report.ScanP95MS = percentileMS(scanLatencies, 95)
+	report.PollP95MS = percentileMS(pollLatencies, 95)
+
+	log.SetOutput(logOutput)
+	if opts.Output != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(opts.Output, append(data, '\n'), 0644); err != nil {
+			return false, err
+		}
+	}
+
+	var baseline *BenchReport
+	if opts.Baseline != "" {
+		data, err := os.ReadFile(opts.Baseline)
+		if err != nil {
+			return false, err
+		}
+		baseline = &BenchReport{}
+		if err := json.Unmarshal(data, baseline); err != nil {
+			return false, fmt.Errorf("invalid baseline %s: %w", opts.Baseline, err)
+		}
+	}
+	return printBenchReport(report, baseline, opts.Threshold), nil
+}
+
+// printBenchReport prints report next to baseline, if any, and returns
+// false when a metric regressed by more than threshold percent
+func printBenchReport(report BenchReport, baseline *BenchReport, threshold float64) bool {
+	metrics := []struct {
+		name           string
+		value          float64
+		baseline       float64
+		higherIsBetter bool
+	}{
+		{"requests/sec", report.RequestsPerSec, 0, true},
+		{"SSE events/sec", report.SSEEventsPerSec, 0, true},
+		{"scan p95 (ms)", report.ScanP95MS, 0, false},
+		{"poll p95 (ms)", report.PollP95MS, 0, false},
+		{"peak goroutines", float64(report.PeakGoroutines), 0, false},
+		{"peak heap (MB)", float64(report.PeakHeapBytes) / (1 << 20), 0, false},
+	}
+	if baseline != nil {
+		metrics[0].baseline = baseline.RequestsPerSec
+		metrics[1].baseline = baseline.SSEEventsPerSec
+		metrics[2].baseline = baseline.ScanP95MS
+		metrics[3].baseline = baseline.PollP95MS
+		metrics[4].baseline = float64(baseline.PeakGoroutines)
+		metrics[5].baseline = float64(baseline.PeakHeapBytes) / (1 << 20)
+	}
+
+	fmt.Printf("Benchmark: %s, %d clients, %d requests (%d rate limited, %d errors), %d SSE events\n",
+		report.Duration, report.Concurrency, report.Requests, report.RateLimited, report.Errors, report.SSEEvents)
+	if baseline != nil && (baseline.Concurrency != report.Concurrency || baseline.Duration != report.Duration) {
+		fmt.Printf("  warning: baseline ran %s with %d clients, figures may not be comparable\n", baseline.Duration, baseline.Concurrency)
+	}
+
+	passed := true
+	for _, m := range metrics {
+		if baseline == nil || m.baseline == 0 {
+			fmt.Printf("  %-16s %10.1f\n", m.name, m.value)
+			continue
+		}
+		change := (m.value - m.baseline) / m.baseline * 100
+		regressed := change < -threshold
+		if !m.higherIsBetter {
+			regressed = change > threshold
+		}
+		verdict := "ok"
+		if regressed {
+			verdict = "REGRESSION"
+			passed = false
+		}
+		fmt.Printf("  %-16s %10.1f   baseline %10.1f  %+6.1f%%  %s\n", m.name, m.value, m.baseline, change, verdict)
+	}
+	return passed
+}
+
+// percentileMS returns the p-th percentile of latencies in milliseconds
+func percentileMS(latencies []time.Duration, p float64) float64 {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(float64(len(sorted))*p/100+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return float64(sorted[index].Microseconds()) / 1000
+}
+
+// Health check function for containers
+func performHealthCheck() error {
+	// Create a timeout context for the health check
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Determine the port to check
+	port := getEnvString("PORT", "8080")
+	healthURL := fmt.Sprintf("http://localhost:%s/health", port)
+
+	// Create HTTP request
+	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create health check request: %w", err)
+	}
+
+	// Create HTTP client with timeout
+	client := &http.Client{
+		Timeout: 3 * time.Second,
+	}
+
+	// Perform the request
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("health check failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Additional checks - verify DNS resolver is working
+	if defaultResolver() != nil && !passiveOnly {
+		testCtx, testCancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer testCancel()
+
+		_, err := defaultResolver().LookupHost(testCtx, "google.com")
+		if err != nil {
+			return fmt.Errorf("DNS resolver health check failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Version handler for API endpoint
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	versionInfo := map[string]interface{}{
+		"version":    version,
+		"build_time": buildTime,
+		"git_commit": gitCommit,
+		"go_version": runtime.Version(),
+		"platform":   fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+		"uptime":     time.Since(stats.StartTime).String(),
+		"start_time": stats.StartTime,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionInfo)
+}
+
+// JobSummary is a job as listed by /api/jobs: everything but its results
+// and per-host details, which stay behind /api/jobs/<id>
+type JobSummary struct {
+	ID               string         `json:"id"`
+	Target           string         `json:"target"`
+	Targets          []string       `json:"targets,omitempty"`
+	Sources          []string       `json:"sources"`
+	Status           string         `json:"status"`
+	StartTime        time.Time      `json:"start_time"`
+	EndTime          time.Time      `json:"end_time,omitzero"`
+	Duration         time.Duration  `json:"duration_ns"` // so far, while running
+	ParentID         string         `json:"parent_id,omitempty"`
+	Results          int            `json:"results"`
+	ResultsBySource  map[string]int `json:"results_by_source"`
+	UniqueHosts      int            `json:"unique_hosts"`
+	UniqueSubdomains int            `json:"unique_subdomains"`
+}
+
+func (j *Job) Summary() JobSummary {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	summary := JobSummary{
+		ID:               j.ID,
+		Target:           j.Target,
+		Targets:          j.Targets,
+		Sources:          j.Sources,
+		Status:           j.Status,
+		StartTime:        j.StartTime,
+		EndTime:          j.EndTime,
+		Duration:         j.elapsed(),
+		ParentID:         j.ParentID,
+		ResultsBySource:  make(map[string]int, len(j.Results)),
+		UniqueHosts:      j.UniqueHosts,
+		UniqueSubdomains: j.UniqueSubdomains,
+	}
+	for source, results := range j.Results {
+		summary.ResultsBySource[source] = len(results)
+		summary.Results += len(results)
+	}
+	return summary
+}
+
+// jobSortKeys are the sort= keys of /api/jobs; a leading - reverses one.
+// Ties go by job ID.
+var jobSortKeys = map[string]func(a, b JobSummary) int{
+	"start_time":   func(a, b JobSummary) int { return a.StartTime.Compare(b.StartTime) },
+	"end_time":     func(a, b JobSummary) int { return a.EndTime.Compare(b.EndTime) },
+	"target":       func(a, b JobSummary) int { return strings.Compare(a.Target, b.Target) },
+	"status":       func(a, b JobSummary) int { return strings.Compare(a.Status, b.Status) },
+	"results":      func(a, b JobSummary) int { return cmp.Compare(a.Results, b.Results) },
+	"unique_hosts": func(a, b JobSummary) int { return cmp.Compare(a.UniqueHosts, b.UniqueHosts) },
+}
+
+// Page sizes for /api/jobs
+const (
+	defaultJobsPage = 50
+	maxJobsPage     = 1000
+)
+
+// jobsHandler lists job summaries, filtered by status=, target= and
+// parent=, ordered by sort= and paged by limit= and offset=. full=1 lists
+// the complete jobs as a bare array, as the endpoint used to; it is kept
+// for one release.
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	parent := query.Get("parent")
+	statuses := splitList(query.Get("status"))
+	target := query.Get("target")
+	if target != "" {
+		target = targetKey(target)
+	}
+	full := query.Get("full") == "1"
+
+	sortKey := cmp.Or(query.Get("sort"), "start_time")
+	compare, ok := jobSortKeys[strings.TrimPrefix(sortKey, "-")]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown sort key %q; use one of %s, optionally prefixed with -",
+			sortKey, strings.Join(slices.Sorted(maps.Keys(jobSortKeys)), ", ")), http.StatusBadRequest)
+		return
+	}
+	descending := strings.HasPrefix(sortKey, "-")
+
+	// Legacy full listings are unpaged unless limit= is given
+	limit := defaultJobsPage
+	if full {
+		limit = 0
+	}
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxJobsPage)
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	type listedJob struct {
+		summary JobSummary
+		job     *Job
+	}
+	jobManager.mu.RLock()
+	listed := make([]listedJob, 0, len(jobManager.jobs))
+	for _, job := range jobManager.jobs {
+		summary := job.Summary()
+		status, _, _ := strings.Cut(summary.Status, ":")
+		if parent != "" && summary.ParentID != parent ||
+			target != "" && summary.Target != target && !slices.Contains(summary.Targets, target) ||
+			len(statuses) > 0 && !slices.Contains(statuses, status) {
+			continue
+		}
+		listed = append(listed, listedJob{summary, job})
+	}
+	jobManager.mu.RUnlock()
+	slices.SortFunc(listed, func(a, b listedJob) int {
+		order := compare(a.summary, b.summary)
+		if descending {
+			order = -order
+		}
+		return cmp.Or(order, strings.Compare(a.summary.ID, b.summary.ID))
+	})
+
+	total := len(listed)
+	page := listed[min(offset, total):]
+	if limit > 0 {
+		page = page[:min(limit, len(page))]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if full {
+		jobs := make([]json.RawMessage, 0, len(page))
+		for _, item := range page {
+			data, err := jobJSON(item.job)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			jobs = append(jobs, data)
+		}
+		json.NewEncoder(w).Encode(jobs)
+		return
+	}
+	summaries := make([]JobSummary, len(page))
+	for i, item := range page {
+		summaries[i] = item.summary
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobs":   summaries,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func jobDetailHandler(w http.ResponseWriter, r *http.Request) {
+	jobID := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+	action := ""
+	if slash := strings.Index(jobID, "/"); slash >= 0 {
+		jobID, action = jobID[:slash], jobID[slash+1:]
+	}
+
+	job, exists := lookupJob(jobID)
+	if !exists {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "":
+		if r.Method == http.MethodDelete {
+			deleteJobHandler(w, r, job)
+			return
+		}
+		if r.URL.Query().Get("signed") == "true" {
+			signedJobHandler(w, job)
+			return
+		}
+	case "bundle":
+		jobBundleHandler(w, r, job)
+		return
+	case "rerun":
+		rerunJobHandler(w, r, job)
+		return
+	case "abort":
+		abortJobHandler(w, r, job)
+		return
+	case "candidate":
+		candidateHandler(w, r, job)
+		return
+	case "candidates":
+		candidateLogHandler(w, r, job)
+		return
+	case "results":
+		jobResultsHandler(w, r, job)
+		return
+	case "export":
+		jobExportHandler(w, r, job)
+		return
+	case "hosts":
+		jobHostsHandler(w, r, job)
+		return
+	case "stream":
+		jobStreamHandler(w, r, job)
+		return
+	case "favicons":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job.FaviconGroups())
+		return
+	case "bodies":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job.BodyGroups())
+		return
+	case "security":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job.SecuritySummary())
+		return
+	default:
+		http.Error(w, "unknown job action", http.StatusNotFound)
+		return
+	}
+
+	data, err := jobJSON(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+// resultOrder is a result's place in listings and exports: registrable
+// domain, then the host label by label from the root, then source. The
+// timestamp only breaks ties, so the order is the same for every download
+// of a job and does not depend on when results arrived.
+type resultOrder struct {
+	Domain string `json:"d"`
+	Host   string `json:"h"`
+	Source string `json:"s"`
+	Time   int64  `json:"t"`
+}
+
+func orderOf(source string, result Result) resultOrder {
+	host := strings.ToLower(result.Host)
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		domain = host
+	}
+	return resultOrder{Domain: domain, Host: host, Source: source, Time: result.Timestamp.UnixNano()}
+}
+
+func (a resultOrder) compare(b resultOrder) int {
+	return cmp.Or(
+		strings.Compare(a.Domain, b.Domain),
+		canonicalNameCompare(a.Host, b.Host),
+		strings.Compare(a.Source, b.Source),
+		cmp.Compare(a.Time, b.Time),
+	)
+}
+
+// cursor encodes the order as an opaque pagination cursor. Resuming after
+// a sort key rather than an offset keeps pages stable while results keep
+// arriving: nothing already listed comes back, and nothing that existed
+// when paging began is skipped.
+func (a resultOrder) cursor() string {
+	data, _ := json.Marshal(a)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseResultCursor(cursor string) (resultOrder, error) {
+	var order resultOrder
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &order)
+	}
+	if err != nil {
+		return order, errors.New("invalid cursor")
+	}
+	return order, nil
+}
+
+// orderedResult is a result with its sort key
+type orderedResult struct {
+	order  resultOrder
+	result Result
+}
+
+// sortedResults returns the job's results across all sources in canonical
+// order. The caller holds the job's lock.
+func (j *Job) sortedResults() []orderedResult {
+	var all []orderedResult
+	for source, results := range j.Results {
+		for _, result := range results {
+			all = append(all, orderedResult{orderOf(source, result), result})
+		}
+	}
+	slices.SortFunc(all, func(a, b orderedResult) int { return a.order.compare(b.order) })
+	return all
+}
+
+// sortResults puts each source's results in canonical order, so exports
+// of the same job are byte-identical. The caller holds the job's write
+// lock.
+func (j *Job) sortResults() {
+	if j.resultsSorted {
+		return
+	}
+	for source, results := range j.Results {
+		ordered := make([]orderedResult, len(results))
+		for i, result := range results {
+			ordered[i] = orderedResult{orderOf(source, result), result}
+		}
+		slices.SortFunc(ordered, func(a, b orderedResult) int { return a.order.compare(b.order) })
+		for i := range ordered {
+			results[i] = ordered[i].result
+		}
+	}
+	j.resultsSorted = true
+	j.sorts++
+}
+
+// Page sizes for /api/jobs/<id>/results
+const (
+	defaultResultsPage = 100
+	maxResultsPage     = 1000
+)
+
+// jobResultsHandler serves /api/jobs/<id>/results: every source's results
+// in one canonically ordered list, limit at a time, continuing after the
+// after= cursor of the previous page
+func jobResultsHandler(w http.ResponseWriter, r *http.Request, job *Job) {
+	limit := defaultResultsPage
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxResultsPage)
+	}
+	var after *resultOrder
+	if value := r.URL.Query().Get("after"); value != "" {
+		order, err := parseResultCursor(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		after = &order
+	}
+	// class= keeps the hosts whose probed page is in one of the classes
+	classes := splitList(r.URL.Query().Get("class"))
+	for _, class := range classes {
+		switch class {
+		case pageClassApp, pageClassDefault, pageClassParked, pageClassError:
+		default:
+			http.Error(w, fmt.Sprintf("unknown class %q", class), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// wildcard=false drops the hosts that answered like the catch-all
+	hideWildcard := r.URL.Query().Get("wildcard") == "false"
+
+	job.mu.RLock()
+	all := job.sortedResults()
+	pageClasses := maps.Clone(job.PageClasses)
+	wildcardHosts := maps.Clone(job.WildcardHosts)
+	availability := maps.Clone(job.Availability)
+	job.mu.RUnlock()
+	if len(classes) > 0 || hideWildcard {
+		all = slices.DeleteFunc(all, func(item orderedResult) bool {
+			host := strings.ToLower(item.result.Host)
+			return len(classes) > 0 && !slices.Contains(classes, pageClasses[host]) ||
+				hideWildcard && wildcardHosts[host]
+		})
+	}
+
+	start := 0
+	if after != nil {
+		start, _ = slices.BinarySearchFunc(all, *after, func(item orderedResult, target resultOrder) int {
+			if item.order.compare(target) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+	page := all[start:min(start+limit, len(all))]
+
+	results := make([]Result, len(page))
+	for i, item := range page {
+		results[i] = item.result
+		results[i].Source = item.order.Source
+		results[i].PageClass = pageClasses[strings.ToLower(item.result.Host)]
+		results[i].WildcardResponse = wildcardHosts[strings.ToLower(item.result.Host)]
+		results[i].Availability = availability[strings.ToLower(item.result.Host)]
+	}
+	next := ""
+	if start+len(page) < len(all) {
+		next = page[len(page)-1].order.cursor()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"job":     job.ID,
+		"results": results,
+		"next":    next,
+	})
+}
+
+// resultWalker reads a job's results in canonical order a chunk at a time,
+// holding the job's read lock only while it reads a chunk. Results are read
+// in place, so a re-sort between chunks (a running job's new results, then
+// a listing) is noticed through Job.sorts; the walk then sorts again and
+// resumes after the last result it returned.
+type resultWalker struct {
+	job     *Job
+	sorts   int
+	sources []string
+	next    []int // position in each source's results
+	last    *resultOrder
+}
+
+func newResultWalker(job *Job) *resultWalker {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.sortResults()
+	sources := slices.Sorted(maps.Keys(job.Results))
+	return &resultWalker{job: job, sorts: job.sorts, sources: sources, next: make([]int, len(sources))}
+}
+
+// chunk returns up to n more results, none when the walk is done
+func (rw *resultWalker) chunk(n int) []orderedResult {
+	rw.job.mu.RLock()
+	if rw.job.sorts != rw.sorts {
+		rw.job.mu.RUnlock()
+		rw.resume()
+		rw.job.mu.RLock()
+	}
+	defer rw.job.mu.RUnlock()
+
+	heads := make([]*orderedResult, len(rw.sources))
+	var out []orderedResult
+	for len(out) < n {
+		best := -1
+		for i, source := range rw.sources {
+			results := rw.job.Results[source]
+			if rw.next[i] >= len(results) {
+				continue
+			}
+			if heads[i] == nil {
+				heads[i] = &orderedResult{orderOf(source, results[rw.next[i]]), results[rw.next[i]]}
+			}
+			if best < 0 || heads[i].order.compare(heads[best].order) < 0 {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		out = append(out, *heads[best])
+		heads[best] = nil
+		rw.next[best]++
+	}
+	if len(out) > 0 {
+		rw.last = &out[len(out)-1].order
+	}
+	return out
+}
+
+// resume sorts the job's results again and moves every position past the
+// last result returned
+func (rw *resultWalker) resume() {
+	rw.job.mu.Lock()
+	defer rw.job.mu.Unlock()
+	rw.job.sortResults()
+	rw.sorts = rw.job.sorts
+	rw.sources = slices.Sorted(maps.Keys(rw.job.Results))
+	rw.next = make([]int, len(rw.sources))
+	if rw.last == nil {
+		return
+	}
+	for i, source := range rw.sources {
+		rw.next[i], _ = slices.BinarySearchFunc(rw.job.Results[source], *rw.last, func(result Result, target resultOrder) int {
+			if orderOf(source, result).compare(target) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+}
+
+// Results read per lock by /api/jobs/<id>/export
+const exportChunkSize = 500
+
+// exportWriter writes one export format
+type exportWriter interface {
+	Write(result Result) error
+	// Flush writes out what is buffered, after each chunk
+	Flush() error
+	Close() error
+}
+
+type csvExportWriter struct{ w *csv.Writer }
+
+func (e *csvExportWriter) Write(result Result) error {
+	ips := make([]string, len(result.IPs))
+	for i, ip := range result.IPs {
+		ips[i] = ip.Address
+	}
+	row := []string{
+		result.Host,
+		result.Source,
+		result.Status,
+		strings.Join(ips, " "),
+		result.Title,
+		result.URL,
+		result.Timestamp.UTC().Format(time.RFC3339),
+		"", "", "", "", "", "",
+	}
+	if a := result.Availability; a != nil {
+		copy(row[7:], []string{
+			strconv.Itoa(a.Taken),
+			strconv.Itoa(a.Failures),
+			strconv.FormatInt(a.MinMS, 10),
+			strconv.FormatInt(a.MedianMS, 10),
+			strconv.FormatInt(a.MaxMS, 10),
+			strconv.FormatBool(a.Flapping),
+		})
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvExportWriter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExportWriter) Close() error { return e.Flush() }
+
+type ndjsonExportWriter struct{ enc *json.Encoder }
+
+func (e *ndjsonExportWriter) Write(result Result) error { return e.enc.Encode(result) }
+func (e *ndjsonExportWriter) Flush() error              { return nil }
+func (e *ndjsonExportWriter) Close() error              { return nil }
+
+// jsonExportWriter writes one JSON array, an element at a time
+type jsonExportWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (e *jsonExportWriter) Write(result Result) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if !e.written {
+		separator = "[\n"
+		e.written = true
+	}
+	_, err = io.WriteString(e.w, separator+string(data))
+	return err
+}
+
+func (e *jsonExportWriter) Flush() error { return nil }
+
+func (e *jsonExportWriter) Close() error {
+	closing := "\n]\n"
+	if !e.written {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(e.w, closing)
+	return err
+}
+
+// mergeHostResult folds another result for the same host into merged: the
+// sources that found the host, the earliest timestamp, and the first
+// addresses, title and URL any of them had
+func mergeHostResult(merged *Result, result Result) {
+	if !slices.Contains(strings.Split(merged.Source, ","), result.Source) {
+		merged.Source += "," + result.Source
+	}
+	if result.Timestamp.Before(merged.Timestamp) {
+		merged.Timestamp = result.Timestamp
+	}
+	if len(merged.IPs) == 0 && len(result.IPs) > 0 {
+		merged.Status = result.Status
+		merged.IPs = result.IPs
+		merged.CNAME = result.CNAME
+		merged.CNAMEChain = result.CNAMEChain
+	}
+	merged.Title = cmp.Or(merged.Title, result.Title)
+	merged.URL = cmp.Or(merged.URL, result.URL)
+}
+
+// jobExportHandler serves /api/jobs/<id>/export?format=csv|ndjson|json:
+// the job's results deduplicated by host, in canonical order. Each row
+// lists every source that found its host, and its availability when a
+// probe for the job took samples. Results are streamed a chunk at
+// a time rather than built up in memory.
+func jobExportHandler(w http.ResponseWriter, r *http.Request, job *Job) {
+	format := cmp.Or(r.URL.Query().Get("format"), "json")
+	var out exportWriter
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Write([]string{"host", "source", "status", "ips", "title", "url", "timestamp",
+			"samples", "sample_failures", "min_ms", "median_ms", "max_ms", "flapping"})
+		out = &csvExportWriter{csvWriter}
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		out = &ndjsonExportWriter{json.NewEncoder(w)}
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		out = &jsonExportWriter{w: w}
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q; use csv, ndjson or json", format), http.StatusBadRequest)
+		return
+	}
+	job.mu.RLock()
+	filename := fmt.Sprintf("%s-%s.%s", job.Target, job.StartTime.UTC().Format("2006-01-02"), format)
+	availability := maps.Clone(job.Availability)
+	job.mu.RUnlock()
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	flusher, _ := w.(http.Flusher)
+	walker := newResultWalker(job)
+	var pending *Result
+	for {
+		chunk := walker.chunk(exportChunkSize)
+		for _, item := range chunk {
+			result := item.result
+			result.Source = item.order.Source
+			if pending != nil && strings.EqualFold(pending.Host, result.Host) {
+				mergeHostResult(pending, result)
+				continue
+			}
+			if pending != nil {
+				if err := out.Write(*pending); err != nil {
+					return
+				}
+			}
+			result.Availability = availability[strings.ToLower(result.Host)]
+			pending = &result
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		if err := out.Flush(); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if pending != nil {
+		if err := out.Write(*pending); err != nil {
+			return
+		}
+	}
+	out.Close()
+}
+
+// RerunRequest is a partial override merged onto the original job's options
+type RerunRequest struct {
+	AddSources    []string          `json:"add_sources"`
+	RemoveSources []string          `json:"remove_sources"`
+	Options       map[string]string `json:"options"`
+}
+
+// rerunJobHandler starts the original job's sources again with the override
+// applied, as one combined scan in the background like POST /api/scan. The
+// new job links back to the original via ParentID and uses it as its diff
+// baseline; the response carries its ID.
+func rerunJobHandler(w http.ResponseWriter, r *http.Request, parent *Job) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if rejectDuringMaintenance(w) {
+		return
+	}
+
+	var override RerunRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&override); err != nil {
+			http.Error(w, fmt.Sprintf("invalid rerun request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if parent.Targets != nil {
+		http.Error(w, "a multi-target scan is rerun through its children's jobs", http.StatusBadRequest)
+		return
+	}
+
+	parent.mu.RLock()
+	options := url.Values{}
+	for key, value := range parent.Options {
+		options.Set(key, value)
+	}
+	sources := append([]string(nil), parent.Sources...)
+	parent.mu.RUnlock()
+
+	var changes []string
+	for _, source := range override.AddSources {
+		if _, ok := lookupSource(source); !ok {
+			writeUnknownSource(w, source)
+			return
+		}
+		if !containsString(sources, source) {
+			sources = append(sources, source)
+			changes = append(changes, "+"+source)
+		}
+	}
+	for _, source := range override.RemoveSources {
+		kept := sources[:0]
+		for _, existing := range sources {
+			if existing != source {
+				kept = append(kept, existing)
+			}
+		}
+		if len(kept) != len(sources) {
+			changes = append(changes, "-"+source)
+		}
+		sources = kept
+	}
+
+	keys := make([]string, 0, len(override.Options))
+	for key := range override.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "target" || key == "rerun_of" || key == "rerun_changes" {
+			continue
+		}
+		options.Set(key, override.Options[key])
+		changes = append(changes, fmt.Sprintf("%s=%s", key, override.Options[key]))
+	}
+
+	if len(sources) == 0 {
+		http.Error(w, "rerun has no sources left", http.StatusBadRequest)
+		return
+	}
+
+	// The rerun belongs to whoever asked for it, not the parent's owner
+	owner := override.Options["owner"]
+	if owner == "" {
+		owner = scanOwner(r)
+	}
+	options.Set("owner", owner)
+	options.Set("target", parent.Target)
+	options.Set("sources", strings.Join(sources, ","))
+	options.Set("rerun_of", parent.ID)
+	if len(changes) > 0 {
+		options.Set("rerun_changes", strings.Join(changes, ","))
+	}
+
+	// Validated, locked and run as if submitted to POST /api/scan
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = options.Encode()
+	scan, ok := prepareScan(w, r)
+	if !ok {
+		return
+	}
+	job, ok := startScan(w, r, scan)
+	if !ok {
+		return
+	}
+
+	log.Printf("Re-running job %s as %s with %v", parent.ID, job.ID, changes)
+	auditLog(r, "scan_submitted", map[string]interface{}{
+		"job":      job.ID,
+		"target":   scan.target,
+		"sources":  scan.names,
+		"rerun_of": parent.ID,
+	})
+	writeScanAccepted(w, job, map[string]interface{}{
+		"rerun_of": parent.ID,
+		"target":   scan.target,
+		"sources":  scan.names,
+		"changes":  changes,
+	})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated option into its non-empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	target := targetKey(r.URL.Query().Get("target"))
+
+	jobManager.mu.RLock()
+	defer jobManager.mu.RUnlock()
+
+	activeJobs := make([]*Job, 0)
+	for _, job := range jobManager.jobs {
+		if job.Target == target && job.Status == "running" {
+			activeJobs = append(activeJobs, job)
+		}
+	}
+
+	status := map[string]interface{}{
+		"target":      target,
+		"active_jobs": len(activeJobs),
+		"jobs":        activeJobs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func abortHandler(w http.ResponseWriter, r *http.Request) {
+	target := targetKey(r.URL.Query().Get("target"))
+
+	// Ending a job waits for its post-processors, so not under the lock
+	cancelled := 0
+	for _, job := range managedJobs() {
+		if job.Target == target && abortJob(job, scanOwner(r)) {
+			cancelled++
+		}
+	}
+	cancelled += probeRuns.abort(target)
+
+	log.Printf("Cancelled %d jobs for target: %s", cancelled, target)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// abortJob cancels a running job's work and marks it cancelled by by,
+// reporting whether it was still running
+func abortJob(job *Job, by string) bool {
+	job.mu.RLock()
+	cancel, running := job.Cancel, job.EndTime.IsZero()
+	job.mu.RUnlock()
+	if !running {
+		return false
+	}
+	cancel()
+	if !job.end("cancelled") {
+		return false
+	}
+	job.mu.Lock()
+	job.AbortedBy = by
+	job.AbortedAt = job.EndTime
+	job.mu.Unlock()
+	return true
+}
+
+// abortJobHandler serves POST /api/jobs/<id>/abort, which cancels that
+// job alone
+func abortJobHandler(w http.ResponseWriter, r *http.Request, job *Job) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !abortJob(job, scanOwner(r)) {
+		http.Error(w, "job is not running", http.StatusConflict)
+		return
+	}
+	log.Printf("Cancelled job %s", job.ID)
+
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"job":        job.ID,
+		"status":     job.Status,
+		"aborted_by": job.AbortedBy,
+		"aborted_at": job.AbortedAt,
+	})
+}
+
+// JobStore keeps jobs across restarts. Jobs are stored whole, keyed by ID.
+type JobStore interface {
+	// Save writes jobs in one batch, replacing earlier versions
+	Save(jobs []*Job) error
+	// Load returns a stored job, or nil if there is none with that ID
+	Load(id string) (*Job, error)
+	// All returns every stored job
+	All() ([]*Job, error)
+	Delete(ids ...string) error
+	// Sizes returns the size of each stored job in bytes
+	Sizes() (map[string]int64, error)
+	// Compact rewrites the store without the space deleted jobs left. The
+	// store is unavailable meanwhile.
+	Compact() (StoreCompaction, error)
+	Stats() (StoreStats, error)
+	Close() error
+}
+
+// StoreCompaction reports a compaction of the job store
+type StoreCompaction struct {
+	Time        time.Time     `json:"time"`
+	Duration    time.Duration `json:"duration_ns"`
+	BytesBefore int64         `json:"bytes_before"`
+	BytesAfter  int64         `json:"bytes_after"`
+	Reclaimed   int64         `json:"reclaimed_bytes"`
+	Evicted     int           `json:"evicted_jobs"` // to stay within JOB_STORE_MAX_BYTES
+}
+
+// StoreStats describes the job store for /api/stats
+type StoreStats struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"` // the file, free pages included
+	DataBytes int64  `json:"data_bytes"` // the stored jobs
+	Jobs      int    `json:"jobs"`
+
+	LastCompaction *StoreCompaction `json:"last_compaction"`
+	MaxBytes       int64            `json:"max_bytes,omitempty"`
+}
+
+// jobStore is the DATA_DIR job database; nil keeps jobs in memory only
+var jobStore JobStore
+
+// jobRecordVersion is the version of the stored job format. Bump it when a
+// change to Job needs stored jobs converted, and convert the older
+// versions in decodeJobRecord; fields that are only added or removed need
+// neither, JSON skips unknown fields and leaves missing ones zero.
+const jobRecordVersion = 1
+
+// jobRecord is how a job is stored: its detail JSON with a version
+type jobRecord struct {
+	Version int             `json:"version"`
+	Job     json.RawMessage `json:"job"`
+}
+
+func encodeJobRecord(job *Job) ([]byte, error) {
+	data, err := jobJSON(job)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jobRecord{Version: jobRecordVersion, Job: data})
+}
+
+func decodeJobRecord(data []byte) (*Job, error) {
+	var record jobRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	if record.Version < 1 || record.Version > jobRecordVersion {
+		return nil, fmt.Errorf("unsupported job record version %d", record.Version)
+	}
+	job := &Job{}
+	if err := json.Unmarshal(record.Job, job); err != nil {
+		return nil, err
+	}
+	job.restore()
+	return job, nil
+}
+
+// restore rebuilds what a stored job does not carry. A job stored while
+// running was cut short by a restart; it is marked interrupted, ending
+// with its last result.
+func (j *Job) restore() {
+	if j.Results == nil {
+		j.Results = make(map[string][]Result)
+	}
+	if j.SourceTimings == nil {
+		j.SourceTimings = make(map[string]*SourceTiming)
+	}
+	if j.Options == nil {
+		j.Options = make(map[string]string)
+	}
+
+	j.hosts = make(map[string]struct{})
+	last := j.StartTime
+	for _, results := range j.Results {
+		for _, result := range results {
+			j.hosts[result.Host] = struct{}{}
+			last = maxTime(last, result.Timestamp)
+			if len(result.IPs) == 0 {
+				continue
+			}
+			if j.hostProviders == nil {
+				j.hostProviders = make(map[string]string)
+			}
+			provider := cmp.Or(result.CloudProvider, "other")
+			if previous, seen := j.hostProviders[result.Host]; !seen || previous == "other" {
+				j.hostProviders[result.Host] = provider
+			}
+		}
+	}
+
+	if j.Status == "running" {
+		j.Status = "interrupted"
+		j.EndTime = last
+		j.Duration = j.EndTime.Sub(j.StartTime)
+		for _, timing := range j.SourceTimings {
+			if timing.End.IsZero() {
+				timing.End = j.EndTime
+				timing.Duration = timing.End.Sub(timing.Start)
+				timing.Outcome = "interrupted"
+			}
+		}
+	}
+}
+
+var boltJobsBucket = []byte("jobs")
+
+// boltJobStore is a JobStore in a bbolt database. mu is held for writing
+// only while Compact swaps the database file.
+type boltJobStore struct {
+	path string
+	mu   sync.RWMutex
+	db   *bbolt.DB
+}
+
+func openBoltJobStore(path string) (*boltJobStore, error) {
+	db, err := openBoltJobDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &boltJobStore{path: path, db: db}, nil
+}
+
+func openBoltJobDB(path string) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltJobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (s *boltJobStore) Save(jobs []*Job) error {
+	// Encode outside the transaction, which would otherwise wait on every
+	// job's lock
+	records := make(map[string][]byte, len(jobs))
+	for _, job := range jobs {
+		data, err := encodeJobRecord(job)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", job.ID, err)
+		}
+		records[job.ID] = data
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltJobsBucket)
+		for id, data := range records {
+			if err := bucket.Put([]byte(id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltJobStore) Load(id string) (*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var data []byte
+	s.db.View(func(tx *bbolt.Tx) error {
+		// Only valid during the transaction
+		data = bytes.Clone(tx.Bucket(boltJobsBucket).Get([]byte(id)))
+		return nil
+	})
+	if data == nil {
+		return nil, nil
+	}
+	return decodeJobRecord(data)
+}
+
+// All returns the stored jobs, skipping (and logging) any that cannot be
+// decoded so one bad record does not hide the rest
+func (s *boltJobStore) All() ([]*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var jobs []*Job
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltJobsBucket).ForEach(func(key, data []byte) error {
+			job, err := decodeJobRecord(data)
+			if err != nil {
+				log.Printf("Warning: skipping stored job %s: %v", key, err)
+				return nil
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+func (s *boltJobStore) Delete(ids ...string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltJobsBucket)
+		for _, id := range ids {
+			if err := bucket.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltJobStore) Sizes() (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sizes := make(map[string]int64)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltJobsBucket).ForEach(func(key, data []byte) error {
+			sizes[string(key)] = int64(len(key) + len(data))
+			return nil
+		})
+	})
+	return sizes, err
+}
+
+// Compact copies the live data into a new file next to the database,
+// which then replaces it. bbolt reuses the pages deleted jobs free but
+// never gives them back, so this is what shrinks the file.
+func (s *boltJobStore) Compact() (StoreCompaction, error) {
+	compaction := StoreCompaction{Time: time.Now()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return compaction, err
+	}
+	compaction.BytesBefore = info.Size()
+
+	compacted := s.path + ".compact"
+	os.Remove(compacted)
+	dst, err := bbolt.Open(compacted, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return compaction, err
+	}
+	if err := bbolt.Compact(dst, s.db, 0); err != nil {
+		dst.Close()
+		os.Remove(compacted)
+		return compaction, err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(compacted)
+		return compaction, err
+	}
+
+	if err := s.db.Close(); err != nil {
+		os.Remove(compacted)
+		return compaction, err
+	}
+	renameErr := os.Rename(compacted, s.path)
+	if renameErr != nil {
+		os.Remove(compacted)
+	}
+	// The original file is still there if the rename failed
+	db, err := openBoltJobDB(s.path)
+	if err != nil {
+		return compaction, fmt.Errorf("reopening %s: %w", s.path, err)
+	}
+	s.db = db
+	if renameErr != nil {
+		return compaction, renameErr
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		compaction.BytesAfter = info.Size()
+	}
+	compaction.Reclaimed = compaction.BytesBefore - compaction.BytesAfter
+	compaction.Duration = time.Since(compaction.Time)
+	return compaction, nil
+}
+
+func (s *boltJobStore) Stats() (StoreStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := StoreStats{Path: s.path}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return stats, err
+	}
+	stats.SizeBytes = info.Size()
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltJobsBucket).ForEach(func(key, data []byte) error {
+			stats.Jobs++
+			stats.DataBytes += int64(len(key) + len(data))
+			return nil
+		})
+	})
+	return stats, err
+}
+
+func (s *boltJobStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+// jobStoreFlushInterval is how long changed jobs wait to be written, so a
+// burst of changes to one job costs one write
+const jobStoreFlushInterval = 2 * time.Second
+
+// pendingJobs are the jobs changed since the last flush to jobStore
+var pendingJobs = struct {
+	jobs map[string]*Job
+	mu   sync.Mutex
+}{jobs: make(map[string]*Job)}
+
+// persistJob queues job to be written to the job store, if there is one
+func persistJob(job *Job) {
+	if job.shadow {
+		return
+	}
+	pendingJobs.mu.Lock()
+	if jobStore != nil {
+		pendingJobs.jobs[job.ID] = job
+	}
+	pendingJobs.mu.Unlock()
+}
+
+// jobStoreWrites orders job store writes against removals, so a flush
+// racing with a removal cannot write the removed job back. It also guards
+// jobStoreClosed, set once closeJobStore has closed the store.
+var (
+	jobStoreWrites sync.Mutex
+	jobStoreClosed bool
+)
+
+// flushJobs writes the queued jobs to the job store. Jobs that fail to
+// save stay queued for the next flush; jobs removed meanwhile are dropped.
+func flushJobs() error {
+	jobStoreWrites.Lock()
+	defer jobStoreWrites.Unlock()
+	if jobStoreClosed {
+		return nil
+	}
+
+	pendingJobs.mu.Lock()
+	queued := slices.Collect(maps.Values(pendingJobs.jobs))
+	clear(pendingJobs.jobs)
+	pendingJobs.mu.Unlock()
+
+	jobManager.mu.RLock()
+	jobs := slices.DeleteFunc(queued, func(job *Job) bool { return jobManager.jobs[job.ID] != job })
+	jobManager.mu.RUnlock()
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	err := jobStore.Save(jobs)
+	if err != nil {
+		pendingJobs.mu.Lock()
+		for _, job := range jobs {
+			if _, requeued := pendingJobs.jobs[job.ID]; !requeued {
+				pendingJobs.jobs[job.ID] = job
+			}
+		}
+		pendingJobs.mu.Unlock()
+	}
+	return err
+}
+
+// closeJobStore writes the jobs still queued and closes the job store.
+// Later flushes and removals leave the store alone.
+func closeJobStore() {
+	if jobStore == nil {
+		return
+	}
+	if err := flushJobs(); err != nil {
+		log.Printf("Warning: cannot save jobs: %v", err)
+	}
+
+	jobStoreWrites.Lock()
+	defer jobStoreWrites.Unlock()
+	if jobStoreClosed {
+		return
+	}
+	jobStoreClosed = true
+	if err := jobStore.Close(); err != nil {
+		log.Printf("Warning: cannot close job store: %v", err)
+	}
+}
+
+func jobStorePath() string {
+	return filepath.Join(currentConfig().DataDir, "jobs.db")
+}
+
+// initializeJobStore opens DATA_DIR/jobs.db, loads the jobs stored there
+// into the job manager and host index, and keeps writing changed jobs
+func initializeJobStore() {
+	cfg := currentConfig()
+	if cfg.DataDir == "" {
+		return
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0o700); err != nil {
+		log.Printf("Warning: cannot create data directory %s: %v", cfg.DataDir, err)
+		return
+	}
+	store, err := openBoltJobStore(jobStorePath())
+	if err != nil {
+		log.Printf("Warning: cannot open job store, jobs will not survive a restart: %v", err)
+		return
+	}
+	jobs, err := store.All()
+	if err != nil {
+		log.Printf("Warning: cannot read stored jobs: %v", err)
+	}
+	jobStore = store
+
+	// Oldest first, so each target's latest job is indexed last
+	slices.SortFunc(jobs, func(a, b *Job) int {
+		return cmp.Or(a.StartTime.Compare(b.StartTime), strings.Compare(a.ID, b.ID))
+	})
+	jobManager.mu.Lock()
+	for _, job := range jobs {
+		jobManager.jobs[job.ID] = job
+		hostIndex.StartJob(job.Target, job.ID)
+		for source, results := range job.Results {
+			for _, result := range results {
+				hostIndex.Record(job.Target, job.ID, source, result)
+			}
+		}
+	}
+	jobManager.mu.Unlock()
+	if len(jobs) > 0 {
+		log.Printf("Loaded %d stored jobs from %s", len(jobs), jobStorePath())
+	}
+
+	go func() {
+		for range time.Tick(jobStoreFlushInterval) {
+			if err := flushJobs(); err != nil {
+				log.Printf("Warning: cannot save jobs: %v", err)
+			}
+		}
+	}()
+	if cfg.Jobs.CompactInterval > 0 {
+		go func() {
+			for range time.Tick(cfg.Jobs.CompactInterval) {
+				compactJobStore()
+			}
+		}()
+	}
+}
+
+// lastCompaction is the job store's latest compaction, for /api/stats
+var lastCompaction struct {
+	mu         sync.Mutex
+	compaction *StoreCompaction
+}
+
+var errNoJobStore = errors.New("no job store: DATA_DIR is not set")
+
+// compactJobStore evicts jobs past JOB_STORE_MAX_BYTES and compacts the
+// job store. Flushes wait meanwhile, and changed jobs stay queued for the
+// next one, so scans go on writing through it.
+func compactJobStore() (StoreCompaction, error) {
+	if jobStore == nil {
+		return StoreCompaction{}, errNoJobStore
+	}
+	evicted := evictOversizeJobs(currentConfig().Jobs.StoreMaxBytes)
+
+	jobStoreWrites.Lock()
+	if jobStoreClosed {
+		jobStoreWrites.Unlock()
+		return StoreCompaction{}, errNoJobStore
+	}
+	compaction, err := jobStore.Compact()
+	jobStoreWrites.Unlock()
+	compaction.Evicted = evicted
+	if err != nil {
+		log.Printf("Warning: cannot compact job store: %v", err)
+		return compaction, err
+	}
+
+	lastCompaction.mu.Lock()
+	lastCompaction.compaction = &compaction
+	lastCompaction.mu.Unlock()
+	log.Printf("🧹 Compacted job store in %v: %d -> %d bytes", compaction.Duration.Round(time.Millisecond), compaction.BytesBefore, compaction.BytesAfter)
+	return compaction, nil
+}
+
+// evictOversizeJobs removes the earliest finished jobs while the stored
+// jobs take more than limit bytes, returning how many it removed. Running
+// jobs stay. The space they free is reused by later writes, and returned
+// to the file system by the next compaction.
+func evictOversizeJobs(limit int64) int {
+	if limit <= 0 || jobStore == nil {
+		return 0
+	}
+	sizes, err := jobStore.Sizes()
+	if err != nil {
+		log.Printf("Warning: cannot size stored jobs: %v", err)
+		return 0
+	}
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	if total <= limit {
+		return 0
+	}
+
+	type finishedJob struct {
+		id  string
+		end time.Time
+	}
+	var finished []finishedJob
+	jobManager.mu.RLock()
+	for id, job := range jobManager.jobs {
+		if _, stored := sizes[id]; !stored {
+			continue
+		}
+		job.mu.RLock()
+		end := job.EndTime
+		job.mu.RUnlock()
+		if !end.IsZero() {
+			finished = append(finished, finishedJob{id, end})
+		}
+	}
+	jobManager.mu.RUnlock()
+	slices.SortFunc(finished, func(a, b finishedJob) int {
+		return cmp.Or(a.end.Compare(b.end), strings.Compare(a.id, b.id))
+	})
+
+	var ids []string
+	for _, job := range finished {
+		if total <= limit {
+			break
+		}
+		ids = append(ids, job.id)
+		total -= sizes[job.id]
+	}
+	if len(ids) == 0 {
+		return 0
+	}
+	removeJobs(ids)
+	atomic.AddInt64(&stats.OversizeJobs, int64(len(ids)))
+	log.Printf("🧹 Evicted %d jobs to keep the job store within %d bytes", len(ids), limit)
+	return len(ids)
+}
+
+// jobStoreStats describes the job store for /api/stats, nil without one
+func jobStoreStats() *StoreStats {
+	if jobStore == nil {
+		return nil
+	}
+	stats, err := jobStore.Stats()
+	if err != nil {
+		log.Printf("Warning: cannot read job store stats: %v", err)
+		return nil
+	}
+	lastCompaction.mu.Lock()
+	stats.LastCompaction = lastCompaction.compaction
+	lastCompaction.mu.Unlock()
+	stats.MaxBytes = currentConfig().Jobs.StoreMaxBytes
+	return &stats
+}
+
+// compactHandler serves POST /api/admin/compact, which compacts the job
+// store now and reports what it reclaimed
+func compactHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	compaction, err := compactJobStore()
+	if errors.Is(err, errNoJobStore) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	auditLog(r, "store_compacted", map[string]interface{}{
+		"reclaimed_bytes": compaction.Reclaimed,
+		"evicted_jobs":    compaction.Evicted,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(compaction)
+}
+
+// jobRetentionInterval is how often finished jobs are checked against
+// JOB_RETENTION, MAX_JOBS and JOB_STORE_MAX_BYTES
+const jobRetentionInterval = time.Minute
+
+// initializeJobRetention evicts finished jobs past JOB_RETENTION, beyond
+// MAX_JOBS or over JOB_STORE_MAX_BYTES, now and every jobRetentionInterval
+func initializeJobRetention() {
+	evictJobs(currentConfig().Jobs, time.Now())
+	evictOversizeJobs(currentConfig().Jobs.StoreMaxBytes)
+	go func() {
+		for now := range time.Tick(jobRetentionInterval) {
+			limits := currentConfig().Jobs
+			evictJobs(limits, now)
+			evictOversizeJobs(limits.StoreMaxBytes)
+		}
+	}()
+}
+
+// evictJobs removes the finished jobs that ended more than Retention
+// before now, then the finished jobs that ended first while there are more
+// than MaxJobs. Running jobs stay, even past MaxJobs.
+func evictJobs(limits JobConfig, now time.Time) {
+	type finishedJob struct {
+		id  string
+		end time.Time
+	}
+
+	// Jobs do not return to running, so the candidates are still finished
+	// when the write lock is taken to remove them
+	jobManager.mu.RLock()
+	total := len(jobManager.jobs)
+	var finished []finishedJob
+	for id, job := range jobManager.jobs {
+		job.mu.RLock()
+		end := job.EndTime
+		job.mu.RUnlock()
+		if !end.IsZero() {
+			finished = append(finished, finishedJob{id, end})
+		}
+	}
+	jobManager.mu.RUnlock()
+	slices.SortFunc(finished, func(a, b finishedJob) int {
+		return cmp.Or(a.end.Compare(b.end), strings.Compare(a.id, b.id))
+	})
+
+	var expired, overLimit []string
+	for _, job := range finished {
+		switch {
+		case limits.Retention > 0 && now.Sub(job.end) > limits.Retention:
+			expired = append(expired, job.id)
+		case limits.MaxJobs > 0 && total > limits.MaxJobs:
+			overLimit = append(overLimit, job.id)
+		default:
+			continue
+		}
+		total--
+	}
+	if len(expired)+len(overLimit) == 0 {
+		return
+	}
+
+	removeJobs(append(expired, overLimit...))
+	atomic.AddInt64(&stats.ExpiredJobs, int64(len(expired)))
+	atomic.AddInt64(&stats.OverLimitJobs, int64(len(overLimit)))
+	log.Printf("🧹 Evicted %d jobs past JOB_RETENTION and %d beyond MAX_JOBS", len(expired), len(overLimit))
+}
+
+// removeJobs drops jobs from memory and from the job store
+func removeJobs(ids []string) {
+	jobStoreWrites.Lock()
+	defer jobStoreWrites.Unlock()
+
+	jobManager.mu.Lock()
+	for _, id := range ids {
+		delete(jobManager.jobs, id)
+	}
+	jobManager.mu.Unlock()
+
+	if jobStore == nil || jobStoreClosed {
+		return
+	}
+	pendingJobs.mu.Lock()
+	for _, id := range ids {
+		delete(pendingJobs.jobs, id)
+	}
+	pendingJobs.mu.Unlock()
+	if err := jobStore.Delete(ids...); err != nil {
+		log.Printf("Warning: cannot delete stored jobs: %v", err)
+	}
+}
+
+// deleteJobHandler serves DELETE /api/jobs/<id>. Running jobs have to be
+// aborted first.
+func deleteJobHandler(w http.ResponseWriter, r *http.Request, job *Job) {
+	job.mu.RLock()
+	running := job.EndTime.IsZero()
+	job.mu.RUnlock()
+	if running {
+		http.Error(w, "job is still running; abort it first", http.StatusConflict)
+		return
+	}
+
+	removeJobs([]string{job.ID})
+	atomic.AddInt64(&stats.DeletedJobs, 1)
+	auditLog(r, "job_deleted", map[string]interface{}{
+		"job":    job.ID,
+		"target": job.Target,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MaintenanceState is the operator kill switch. It is persisted under
+// DATA_DIR so a restart does not silently resume scanning.
+type MaintenanceState struct {
+	Enabled bool      `json:"enabled"`
+	Message string    `json:"message,omitempty"`
+	Action  string    `json:"action,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+	By      string    `json:"by,omitempty"`
+}
+
+// paused reports whether the state holds running jobs' work
+func (s MaintenanceState) paused() bool {
+	return s.Enabled && s.Action == "pause"
+}
+
+type MaintenanceSwitch struct {
+	state MaintenanceState
+	mu    sync.RWMutex
+
+	// While paused, resumed is open and pausedSince set; pausedTotal is
+	// the time spent in earlier pauses
+	resumed     chan struct{}
+	pausedSince time.Time
+	pausedTotal time.Duration
+}
+
+var maintenance = &MaintenanceSwitch{}
+
+// apply replaces the state, starting or ending a pause when it changes
+func (m *MaintenanceSwitch) apply(state MaintenanceState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch was, now := m.state.paused(), state.paused(); {
+	case now && !was:
+		m.resumed = make(chan struct{})
+		m.pausedSince = time.Now()
+	case was && !now:
+		close(m.resumed)
+		m.pausedTotal += time.Since(m.pausedSince)
+	}
+	m.state = state
+}
+
+// pausedFor returns the time spent paused since the process started,
+// which only grows. Work that is timed subtracts what it grew by.
+func (m *MaintenanceSwitch) pausedFor() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.state.paused() {
+		return m.pausedTotal + time.Since(m.pausedSince)
+	}
+	return m.pausedTotal
+}
+
+type maintenancePauseContextKey struct{}
+
+// withMaintenancePause marks the work done under ctx as a job's, which a
+// maintenance pause holds
+func withMaintenancePause(ctx context.Context) context.Context {
+	return context.WithValue(ctx, maintenancePauseContextKey{}, true)
+}
+
+// awaitResume blocks a job's work while maintenance is paused, until it is
+// resumed or ctx is done. Work that is not a job's never waits.
+func (m *MaintenanceSwitch) awaitResume(ctx context.Context) error {
+	if ctx.Value(maintenancePauseContextKey{}) == nil {
+		return nil
+	}
+	m.mu.RLock()
+	paused, resumed := m.state.paused(), m.resumed
+	m.mu.RUnlock()
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withPausableTimeout is context.WithTimeout for a job's work, whose
+// deadline moves back by the time maintenance held it paused
+func withPausableTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		paused := maintenance.pausedFor()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			}
+			// A pause still on is waited out before its time is owed
+			if maintenance.awaitResume(withMaintenancePause(ctx)) != nil {
+				return
+			}
+			now := maintenance.pausedFor()
+			if now == paused {
+				cancel()
+				return
+			}
+			timer.Reset(now - paused)
+			paused = now
+		}
+	}()
+	return ctx, cancel
+}
+
+func (m *MaintenanceSwitch) Get() MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+func (m *MaintenanceSwitch) Enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state.Enabled
+}
+
+// Set replaces the state and writes it to disk. The in-memory state is
+// updated even if persisting fails.
+func (m *MaintenanceSwitch) Set(state MaintenanceState) error {
+	cfg := currentConfig()
+	m.apply(state)
+
+	if cfg.DataDir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := maintenanceStatePath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func maintenanceStatePath() string {
+	cfg := currentConfig()
+	return filepath.Join(cfg.DataDir, "maintenance.json")
+}
+
+func initializeMaintenance() {
+	cfg := currentConfig()
+	if cfg.DataDir == "" {
+		return
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0o700); err != nil {
+		log.Printf("Warning: cannot create data directory %s: %v", cfg.DataDir, err)
+		return
+	}
+
+	data, err := os.ReadFile(maintenanceStatePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: cannot read maintenance state: %v", err)
+		}
+		return
+	}
+	var state MaintenanceState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Warning: ignoring corrupt maintenance state: %v", err)
+		return
+	}
+
+	maintenance.apply(state)
+	if state.Enabled {
+		log.Printf("⚠️ Maintenance mode is active since %s: %s", state.Since.Format(time.RFC3339), state.Message)
+	}
+}
+
+// rejectDuringMaintenance answers 503 with the operator's message and reports
+// whether the request was rejected
+func rejectDuringMaintenance(w http.ResponseWriter) bool {
+	state := maintenance.Get()
+	if !state.Enabled {
+		return false
+	}
+	message := state.Message
+	if message == "" {
+		message = "scanning is disabled for maintenance"
+	}
+	w.Header().Set("Retry-After", "300")
+	http.Error(w, "maintenance: "+message, http.StatusServiceUnavailable)
+	return true
+}
+
+// TargetLease is a cooperative lock on a target held by one owner while
+// their scans with active sources run. Several scans by the same owner share
+// one lease; it is released when the last of them finishes, by the owner or
+// an admin, or when it expires.
+type TargetLease struct {
+	Target   string    `json:"target"`
+	Owner    string    `json:"owner"`
+	Note     string    `json:"note,omitempty"`
+	JobIDs   []string  `json:"job_ids"`
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"`
+}
+
+// TargetLocks holds the current leases by target
+type TargetLocks struct {
+	leases map[string]*TargetLease
+	mu     sync.Mutex
+}
+
+var targetLocks = &TargetLocks{leases: make(map[string]*TargetLease)}
+
+// current returns target's unexpired lease. Callers must hold tl.mu.
+func (tl *TargetLocks) current(target string) *TargetLease {
+	lease, ok := tl.leases[target]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(lease.Expires) {
+		delete(tl.leases, target)
+		return nil
+	}
+	return lease
+}
+
+// Conflict returns a copy of target's lease if someone other than owner
+// holds it
+func (tl *TargetLocks) Conflict(target, owner string) (TargetLease, bool) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	lease := tl.current(target)
+	if lease == nil || lease.Owner == owner {
+		return TargetLease{}, false
+	}
+	return *lease, true
+}
+
+// Acquire takes or joins owner's lease on target. If another owner holds it
+// the held lease is returned with ok false, unless steal is set, in which
+// case the lease is taken over and the previous one returned as stolen.
+func (tl *TargetLocks) Acquire(target, owner, note string, ttl time.Duration, steal bool) (lease *TargetLease, stolen *TargetLease, held TargetLease, ok bool) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	now := time.Now()
+	existing := tl.current(target)
+	if existing != nil && existing.Owner == owner {
+		existing.Expires = now.Add(ttl)
+		if note != "" {
+			existing.Note = note
+		}
+		return existing, nil, TargetLease{}, true
+	}
+	if existing != nil && !steal {
+		return nil, nil, *existing, false
+	}
+
+	lease = &TargetLease{
+		Target:   target,
+		Owner:    owner,
+		Note:     note,
+		Acquired: now,
+		Expires:  now.Add(ttl),
+	}
+	tl.leases[target] = lease
+	return lease, existing, TargetLease{}, true
+}
+
+// AddJob records a job as holding lease
+func (tl *TargetLocks) AddJob(lease *TargetLease, jobID string) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	lease.JobIDs = append(lease.JobIDs, jobID)
+}
+
+// FinishJob drops a finished job from lease and releases the lease once no
+// job holds it. A lease that was stolen or released meanwhile is left alone.
+func (tl *TargetLocks) FinishJob(lease *TargetLease, jobID string) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	kept := lease.JobIDs[:0]
+	for _, id := range lease.JobIDs {
+		if id != jobID {
+			kept = append(kept, id)
+		}
+	}
+	lease.JobIDs = kept
+	if len(lease.JobIDs) == 0 && tl.leases[lease.Target] == lease {
+		delete(tl.leases, lease.Target)
+	}
+}
+
+// Release drops target's lease and returns it
+func (tl *TargetLocks) Release(target string) (TargetLease, bool) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	lease := tl.current(target)
+	if lease == nil {
+		return TargetLease{}, false
+	}
+	delete(tl.leases, target)
+	return *lease, true
+}
+
+// List returns copies of all unexpired leases ordered by target
+func (tl *TargetLocks) List() []TargetLease {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	leases := make([]TargetLease, 0, len(tl.leases))
+	for target := range tl.leases {
+		if lease := tl.current(target); lease != nil {
+			copied := *lease
+			copied.JobIDs = append([]string(nil), lease.JobIDs...)
+			leases = append(leases, copied)
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Target < leases[j].Target })
+	return leases
+}
+
+// scanOwner identifies who is scanning: the owner parameter, or the client
+// address when none is given
+func scanOwner(r *http.Request) string {
+	if owner := strings.TrimSpace(r.URL.Query().Get("owner")); owner != "" {
+		return owner
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return "anonymous"
+}
+
+// lockConflictMessage says who holds the lease that blocks a scan
+func lockConflictMessage(lease TargetLease) string {
+	return fmt.Sprintf("%s is locked by %s until %s; pass steal=true to take over",
+		lease.Target, lease.Owner, lease.Expires.Format(time.RFC3339))
+}
+
+// writeLockConflict answers 423 Locked with the lease that blocks the scan
+func writeLockConflict(w http.ResponseWriter, lease TargetLease) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusLocked)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": lockConflictMessage(lease),
+		"lock":  lease,
+	})
+}
+
+// lockTarget takes the target lease for a scan running entries, as
+// acquireTargetLock does, and answers 423 and returns false when another
+// owner holds it
+func lockTarget(w http.ResponseWriter, r *http.Request, target string, entries []sourceEntry) (attach func(job *Job) func(), ok bool) {
+	attach, conflict := acquireTargetLock(r, target, entries)
+	if conflict != nil {
+		writeLockConflict(w, *conflict)
+		return nil, false
+	}
+	return attach, true
+}
+
+// acquireTargetLock takes the target lease for a scan running entries when
+// target locks are enabled and any of them is active, or returns the lease
+// another owner holds. The returned attach records the scan's job on the
+// lease and returns the function to call when it ends.
+func acquireTargetLock(r *http.Request, target string, entries []sourceEntry) (attach func(job *Job) func(), conflict *TargetLease) {
+	noop := func(*Job) func() { return func() {} }
+	cfg := configFrom(r.Context())
+	if !cfg.Security.TargetLocks {
+		return noop, nil
+	}
+	active := false
+	for _, entry := range entries {
+		active = active || entry.active
+	}
+	if !active {
+		return noop, nil
+	}
+
+	query := r.URL.Query()
+	owner := scanOwner(r)
+	steal := query.Get("steal") == "true"
+	lease, stolen, held, ok := targetLocks.Acquire(strings.ToLower(target), owner, query.Get("note"), cfg.Security.TargetLockTTL, steal)
+	if !ok {
+		return nil, &held
+	}
+	if stolen != nil {
+		auditLog(r, "target_lock_stolen", map[string]interface{}{
+			"target":         lease.Target,
+			"owner":          owner,
+			"previous_owner": stolen.Owner,
+			"previous_jobs":  stolen.JobIDs,
+		})
+	}
+
+	return func(job *Job) func() {
+		job.mu.Lock()
+		job.Options["owner"] = owner
+		job.mu.Unlock()
+		targetLocks.AddJob(lease, job.ID)
+		return func() { targetLocks.FinishJob(lease, job.ID) }
+	}, nil
+}
+
+// targetsHandler lists targets with running jobs or locks (GET /api/targets)
+// and releases a lock early (DELETE /api/targets/{target}/lock), which only
+// the lease owner or an admin may do
+func targetsHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/targets"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		listTargets(w)
+		return
+	}
+
+	target, action, _ := strings.Cut(path, "/")
+	if action != "lock" {
+		http.Error(w, "unknown target action", http.StatusNotFound)
+		return
+	}
+	target = targetKey(target)
+	switch r.Method {
+	case http.MethodGet:
+		for _, lease := range targetLocks.List() {
+			if lease.Target == target {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(lease)
+				return
+			}
+		}
+		http.Error(w, "target is not locked", http.StatusNotFound)
+	case http.MethodDelete:
+		held, locked := targetLocks.Conflict(target, scanOwner(r))
+		if locked {
+			if status, _ := checkAdmin(r); status != 0 {
+				http.Error(w, fmt.Sprintf("lock is held by %s; only the owner or an admin may release it", held.Owner), http.StatusForbidden)
+				return
+			}
+		}
+		lease, ok := targetLocks.Release(target)
+		if !ok {
+			http.Error(w, "target is not locked", http.StatusNotFound)
+			return
+		}
+		auditLog(r, "target_lock_released", map[string]interface{}{
+			"target": target,
+			"owner":  lease.Owner,
+			"by":     scanOwner(r),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"released": lease})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// TargetSummary is one entry of /api/targets
+type TargetSummary struct {
+	Target      string       `json:"target"`
+	RunningJobs int          `json:"running_jobs"`
+	Lock        *TargetLease `json:"lock,omitempty"`
+}
+
+func listTargets(w http.ResponseWriter) {
+	byTarget := make(map[string]*TargetSummary)
+	summary := func(target string) *TargetSummary {
+		entry, ok := byTarget[target]
+		if !ok {
+			entry = &TargetSummary{Target: target}
+			byTarget[target] = entry
+		}
+		return entry
+	}
+
+	jobManager.mu.RLock()
+	for _, job := range jobManager.jobs {
+		job.mu.RLock()
+		running := job.Status == "running"
+		job.mu.RUnlock()
+		if running {
+			summary(strings.ToLower(job.Target)).RunningJobs++
+		}
+	}
+	jobManager.mu.RUnlock()
+	for _, lease := range targetLocks.List() {
+		summary(lease.Target).Lock = &lease
+	}
+
+	targets := make([]*TargetSummary, 0, len(byTarget))
+	for _, entry := range byTarget {
+		targets = append(targets, entry)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Target < targets[j].Target })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"targets": targets})
+}
+
+// HostIndex keeps what is known about every host across jobs, by target,
+// so one host can be described without going through every job's results
+type HostIndex struct {
+	targets map[string]*indexedTarget
+	asns    map[string][]ASNInfo // origins by address
+	pending map[string]bool      // addresses with an ASN lookup in flight
+	mu      sync.RWMutex
+}
+
+type indexedTarget struct {
+	latestJob string
+	hosts     map[string]*HostRecord
+}
+
+// Jobs listed per host; older ones drop off first
+const maxHostJobs = 50
+
+// HostRecord is the merged view of one host of a target
+type HostRecord struct {
+	Host      string                     `json:"host"`
+	Target    string                     `json:"target"`
+	FirstSeen time.Time                  `json:"first_seen"`
+	LastSeen  time.Time                  `json:"last_seen"`
+	Sources   map[string]*HostProvenance `json:"sources"`
+	Tags      []string                   `json:"tags,omitempty"`
+
+	// Latest lookup and probe outcome, nil until there has been one
+	Resolution *HostResolution `json:"resolution,omitempty"`
+	Probe      *HostProbe      `json:"probe,omitempty"`
+
+	firstJob string
+	jobs     []string // that reported the host, oldest first
+}
+
+// HostProvenance is when and how often one source reported a host, with
+// the evidence it gave last
+type HostProvenance struct {
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Results      int       `json:"results"`
+	Title        string    `json:"title,omitempty"`
+	EvidenceTime time.Time `json:"evidence_time,omitzero"`
+}
+
+// HostResolution is the latest result that said what a host resolves to.
+// TTLs are the time left on answers still in the resolver cache, in
+// seconds by record type.
+type HostResolution struct {
+	Status     string         `json:"status"`
+	IPs        []ResultIP     `json:"ips,omitempty"`
+	CNAME      string         `json:"cname,omitempty"`
+	CNAMEChain []string       `json:"cname_chain,omitempty"`
+	Rcode      string         `json:"rcode,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	TTLs       map[string]int `json:"ttls,omitempty"`
+	Source     string         `json:"source"`
+	Job        string         `json:"job"`
+	ObservedAt time.Time      `json:"observed_at"`
+}
+
+// HostProbe is the latest /api/probe response for a host
+type HostProbe struct {
+	ProbeResponse
+	URL        string    `json:"url"`
+	ObservedAt time.Time `json:"observed_at"`
+}
+
+var hostIndex = &HostIndex{
+	targets: make(map[string]*indexedTarget),
+	asns:    make(map[string][]ASNInfo),
+	pending: make(map[string]bool),
+}
+
+// target returns the index entry of target, creating it on first use.
+// Callers must hold hi.mu.
+func (hi *HostIndex) target(target string) *indexedTarget {
+	entry, ok := hi.targets[target]
+	if !ok {
+		entry = &indexedTarget{hosts: make(map[string]*HostRecord)}
+		hi.targets[target] = entry
+	}
+	return entry
+}
+
+// StartJob makes jobID the target's latest job, which host lifecycles are
+// judged against
+func (hi *HostIndex) StartJob(target, jobID string) {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	hi.target(strings.ToLower(target)).latestJob = jobID
+}
+
+// Tag adds tags to a host's record, for the post-processors' tags, which
+// come after the result
+func (hi *HostIndex) Tag(target, host string, tags []string) {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	record, ok := hi.target(strings.ToLower(target)).hosts[strings.ToLower(host)]
+	if !ok {
+		return
+	}
+	for _, tag := range tags {
+		if !containsString(record.Tags, tag) {
+			record.Tags = append(record.Tags, tag)
+		}
+	}
+}
+
+// Record merges a job's result into its host's record
+func (hi *HostIndex) Record(target, jobID, source string, result Result) {
+	target, host := strings.ToLower(target), strings.ToLower(result.Host)
+	if host == "" {
+		return
+	}
+	seen := result.Timestamp
+	if seen.IsZero() {
+		seen = time.Now()
+	}
+
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
 
-# HELP subdomain_scanner_subdomains_total Total number of subdomains discovered
-# TYPE subdomain_scanner_subdomains_total counter
-subdomain_scanner_subdomains_total %d
+	hosts := hi.target(target).hosts
+	record, ok := hosts[host]
+	if !ok {
+		record = &HostRecord{
+			Host:      host,
+			Target:    target,
+			FirstSeen: seen,
+			Sources:   make(map[string]*HostProvenance),
+			firstJob:  jobID,
+		}
+		hosts[host] = record
+	}
+	record.LastSeen = maxTime(record.LastSeen, seen)
+	if !containsString(record.jobs, jobID) {
+		record.jobs = append(record.jobs, jobID)
+		if len(record.jobs) > maxHostJobs {
+			record.jobs = record.jobs[len(record.jobs)-maxHostJobs:]
+		}
+	}
+	for _, tag := range result.Tags {
+		if !containsString(record.Tags, tag) {
+			record.Tags = append(record.Tags, tag)
+		}
+	}
 
-# HELP subdomain_scanner_dns_queries_total Total number of DNS queries
-# TYPE subdomain_scanner_dns_queries_total counter
-subdomain_scanner_dns_queries_total %d
+	provenance, ok := record.Sources[source]
+	if !ok {
+		provenance = &HostProvenance{FirstSeen: seen}
+		record.Sources[source] = provenance
+	}
+	provenance.LastSeen = maxTime(provenance.LastSeen, seen)
+	provenance.Results++
+	if result.Title != "" {
+		provenance.Title = result.Title
+	}
+	provenance.EvidenceTime = maxTime(provenance.EvidenceTime, result.EvidenceTime)
 
-# HELP subdomain_scanner_uptime_seconds Uptime in seconds
-# TYPE subdomain_scanner_uptime_seconds counter
-subdomain_scanner_uptime_seconds %f
-`,
-		atomic.LoadInt64(&stats.TotalRequests),
-		atomic.LoadInt64(&stats.ActiveJobs),
-		atomic.LoadInt64(&stats.TotalSubdomains),
-		atomic.LoadInt64(&stats.DNSQueries),
-		time.Since(stats.StartTime).Seconds(),
-	)
-	
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte(metrics))
+	// Passive sources only name the host; they say nothing of where it
+	// points and must not replace a lookup
+	resolved := len(result.IPs) > 0
+	if !resolved && result.CNAME == "" && result.Status != "unresolved" && result.Status != "dns-error" {
+		return
+	}
+	if record.Resolution != nil && seen.Before(record.Resolution.ObservedAt) {
+		return
+	}
+	resolution := &HostResolution{
+		Status:     result.Status,
+		IPs:        result.IPs,
+		CNAME:      result.CNAME,
+		CNAMEChain: result.CNAMEChain,
+		Rcode:      result.Rcode,
+		Error:      result.Error,
+		Source:     source,
+		Job:        jobID,
+		ObservedAt: seen,
+	}
+	if resolved && result.Status != "unbound-ip" {
+		resolution.Status = "resolved"
+	}
+	record.Resolution = resolution
+}
+
+// maxTime returns the later of a and b
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
 }
 
-// The rest of the stream handlers would be similar to your original implementation
-// but with enhanced error handling, logging, and metrics collection
-// Due to length constraints, I'm showing the framework - you would implement
-// waybackStream, crtshStream, dnsStream, etc. with similar enhancements
+// RecordProbe stores a probe response against the host under every target
+// that knows it. Hosts no job has reported are not indexed.
+func (hi *HostIndex) RecordProbe(host, probedURL string, response ProbeResponse) {
+	host = strings.ToLower(host)
+	probe := &HostProbe{ProbeResponse: response, URL: probedURL, ObservedAt: time.Now()}
 
-// Enhanced wayback stream with better error handling
-func waybackStream(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	if target == "" {
-		http.Error(w, "missing target parameter", http.StatusBadRequest)
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	for _, entry := range hi.targets {
+		if record, ok := entry.hosts[host]; ok {
+			record.Probe = probe
+		}
+	}
+}
+
+// RecordASN stores the origins of an address for later host details
+func (hi *HostIndex) RecordASN(address string, infos []ASNInfo) {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	hi.asns[address] = infos
+	delete(hi.pending, address)
+}
+
+// HostJobLink points at a job that reported the host
+type HostJobLink struct {
+	ID   string `json:"id"`
+	Href string `json:"href"`
+}
+
+// HostASN is the origin of one of the host's addresses
+type HostASN struct {
+	Address string `json:"address"`
+	ASNInfo
+}
+
+// HostDetail is the response of /api/hosts/{target}/{host}. Sections that
+// are not known, or that the server cannot provide, are named in
+// unavailable with the reason.
+type HostDetail struct {
+	HostRecord
+	Lifecycle   string            `json:"lifecycle"`
+	ASN         []HostASN         `json:"asn,omitempty"`
+	Jobs        []HostJobLink     `json:"jobs"`
+	Unavailable map[string]string `json:"unavailable,omitempty"`
+}
+
+// Detail returns a snapshot of host under target, and the IPv4 addresses
+// whose origins are not known yet
+func (hi *HostIndex) Detail(target, host string) (*HostDetail, []string, bool) {
+	hi.mu.RLock()
+	defer hi.mu.RUnlock()
+
+	entry, ok := hi.targets[target]
+	if !ok {
+		return nil, nil, false
+	}
+	record, ok := entry.hosts[host]
+	if !ok {
+		return nil, nil, false
+	}
+
+	detail := &HostDetail{HostRecord: *record, Unavailable: make(map[string]string)}
+	detail.Sources = make(map[string]*HostProvenance, len(record.Sources))
+	for source, provenance := range record.Sources {
+		copied := *provenance
+		detail.Sources[source] = &copied
+	}
+	detail.Tags = slices.Clone(record.Tags)
+	if record.Resolution != nil {
+		resolution := *record.Resolution
+		detail.Resolution = &resolution
+	}
+
+	// new: first reported by the target's latest job; active: reported by
+	// it again; stale: the latest job did not report it
+	switch last := record.jobs[len(record.jobs)-1]; {
+	case record.firstJob == entry.latestJob:
+		detail.Lifecycle = "new"
+	case last == entry.latestJob:
+		detail.Lifecycle = "active"
+	default:
+		detail.Lifecycle = "stale"
+	}
+
+	detail.Jobs = make([]HostJobLink, len(record.jobs))
+	for i, id := range record.jobs {
+		detail.Jobs[i] = HostJobLink{ID: id, Href: "/api/jobs/" + url.PathEscape(id)}
+	}
+
+	var unknown []string
+	if detail.Resolution != nil {
+		for _, ip := range detail.Resolution.IPs {
+			infos, ok := hi.asns[ip.Address]
+			switch {
+			case ok:
+				for _, info := range infos {
+					detail.ASN = append(detail.ASN, HostASN{Address: ip.Address, ASNInfo: info})
+				}
+			case ip.Family == "ipv4":
+				unknown = append(unknown, ip.Address)
+			}
+		}
+	}
+	return detail, unknown, true
+}
+
+// lookupASNs resolves the origins of addresses in the background, so they
+// are part of the next detail of hosts pointing there
+func (hi *HostIndex) lookupASNs(cfg *Config, addresses []string) {
+	hi.mu.Lock()
+	var claimed []string
+	for _, address := range addresses {
+		if !hi.pending[address] {
+			hi.pending[address] = true
+			claimed = append(claimed, address)
+		}
+	}
+	hi.mu.Unlock()
+
+	for _, address := range claimed {
+		ctx, cancel := context.WithTimeout(withConfig(context.Background(), cfg), cfg.DNS.Timeout)
+		infos, err := defaultResolver().LookupASN(ctx, net.ParseIP(address))
+		cancel()
+		if err != nil {
+			hi.mu.Lock()
+			delete(hi.pending, address)
+			hi.mu.Unlock()
+			continue
+		}
+		hi.RecordASN(address, infos)
+	}
+}
+
+// hostDetailHandler serves GET /api/hosts/{target}/{host} from the host
+// index. Sections with nothing behind them are left out and explained.
+func hostDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	target, host, ok := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/hosts"), "/"), "/")
+	if !ok || target == "" || host == "" || strings.Contains(host, "/") {
+		http.Error(w, "expected /api/hosts/{target}/{host}", http.StatusNotFound)
+		return
+	}
+	target, host = strings.ToLower(target), strings.ToLower(host)
+
+	detail, unknown, found := hostIndex.Detail(target, host)
+	if !found {
+		http.Error(w, fmt.Sprintf("%s has not been reported for %s", host, target), http.StatusNotFound)
+		return
+	}
+
+	cfg := configFrom(r.Context())
+	if detail.Resolution == nil {
+		if passiveOnly {
+			detail.Unavailable["resolution"] = errPassiveOnly.Error()
+		} else {
+			detail.Unavailable["resolution"] = "no lookup of the host has been recorded"
+		}
+	} else if detail.Resolution.Status == "resolved" {
+		ttls := make(map[string]int)
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME} {
+			if left := defaultResolver().cache.remaining(host, qtype); left > 0 {
+				ttls[dns.TypeToString[qtype]] = int(left.Seconds())
+			}
+		}
+		if len(ttls) > 0 {
+			detail.Resolution.TTLs = ttls
+		}
+	}
+
+	switch {
+	case detail.Probe != nil:
+	case passiveOnly:
+		detail.Unavailable["probe"] = errPassiveOnly.Error()
+	default:
+		detail.Unavailable["probe"] = "the host has not been probed"
+	}
+
+	switch {
+	case len(unknown) > 0 && passiveOnly:
+		detail.Unavailable["asn"] = errPassiveOnly.Error()
+	case len(unknown) > 0:
+		// Looked up for the next request rather than delaying this one
+		go hostIndex.lookupASNs(cfg, unknown)
+		detail.Unavailable["asn"] = "origin lookup in progress for " + strings.Join(unknown, ", ")
+	case len(detail.ASN) == 0 && detail.Resolution != nil && len(detail.Resolution.IPs) > 0:
+		detail.Unavailable["asn"] = "no origin known for the host's addresses"
+	}
+
+	detail.Unavailable["geoip"] = "GeoIP enrichment is not available on this server"
+	detail.Unavailable["notes"] = "host notes are not available on this server"
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// Client roles, from least to most access
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+)
+
+var roleRank = map[string]int{roleViewer: 1, roleOperator: 2}
+
+// parseClientKeys reads API_KEYS entries of the form key:role. A key
+// without a role is an operator key; entries with an unknown role are
+// skipped.
+func parseClientKeys(entries []string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range entries {
+		key, role, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if key == "" {
+			continue
+		}
+		if !found {
+			role = roleOperator
+		}
+		if _, ok := roleRank[role]; !ok {
+			log.Printf("Warning: API_KEYS entry for key %s...: unknown role %q", key[:min(4, len(key))], role)
+			continue
+		}
+		keys[key] = role
+	}
+	return keys
+}
+
+// routePermission names the role a request needs. Patterns follow
+// path.Match, so * stands for one path segment.
+type routePermission struct {
+	method  string // empty for any method
+	pattern string
+	role    string
+}
+
+// routePermissions lists everything that starts, stops or changes work.
+// Requests matching none of them need the viewer role. /api/admin
+// endpoints check ADMIN_TOKEN instead.
+var routePermissions = []routePermission{
+	{pattern: "/api/*/stream", role: roleOperator}, // every scan, enumerate included
+	{pattern: "/api/scan", role: roleOperator},
+	{pattern: "/ws/enumerate", role: roleOperator},
+	{pattern: "/api/probe", role: roleOperator},
+	{pattern: "/api/abort", role: roleOperator},
+	{pattern: "/api/jobs/*/rerun", role: roleOperator},
+	{pattern: "/api/jobs/*/abort", role: roleOperator},
+	{method: http.MethodDelete, pattern: "/api/jobs/*", role: roleOperator},
+	{method: http.MethodDelete, pattern: "/api/targets/*/lock", role: roleOperator},
+	{method: http.MethodPost, pattern: "/api/wordlists", role: roleOperator},
+	{method: http.MethodDelete, pattern: "/api/wordlists/*", role: roleOperator},
+}
+
+// requiredRole returns the role r needs under routePermissions
+func requiredRole(r *http.Request) string {
+	for _, permission := range routePermissions {
+		if permission.method != "" && permission.method != r.Method {
+			continue
+		}
+		if ok, _ := path.Match(permission.pattern, r.URL.Path); ok {
+			return permission.role
+		}
+	}
+	return roleViewer
+}
+
+type roleContextKey struct{}
+
+// roleFrom returns the caller's role as set by authorize
+func roleFrom(ctx context.Context) string {
+	if role, ok := ctx.Value(roleContextKey{}).(string); ok {
+		return role
+	}
+	return roleOperator
+}
+
+// authorize checks the request's API key against the route's required
+// role, answering 401 or 403 itself when it falls short. Keys come in the
+// X-API-Key header, or the api_key parameter for clients that cannot set
+// headers, such as EventSource.
+func authorize(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	keys := configFrom(r.Context()).Security.ClientKeys
+	if len(keys) == 0 || strings.HasPrefix(r.URL.Path, "/api/admin/") {
+		return r.WithContext(context.WithValue(r.Context(), roleContextKey{}, roleOperator)), true
+	}
+
+	presented := r.Header.Get("X-API-Key")
+	if presented == "" {
+		presented = r.URL.Query().Get("api_key")
+	}
+	role := ""
+	for key, keyRole := range keys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(key)) == 1 {
+			role = keyRole
+		}
+	}
+	if role == "" {
+		http.Error(w, "API key required", http.StatusUnauthorized)
+		return r, false
+	}
+
+	if required := requiredRole(r); roleRank[role] < roleRank[required] {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":         fmt.Sprintf("%s %s requires the %s role", r.Method, r.URL.Path, required),
+			"role":          role,
+			"required_role": required,
+		})
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), roleContextKey{}, role)), true
+}
+
+// whoamiHandler reports the caller's role and the routes it may not use,
+// so interfaces can hide what would be refused
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	role := roleFrom(r.Context())
+	denied := []map[string]string{}
+	for _, permission := range routePermissions {
+		if roleRank[role] >= roleRank[permission.role] {
+			continue
+		}
+		method := permission.method
+		if method == "" {
+			method = "*"
+		}
+		denied = append(denied, map[string]string{"method": method, "path": permission.pattern, "required_role": permission.role})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"role":          role,
+		"keys_required": len(configFrom(r.Context()).Security.ClientKeys) > 0,
+		"denied":        denied,
+	})
+}
+
+// withScanGuard refuses to start new scans while maintenance is enabled
+func withScanGuard(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rejectDuringMaintenance(w) {
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// withAdmin restricts a handler to operators. With ADMIN_TOKEN set the
+// request must carry it as a bearer token; without it only loopback clients
+// are accepted.
+func withAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if status, message := checkAdmin(r); status != 0 {
+			http.Error(w, message, status)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// checkAdmin returns a non-zero status and message unless r carries the
+// admin token, or comes from localhost when no token is configured
+func checkAdmin(r *http.Request) (int, string) {
+	cfg := configFrom(r.Context())
+	if cfg.Admin.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Admin.Token)) != 1 {
+			return http.StatusUnauthorized, "admin token required"
+		}
+		return 0, ""
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	ip := net.ParseIP(host)
+	if err != nil || ip == nil || !ip.IsLoopback() {
+		return http.StatusForbidden, "admin endpoints are only available from localhost unless ADMIN_TOKEN is set"
+	}
+	return 0, ""
+}
+
+// auditLog records an operator action in the server log and, when DATA_DIR
+// is set, appends it to DATA_DIR/audit.log as a JSON line
+func auditLog(r *http.Request, event string, details map[string]interface{}) {
+	cfg := configFrom(r.Context())
+	entry := map[string]interface{}{
+		"time":   time.Now().UTC(),
+		"event":  event,
+		"remote": r.RemoteAddr,
+	}
+	for key, value := range details {
+		entry[key] = value
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("AUDIT %s (unencodable details: %v)", event, err)
+		return
+	}
+	log.Printf("AUDIT %s", data)
+
+	if cfg.DataDir == "" {
+		return
+	}
+	f, err := os.OpenFile(filepath.Join(cfg.DataDir, "audit.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Warning: cannot write audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Warning: cannot write audit log: %v", err)
+	}
+}
+
+type MaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+	Action  string `json:"action"`
+}
+
+// resolverReloadHandler re-reads DNS_SERVERS_FILE and swaps the shared
+// resolver's servers without a full configuration reload
+func resolverReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := *currentConfig()
+	if cfg.DNS.ServersFile == "" {
+		http.Error(w, "DNS_SERVERS_FILE is not set", http.StatusConflict)
+		return
+	}
+	servers, err := loadResolverFile(cfg.DNS.ServersFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	previous := len(defaultResolver().servers)
+	cfg.DNS.Servers = servers
+	setConfig(&cfg)
+	reloadDNSResolver(&cfg)
+
+	auditLog(r, "resolvers_reloaded", map[string]interface{}{
+		"file":     cfg.DNS.ServersFile,
+		"servers":  len(servers),
+		"previous": previous,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"file":     cfg.DNS.ServersFile,
+		"servers":  len(servers),
+		"previous": previous,
+	})
+}
+
+// maintenanceHandler reports (GET) or toggles (POST) maintenance mode.
+// Entering maintenance aborts, drains or pauses running jobs depending on
+// the requested or configured action; leaving it resumes paused ones.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := configFrom(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(maintenance.Get())
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req MaintenanceRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid maintenance request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Action == "" {
+		req.Action = cfg.Admin.MaintenanceAction
+	}
+	if req.Action != "abort" && req.Action != "drain" && req.Action != "pause" {
+		http.Error(w, fmt.Sprintf("unknown maintenance action %q (want abort, drain or pause)", req.Action), http.StatusBadRequest)
+		return
+	}
+
+	previous := maintenance.Get()
+	state := MaintenanceState{}
+	if req.Enabled {
+		state = MaintenanceState{
+			Enabled: true,
+			Message: req.Message,
+			Action:  req.Action,
+			Since:   time.Now(),
+			By:      r.RemoteAddr,
+		}
+	}
+	persistErr := maintenance.Set(state)
+
+	aborted, paused := 0, 0
+	for _, job := range managedJobs() {
+		switch {
+		case !req.Enabled:
+		case req.Action == "abort":
+			if abortJob(job, "maintenance") {
+				aborted++
+			}
+		case req.Action == "pause":
+			job.mu.RLock()
+			running := job.Status == "running"
+			job.mu.RUnlock()
+			if running {
+				paused++
+			}
+		}
+	}
+
+	event := "maintenance.exit"
+	if req.Enabled {
+		event = "maintenance.enter"
+	}
+	details := map[string]interface{}{
+		"message":      req.Message,
+		"action":       req.Action,
+		"was_enabled":  previous.Enabled,
+		"aborted_jobs": aborted,
+		"paused_jobs":  paused,
+		"persisted":    cfg.DataDir != "" && persistErr == nil,
+	}
+	if persistErr != nil {
+		details["persist_error"] = persistErr.Error()
+	}
+	auditLog(r, event, details)
+
+	response := map[string]interface{}{
+		"maintenance":  state,
+		"aborted_jobs": aborted,
+		"paused_jobs":  paused,
+		"persisted":    cfg.DataDir != "" && persistErr == nil,
+	}
+	if cfg.DataDir == "" {
+		response["warning"] = "DATA_DIR is not set; maintenance mode will not survive a restart"
+	} else if persistErr != nil {
+		response["warning"] = fmt.Sprintf("failed to persist maintenance state: %v", persistErr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CandidateDisposition records what happened to one brute-force candidate
+type CandidateDisposition struct {
+	Name    string    `json:"name"`
+	Source  string    `json:"source"`
+	Outcome string    `json:"outcome"`
+	Detail  string    `json:"detail,omitempty"`
+	IPs     []string  `json:"ips,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// candidateLog is the debug artifact of a scan: gzip-compressed NDJSON of
+// every disposition plus an index by name. Both stop growing at the
+// configured caps; later candidates are only counted as dropped. The gzip
+// stream is finalized when the job finishes.
+type candidateLog struct {
+	buf        bytes.Buffer
+	gz         *gzip.Writer
+	index      map[string]CandidateDisposition
+	maxEntries int
+	maxBytes   int64
+	dropped    int
+	closed     bool
+	mu         sync.Mutex
+}
+
+func newCandidateLog(cfg DNSConfig) *candidateLog {
+	cl := &candidateLog{
+		index:      make(map[string]CandidateDisposition),
+		maxEntries: cfg.CandidateLogMaxEntries,
+		maxBytes:   cfg.CandidateLogMaxBytes,
+	}
+	cl.gz = gzip.NewWriter(&cl.buf)
+	return cl
+}
+
+func (cl *candidateLog) add(d CandidateDisposition) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.closed {
 		return
 	}
+	if len(cl.index) >= cl.maxEntries || int64(cl.buf.Len()) >= cl.maxBytes {
+		cl.dropped++
+		return
+	}
+	line, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	cl.gz.Write(append(line, '\n'))
+	cl.index[d.Name] = d
+}
+
+func (cl *candidateLog) lookup(name string) (CandidateDisposition, bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	d, ok := cl.index[name]
+	return d, ok
+}
+
+func (cl *candidateLog) close() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if !cl.closed {
+		cl.gz.Close()
+		cl.closed = true
+	}
+}
+
+// artifact returns the finished gzip stream, or false while the scan runs
+func (cl *candidateLog) artifact() (data []byte, entries, dropped int, ok bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if !cl.closed {
+		return nil, 0, 0, false
+	}
+	return cl.buf.Bytes(), len(cl.index), cl.dropped, true
+}
 
-	if !domainRe.MatchString(target) {
-		http.Error(w, "invalid domain format", http.StatusBadRequest)
+// RecordCandidate stores a disposition when the job runs in debug mode
+func (j *Job) RecordCandidate(source, name, outcome, detail string, ips []net.IP) {
+	if j == nil || j.candidates == nil {
 		return
 	}
+	d := CandidateDisposition{
+		Name:    strings.ToLower(name),
+		Source:  source,
+		Outcome: outcome,
+		Detail:  detail,
+		Time:    time.Now(),
+	}
+	for _, ip := range ips {
+		d.IPs = append(d.IPs, ip.String())
+	}
+	j.candidates.add(d)
+}
 
-	sseHeader(w)
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+// RecordLookup classifies a brute-force lookup result for the debug log
+func (j *Job) RecordLookup(source, name string, ips []net.IP, err error) {
+	if j == nil || j.candidates == nil {
 		return
 	}
+	outcome := lookupOutcome(err)
+	switch {
+	case err == nil && len(ips) > 0:
+		j.RecordCandidate(source, name, "resolved", "", ips)
+	case outcome == "nxdomain":
+		j.RecordCandidate(source, name, "nxdomain", "", nil)
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		j.RecordCandidate(source, name, "not-attempted", "scan cancelled or timed out", nil)
+	case outcome == "no-answer":
+		j.RecordCandidate(source, name, "no-answer", err.Error(), nil)
+	case outcome == "error" || outcome == "resolved":
+		j.RecordCandidate(source, name, "error", fmt.Sprint(err), nil)
+	default:
+		// servfail, refused or timeout
+		j.RecordCandidate(source, name, outcome, err.Error(), nil)
+	}
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Wayback)
-	defer cancel()
-
-	job := createJob(target, []string{"wayback"})
-	defer job.Complete()
-
-	// Create API URL for Wayback Machine
-	apiURL := fmt.Sprintf(
-		"https://web.archive.org/cdx/search/cdx?url=*.%s/*&output=text&fl=original&collapse=urlkey",
-		target,
-	)
+// CandidateReport answers "why wasn't this name found" for one job
+type CandidateReport struct {
+	Name           string                `json:"name"`
+	JobID          string                `json:"job_id"`
+	InScope        bool                  `json:"in_scope"`
+	Found          bool                  `json:"found"`
+	FoundBy        []string              `json:"found_by,omitempty"`
+	InWordlist     bool                  `json:"in_wordlist"`
+	InPermutations bool                  `json:"in_permutations"`
+	DebugEnabled   bool                  `json:"debug_enabled"`
+	Disposition    *CandidateDisposition `json:"disposition,omitempty"`
+	CurrentLookup  string                `json:"current_lookup,omitempty"`
+	CurrentIPs     []string              `json:"current_ips,omitempty"`
+	Explanation    string                `json:"explanation"`
+}
 
-	// Create HTTP request with context
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
-	if err != nil {
-		log.Printf("Wayback request creation error: %v", err)
-		// Send completion signal and return
-		fmt.Fprintf(w, "event: complete\ndata: Wayback scan completed with errors\n\n")
-		flusher.Flush()
+// candidateHandler explains what happened to a single name in a job. It
+// works without debug mode by checking the job's results and the candidate
+// lists the brute-force sources would have generated.
+func candidateHandler(w http.ResponseWriter, r *http.Request, job *Job) {
+	name := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("name"))), ".")
+	if name == "" {
+		http.Error(w, "missing name parameter", http.StatusBadRequest)
 		return
 	}
 
-	client := &http.Client{
-		Timeout: config.HTTP.Timeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: config.HTTP.SkipTLSVerify,
-			},
-		},
+	report := CandidateReport{
+		Name:         name,
+		JobID:        job.ID,
+		InScope:      name == job.Target || strings.HasSuffix(name, "."+job.Target),
+		DebugEnabled: job.candidates != nil,
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Wayback API error: %v", err)
-		// Send completion signal and return
-		fmt.Fprintf(w, "event: complete\ndata: Wayback scan completed - API unavailable\n\n")
-		flusher.Flush()
-		return
+	job.mu.RLock()
+	sources := append([]string(nil), job.Sources...)
+	options := url.Values{}
+	for key, value := range job.Options {
+		options.Set(key, value)
 	}
-	defer resp.Body.Close()
+	for source, results := range job.Results {
+		for _, result := range results {
+			if strings.EqualFold(result.Host, name) {
+				report.FoundBy = append(report.FoundBy, source)
+				break
+			}
+		}
+	}
+	job.mu.RUnlock()
+	sort.Strings(report.FoundBy)
+	report.Found = len(report.FoundBy) > 0
 
-	seen := make(map[string]struct{})
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Wayback response read error: %v", err)
-		fmt.Fprintf(w, "event: complete\ndata: Wayback scan completed with errors\n\n")
-		flusher.Flush()
-		return
+	if report.InScope {
+		label := strings.TrimSuffix(name, "."+job.Target)
+		if labels, err := parseBruteLabels(options); err == nil {
+			report.InWordlist = labels.Contains(label)
+		}
+		report.InPermutations = containsString(generatePermutations(job.Target), name)
+	}
+	if job.candidates != nil {
+		if d, ok := job.candidates.lookup(name); ok {
+			report.Disposition = &d
+		}
 	}
 
-	lines := strings.Split(string(body), "\n")
-	for _, line := range lines {
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(w, "event: complete\ndata: Wayback scan cancelled\n\n")
-			flusher.Flush()
-			return
+	// A fresh lookup helps tell "didn't exist then" from "broken resolver"
+	if report.InScope && !report.Found {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ips, err := job.Resolver().LookupHostAll(ctx, name)
+		cancel()
+		switch {
+		case err == nil:
+			report.CurrentLookup = "resolved"
+			for _, ip := range ips {
+				report.CurrentIPs = append(report.CurrentIPs, ip.String())
+			}
+		case lookupOutcome(err) == "error":
+			report.CurrentLookup = err.Error()
 		default:
+			report.CurrentLookup = lookupOutcome(err)
 		}
+	}
 
-		if matches := hostRe.FindStringSubmatch(line); matches != nil {
-			host := strings.ToLower(matches[1])
-			if strings.HasSuffix(host, "."+target) {
-				if _, dup := seen[host]; !dup {
-					seen[host] = struct{}{}
-					
-					result := Result{
-						Host:      host,
-						Source:    "wayback",
-						Status:    "discovered",
-						Timestamp: time.Now(),
-					}
-					
-					job.AddResult("wayback", result)
-					
-					// Send to client
-					fmt.Fprintf(w, "data: %s\n\n", host)
-					flusher.Flush()
-				}
-			}
+	bruteForced := containsString(sources, "dns") && report.InWordlist ||
+		containsString(sources, "permute") && report.InPermutations
+	switch {
+	case !report.InScope:
+		report.Explanation = fmt.Sprintf("%s is not under the job target %s, so it is out of scope", name, job.Target)
+	case report.Found:
+		report.Explanation = fmt.Sprintf("found by %s", strings.Join(report.FoundBy, ", "))
+	case report.Disposition != nil:
+		report.Explanation = fmt.Sprintf("attempted by %s: %s", report.Disposition.Source, report.Disposition.Outcome)
+		if report.Disposition.Detail != "" {
+			report.Explanation += " (" + report.Disposition.Detail + ")"
 		}
+	case !bruteForced:
+		report.Explanation = fmt.Sprintf("never attempted: not in the candidate list of this job's brute-force sources (%s)", strings.Join(sources, ", "))
+	case report.DebugEnabled:
+		report.Explanation = "in the candidate list but no outcome was recorded (debug log cap reached or scan still running)"
+	default:
+		report.Explanation = "attempted but did not resolve during the scan; re-run with debug=true for the exact outcome"
 	}
 
-	log.Printf("Wayback found %d unique hosts for %s", len(seen), target)
-	// Send completion signal
-	fmt.Fprintf(w, "event: complete\ndata: Wayback scan completed - found %d hosts\n\n", len(seen))
-	flusher.Flush()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
 }
 
-// Implement other stream handlers similarly...
-func crtshStream(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	if target == "" {
-		http.Error(w, "missing target parameter", http.StatusBadRequest)
+// candidateLogHandler downloads the compressed per-candidate debug log
+func candidateLogHandler(w http.ResponseWriter, r *http.Request, job *Job) {
+	if job.candidates == nil {
+		http.Error(w, "job was not started with debug=true", http.StatusNotFound)
 		return
 	}
-
-	sseHeader(w)
-	flusher, ok := w.(http.Flusher)
+	data, entries, dropped, ok := job.candidates.artifact()
 	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		http.Error(w, "debug log is available once the job finishes", http.StatusConflict)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.CrtSh)
-	defer cancel()
-
-	job := createJob(target, []string{"crtsh"})
-	defer job.Complete()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.ID+"-candidates.ndjson.gz"))
+	w.Header().Set("X-Candidate-Entries", strconv.Itoa(entries))
+	w.Header().Set("X-Candidate-Dropped", strconv.Itoa(dropped))
+	w.Write(data)
+}
 
-	apiURL := fmt.Sprintf("https://crt.sh/?q=%%25.%s&output=json", target)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
-	if err != nil {
-		log.Printf("crt.sh request creation error: %v", err)
-		fmt.Fprintf(w, "event: complete\ndata: Certificate transparency scan completed with errors\n\n")
-		flusher.Flush()
-		return
-	}
+// Built-in message catalogs, one <lang>.json per locale. Keys starting with
+// "_format." hold number and date formatting rules.
+//
+//go:embed locales/*.json
+var builtinCatalogs embed.FS
 
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", config.HTTP.UserAgent)
+// fallbackLocale is used for keys missing from the selected catalog
+const fallbackLocale = "en"
 
-	client := &http.Client{Timeout: config.HTTP.Timeout}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("crt.sh API error: %v", err)
-		fmt.Fprintf(w, "event: complete\ndata: Certificate transparency scan completed - API unavailable\n\n")
-		flusher.Flush()
-		return
-	}
-	defer resp.Body.Close()
+var catalogs map[string]map[string]string
 
-	var entries []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
-		log.Printf("crt.sh JSON decode error: %v", err)
-		fmt.Fprintf(w, "event: complete\ndata: Certificate transparency scan completed with errors\n\n")
-		flusher.Flush()
-		return
-	}
+// initializeCatalogs loads the built-in catalogs and any catalogs in
+// LOCALES_DIR, which add languages or override built-in keys
+func initializeCatalogs() {
+	cfg := currentConfig()
+	loaded := make(map[string]map[string]string)
 
-	seen := make(map[string]struct{})
-	for _, entry := range entries {
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(w, "event: complete\ndata: Certificate transparency scan cancelled\n\n")
-			flusher.Flush()
+	load := func(name string, data []byte) {
+		locale := strings.ToLower(strings.TrimSuffix(filepath.Base(name), ".json"))
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			log.Printf("Warning: ignoring message catalog %s: %v", name, err)
 			return
-		default:
 		}
+		if loaded[locale] == nil {
+			loaded[locale] = make(map[string]string)
+		}
+		for key, value := range messages {
+			loaded[locale][key] = value
+		}
+	}
 
-		if nameValue, ok := entry["name_value"].(string); ok {
-			for _, name := range strings.Split(nameValue, "\n") {
-				host := strings.ToLower(strings.TrimSpace(name))
-				host = strings.TrimPrefix(host, "*.")
-
-				if strings.HasSuffix(host, "."+target) && host != target {
-					if _, dup := seen[host]; !dup {
-						seen[host] = struct{}{}
+	builtin, _ := fs.Glob(builtinCatalogs, "locales/*.json")
+	for _, name := range builtin {
+		data, err := builtinCatalogs.ReadFile(name)
+		if err == nil {
+			load(name, data)
+		}
+	}
+	if cfg.Locale.Dir != "" {
+		extra, err := filepath.Glob(filepath.Join(cfg.Locale.Dir, "*.json"))
+		if err != nil {
+			log.Printf("Warning: cannot list message catalogs in %s: %v", cfg.Locale.Dir, err)
+		}
+		for _, name := range extra {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				log.Printf("Warning: cannot read message catalog %s: %v", name, err)
+				continue
+			}
+			load(name, data)
+		}
+	}
 
-						result := Result{
-							Host:      host,
-							Source:    "crtsh",
-							Status:    "discovered",
-							Timestamp: time.Now(),
-						}
+	catalogs = loaded
+	if _, ok := catalogs[cfg.Locale.Default]; !ok {
+		log.Printf("Warning: default locale %q has no catalog, falling back to %s", cfg.Locale.Default, fallbackLocale)
+	}
+}
 
-						job.AddResult("crtsh", result)
+// negotiateLocale picks the catalog for a request: ?lang= first, then the
+// Accept-Language preferences, then DEFAULT_LOCALE
+func negotiateLocale(r *http.Request) string {
+	if locale, ok := matchLocale(r.URL.Query().Get("lang")); ok {
+		return locale
+	}
 
-						fmt.Fprintf(w, "data: %s\n\n", host)
-						flusher.Flush()
-					}
-				}
+	type preference struct {
+		tag string
+		q   float64
+	}
+	var prefs []preference
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
 			}
 		}
+		if tag != "" && q > 0 {
+			prefs = append(prefs, preference{tag, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, pref := range prefs {
+		if locale, ok := matchLocale(pref.tag); ok {
+			return locale
+		}
 	}
 
-	log.Printf("crt.sh found %d unique hosts for %s", len(seen), target)
-	fmt.Fprintf(w, "event: complete\ndata: Certificate transparency scan completed - found %d hosts\n\n", len(seen))
-	flusher.Flush()
+	if locale, ok := matchLocale(configFrom(r.Context()).Locale.Default); ok {
+		return locale
+	}
+	return fallbackLocale
 }
 
-func dnsStream(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	if target == "" {
-		http.Error(w, "missing target parameter", http.StatusBadRequest)
-		return
+// matchLocale maps a language tag like "de-AT" to a loaded catalog
+func matchLocale(tag string) (string, bool) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if tag == "" {
+		return "", false
+	}
+	if _, ok := catalogs[tag]; ok {
+		return tag, true
 	}
+	base, _, _ := strings.Cut(tag, "-")
+	if _, ok := catalogs[base]; ok {
+		return base, true
+	}
+	return "", false
+}
 
-	sseHeader(w)
-	flusher, ok := w.(http.Flusher)
+// translate looks key up in the locale's catalog, falling back to English
+// and finally the key itself, and substitutes {name} placeholders
+func translate(locale, key string, params map[string]string) string {
+	message, ok := catalogs[locale][key]
 	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
-		return
+		message, ok = catalogs[fallbackLocale][key]
 	}
+	if !ok {
+		message = key
+	}
+	for name, value := range params {
+		message = strings.ReplaceAll(message, "{"+name+"}", value)
+	}
+	return message
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.DNS)
-	defer cancel()
-
-	job := createJob(target, []string{"dns"})
-	defer job.Complete()
-
-	// Get all subdomains from all categories
-	var allSubdomains []string
-	for _, subdomains := range commonSubdomains {
-		allSubdomains = append(allSubdomains, subdomains...)
+// formatNumber renders n with the locale's digit grouping
+func formatNumber(locale string, n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	group := translate(locale, "_format.group", nil)
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(group)
+		}
+		b.WriteRune(digit)
 	}
+	return sign + b.String()
+}
 
-	seen := make(map[string]struct{})
-	semaphore := make(chan struct{}, config.DNS.Concurrency)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
+// formatTime renders t with the locale's date layout
+func formatTime(locale string, t time.Time) string {
+	return t.Format(translate(locale, "_format.date", nil))
+}
 
-	for _, subdomain := range allSubdomains {
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(w, "event: complete\ndata: DNS brute force scan cancelled\n\n")
-			flusher.Flush()
-			return
-		default:
-		}
+// messagesHandler serves the merged catalog for the web UI
+func messagesHandler(w http.ResponseWriter, r *http.Request) {
+	locale := negotiateLocale(r)
 
-		wg.Add(1)
-		go func(sub string) {
-			defer wg.Done()
+	messages := make(map[string]string)
+	for key, value := range catalogs[fallbackLocale] {
+		messages[key] = value
+	}
+	for key, value := range catalogs[locale] {
+		messages[key] = value
+	}
+	available := make(map[string]string)
+	for code := range catalogs {
+		available[code] = translate(code, "_meta.name", nil)
+	}
 
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", locale)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locale":    locale,
+		"available": available,
+		"messages":  messages,
+	})
+}
 
-			host := fmt.Sprintf("%s.%s", sub, target)
+// Evidence signing. With SIGNING_KEY_PATH pointing at a PEM (PKCS#8) ed25519
+// private key, job bundles carry a signed manifest and job exports can be
+// requested as a signed envelope. Signatures cover canonical JSON (sorted
+// keys, compact, numbers kept verbatim), so re-serializing a document does
+// not invalidate them.
+var evidenceSigner *Signer
 
-			ips, err := dnsResolver.LookupHost(ctx, host)
-			if err == nil && len(ips) > 0 {
-				mu.Lock()
-				if _, dup := seen[host]; !dup {
-					seen[host] = struct{}{}
+type Signer struct {
+	key         ed25519.PrivateKey
+	fingerprint string
+}
 
-					result := Result{
-						Host:      host,
-						Source:    "dns",
-						Status:    "discovered",
-						Timestamp: time.Now(),
-					}
+func initializeSigning() {
+	cfg := currentConfig()
+	evidenceSigner = nil
+	if cfg.Security.SigningKeyPath == "" {
+		return
+	}
 
-					job.AddResult("dns", result)
+	key, err := loadSigningKey(cfg.Security.SigningKeyPath)
+	if err != nil {
+		// Silently producing unsigned evidence would defeat the point
+		log.Fatalf("Failed to load signing key from %s: %v", cfg.Security.SigningKeyPath, err)
+	}
+	evidenceSigner = &Signer{
+		key:         key,
+		fingerprint: keyFingerprint(key.Public().(ed25519.PublicKey)),
+	}
+	log.Printf("Signing exports and bundles with key %s", evidenceSigner.fingerprint)
+}
 
-					fmt.Fprintf(w, "data: %s\n\n", host)
-					flusher.Flush()
-				}
-				mu.Unlock()
-			}
-		}(subdomain)
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key is %T, not ed25519", parsed)
+	}
+	return key, nil
+}
 
-	// Wait for all DNS queries to complete or context to be cancelled
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+// loadVerifyKey reads a PEM ed25519 public key, or derives it from a private
+// key file
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if block.Type == "PRIVATE KEY" {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key is %T, not ed25519", parsed)
+	}
+	return key, nil
+}
 
-	select {
-	case <-done:
-		log.Printf("DNS enumeration found %d unique hosts for %s", len(seen), target)
-		fmt.Fprintf(w, "event: complete\ndata: DNS brute force scan completed - found %d hosts\n\n", len(seen))
-		flusher.Flush()
-	case <-ctx.Done():
-		log.Printf("DNS enumeration cancelled for %s", target)
-		fmt.Fprintf(w, "event: complete\ndata: DNS brute force scan cancelled\n\n")
-		flusher.Flush()
+// keyFingerprint is SHA256: and the hex digest of the PKIX-encoded key
+func keyFingerprint(key ed25519.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
 	}
+	sum := sha256.Sum256(der)
+	return "SHA256:" + hex.EncodeToString(sum[:])
 }
 
-func searchEngineStream(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	if target == "" {
-		http.Error(w, "missing target parameter", http.StatusBadRequest)
-		return
+// canonicalJSON re-encodes a JSON document with sorted object keys and no
+// insignificant whitespace, keeping numbers exactly as written
+func canonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
 	}
+	if decoder.More() {
+		return nil, errors.New("trailing data after JSON document")
+	}
+	return json.Marshal(value)
+}
 
-	sseHeader(w)
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
-		return
+// Sign returns the base64 ed25519 signature of the canonical form of doc
+func (s *Signer) Sign(doc []byte) (string, error) {
+	canonical, err := canonicalJSON(doc)
+	if err != nil {
+		return "", err
 	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, canonical)), nil
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Search)
-	defer cancel()
+// verifyJSONSignature checks a base64 signature over the canonical form of doc
+func verifyJSONSignature(key ed25519.PublicKey, doc []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	canonical, err := canonicalJSON(doc)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, canonical, sig) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
 
-	job := createJob(target, []string{"search"})
-	defer job.Complete()
+// SignedEnvelope is the JSON export of a job, signed when a key is configured
+type SignedEnvelope struct {
+	Job            json.RawMessage `json:"job"`
+	Algorithm      string          `json:"algorithm"`
+	Signature      string          `json:"signature"`
+	KeyFingerprint string          `json:"key_fingerprint"`
+}
 
-	// Simple Google search implementation
-	searchURL := fmt.Sprintf("https://www.google.com/search?q=site:%s", target)
-	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
+// BundleManifest lists every artifact in a job bundle with its SHA-256
+type BundleManifest struct {
+	Version        int              `json:"version"`
+	JobID          string           `json:"job_id"`
+	Target         string           `json:"target"`
+	Created        time.Time        `json:"created"`
+	KeyFingerprint string           `json:"key_fingerprint,omitempty"`
+	PassiveOnly    bool             `json:"passive_only"`
+	Artifacts      []BundleArtifact `json:"artifacts"`
+}
+
+type BundleArtifact struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Names in a bundle that are not themselves listed artifacts
+const (
+	bundleManifestName  = "manifest.json"
+	bundleSignatureName = "manifest.sig"
+)
+
+// jobJSON is the job as served by /api/jobs/<id>, taken under its lock
+// with results in canonical order
+func jobJSON(job *Job) ([]byte, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.sortResults()
+	return json.Marshal(job)
+}
+
+// signedJobHandler serves /api/jobs/<id>?signed=true as a SignedEnvelope
+func signedJobHandler(w http.ResponseWriter, job *Job) {
+	if evidenceSigner == nil {
+		http.Error(w, "signing is not configured (SIGNING_KEY_PATH)", http.StatusNotImplemented)
+		return
+	}
+	data, err := jobJSON(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	signature, err := evidenceSigner.Sign(data)
 	if err != nil {
-		log.Printf("Search engine request error: %v", err)
-		fmt.Fprintf(w, "event: complete\ndata: Search engine scan completed with errors\n\n")
-		flusher.Flush()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	req.Header.Set("User-Agent", config.HTTP.UserAgent)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SignedEnvelope{
+		Job:            data,
+		Algorithm:      "ed25519",
+		Signature:      signature,
+		KeyFingerprint: evidenceSigner.fingerprint,
+	})
+}
+
+type bundleFile struct {
+	name string
+	data []byte
+}
 
-	client := &http.Client{Timeout: config.HTTP.Timeout}
-	resp, err := client.Do(req)
+// jobBundleHandler serves a zip of the job's artifacts (job.json and, for
+// finished debug scans, the candidate log) with a manifest of their hashes,
+// plus manifest.sig when signing is configured
+func jobBundleHandler(w http.ResponseWriter, r *http.Request, job *Job) {
+	data, err := jobJSON(job)
 	if err != nil {
-		log.Printf("Search engine request failed: %v", err)
-		fmt.Fprintf(w, "event: complete\ndata: Search engine scan completed - service unavailable\n\n")
-		flusher.Flush()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
+	artifacts := []bundleFile{{"job.json", data}}
+	if job.candidates != nil {
+		if candidates, _, _, ok := job.candidates.artifact(); ok {
+			artifacts = append(artifacts, bundleFile{"candidates.ndjson.gz", candidates})
+		}
+	}
 
-	body, err := io.ReadAll(resp.Body)
+	// A finished job's bundle is dated by the job, so downloading it twice
+	// gives the same bytes
+	created := time.Now()
+	job.mu.RLock()
+	if !job.EndTime.IsZero() {
+		created = job.EndTime
+	}
+	job.mu.RUnlock()
+	manifest := BundleManifest{
+		Version:     1,
+		JobID:       job.ID,
+		Target:      job.Target,
+		Created:     created.UTC(),
+		PassiveOnly: job.PassiveOnly,
+	}
+	for _, artifact := range artifacts {
+		sum := sha256.Sum256(artifact.data)
+		manifest.Artifacts = append(manifest.Artifacts, BundleArtifact{
+			Name:   artifact.name,
+			SHA256: hex.EncodeToString(sum[:]),
+			Size:   int64(len(artifact.data)),
+		})
+	}
+	var signature string
+	if evidenceSigner != nil {
+		manifest.KeyFingerprint = evidenceSigner.fingerprint
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
-		log.Printf("Failed to read search response: %v", err)
-		fmt.Fprintf(w, "event: complete\ndata: Search engine scan completed with errors\n\n")
-		flusher.Flush()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if evidenceSigner != nil {
+		if signature, err = evidenceSigner.Sign(manifestJSON); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	urlPattern := regexp.MustCompile(`https?://([^/\s"'<>]+\.` + regexp.QuoteMeta(target) + `)`)
-	matches := urlPattern.FindAllStringSubmatch(string(body), -1)
-
-	seen := make(map[string]struct{})
-	for _, match := range matches {
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(w, "event: complete\ndata: Search engine scan cancelled\n\n")
-			flusher.Flush()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	write := func(name string, data []byte) error {
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: manifest.Created,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	}
+	for _, artifact := range artifacts {
+		if err := write(artifact.name, artifact.data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := write(bundleManifestName, manifestJSON); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if signature != "" {
+		if err := write(bundleSignatureName, []byte(signature+"\n")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
-		default:
 		}
+	}
+	if err := archive.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		if len(match) > 1 {
-			host := strings.ToLower(match[1])
-			if _, dup := seen[host]; !dup {
-				seen[host] = struct{}{}
-
-				result := Result{
-					Host:      host,
-					Source:    "search",
-					Status:    "discovered",
-					Timestamp: time.Now(),
-				}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.ID+"-bundle.zip"))
+	w.Write(buf.Bytes())
+}
 
-				job.AddResult("search", result)
+// verifyEvidence checks a job bundle (zip) or signed JSON envelope against
+// an ed25519 public key and returns a one-line summary
+func verifyEvidence(path, publicKeyPath string) (string, error) {
+	if publicKeyPath == "" {
+		return "", errors.New("--public-key is required")
+	}
+	key, err := loadVerifyKey(publicKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("loading public key: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
 
-				fmt.Fprintf(w, "data: %s\n\n", host)
-				flusher.Flush()
-			}
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		var envelope SignedEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return "", fmt.Errorf("neither a zip bundle nor a JSON envelope: %w", err)
 		}
+		if err := verifyJSONSignature(key, envelope.Job, envelope.Signature); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("envelope signature valid (key %s)", keyFingerprint(key)), nil
 	}
 
-	log.Printf("Search engine found %d unique hosts for %s", len(seen), target)
-	fmt.Fprintf(w, "event: complete\ndata: Search engine scan completed - found %d hosts\n\n", len(seen))
-	flusher.Flush()
-}
-
-func permuteStream(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	if target == "" {
-		http.Error(w, "missing target parameter", http.StatusBadRequest)
-		return
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	files := make(map[string][]byte)
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", f.Name, err)
+		}
+		files[f.Name] = content
 	}
 
-	sseHeader(w)
-	flusher, ok := w.(http.Flusher)
+	manifestJSON, ok := files[bundleManifestName]
 	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
-		return
+		return "", errors.New("bundle has no " + bundleManifestName)
+	}
+	signature, ok := files[bundleSignatureName]
+	if !ok {
+		return "", errors.New("bundle is not signed (no " + bundleSignatureName + ")")
+	}
+	if err := verifyJSONSignature(key, manifestJSON, string(signature)); err != nil {
+		return "", fmt.Errorf("manifest: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Permute)
-	defer cancel()
+	var manifest BundleManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return "", err
+	}
+	listed := map[string]bool{bundleManifestName: true, bundleSignatureName: true}
+	for _, artifact := range manifest.Artifacts {
+		listed[artifact.Name] = true
+		content, ok := files[artifact.Name]
+		if !ok {
+			return "", fmt.Errorf("%s is listed in the manifest but missing", artifact.Name)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != artifact.SHA256 || int64(len(content)) != artifact.Size {
+			return "", fmt.Errorf("%s does not match its manifest hash", artifact.Name)
+		}
+	}
+	for name := range files {
+		if !listed[name] {
+			return "", fmt.Errorf("%s is not listed in the manifest", name)
+		}
+	}
+	return fmt.Sprintf("bundle for job %s valid: %d artifacts match the signed manifest (key %s)",
+		manifest.JobID, len(manifest.Artifacts), keyFingerprint(key)), nil
+}
 
-	job := createJob(target, []string{"permute"})
-	defer job.Complete()
+// prefixTrie is a binary trie over address bits for longest-prefix
+// matching, so a lookup costs at most one step per prefix bit however many
+// ranges are loaded
+type prefixTrie[T any] struct {
+	v4, v6 *trieNode[T]
+}
 
-	permutations := generatePermutations(target)
-	seen := make(map[string]struct{})
-	semaphore := make(chan struct{}, config.DNS.Concurrency)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
+type trieNode[T any] struct {
+	children [2]*trieNode[T]
+	value    T
+	set      bool
+}
 
-	for _, perm := range permutations {
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(w, "event: complete\ndata: Permutation scan cancelled\n\n")
-			flusher.Flush()
-			return
-		default:
+// Insert stores value for network, replacing any value for the same prefix
+func (t *prefixTrie[T]) Insert(network *net.IPNet, value T) {
+	addr, root := t.root(network.IP, true)
+	if root == nil {
+		return
+	}
+	ones, _ := network.Mask.Size()
+	node := *root
+	for i := 0; i < ones; i++ {
+		bit := addr[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode[T]{}
 		}
+		node = node.children[bit]
+	}
+	node.value, node.set = value, true
+}
 
-		wg.Add(1)
-		go func(host string) {
-			defer wg.Done()
+// Lookup returns the value of the longest prefix containing ip
+func (t *prefixTrie[T]) Lookup(ip net.IP) (T, bool) {
+	var best T
+	found := false
+	addr, root := t.root(ip, false)
+	if root == nil || *root == nil {
+		return best, false
+	}
+	node := *root
+	for i := 0; node != nil; i++ {
+		if node.set {
+			best, found = node.value, true
+		}
+		if i == len(addr)*8 {
+			break
+		}
+		node = node.children[addr[i/8]>>(7-i%8)&1]
+	}
+	return best, found
+}
 
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+// root returns ip in its family's byte form and that family's root,
+// creating the root when asked to
+func (t *prefixTrie[T]) root(ip net.IP, create bool) (net.IP, **trieNode[T]) {
+	root := &t.v6
+	addr := ip.To16()
+	if v4 := ip.To4(); v4 != nil {
+		root, addr = &t.v4, v4
+	}
+	if addr == nil {
+		return nil, nil
+	}
+	if *root == nil && create {
+		*root = &trieNode[T]{}
+	}
+	return addr, root
+}
 
-			ips, err := dnsResolver.LookupHost(ctx, host)
-			if err == nil && len(ips) > 0 {
-				mu.Lock()
-				if _, dup := seen[host]; !dup {
-					seen[host] = struct{}{}
+// CloudRange is a published address range of a cloud provider
+type CloudRange struct {
+	Provider string `json:"provider"`
+	Prefix   string `json:"prefix"`
+	Region   string `json:"region,omitempty"`
+	Service  string `json:"service,omitempty"`
+}
 
-					result := Result{
-						Host:      host,
-						Source:    "permute",
-						Status:    "discovered",
-						Timestamp: time.Now(),
-					}
+// CloudFeedStatus is where one provider's ranges currently come from
+type CloudFeedStatus struct {
+	Provider  string    `json:"provider"`
+	Source    string    `json:"source"` // embedded or feed
+	Date      string    `json:"date"`   // of the snapshot or feed
+	Ranges    int       `json:"ranges"`
+	FetchedAt time.Time `json:"fetched_at,omitzero"`
+	Error     string    `json:"error,omitempty"` // last refresh failure
+}
 
-					job.AddResult("permute", result)
+//go:embed cloudranges.json
+var embeddedCloudRanges []byte
 
-					fmt.Fprintf(w, "data: %s\n\n", host)
-					flusher.Flush()
-				}
-				mu.Unlock()
-			}
-		}(perm)
-	}
+// cloudRangeSet is the ranges in use: each provider's list, its status,
+// and a trie over all of them
+type cloudRangeSet struct {
+	ranges map[string][]CloudRange
+	status map[string]CloudFeedStatus
+	trie   *prefixTrie[CloudRange]
+}
 
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+var (
+	cloudRanges   atomic.Pointer[cloudRangeSet]
+	cloudRangesMu sync.Mutex // serializes updates
+)
 
-	select {
-	case <-done:
-		log.Printf("Permutation generation found %d unique hosts for %s", len(seen), target)
-		fmt.Fprintf(w, "event: complete\ndata: Permutation scan completed - found %d hosts\n\n", len(seen))
-		flusher.Flush()
-	case <-ctx.Done():
-		log.Printf("Permutation generation cancelled for %s", target)
-		fmt.Fprintf(w, "event: complete\ndata: Permutation scan cancelled\n\n")
-		flusher.Flush()
-	}
+// cloudFeeds are the providers' published range feeds and their parsers,
+// which return the ranges and the feed's own date when it has one
+var cloudFeeds = []struct {
+	provider string
+	url      func(CloudConfig) string
+	parse    func([]byte) ([]CloudRange, string, error)
+}{
+	{"aws", func(c CloudConfig) string { return c.AWSURL }, parseAWSRanges},
+	{"gcp", func(c CloudConfig) string { return c.GCPURL }, parseGCPRanges},
+	{"azure", func(c CloudConfig) string { return c.AzureURL }, parseAzureRanges},
+	{"cloudflare", func(c CloudConfig) string { return c.CloudflareURL }, parseCloudflareRanges},
 }
 
-func zoneTransferStream(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	if target == "" {
-		http.Error(w, "missing target parameter", http.StatusBadRequest)
-		return
+// initializeCloudRanges loads the embedded snapshot and, when
+// CLOUD_RANGES_REFRESH is set, keeps refreshing it from the feeds
+func initializeCloudRanges() {
+	var snapshot struct {
+		Date   string       `json:"date"`
+		Ranges []CloudRange `json:"ranges"`
 	}
-
-	sseHeader(w)
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+	if err := json.Unmarshal(embeddedCloudRanges, &snapshot); err != nil {
+		log.Printf("Warning: embedded cloud ranges are invalid: %v", err)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), config.Timeouts.Zone)
-	defer cancel()
-
-	job := createJob(target, []string{"zone"})
-	defer job.Complete()
-
-	// Look up nameservers for the domain
-	nsRecords, err := net.LookupNS(target)
-	if err != nil {
-		log.Printf("Failed to lookup NS records for %s: %v", target, err)
-		// Send error message to client
-		fmt.Fprintf(w, "event: complete\ndata: Zone transfer completed with errors - Failed to lookup NS records\n\n")
-		flusher.Flush()
-		return
+	set := &cloudRangeSet{ranges: make(map[string][]CloudRange), status: make(map[string]CloudFeedStatus)}
+	for _, r := range snapshot.Ranges {
+		set.ranges[r.Provider] = append(set.ranges[r.Provider], r)
 	}
+	for provider, ranges := range set.ranges {
+		set.status[provider] = CloudFeedStatus{Provider: provider, Source: "embedded", Date: snapshot.Date, Ranges: len(ranges)}
+	}
+	set.trie = buildCloudTrie(set.ranges)
+	cloudRanges.Store(set)
 
-	// Send nameserver information to client
-	fmt.Fprintf(w, "data: info: Found %d nameservers for %s\n\n", len(nsRecords), target)
-	flusher.Flush()
-
-	seen := make(map[string]struct{})
+	if interval := currentConfig().Cloud.RefreshInterval; interval > 0 {
+		go func() {
+			for {
+				refreshCloudRanges(context.Background())
+				time.Sleep(interval)
+			}
+		}()
+	}
+}
 
-	// Try zone transfer against each nameserver
-	for _, ns := range nsRecords {
-		select {
-		case <-ctx.Done():
-			fmt.Fprintf(w, "event: complete\ndata: Zone transfer scan cancelled\n\n")
-			flusher.Flush()
-			return
-		default:
+// buildCloudTrie indexes every provider's ranges. Where the same prefix is
+// listed twice, the entry naming a service wins over the generic one.
+func buildCloudTrie(ranges map[string][]CloudRange) *prefixTrie[CloudRange] {
+	trie := &prefixTrie[CloudRange]{}
+	for _, list := range ranges {
+		for _, r := range list {
+			_, network, err := net.ParseCIDR(r.Prefix)
+			if err != nil {
+				continue
+			}
+			if existing, ok := trie.Lookup(network.IP); ok && existing.Prefix == network.String() && r.Service == "" {
+				continue
+			}
+			r.Prefix = network.String()
+			trie.Insert(network, r)
 		}
+	}
+	return trie
+}
 
-		log.Printf("Attempting zone transfer from %s for %s", ns.Host, target)
-		
-		// Send status update to client
-		fmt.Fprintf(w, "data: status: Testing nameserver %s\n\n", ns.Host)
-		flusher.Flush()
+// refreshCloudRanges fetches every configured feed. A provider whose feed
+// fails keeps the ranges it had, and the failure is recorded next to the
+// date of those ranges.
+func refreshCloudRanges(ctx context.Context) {
+	cfg := currentConfig()
+	client := &http.Client{Timeout: time.Minute, Transport: discoveryTransport(nil)}
 
-		// Simple connection test (actual zone transfer would need more complex DNS library usage)
-		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ns.Host, "53"), 5*time.Second)
-		if err != nil {
-			log.Printf("Failed to connect to nameserver %s: %v", ns.Host, err)
-			fmt.Fprintf(w, "data: error: Failed to connect to %s: %v\n\n", ns.Host, err)
-			flusher.Flush()
+	for _, feed := range cloudFeeds {
+		feedURL := feed.url(cfg.Cloud)
+		if feedURL == "" {
 			continue
 		}
-		conn.Close()
+		ranges, date, err := fetchCloudFeed(ctx, client, feedURL, feed.parse)
 
-		// If we successfully connected, record the nameserver
-		if _, dup := seen[ns.Host]; !dup {
-			seen[ns.Host] = struct{}{}
-			
-			result := Result{
-				Host:      ns.Host,
-				Source:    "zone",
-				Status:    "nameserver",
-				Title:     fmt.Sprintf("Nameserver for %s", target),
-				Timestamp: time.Now(),
-			}
-			
-			job.AddResult("zone", result)
-			
-			// Send nameserver as a result (even though it's not a subdomain, it's useful info)
-			fmt.Fprintf(w, "data: %s\n\n", ns.Host)
-			flusher.Flush()
+		cloudRangesMu.Lock()
+		current := cloudRanges.Load()
+		next := &cloudRangeSet{ranges: maps.Clone(current.ranges), status: maps.Clone(current.status), trie: current.trie}
+		status := next.status[feed.provider]
+		status.Provider = feed.provider
+		if err != nil {
+			status.Error = err.Error()
+			log.Printf("Warning: %s range feed failed, keeping %s ranges from %s: %v", feed.provider, status.Source, status.Date, err)
+		} else {
+			next.ranges[feed.provider] = ranges
+			next.trie = buildCloudTrie(next.ranges)
+			status = CloudFeedStatus{Provider: feed.provider, Source: "feed", Date: date, Ranges: len(ranges), FetchedAt: time.Now()}
 		}
-
-		log.Printf("Successfully connected to nameserver %s (zone transfer would require DNS protocol implementation)", ns.Host)
+		next.status[feed.provider] = status
+		cloudRanges.Store(next)
+		cloudRangesMu.Unlock()
 	}
-
-	// Send completion message
-	log.Printf("Zone transfer attempt completed for %s (found %d nameservers)", target, len(nsRecords))
-	fmt.Fprintf(w, "event: complete\ndata: Zone transfer scan completed - found %d nameservers\n\n", len(nsRecords))
-	flusher.Flush()
 }
 
-func generatePermutations(domain string) []string {
-	var permutations []string
-
-	prefixes := []string{"dev", "test", "stage", "staging", "prod", "production", "www", "api", "admin", "app", "mobile", "m"}
-	suffixes := []string{"dev", "test", "stage", "staging", "prod", "production", "api", "admin", "backup", "old", "new"}
-
-	// Add base subdomains
-	for _, prefix := range prefixes {
-		permutations = append(permutations, fmt.Sprintf("%s.%s", prefix, domain))
+// fetchCloudFeed downloads and parses one feed
+func fetchCloudFeed(ctx context.Context, client *http.Client, feedURL string, parse func([]byte) ([]CloudRange, string, error)) ([]CloudRange, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("HTTP %d from %s", resp.StatusCode, feedURL)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024*1024))
+	if err != nil {
+		return nil, "", err
+	}
+	ranges, date, err := parse(body)
+	if err == nil && len(ranges) == 0 {
+		err = errors.New("feed lists no ranges")
 	}
+	if date == "" {
+		date = time.Now().UTC().Format("2006-01-02")
+	}
+	return ranges, date, err
+}
 
-	// Add permutations with suffixes
-	parts := strings.Split(domain, ".")
-	if len(parts) >= 2 {
-		baseDomain := parts[0]
-		tld := strings.Join(parts[1:], ".")
+// parseAWSRanges reads ip-ranges.json
+func parseAWSRanges(body []byte) ([]CloudRange, string, error) {
+	var feed struct {
+		CreateDate string `json:"createDate"`
+		Prefixes   []struct {
+			Prefix  string `json:"ip_prefix"`
+			Region  string `json:"region"`
+			Service string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			Prefix  string `json:"ipv6_prefix"`
+			Region  string `json:"region"`
+			Service string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, "", err
+	}
 
-		for _, suffix := range suffixes {
-			permutations = append(permutations, fmt.Sprintf("%s-%s.%s", baseDomain, suffix, tld))
-			permutations = append(permutations, fmt.Sprintf("%s%s.%s", baseDomain, suffix, tld))
+	var ranges []CloudRange
+	// AMAZON is the catch-all listing; specific services say more
+	service := func(s string) string {
+		if s == "AMAZON" {
+			return ""
 		}
+		return s
 	}
-
-	// Add numbered variations
-	for i := 1; i <= 10; i++ {
-		permutations = append(permutations, fmt.Sprintf("www%d.%s", i, domain))
-		permutations = append(permutations, fmt.Sprintf("mail%d.%s", i, domain))
-		permutations = append(permutations, fmt.Sprintf("ftp%d.%s", i, domain))
+	for _, p := range feed.Prefixes {
+		ranges = append(ranges, CloudRange{Provider: "aws", Prefix: p.Prefix, Region: p.Region, Service: service(p.Service)})
 	}
-
-	return permutations
+	for _, p := range feed.IPv6Prefixes {
+		ranges = append(ranges, CloudRange{Provider: "aws", Prefix: p.Prefix, Region: p.Region, Service: service(p.Service)})
+	}
+	// createDate looks like 2024-06-13-21-13-07
+	date := feed.CreateDate
+	if len(date) >= 10 {
+		date = date[:10]
+	}
+	return ranges, date, nil
 }
 
-// Health check function for containers
-func performHealthCheck() error {
-	// Create a timeout context for the health check
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Determine the port to check
-	port := getEnvString("PORT", "8080")
-	healthURL := fmt.Sprintf("http://localhost:%s/health", port)
-
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create health check request: %w", err)
+// parseGCPRanges reads cloud.json
+func parseGCPRanges(body []byte) ([]CloudRange, string, error) {
+	var feed struct {
+		CreationTime string `json:"creationTime"`
+		Prefixes     []struct {
+			IPv4    string `json:"ipv4Prefix"`
+			IPv6    string `json:"ipv6Prefix"`
+			Service string `json:"service"`
+			Scope   string `json:"scope"`
+		} `json:"prefixes"`
 	}
-
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 3 * time.Second,
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, "", err
 	}
 
-	// Perform the request
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("health check request failed: %w", err)
+	var ranges []CloudRange
+	for _, p := range feed.Prefixes {
+		prefix := p.IPv4
+		if prefix == "" {
+			prefix = p.IPv6
+		}
+		ranges = append(ranges, CloudRange{Provider: "gcp", Prefix: prefix, Region: p.Scope})
 	}
-	defer resp.Body.Close()
+	date := feed.CreationTime
+	if len(date) >= 10 {
+		date = date[:10]
+	}
+	return ranges, date, nil
+}
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("health check failed with status %d: %s", resp.StatusCode, string(body))
+// parseAzureRanges reads a ServiceTags_Public file. Only the regional
+// AzureCloud.<region> tags are used; service tags overlap them.
+func parseAzureRanges(body []byte) ([]CloudRange, string, error) {
+	var feed struct {
+		Values []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				Region          string   `json:"region"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, "", err
 	}
 
-	// Additional checks - verify DNS resolver is working
-	if dnsResolver != nil {
-		testCtx, testCancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer testCancel()
-		
-		_, err := dnsResolver.LookupHost(testCtx, "google.com")
-		if err != nil {
-			return fmt.Errorf("DNS resolver health check failed: %w", err)
+	var ranges []CloudRange
+	for _, value := range feed.Values {
+		if !strings.HasPrefix(value.Name, "AzureCloud.") {
+			continue
+		}
+		for _, prefix := range value.Properties.AddressPrefixes {
+			ranges = append(ranges, CloudRange{Provider: "azure", Prefix: prefix, Region: value.Properties.Region})
 		}
 	}
-
-	return nil
+	return ranges, "", nil
 }
 
-// Version handler for API endpoint
-func versionHandler(w http.ResponseWriter, r *http.Request) {
-	versionInfo := map[string]interface{}{
-		"version":     version,
-		"build_time":  buildTime,
-		"git_commit":  gitCommit,
-		"go_version":  runtime.Version(),
-		"platform":    fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
-		"uptime":      time.Since(stats.StartTime).String(),
-		"start_time":  stats.StartTime,
+// parseCloudflareRanges reads the /client/v4/ips API response
+func parseCloudflareRanges(body []byte) ([]CloudRange, string, error) {
+	var feed struct {
+		Result struct {
+			IPv4 []string `json:"ipv4_cidrs"`
+			IPv6 []string `json:"ipv6_cidrs"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, "", err
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(versionInfo)
-}
-
-// Enhanced job management endpoints
-func jobsHandler(w http.ResponseWriter, r *http.Request) {
-	jobManager.mu.RLock()
-	defer jobManager.mu.RUnlock()
-	
-	jobs := make([]*Job, 0, len(jobManager.jobs))
-	for _, job := range jobManager.jobs {
-		jobs = append(jobs, job)
+	var ranges []CloudRange
+	for _, prefix := range append(feed.Result.IPv4, feed.Result.IPv6...) {
+		ranges = append(ranges, CloudRange{Provider: "cloudflare", Prefix: prefix})
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(jobs)
+	return ranges, "", nil
 }
 
-func jobDetailHandler(w http.ResponseWriter, r *http.Request) {
-	jobID := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
-	
-	jobManager.mu.RLock()
-	job, exists := jobManager.jobs[jobID]
-	jobManager.mu.RUnlock()
-	
-	if !exists {
-		http.Error(w, "job not found", http.StatusNotFound)
-		return
+// classifyCloudIP returns the provider range ip falls in, if any
+func classifyCloudIP(ip net.IP) (CloudRange, bool) {
+	set := cloudRanges.Load()
+	if set == nil {
+		return CloudRange{}, false
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(job)
+	return set.trie.Lookup(ip)
 }
 
-func statusHandler(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	
-	jobManager.mu.RLock()
-	defer jobManager.mu.RUnlock()
-	
-	activeJobs := make([]*Job, 0)
-	for _, job := range jobManager.jobs {
-		if job.Target == target && job.Status == "running" {
-			activeJobs = append(activeJobs, job)
-		}
+// cloudRangeStatus reports each provider's range source and date
+func cloudRangeStatus() []CloudFeedStatus {
+	set := cloudRanges.Load()
+	if set == nil {
+		return nil
 	}
-	
-	status := map[string]interface{}{
-		"target":      target,
-		"active_jobs": len(activeJobs),
-		"jobs":        activeJobs,
+	statuses := make([]CloudFeedStatus, 0, len(set.status))
+	for _, status := range set.status {
+		statuses = append(statuses, status)
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Provider < statuses[j].Provider })
+	return statuses
 }
-
-func abortHandler(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	
-	jobManager.mu.Lock()
-	cancelled := 0
-	for _, job := range jobManager.jobs {
-		if job.Target == target && job.Status == "running" {
-			if job.Cancel != nil {
-				job.Cancel()
-			}
-			job.Status = "cancelled"
-			cancelled++
-		}
-	}
-	jobManager.mu.Unlock()
-	
-	log.Printf("Cancelled %d jobs for target: %s", cancelled, target)
-	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
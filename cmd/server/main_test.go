@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain builds the server's global components once, in memory, for
+// every test; tests that need other settings change the config they use.
+// Egress lookups go nowhere, falling back to the interface addresses at
+// once, so the checks that every job start and end makes stay off the
+// network and don't queue behind each other.
+func TestMain(m *testing.M) {
+	os.Setenv("DATA_DIR", "")
+	os.Setenv("EGRESS_ECHO_ENDPOINTS", "http://127.0.0.1:1")
+	initialize()
+	os.Exit(m.Run())
+}
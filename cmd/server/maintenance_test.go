@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tickSource reports n hosts, one every interval
+type tickSource struct {
+	n        int
+	interval time.Duration
+}
+
+func (s tickSource) Name() string { return "tick" }
+
+func (s tickSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	for i := 0; i < s.n; i++ {
+		select {
+		case <-time.After(s.interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !emit(ctx, out, Result{Host: fmt.Sprintf("h%d.%s", i, target)}) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// setMaintenance posts body to the maintenance endpoint
+func setMaintenance(t *testing.T, body string) map[string]interface{} {
+	t.Helper()
+	response := httptest.NewRecorder()
+	maintenanceHandler(response, httptest.NewRequest(http.MethodPost, "/api/admin/maintenance", strings.NewReader(body)))
+	if response.Code != http.StatusOK {
+		t.Fatalf("%s: HTTP %d: %s", body, response.Code, response.Body)
+	}
+	var answer map[string]interface{}
+	json.NewDecoder(response.Body).Decode(&answer)
+	return answer
+}
+
+// A paused job's source is held until maintenance is left, and the pause
+// counts neither against its timeout nor in its durations
+func TestMaintenancePause(t *testing.T) {
+	t.Cleanup(func() { maintenance.Set(MaintenanceState{}) })
+
+	job, ctx := createJob(context.Background(), "example.com", []string{"tick"}, nil)
+	var found int64
+	outcome := make(chan string, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer job.Complete()
+		runSources(ctx, job, "example.com", []sourceEntry{{
+			source:  tickSource{n: 10, interval: 20 * time.Millisecond},
+			label:   "tick",
+			timeout: func(TimeoutConfig) time.Duration { return time.Second },
+		}}, nil, sourceHooks{
+			result: func(Result) { atomic.AddInt64(&found, 1) },
+			notice: func(string, string, string) {},
+			event:  func(string, string, interface{}) {},
+			done:   func(_, result string, _ int) { outcome <- result },
+		})
+	}()
+
+	for atomic.LoadInt64(&found) < 3 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if answer := setMaintenance(t, `{"enabled": true, "action": "pause"}`); answer["paused_jobs"] != float64(1) {
+		t.Errorf("pause answered %v, want 1 paused job", answer)
+	}
+	// A result already on its way may still land
+	time.Sleep(50 * time.Millisecond)
+	held := atomic.LoadInt64(&found)
+	time.Sleep(1200 * time.Millisecond)
+	if got := atomic.LoadInt64(&found); got != held {
+		t.Errorf("%d results arrived while paused", got-held)
+	}
+	job.mu.RLock()
+	ended := !job.EndTime.IsZero()
+	job.mu.RUnlock()
+	if ended {
+		t.Fatal("paused job ended")
+	}
+
+	setMaintenance(t, `{"enabled": false}`)
+	select {
+	case result := <-outcome:
+		if result != "complete" {
+			t.Errorf("source ended %s after the pause, want complete", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("source never resumed")
+	}
+	if got := atomic.LoadInt64(&found); got != 10 {
+		t.Errorf("found %d hosts, want 10", got)
+	}
+
+	<-finished
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	if job.Paused < time.Second || job.Duration != job.EndTime.Sub(job.StartTime)-job.Paused || job.Duration > time.Second {
+		t.Errorf("job ran %v with %v paused, want under a second after over one paused", job.Duration, job.Paused)
+	}
+	if timing := job.SourceTimings["tick"]; timing.Paused < time.Second || timing.Duration > time.Second {
+		t.Errorf("source ran %v with %v paused, want under a second after over one paused", timing.Duration, timing.Paused)
+	}
+}
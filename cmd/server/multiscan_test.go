@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fixedSource reports the same full host names for any target, after
+// delay, counting how many of its scans run at once
+type fixedSource struct {
+	name    string
+	hosts   []string
+	delay   time.Duration
+	running *int64
+	peak    *int64
+}
+
+func (s fixedSource) Name() string { return s.name }
+
+func (s fixedSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	if s.running != nil {
+		now := atomic.AddInt64(s.running, 1)
+		defer atomic.AddInt64(s.running, -1)
+		for peak := atomic.LoadInt64(s.peak); now > peak && !atomic.CompareAndSwapInt64(s.peak, peak, now); peak = atomic.LoadInt64(s.peak) {
+		}
+	}
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for _, host := range s.hosts {
+		if !emit(ctx, out, Result{Host: host}) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// submitScan posts a scan of targets with source to server and returns
+// the job ID it answers with
+func submitScan(t *testing.T, server *httptest.Server, source string, targets ...string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"targets": targets, "sources": []string{source}})
+	resp, err := http.Post(server.URL+"/api/scan", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var accepted struct {
+		JobID string `json:"job_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&accepted); err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("scan of %v: HTTP %d, %v", targets, resp.StatusCode, err)
+	}
+	return accepted.JobID
+}
+
+// Children keep their own dedup sets, their events reach the parent's
+// stream under their target, and the parent's hosts filter by target
+func TestMultiScan(t *testing.T) {
+	useSources(t, fixedSource{name: "fixed", hosts: []string{"www.a.example.com"}})
+	// A job holding the only slot keeps the children from starting until
+	// the stream below follows the parent
+	blocker, _ := createJob(context.Background(), "blocker.example.com", []string{"test"}, nil)
+	cfg := *currentConfig()
+	cfg.Security.MaxConcurrentJobs = int(atomic.LoadInt64(&stats.ActiveJobs))
+	useConfig(t, &cfg)
+	server := httptest.NewServer(newRouter(&cfg))
+	defer server.Close()
+
+	// The host is under both targets, so each child reports it
+	id := submitScan(t, server, "fixed", "example.com", "a.example.com")
+	time.AfterFunc(300*time.Millisecond, blocker.Complete)
+	events := streamEvents(t, server.URL+"/api/jobs/"+id+"/stream")
+	if len(events) == 0 || events[len(events)-1].name != "complete" {
+		t.Fatalf("parent stream did not end on its complete event: %v", events)
+	}
+	children := make(map[string]string)
+	labels := make(map[string][]string)
+	for _, event := range events[:len(events)-1] {
+		if event.name == "complete" {
+			t.Error("a child's complete event reached the parent unrenamed")
+		}
+		var wrapped targetEvent
+		if event.name == "start" || json.Unmarshal(event.data, &wrapped) != nil || wrapped.Target == "" {
+			continue
+		}
+		labels[wrapped.Target] = append(labels[wrapped.Target], event.name)
+		if wrapped.Job != "" {
+			children[wrapped.Target] = wrapped.Job
+		}
+	}
+	for _, target := range []string{"example.com", "a.example.com"} {
+		got := strings.Join(labels[target], ",")
+		if !strings.HasPrefix(got, "target-start,") || !strings.HasSuffix(got, ",target-complete") || !strings.Contains(got, "message") {
+			t.Errorf("%s: events %s, want target-start, its results, target-complete", target, got)
+		}
+		child, ok := lookupJob(children[target])
+		if !ok {
+			t.Errorf("%s: no child job %q", target, children[target])
+			continue
+		}
+		child.mu.RLock()
+		parentID := child.ParentID
+		child.mu.RUnlock()
+		if _, found := child.Hosts()["www.a.example.com"]; !found || parentID != id {
+			t.Errorf("%s: child %s (parent %s) has hosts %v", target, child.ID, parentID, child.Hosts())
+		}
+	}
+
+	for _, target := range []string{"example.com", "a.example.com", "other.example.com"} {
+		resp, err := http.Get(server.URL + "/api/jobs/" + id + "/hosts?target=" + url.QueryEscape(target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var listed struct {
+			Hosts []JobHost `json:"hosts"`
+		}
+		json.NewDecoder(resp.Body).Decode(&listed)
+		resp.Body.Close()
+		found := false
+		for _, host := range listed.Hosts {
+			found = found || host.Host == "www.a.example.com"
+			if host.Target != target {
+				t.Errorf("hosts for target=%s list %s under %s", target, host.Host, host.Target)
+			}
+		}
+		if want := target != "other.example.com"; found != want {
+			t.Errorf("hosts for target=%s: www.a.example.com listed %v, want %v", target, found, want)
+		}
+	}
+}
+
+// Children start no faster than MaxConcurrentJobs allows
+func TestMultiScanFanOut(t *testing.T) {
+	var running, peak int64
+	useSources(t, fixedSource{name: "slow", delay: 300 * time.Millisecond, running: &running, peak: &peak})
+	cfg := *currentConfig()
+	// Jobs other tests left running take slots too
+	cfg.Security.MaxConcurrentJobs = int(atomic.LoadInt64(&stats.ActiveJobs)) + 2
+	useConfig(t, &cfg)
+	server := httptest.NewServer(newRouter(&cfg))
+	defer server.Close()
+
+	id := submitScan(t, server, "slow", "a.example.com", "b.example.com", "c.example.com", "d.example.com")
+	streamEvents(t, server.URL+"/api/jobs/"+id+"/stream")
+	if got := atomic.LoadInt64(&peak); got != 2 {
+		t.Errorf("%d children ran at once, want 2", got)
+	}
+	parent, _ := lookupJob(id)
+	parent.mu.RLock()
+	defer parent.mu.RUnlock()
+	if len(parent.Children) != 4 {
+		t.Errorf("parent started %d children, want 4", len(parent.Children))
+	}
+}
+
+// A submitted scan runs in the background: its stream follows it live or
+// replays it once finished, each host once, and a follower of an aborted
+// scan gets a cancelled complete event. Submissions past
+// MaxConcurrentJobs are turned away with 429.
+func TestScanSubmit(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	hosts := []string{"www." + tt.Zone, "api." + tt.Zone}
+	useSources(t,
+		fixedSource{name: "fixed", hosts: hosts, delay: 200 * time.Millisecond},
+		fixedSource{name: "stuck", delay: time.Minute})
+	cfg.Security.MaxConcurrentJobs = int(atomic.LoadInt64(&stats.ActiveJobs)) + 1
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	// found lists the hosts of a job's stream and its complete event
+	found := func(events []sseEvent) (names []string, complete map[string]interface{}) {
+		for _, event := range events {
+			var result Result
+			switch {
+			case event.name == "complete":
+				json.Unmarshal(event.data, &complete)
+			case event.name == "message" && json.Unmarshal(event.data, &result) == nil && result.Source == "fixed":
+				names = append(names, result.Host)
+			}
+		}
+		return names, complete
+	}
+	id := submitScan(t, server, "fixed", tt.Zone)
+	for _, follow := range []string{"live", "replayed"} {
+		names, complete := found(streamEvents(t, server.URL+"/api/jobs/"+id+"/stream"))
+		slices.Sort(names)
+		if want := slices.Sorted(slices.Values(hosts)); !slices.Equal(names, want) || complete == nil || complete["cancelled"] != false {
+			t.Errorf("%s: hosts %v and complete %v, want %v", follow, names, complete, want)
+		}
+	}
+
+	id = submitScan(t, server, "stuck", tt.Zone)
+	body := `{"targets": ["other.example.com"], "sources": ["fixed"]}`
+	resp, err := http.Post(server.URL+"/api/scan", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("scan past the limit: HTTP %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	time.AfterFunc(100*time.Millisecond, func() {
+		resp, err := http.Post(server.URL+"/api/jobs/"+id+"/abort", "", nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+	})
+	if _, complete := found(streamEvents(t, server.URL+"/api/jobs/"+id+"/stream")); complete == nil || complete["cancelled"] != true {
+		t.Errorf("follower of an aborted scan completed with %v", complete)
+	}
+
+	for _, body := range []string{`{"targets": []}`, `{"targets": ["not a host"]}`, `{"targets": ["example.com"], "sources": ["nonexistent"]}`, `{`} {
+		resp, err := http.Post(server.URL+"/api/scan", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: HTTP %d, want 400", body, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+// usePassiveOnly turns passive-only mode on for the rest of the test, as
+// startup would: with the probe client built refusing, and only the
+// passive sources the synthetic target stands in for left to run
+func usePassiveOnly(t *testing.T) {
+	t.Helper()
+	saved, disabled, probe := sourceRegistry, disabledSources, sharedProbe.Load()
+	t.Cleanup(func() {
+		passiveOnly = false
+		sourceRegistry, disabledSources = saved, disabled
+		sharedProbe.Store(probe)
+	})
+	sourceRegistry = slices.DeleteFunc(slices.Clone(sourceRegistry), func(entry sourceEntry) bool {
+		name := entry.source.Name()
+		return !entry.active && !entry.resolves && name != "crtsh" && name != "wayback"
+	})
+	disabledSources = nil
+	t.Setenv("PASSIVE_ONLY", "true")
+	initializePassiveMode()
+	sharedProbe.Store(newProbeClient(currentConfig()))
+}
+
+// A passive-only scan of the synthetic target, and every blocked path
+// tried directly, reach neither its DNS nor its web listeners
+func TestPassiveOnly(t *testing.T) {
+	useConfig(t, currentConfig())
+	usePassiveOnly(t)
+	if len(disabledSources) == 0 {
+		t.Fatal("passive-only mode disabled no sources")
+	}
+
+	var passed bool
+	var err error
+	// The port is free for UDP when picked; TCP may still take it first
+	for range 5 {
+		var conn net.PacketConn
+		if conn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		addr := conn.LocalAddr().String()
+		conn.Close()
+		if passed, err = runPassiveCheck(addr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !passed {
+		t.Error("passive-only check failed; see its output")
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Every kind of permutation is generated once per name, never for names
+// already seen, and never past the limit
+func TestPermutationEngine(t *testing.T) {
+	engine := newPermutationEngine("example.com", []string{"dev", "2024"}, 1000)
+	got := engine.Generate("api2.example.com")
+	// Two numbered, and per word two inserted and four joined
+	if len(got) != 14 {
+		t.Errorf("api2 gave %d candidates, want 14: %v", len(got), got)
+	}
+	for _, want := range []string{"api1.example.com", "api3.example.com", "dev.api2.example.com", "api2.2024.example.com", "api2-dev.example.com", "devapi2.example.com"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("api2 did not give %s", want)
+		}
+	}
+	if again := engine.Generate("api2.example.com"); len(again) != 0 {
+		t.Errorf("api2 again gave %v", again)
+	}
+
+	tokens := engine.Generate("gitlab-staging.example.com")
+	for _, want := range []string{"gitlab-dev.example.com", "gitlab.example.com", "gitlab2.example.com", "gitlab.staging.example.com", "dev-staging.example.com"} {
+		if !slices.Contains(tokens, want) {
+			t.Errorf("gitlab-staging did not give %s: %v", want, tokens)
+		}
+	}
+	if dots := engine.Generate("a.b.example.com"); !slices.Contains(dots, "a-b.example.com") {
+		t.Errorf("a.b did not give a-b: %v", dots)
+	}
+	if outside := engine.Generate("www.example.org"); outside != nil {
+		t.Errorf("a name outside the target gave %v", outside)
+	}
+
+	seen := newPermutationEngine("example.com", []string{"dev"}, 1000)
+	seen.Seen("api1.example.com")
+	if got := seen.Generate("api2.example.com"); slices.Contains(got, "api1.example.com") || len(got) != 7 {
+		t.Errorf("with api1 seen, api2 gave %v", got)
+	}
+
+	capped := newPermutationEngine("example.com", alterationWords, 500)
+	unique := make(map[string]bool)
+	for i := range 50 {
+		for _, candidate := range capped.Generate(fmt.Sprintf("svc-%d.eu%02d.example.com", i, i)) {
+			if unique[candidate] {
+				t.Errorf("%s generated twice", candidate)
+			}
+			unique[candidate] = true
+		}
+	}
+	if len(unique) != 500 || !capped.Exhausted() {
+		t.Errorf("generated %d candidates under a limit of 500 (exhausted %v)", len(unique), capped.Exhausted())
+	}
+}
+
+// mode=smart permutes what the target's latest scan found, resolving only
+// names that scan didn't have
+func TestSmartPermutations(t *testing.T) {
+	// Scans of the zone by earlier tests would count as found already
+	useJobManager(t)
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	if notices := permuteNotices(t, server, tt.Zone); len(notices) == 0 {
+		t.Error("mode=smart with nothing found yet gave no warning")
+	}
+	job, _ := createJob(context.Background(), tt.Zone, []string{"dns"}, nil)
+	for _, host := range []string{"dev-api", "api"} {
+		job.AddResult("dns", Result{Host: host + "." + tt.Zone, Source: "dns", Status: "discovered"})
+	}
+	job.Complete()
+
+	var hosts []string
+	query := url.Values{"target": {tt.Zone}, "mode": {"smart"}}
+	for _, event := range streamEvents(t, server.URL+"/api/permute/stream?"+query.Encode()) {
+		var result Result
+		if event.name == "message" && json.Unmarshal(event.data, &result) == nil {
+			hosts = append(hosts, result.Host)
+		}
+	}
+	// Of what dev-api gives, the zone has api (found already), api2 and dev
+	slices.Sort(hosts)
+	if want := []string{"api2." + tt.Zone, "dev." + tt.Zone}; !slices.Equal(hosts, want) {
+		t.Errorf("mode=smart found %v, want %v", hosts, want)
+	}
+}
+
+// permuteNotices runs a mode=smart permutation of target and returns its
+// warnings
+func permuteNotices(t *testing.T, server *httptest.Server, target string) []string {
+	t.Helper()
+	var notices []string
+	query := url.Values{"target": {target}, "mode": {"smart"}}
+	for _, event := range streamEvents(t, server.URL+"/api/permute/stream?"+query.Encode()) {
+		var notice struct {
+			Kind    string `json:"kind"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(event.data, &notice) == nil && notice.Kind == "warning" {
+			notices = append(notices, notice.Message)
+		}
+	}
+	return notices
+}
+
+// In a combined scan, what passive sources find is permuted as it comes
+// in, and each name found that way says what it was derived from
+func TestFeedbackPermutations(t *testing.T) {
+	useSources(t, staticSource{"static-passive", []string{"dev-api"}})
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	found := make(map[string]Result)
+	query := url.Values{"target": {tt.Zone}, "sources": {"static-passive,feedback"}}
+	for _, event := range streamEvents(t, server.URL+"/api/enumerate/stream?"+query.Encode()) {
+		var result Result
+		if event.name == "message" && json.Unmarshal(event.data, &result) == nil && result.Source == "feedback" {
+			found[result.Host] = result
+		}
+	}
+	seed := "dev-api." + tt.Zone
+	for _, host := range []string{"api." + tt.Zone, "api2." + tt.Zone, "dev." + tt.Zone} {
+		if result, ok := found[host]; !ok || result.Seed != seed || result.SeedSource != "static-passive" {
+			t.Errorf("%s: found %v as %+v, want it derived from %s", host, ok, result, seed)
+		}
+	}
+	if len(found) != 3 {
+		t.Errorf("feedback found %d names, want 3", len(found))
+	}
+
+	// The budget stops the feedback, saying so
+	budget := *cfg
+	budget.DNS.FeedbackMaxCandidates = 5
+	useConfig(t, &budget)
+	warned := false
+	for _, event := range streamEvents(t, server.URL+"/api/enumerate/stream?"+query.Encode()) {
+		var notice struct {
+			Source  string `json:"source"`
+			Kind    string `json:"kind"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(event.data, &notice)
+		warned = warned || notice.Source == "feedback" && notice.Kind == "warning" && strings.Contains(notice.Message, "limit of 5")
+	}
+	if !warned {
+		t.Error("no warning when feedback stopped at its budget")
+	}
+}
+
+// Each pattern group tries as many names as its doc comment says, all of
+// them valid, and patterns= picks which groups a scan runs
+func TestPermutationPatterns(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		generate func(string, time.Time) []string
+		count    int
+		want     []string
+	}{
+		{yearPermutations, 185, []string{"app-2023.example.com", "2024-portal.example.com", "api2027.example.com", "2026.example.com"}},
+		{datePermutations, 96, []string{"q1-2026.example.com", "2025q4.example.com", "2026-q2.example.com", "jan2026.example.com", "dec-2025.example.com", "2026-01.example.com"}},
+		{cloudPermutations, 156, []string{"example-com-prod.example.com", "examplecom-backup.example.com", "example-prod-s3.example.com", "examplecorp-backup.example.com", "examplecorpassets.example.com"}},
+	} {
+		got := tc.generate("example.com", now)
+		if len(got) != tc.count {
+			t.Errorf("%d names, want %d: %v", len(got), tc.count, got)
+		}
+		for _, want := range tc.want {
+			if !slices.Contains(got, want) {
+				t.Errorf("%s not among %v", want, got)
+			}
+		}
+		for _, name := range got {
+			if !validCandidate(name) {
+				t.Errorf("%s is not a valid candidate", name)
+			}
+		}
+	}
+	if names := cloudPermutations("api.example.co.uk", now); !slices.Contains(names, "example-co-uk-prod.api.example.co.uk") || !slices.Contains(names, "example-dev-storage.api.example.co.uk") {
+		t.Errorf("cloud names for api.example.co.uk are not built from example.co.uk: %v", names)
+	}
+	for _, name := range []string{"-prod.example.com", "prod-.example.com", strings.Repeat("a", 64) + ".example.com", "bad_name.example.com"} {
+		if validCandidate(name) {
+			t.Errorf("%s passed as a valid candidate", name)
+		}
+	}
+
+	for list, want := range map[string]string{"": "basic", "years,cloud": "years,cloud", "cloud, years ,cloud": "cloud,years"} {
+		selected, err := selectPermutationPatterns(list)
+		var names []string
+		for _, pattern := range selected {
+			names = append(names, pattern.name)
+		}
+		if err != nil || strings.Join(names, ",") != want {
+			t.Errorf("patterns=%q selected %v (%v), want %s", list, names, err, want)
+		}
+	}
+	counts := make(map[string]interface{})
+	for _, group := range describePermutationPatterns() {
+		counts[group["name"].(string)] = group["candidates_example_com"]
+	}
+	if counts["years"] != 185 || counts["dates"] != 96 || counts["cloud"] != 156 {
+		t.Errorf("described counts %v", counts)
+	}
+
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/api/permute/stream?target=" + tt.Zone + "&patterns=years,bogus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("an unknown group gave HTTP %d, want 400", resp.StatusCode)
+	}
+	streamEvents(t, server.URL+"/api/permute/stream?target="+tt.Zone+"&patterns=years,cloud")
+	job := latestJob(tt.Zone, nil)
+	if total := job.Progress.Report().Sources["permute"].Total; total != 185+156 {
+		t.Errorf("patterns=years,cloud tried %d names, want %d", total, 185+156)
+	}
+}
+
+// resultSource reports the same results for any target
+type resultSource struct {
+	name    string
+	results []Result
+}
+
+func (s resultSource) Name() string { return s.name }
+
+func (s resultSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	for _, result := range s.results {
+		if !emit(ctx, out, result) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Look-alikes change only the registered domain's first label, by every
+// technique, up to the cap; those found stream as "lookalike" without
+// counting among the job's hosts
+func TestTypoPermutations(t *testing.T) {
+	candidates := typoCandidates("example.com", maxTypoCandidates)
+	if len(candidates) != 75 {
+		t.Errorf("%d look-alikes of example.com, want 75", len(candidates))
+	}
+	techniques := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		techniques[candidate.technique]++
+		if seen[candidate.name] || candidate.name == "example.com" || !strings.HasSuffix(candidate.name, ".com") || !validCandidate(candidate.name) {
+			t.Errorf("look-alike %s (%s) is repeated, the target itself or invalid", candidate.name, candidate.technique)
+		}
+		seen[candidate.name] = true
+	}
+	if len(techniques) != 5 {
+		t.Errorf("techniques used %v, want all five", techniques)
+	}
+	for _, want := range []string{"examp1e.com", "xn--xample-2of.com", "exmple.com", "exaample.com", "wxample.com", "dxample.com"} {
+		if !seen[want] {
+			t.Errorf("%s not among the look-alikes", want)
+		}
+	}
+	if capped := typoCandidates("example.com", 10); len(capped) != 10 {
+		t.Errorf("a cap of 10 gave %d look-alikes", len(capped))
+	}
+	if sub := typoCandidates("example.co.uk", maxTypoCandidates); len(sub) == 0 || !strings.HasSuffix(sub[0].name, ".co.uk") {
+		t.Errorf("look-alikes of example.co.uk keep their suffix: %v", sub)
+	}
+
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+	lookalike := Result{Host: "synthetlc.test", Status: "lookalike", Tags: []string{"lookalike", "homoglyph"}}
+	useSources(t, resultSource{"static-typo", []Result{{Host: "www." + tt.Zone}, lookalike, lookalike}})
+
+	var streamed []Result
+	query := url.Values{"target": {tt.Zone}, "sources": {"static-typo"}}
+	for _, event := range streamEvents(t, server.URL+"/api/enumerate/stream?"+query.Encode()) {
+		var result Result
+		if event.name == "message" && json.Unmarshal(event.data, &result) == nil && result.Host != "" {
+			streamed = append(streamed, result)
+		}
+	}
+	if len(streamed) != 2 || streamed[1].Host != lookalike.Host || streamed[1].Status != "lookalike" {
+		t.Errorf("streamed %+v, want www and the look-alike once", streamed)
+	}
+	job := latestJob(tt.Zone, nil)
+	hosts := job.Hosts()
+	job.mu.RLock()
+	lookalikes := job.Lookalikes
+	job.mu.RUnlock()
+	if _, counted := hosts[lookalike.Host]; counted || len(lookalikes) != 1 {
+		t.Errorf("job has hosts %v and look-alikes %v, want the look-alike kept apart", hosts, lookalikes)
+	}
+
+	checked := false
+	query = url.Values{"target": {"www." + tt.Zone}, "mode": {"typo"}}
+	for _, event := range streamEvents(t, server.URL+"/api/permute/stream?"+query.Encode()) {
+		var notice struct {
+			Kind    string `json:"kind"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(event.data, &notice)
+		checked = checked || notice.Kind == "info" && strings.HasSuffix(notice.Message, "look-alikes of "+tt.Zone)
+	}
+	if !checked {
+		t.Error("mode=typo did not check look-alikes of the registered domain")
+	}
+}
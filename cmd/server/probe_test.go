@@ -0,0 +1,1223 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useSANServer serves HTTPS with a self-signed certificate for names and
+// points the HTTPS probes of cfg at it, uncached so that its answers don't
+// outlive the test
+func useSANServer(t *testing.T, cfg *Config, names ...string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>ok</title>"))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	parsed, _ := url.Parse(server.URL)
+	cfg.HTTP.ProbeHTTPSPort, _ = strconv.Atoi(parsed.Port())
+	cfg.HTTP.SkipTLSVerify = true
+	cfg.HTTP.ProbeCacheTTL = 0
+}
+
+// Certificate SANs found by a probe stream or a scan's probe pass join
+// the job as "tls-san" results and go out on the stream
+func TestProbeSANFeedback(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	certOnly := "cert-only-7f3a." + tt.Zone
+	useSANServer(t, cfg, "www."+tt.Zone, certOnly)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	job, _ := createJob(t.Context(), tt.Zone, []string{"test"}, nil)
+	job.AddResult("test", Result{Host: "www." + tt.Zone, Source: "test", Status: "discovered", Timestamp: time.Now()})
+	job.Complete()
+
+	var streamed []string
+	for _, event := range streamEvents(t, server.URL+"/api/probe/stream?job="+job.ID) {
+		var probe ProbeEvent
+		if event.name == "probe" && json.Unmarshal(event.data, &probe) == nil {
+			for _, result := range probe.Discovered {
+				if result.Source == "tls-san" {
+					streamed = append(streamed, result.Host)
+				}
+			}
+		}
+	}
+	if len(streamed) != 1 || streamed[0] != certOnly {
+		t.Errorf("probe stream discovered %v over TLS, want %s", streamed, certOnly)
+	}
+	if _, ok := job.Hosts()[certOnly]; !ok {
+		t.Errorf("job lacks %s after the probe stream", certOnly)
+	}
+
+	found := make(map[string]string)
+	query := url.Values{"target": {tt.Zone}, "sources": {"dns"}, "probe": {"true"}}
+	for _, event := range streamEvents(t, server.URL+"/api/enumerate/stream?"+query.Encode()) {
+		var result Result
+		if event.name == "message" && json.Unmarshal(event.data, &result) == nil {
+			found[result.Host] = result.Source
+		}
+	}
+	if found[certOnly] != "tls-san" {
+		t.Errorf("scan stream sent %s from %q, want tls-san", certOnly, found[certOnly])
+	}
+}
+
+// A wildcard baseline probe cut short by a reset is tried again, and the
+// request that first asked for it ending doesn't cut it short
+func TestWildcardBaselineRetry(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	job, _ := createJob(t.Context(), "wild."+tt.Zone, []string{"test"}, nil)
+	defer job.Complete()
+	targetURL := "http://www." + job.Target + "/"
+
+	// The zone's wildcard points at 127.0.0.2
+	listen := func() net.Listener {
+		listener, err := net.Listen("tcp", "127.0.0.2:0")
+		if err != nil {
+			t.Skip(err)
+		}
+		return listener
+	}
+	resetting := listen()
+	defer resetting.Close()
+	go func() {
+		for {
+			conn, err := resetting.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		}
+	}()
+	catchAll := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>parked</title>"))
+	}))
+	catchAll.Listener.Close()
+	catchAll.Listener = listen()
+	catchAll.Start()
+	defer catchAll.Close()
+
+	reset := *cfg
+	reset.HTTP.ProbeHTTPPort = resetting.Addr().(*net.TCPAddr).Port
+	if baseline := job.WildcardBaseline(withConfig(t.Context(), &reset), targetURL); baseline != nil {
+		t.Fatalf("baseline %+v from a reset connection", baseline)
+	}
+
+	answering := *cfg
+	answering.HTTP.ProbeHTTPPort = catchAll.Listener.Addr().(*net.TCPAddr).Port
+	cancelled, cancel := context.WithCancel(withConfig(t.Context(), &answering))
+	cancel()
+	baseline := job.WildcardBaseline(cancelled, targetURL)
+	if baseline == nil || baseline.Status != "200" {
+		t.Fatalf("baseline after the reset is %+v, want the catch-all's 200", baseline)
+	}
+	if again := job.WildcardBaseline(withConfig(t.Context(), &reset), targetURL); again != baseline {
+		t.Errorf("baseline probed again after an answer: %+v", again)
+	}
+}
+
+// A probe stream sends each probe as it finishes and a summary; a name
+// that doesn't resolve fails fast, and /api/abort stops a stuck probe
+func TestProbeStream(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	probes := make(map[string]ProbeEvent)
+	var summary ProbeSummary
+	targets := "www." + tt.Zone + ",intranet." + tt.Zone
+	for _, event := range streamEvents(t, server.URL+"/api/probe/stream?targets="+targets) {
+		switch event.name {
+		case "probe":
+			var probe ProbeEvent
+			json.Unmarshal(event.data, &probe)
+			probes[probe.Host] = probe
+		case "complete":
+			json.Unmarshal(event.data, &summary)
+		}
+	}
+	if probe := probes["www."+tt.Zone]; probe.Status != "200" || probe.Title != "Synthetic www."+tt.Zone {
+		t.Errorf("www probed as %+v", probe)
+	}
+	if probe := probes["intranet."+tt.Zone]; probe.Error != "no-dns" || probe.ProbeTime >= cfg.HTTP.Timeout.Milliseconds() {
+		t.Errorf("intranet probed as %+v, want a quick no-dns", probe)
+	}
+	if summary.Probed != 2 || summary.Live != 1 || summary.NoDNS != 1 || summary.Cancelled {
+		t.Errorf("summary %+v", summary)
+	}
+
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer stuck.Close()
+	_, port, _ := net.SplitHostPort(stuck.Listener.Addr().String())
+	host := "www." + tt.Zone
+	time.AfterFunc(200*time.Millisecond, func() {
+		resp, err := http.Post(server.URL+"/api/abort?target="+host, "", nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+	})
+	start := time.Now()
+	summary = ProbeSummary{}
+	for _, event := range streamEvents(t, server.URL+"/api/probe/stream?targets=http://"+net.JoinHostPort(host, port)+"/") {
+		if event.name == "complete" {
+			json.Unmarshal(event.data, &summary)
+		}
+	}
+	if elapsed := time.Since(start); !summary.Cancelled || elapsed >= cfg.HTTP.Timeout {
+		t.Errorf("aborted probe stream ended after %v with %+v", elapsed, summary)
+	}
+}
+
+// Titles are found in gzip and deflate bodies, whether the transport
+// asked for the encoding or the server sent it unasked, and the body
+// limit applies to the decoded bytes
+func TestProbeCompressedBody(t *testing.T) {
+	page := "<html><head><title>Compressed page</title></head><body>" + strings.Repeat("padding ", 100) + "</body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.TrimPrefix(r.URL.Path, "/")
+		if encoding == "negotiated" {
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				t.Errorf("negotiated probe sent Accept-Encoding %q", r.Header.Get("Accept-Encoding"))
+			}
+			encoding = "gzip"
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", encoding)
+		var compressed io.WriteCloser
+		if encoding == "gzip" {
+			compressed = gzip.NewWriter(w)
+		} else {
+			compressed, _ = flate.NewWriter(w, flate.DefaultCompression)
+		}
+		io.WriteString(compressed, page)
+		compressed.Close()
+	}))
+	defer server.Close()
+	cfg := *currentConfig()
+	cfg.HTTP.ProbeCacheTTL = 0
+	useConfig(t, &cfg)
+	ctx := withConfig(context.Background(), &cfg)
+
+	for _, path := range []string{"/negotiated", "/gzip", "/deflate"} {
+		response := probeURL(ctx, probeHTTPClient(ctx), server.URL+path)
+		if response.Title != "Compressed page" || response.Body == nil || response.Body.Read != int64(len(page)) {
+			t.Errorf("%s: title %q, body %+v, want the decoded %d bytes", path, response.Title, response.Body, len(page))
+		}
+	}
+
+	cfg.HTTP.MaxBodySize = 100
+	response := probeURL(ctx, probeHTTPClient(ctx), server.URL+"/gzip")
+	if response.Title != "Compressed page" || response.Body == nil || response.Body.Read != 100 || response.Body.Truncated != "limit" {
+		t.Errorf("limited to 100 bytes: title %q, body %+v", response.Title, response.Body)
+	}
+}
+
+// An https probe reports the certificate it was shown, expired and
+// self-signed ones included, and a plain-HTTP probe none
+func TestProbeTLSDetails(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notAfter := time.Now().Add(-time.Hour).Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "expired.example.com", Organization: []string{"Test"}},
+		DNSNames:     []string{"expired.example.com", "www.expired.example.com"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>ok</title>"))
+	})
+	secure := httptest.NewUnstartedServer(handler)
+	secure.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	secure.StartTLS()
+	defer secure.Close()
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+
+	cfg := *currentConfig()
+	cfg.HTTP.SkipTLSVerify = true
+	cfg.HTTP.ProbeCacheTTL = 0
+	useConfig(t, &cfg)
+	ctx := withConfig(context.Background(), &cfg)
+
+	details := probeURL(ctx, probeHTTPClient(ctx), secure.URL).TLS
+	if details == nil {
+		t.Fatal("https probe reported no certificate")
+	}
+	if details.SubjectCN != "expired.example.com" || details.Issuer != "CN=expired.example.com,O=Test" || len(details.SANs) != 2 || !details.NotAfter.Equal(notAfter) {
+		t.Errorf("certificate reported as %+v", details)
+	}
+	if !details.Expired || !details.SelfSigned || details.Validated || details.VerifyError == "" {
+		t.Errorf("expired self-signed certificate reported as expired %v, self-signed %v, validated %v (%q)",
+			details.Expired, details.SelfSigned, details.Validated, details.VerifyError)
+	}
+	if response := probeURL(ctx, probeHTTPClient(ctx), plain.URL); response.Status != "200" || response.TLS != nil {
+		t.Errorf("plain-HTTP probe gave status %s and certificate %+v", response.Status, response.TLS)
+	}
+}
+
+// Technology headers are reported as sent, followed by the fingerprints
+// whose header, cookie or body patterns match, each once
+func TestDetectTechnologies(t *testing.T) {
+	if _, err := parseTechFingerprints(embeddedTechFingerprints); err != nil || len(techFingerprints) == 0 {
+		t.Fatalf("embedded fingerprints: %d loaded, %v", len(techFingerprints), err)
+	}
+	for _, tc := range []struct {
+		header map[string][]string
+		body   string
+		want   string
+	}{
+		{header: map[string][]string{"Server": {"nginx/1.24.0"}, "X-Powered-By": {"PHP/8.2.7"}}, want: "nginx/1.24.0,PHP/8.2.7,PHP"},
+		{body: `<link href="/wp-content/themes/x/style.css"><script src="/wp-includes/js/a.js">`, want: "WordPress"},
+		{header: map[string][]string{"Set-Cookie": {"atlassian.xsrf.token=abc; Path=/"}}, want: "Jira"},
+		{header: map[string][]string{"X-Jenkins": {"2.440"}, "Server": {"Jetty(10.0.18)"}}, body: "<title>Dashboard [Jenkins]</title>", want: "Jetty(10.0.18),Jenkins"},
+		{header: map[string][]string{"Kbn-Name": {"kibana"}}, want: "Kibana"},
+		{header: map[string][]string{"X-Generator": {"Drupal 10"}}, want: "Drupal 10,Drupal"},
+		{header: map[string][]string{"Set-Cookie": {"session=abc"}}, body: "<title>Plain</title>"},
+	} {
+		got := detectTechnologies(http.Header(tc.header), []byte(tc.body))
+		if strings.Join(got, ",") != tc.want {
+			t.Errorf("%v %q: detected %v, want %s", tc.header, tc.body, got, tc.want)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"fingerprints": [{"body": ["x"]}]}`,
+		`{"fingerprints": [{"name": "Empty"}]}`,
+		`{"fingerprints": [{"name": "Broken", "body": ["("]}]}`,
+		`{"fingerprints": {}}`,
+	} {
+		if _, err := parseTechFingerprints([]byte(invalid)); err == nil {
+			t.Errorf("%s parsed", invalid)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+		w.Header().Add("Set-Cookie", "grafana_session=abc; Path=/")
+		w.Write([]byte("<title>Grafana</title>"))
+	}))
+	defer server.Close()
+	cfg := *currentConfig()
+	cfg.HTTP.ProbeCacheTTL = 0
+	useConfig(t, &cfg)
+	ctx := withConfig(context.Background(), &cfg)
+	if got := probeURL(ctx, probeHTTPClient(ctx), server.URL).Technologies; strings.Join(got, ",") != "nginx,Grafana" {
+		t.Errorf("probe reported technologies %v, want nginx and Grafana", got)
+	}
+}
+
+// favicon=true hashes /favicon.ico as Shodan does, leaving out missing
+// and oversized ones, and the job groups its hosts by that hash
+func TestFaviconHash(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		want uint32
+	}{
+		{"", 0},
+		{"hello", 0x248bfa47},
+		{"The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	} {
+		if got := murmur3([]byte(tc.data), 0); got != tc.want {
+			t.Errorf("murmur3(%q) = %#x, want %#x", tc.data, got, tc.want)
+		}
+	}
+	if lines := strings.Split(string(mimeBase64(make([]byte, 100))), "\n"); len(lines) != 3 || len(lines[0]) != 76 || len(lines[1]) != 60 || lines[2] != "" {
+		t.Errorf("100 bytes encoded as lines %q", lines)
+	}
+
+	icon := []byte("\x00\x00\x01\x00 not really an icon")
+	tt, cfg := startTestTargetT(t)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/favicon.ico" {
+			w.Write([]byte("<title>site</title>"))
+			return
+		}
+		switch strings.Split(r.Host, ".")[0] {
+		case "www", "api":
+			w.Write(icon)
+		case "mail":
+			w.Write(make([]byte, 2000))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+	_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+	cfg.HTTP.ProbeCacheTTL = 0
+	cfg.HTTP.MaxBodySize = 1000
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	job, _ := createJob(t.Context(), tt.Zone, []string{"test"}, nil)
+	job.Complete()
+	want := int32(murmur3(mimeBase64(icon), 0))
+	for _, label := range []string{"www", "api", "dev", "mail"} {
+		query := url.Values{"url": {"http://" + net.JoinHostPort(label+"."+tt.Zone, port)}, "favicon": {"true"}, "job": {job.ID}}
+		resp, err := http.Get(server.URL + "/api/probe?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var probe ProbeResponse
+		json.NewDecoder(resp.Body).Decode(&probe)
+		resp.Body.Close()
+		hashed := label == "www" || label == "api"
+		if probe.Status != "200" || (probe.FaviconHash != nil) != hashed || hashed && *probe.FaviconHash != want {
+			t.Errorf("%s: status %s, favicon hash %v, want hashed %v as %d", label, probe.Status, probe.FaviconHash, hashed, want)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/api/jobs/" + job.ID + "/favicons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var groups []FaviconGroup
+	json.NewDecoder(resp.Body).Decode(&groups)
+	if len(groups) != 1 || groups[0].Hash != want || groups[0].Count != 2 || groups[0].Hosts[0] != "api."+tt.Zone || groups[0].Hosts[1] != "www."+tt.Zone {
+		t.Errorf("favicon groups %+v", groups)
+	}
+}
+
+// probeAt asks server's /api/probe about query
+func probeAt(t *testing.T, server *httptest.Server, query url.Values) ProbeResponse {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/api/probe?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var probe ProbeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&probe); err != nil {
+		t.Fatalf("probe of %v: %v", query, err)
+	}
+	return probe
+}
+
+// A bare host falls back to http when https can't connect, reporting
+// where it ended up and every redirect on the way; a full URL is probed
+// as given
+func TestProbeSchemeFallback(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		w.Write([]byte("<title>Sign in</title>"))
+	}))
+	defer site.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	cfg.HTTP.ProbeHTTPPort = site.Listener.Addr().(*net.TCPAddr).Port
+	cfg.HTTP.ProbeHTTPSPort = closed.Addr().(*net.TCPAddr).Port
+	cfg.HTTP.ProbeCacheTTL = 0
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	host := "www." + tt.Zone
+	probe := probeAt(t, server, url.Values{"url": {host}})
+	want := []ProbeHop{{URL: "http://" + host, Status: http.StatusFound}, {URL: "http://" + host + "/login", Status: http.StatusOK}}
+	if probe.Status != "200" || probe.Title != "Sign in" || probe.FinalURL != "http://"+host+"/login" || !slices.Equal(probe.Redirects, want) {
+		t.Errorf("bare host probed as status %s, title %q, final URL %s, redirects %v", probe.Status, probe.Title, probe.FinalURL, probe.Redirects)
+	}
+	if probe := probeAt(t, server, url.Values{"url": {"https://" + host}}); probe.Status != "0" || probe.FinalURL != "" {
+		t.Errorf("https URL fell back: status %s, final URL %s", probe.Status, probe.FinalURL)
+	}
+	if probe := probeAt(t, server, url.Values{"url": {"http://" + host + "/login"}}); probe.Status != "200" || len(probe.Redirects) != 0 {
+		t.Errorf("unredirected probe gave status %s and redirects %v", probe.Status, probe.Redirects)
+	}
+}
+
+// ports= probes a host on each port, guessing the scheme and falling back
+// to the other, and reports only the ports that answered; a batch probe
+// multiplies hosts by ports up to its cap
+func TestProbePorts(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>port</title>"))
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	plainPort := plain.Listener.Addr().(*net.TCPAddr).Port
+	securePort := secure.Listener.Addr().(*net.TCPAddr).Port
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	ports := fmt.Sprintf("%d,%d,%d", closedPort, securePort, plainPort)
+	cfg.HTTP.SkipTLSVerify = true
+	cfg.HTTP.ProbeCacheTTL = 0
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	host := "www." + tt.Zone
+	resp, err := http.Get(server.URL + "/api/probe?" + url.Values{"url": {host}, "ports": {ports}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var answered []ProbeEvent
+	json.NewDecoder(resp.Body).Decode(&answered)
+	resp.Body.Close()
+	want := map[int]string{
+		plainPort:  "http://" + net.JoinHostPort(host, strconv.Itoa(plainPort)),
+		securePort: "https://" + net.JoinHostPort(host, strconv.Itoa(securePort)),
+	}
+	if len(answered) != 2 {
+		t.Fatalf("ports %s answered as %+v, want two", ports, answered)
+	}
+	for _, event := range answered {
+		if event.Status != "200" || event.URL != want[event.Port] {
+			t.Errorf("port %d answered %s at %s, want 200 at %s", event.Port, event.Status, event.URL, want[event.Port])
+		}
+	}
+	for _, invalid := range []string{"0", "65536", "http", ""} {
+		resp, err := http.Get(server.URL + "/api/probe?" + url.Values{"url": {host}, "ports": {invalid}}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("ports=%q gave HTTP %d, want 400", invalid, resp.StatusCode)
+		}
+	}
+
+	cfg.HTTP.ProbeMaxTargets = 4
+	var summary ProbeSummary
+	events := 0
+	query := url.Values{"targets": {host + ",api." + tt.Zone}, "ports": {ports}}
+	for _, event := range streamEvents(t, server.URL+"/api/probe/stream?"+query.Encode()) {
+		switch event.name {
+		case "probe":
+			events++
+		case "complete":
+			json.Unmarshal(event.data, &summary)
+		}
+	}
+	// Six URLs capped at four: www on all three ports and api on the closed one
+	if summary.Probed != 4 || summary.Skipped != 2 || summary.Live != 2 || events != 2 {
+		t.Errorf("batch of 2 hosts x 3 ports capped at 4: %d events, summary %+v", events, summary)
+	}
+}
+
+// Probes measure and hash the decoded body, and the job groups hosts
+// serving the same one
+func TestProbeBodyMeasures(t *testing.T) {
+	for body, want := range map[string]int{"": 0, "one": 1, "one\n": 1, "one\ntwo": 2, "\n\n": 2} {
+		if got := lineCount([]byte(body)); got != want {
+			t.Errorf("lineCount(%q) = %d, want %d", body, got, want)
+		}
+	}
+
+	tt, cfg := startTestTargetT(t)
+	defaultPage := "<title>Welcome</title>\nload balancer  default\tpage\n"
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := defaultPage
+		if strings.HasPrefix(r.Host, "dev.") {
+			page = "<title>Dev</title>"
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		io.WriteString(gz, page)
+		gz.Close()
+	}))
+	defer site.Close()
+	_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+	cfg.HTTP.ProbeCacheTTL = 0
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	job, _ := createJob(t.Context(), tt.Zone, []string{"test"}, nil)
+	job.Complete()
+	for _, label := range []string{"www", "api", "dev"} {
+		probe := probeAt(t, server, url.Values{"url": {"http://" + net.JoinHostPort(label+"."+tt.Zone, port)}, "job": {job.ID}})
+		if label == "www" && (probe.ContentLength != len(defaultPage) || probe.WordCount != 5 || probe.LineCount != 2) {
+			t.Errorf("www measured as %d bytes, %d words, %d lines", probe.ContentLength, probe.WordCount, probe.LineCount)
+		}
+	}
+	sum := sha256.Sum256([]byte(defaultPage))
+
+	resp, err := http.Get(server.URL + "/api/jobs/" + job.ID + "/bodies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var groups []BodyGroup
+	json.NewDecoder(resp.Body).Decode(&groups)
+	if len(groups) != 2 || groups[0].SHA256 != hex.EncodeToString(sum[:]) || !slices.Equal(groups[0].Hosts, []string{"api." + tt.Zone, "www." + tt.Zone}) ||
+		groups[1].Count != 1 || groups[1].Hosts[0] != "dev."+tt.Zone {
+		t.Errorf("body groups %+v", groups)
+	}
+}
+
+// Consecutive probes of a server share one connection, and the client is
+// rebuilt only when the settings it was built from change
+func TestSharedProbeClient(t *testing.T) {
+	var connections int64
+	site := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/loop" {
+			http.Redirect(w, r, "/loop", http.StatusFound)
+			return
+		}
+		w.Write([]byte("<title>pooled</title>"))
+	}))
+	site.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&connections, 1)
+		}
+	}
+	site.Start()
+	defer site.Close()
+	cfg := *currentConfig()
+	cfg.HTTP.ProbeCacheTTL = 0
+	ctx := withConfig(context.Background(), &cfg)
+
+	for range 5 {
+		if probe := probeURL(ctx, probeHTTPClient(ctx), site.URL); probe.Status != "200" {
+			t.Fatalf("probe answered %s: %s", probe.Status, probe.Error)
+		}
+	}
+	if got := atomic.LoadInt64(&connections); got != 1 {
+		t.Errorf("5 probes opened %d connections, want 1", got)
+	}
+
+	t.Cleanup(func() { reloadProbeClient(currentConfig()) })
+	shared := currentProbeClient()
+	reloadProbeClient(&cfg)
+	if currentProbeClient() != shared {
+		t.Error("probe client rebuilt for unchanged settings")
+	}
+	cfg.HTTP.MaxRedirects = 1
+	reloadProbeClient(&cfg)
+	if currentProbeClient() == shared {
+		t.Fatal("probe client kept after the redirect limit changed")
+	}
+	if probe := probeURL(ctx, probeHTTPClient(ctx), site.URL+"/loop"); !strings.Contains(probe.Error, "too many redirects (1)") {
+		t.Errorf("redirect loop under a limit of 1 gave %q", probe.Error)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for the log's concurrent writers
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// HTTP_EXTRA_HEADERS go with every probe and a batch's headers with its
+// probes, replacing the defaults they name; hop-by-hop headers are
+// refused, and DEBUG logs show the headers sent without secrets
+func TestProbeHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var seen []*http.Request
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r)
+		mu.Unlock()
+		w.Write([]byte("<title>ok</title>"))
+	}))
+	defer site.Close()
+	logs := &lockedBuffer{}
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	cfg := *currentConfig()
+	cfg.HTTP.ExtraHeaders = parseExtraHeaders(`{"Cookie": "session=abc", "X-Team": "red"}`)
+	cfg.HTTP.ProbeCacheTTL = 0
+	cfg.LogLevel = "DEBUG"
+	useConfig(t, &cfg)
+	server := httptest.NewServer(newRouter(&cfg))
+	defer server.Close()
+
+	probeAt(t, server, url.Values{"url": {site.URL + "/single"}})
+	body := `{"targets": ["` + site.URL + `/batch"], "headers": {"user-agent": "custom", "Origin": "https://app.example", "Host": "vhost.example"}}`
+	resp, err := http.Post(server.URL+"/api/probe/stream", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	mu.Lock()
+	if len(seen) != 2 {
+		t.Fatalf("site got %d requests, want 2", len(seen))
+	}
+	single, batch := seen[0], seen[1]
+	mu.Unlock()
+	if single.Header.Get("Cookie") != "session=abc" || single.Header.Get("X-Team") != "red" || single.UserAgent() != cfg.HTTP.UserAgent || single.Header.Get("Origin") != "" {
+		t.Errorf("single probe sent %v", single.Header)
+	}
+	if batch.Header.Get("Cookie") != "session=abc" || batch.UserAgent() != "custom" || batch.Header.Get("Origin") != "https://app.example" || batch.Host != "vhost.example" {
+		t.Errorf("batch probe sent host %s and %v", batch.Host, batch.Header)
+	}
+	if logged := logs.String(); !strings.Contains(logged, "Cookie: <11 bytes>") || !strings.Contains(logged, "X-Team: red") || strings.Contains(logged, "session=abc") {
+		t.Errorf("DEBUG log of the headers: %s", logged)
+	}
+
+	for _, header := range []string{"Connection", "transfer-encoding", "Bad Name"} {
+		body := `{"targets": ["` + site.URL + `"], "headers": {"` + header + `": "x"}}`
+		resp, err := http.Post(server.URL+"/api/probe/stream", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("header %q gave HTTP %d, want 400", header, resp.StatusCode)
+		}
+	}
+	if extra := parseExtraHeaders(`{"Connection": "close"}`); extra != nil {
+		t.Errorf("HTTP_EXTRA_HEADERS with a hop-by-hop header parsed as %v", extra)
+	}
+}
+
+// mode=vhost and batch records reach a name-based virtual host at a
+// given address, naming the host in the Host header and TLS SNI
+func TestProbeVHost(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gone.example.com"},
+		DNSNames:     []string{"gone.example.com", "other.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var names []string
+	site := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "gone.example.com" && r.Host != "other.example.com" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("<title>vhost " + r.Host + "</title>"))
+	}))
+	site.TLS = &tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		mu.Lock()
+		names = append(names, hello.ServerName)
+		mu.Unlock()
+		return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+	}}
+	site.StartTLS()
+	defer site.Close()
+
+	cfg := *currentConfig()
+	cfg.HTTP.ProbeHTTPSPort = site.Listener.Addr().(*net.TCPAddr).Port
+	cfg.HTTP.SkipTLSVerify = true
+	cfg.HTTP.ProbeCacheTTL = 0
+	useConfig(t, &cfg)
+	server := httptest.NewServer(newRouter(&cfg))
+	defer server.Close()
+
+	probe := probeAt(t, server, url.Values{"mode": {"vhost"}, "host": {"gone.example.com"}, "ip": {"127.0.0.1"}})
+	if probe.Status != "200" || probe.Title != "vhost gone.example.com" || probe.ConnectedIP != "127.0.0.1" || probe.TLS == nil {
+		t.Errorf("vhost probe gave status %s, title %q, connected to %q", probe.Status, probe.Title, probe.ConnectedIP)
+	}
+
+	body := `{"records": [{"host": "other.example.com", "ip": "127.0.0.1"}]}`
+	var events []ProbeEvent
+	for _, event := range postEvents(t, server.URL+"/api/probe/stream", body) {
+		var probe ProbeEvent
+		if event.name == "probe" && json.Unmarshal(event.data, &probe) == nil {
+			events = append(events, probe)
+		}
+	}
+	if len(events) != 1 || events[0].Host != "other.example.com" || events[0].Title != "vhost other.example.com" {
+		t.Errorf("batch vhost record probed as %+v", events)
+	}
+	mu.Lock()
+	if !slices.Contains(names, "gone.example.com") || !slices.Contains(names, "other.example.com") {
+		t.Errorf("TLS handshakes named %v", names)
+	}
+	mu.Unlock()
+
+	for _, query := range []url.Values{
+		{"mode": {"vhost"}, "host": {"gone.example.com"}},
+		{"mode": {"vhost"}, "host": {"gone.example.com"}, "ip": {"not-an-ip"}},
+		{"mode": {"vhost"}, "host": {"bad_host!"}, "ip": {"127.0.0.1"}},
+	} {
+		resp, err := http.Get(server.URL + "/api/probe?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v gave HTTP %d, want 400", query, resp.StatusCode)
+		}
+	}
+}
+
+// Probes report each security header's first value and whether the
+// plain-HTTP site redirects to https, and the job counts hosts lacking them
+func TestProbeSecurityHeaders(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Host, "www.") {
+			w.Header().Add("Strict-Transport-Security", " max-age=63072000 ")
+			w.Header().Add("Strict-Transport-Security", "max-age=0")
+			w.Header().Set("Content-Security-Policy", "default-src 'self'")
+			w.Header().Set("X-Frame-Options", "DENY")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("Referrer-Policy", "no-referrer")
+		}
+		w.Write([]byte("<title>secure</title>"))
+	}))
+	defer secure.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Host, "www.") {
+			http.Redirect(w, r, "https://"+r.Host+"/", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("<title>plain</title>"))
+	}))
+	defer plain.Close()
+	cfg.HTTP.ProbeHTTPPort = plain.Listener.Addr().(*net.TCPAddr).Port
+	cfg.HTTP.ProbeHTTPSPort = secure.Listener.Addr().(*net.TCPAddr).Port
+	cfg.HTTP.SkipTLSVerify = true
+	cfg.HTTP.ProbeCacheTTL = 0
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	job, _ := createJob(t.Context(), tt.Zone, []string{"test"}, nil)
+	job.Complete()
+	www := probeAt(t, server, url.Values{"url": {"https://www." + tt.Zone}, "job": {job.ID}}).SecurityHeaders
+	if www == nil || www.StrictTransportSecurity != (SecurityHeader{Present: true, Value: "max-age=63072000"}) ||
+		!www.ContentSecurityPolicy.Present || www.XFrameOptions.Value != "DENY" || !www.XContentTypeOptions.Present || !www.ReferrerPolicy.Present ||
+		www.HTTPRedirectsToHTTPS == nil || !*www.HTTPRedirectsToHTTPS {
+		t.Errorf("www reported as %+v", www)
+	}
+	api := probeAt(t, server, url.Values{"url": {"https://api." + tt.Zone}, "job": {job.ID}}).SecurityHeaders
+	if api == nil || api.StrictTransportSecurity.Present || api.ReferrerPolicy.Present || api.HTTPRedirectsToHTTPS == nil || *api.HTTPRedirectsToHTTPS {
+		t.Errorf("api reported as %+v", api)
+	}
+	if dev := probeAt(t, server, url.Values{"url": {"http://dev." + tt.Zone}}).SecurityHeaders; dev == nil || dev.HTTPRedirectsToHTTPS == nil || *dev.HTTPRedirectsToHTTPS {
+		t.Errorf("plain-HTTP dev reported as %+v", dev)
+	}
+
+	resp, err := http.Get(server.URL + "/api/jobs/" + job.ID + "/security")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var summary SecuritySummary
+	json.NewDecoder(resp.Body).Decode(&summary)
+	apiHost := []string{"api." + tt.Zone}
+	if summary.Hosts != 2 || len(summary.Missing) != 5 || summary.Missing["strict_transport_security"] != 1 ||
+		!slices.Equal(summary.MissingHosts["referrer_policy"], apiHost) || !slices.Equal(summary.NoHTTPSRedirect, apiHost) {
+		t.Errorf("security summary %+v", summary)
+	}
+}
+
+// probeSummary runs a probe stream of targets and returns its summary
+// and the probes by host
+func probeSummary(t *testing.T, server *httptest.Server, targets string) (ProbeSummary, map[string]ProbeEvent) {
+	t.Helper()
+	var summary ProbeSummary
+	probes := make(map[string]ProbeEvent)
+	for _, event := range streamEvents(t, server.URL+"/api/probe/stream?targets="+url.QueryEscape(targets)) {
+		switch event.name {
+		case "probe":
+			var probe ProbeEvent
+			json.Unmarshal(event.data, &probe)
+			probes[probe.Host] = probe
+		case "complete":
+			json.Unmarshal(event.data, &summary)
+		}
+	}
+	return summary, probes
+}
+
+// Dead names cost a lookup rather than an HTTP attempt, with the time
+// saved in the summary, unless HTTP_PROBE_SKIP_DEAD is off
+func TestProbeSkipDead(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	cfg.HTTP.Timeout = 3 * time.Second
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	dead := "intranet." + tt.Zone
+	summary, probes := probeSummary(t, server, dead)
+	if probes[dead].Error != "no-dns" || summary.NoDNS != 1 || summary.TimeSavedMS <= 0 || summary.TimeSavedMS > cfg.HTTP.Timeout.Milliseconds() {
+		t.Errorf("skipping: probe %+v, summary %+v", probes[dead], summary)
+	}
+
+	probing := *cfg
+	probing.HTTP.ProbeSkipDead = false
+	useConfig(t, &probing)
+	summary, probes = probeSummary(t, server, dead)
+	if probes[dead].Error == "no-dns" || probes[dead].Status != "0" || summary.NoDNS != 0 || summary.Failed != 1 || summary.TimeSavedMS != 0 {
+		t.Errorf("HTTP_PROBE_SKIP_DEAD=false: probe %+v, summary %+v", probes[dead], summary)
+	}
+}
+
+// The probe cache drops the least recently used result past its size and
+// results past the TTL, and survives a save and load
+func TestProbeCacheEviction(t *testing.T) {
+	cache := newProbeCache()
+	for _, key := range []string{"a", "b", "c"} {
+		cache.put(key, ProbeResponse{Status: "200", Title: key}, 2)
+	}
+	if _, ok := cache.get("a", time.Hour); ok || cache.Len() != 2 {
+		t.Errorf("a kept past a size of 2 (%d entries)", cache.Len())
+	}
+	cache.get("b", time.Hour)
+	cache.put("d", ProbeResponse{Status: "200", Title: "d"}, 2)
+	if _, ok := cache.get("c", time.Hour); ok {
+		t.Error("c, least recently used, was kept")
+	}
+	response, ok := cache.get("b", time.Hour)
+	if !ok || !response.Cached || response.ProbedAt == nil || response.Title != "b" {
+		t.Errorf("b cached as %+v (%v)", response, ok)
+	}
+
+	path := filepath.Join(t.TempDir(), "probe-cache.json")
+	if err := cache.save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newProbeCache()
+	if err := loaded.load(path, time.Hour, 10); err != nil || loaded.Len() != 2 {
+		t.Fatalf("loaded %d entries: %v", loaded.Len(), err)
+	}
+	if response, ok := loaded.get("d", time.Hour); !ok || response.Title != "d" {
+		t.Errorf("d loaded as %+v", response)
+	}
+	if _, ok := loaded.get("b", 0); ok || loaded.Len() != 1 {
+		t.Errorf("b served past its TTL (%d entries left)", loaded.Len())
+	}
+}
+
+// A repeated probe is answered from the cache, marked with when it was
+// probed, unless nocache=1; /api/stats counts hits and misses
+func TestProbeCache(t *testing.T) {
+	var requests int64
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Write([]byte("<title>cached</title>"))
+	}))
+	defer site.Close()
+	cfg := *currentConfig()
+	cfg.HTTP.ProbeCacheTTL = time.Hour
+	useConfig(t, &cfg)
+	server := httptest.NewServer(newRouter(&cfg))
+	defer server.Close()
+	cacheStats := func() (hits, misses float64) {
+		resp, err := http.Get(server.URL + "/api/stats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var stats struct {
+			ProbeCache map[string]interface{} `json:"probe_cache"`
+		}
+		json.NewDecoder(resp.Body).Decode(&stats)
+		hits, _ = stats.ProbeCache["hits"].(float64)
+		misses, _ = stats.ProbeCache["misses"].(float64)
+		return hits, misses
+	}
+
+	target := site.URL + "/" + rand.Text()
+	hits, misses := cacheStats()
+	first := probeAt(t, server, url.Values{"url": {target}})
+	second := probeAt(t, server, url.Values{"url": {target}})
+	if first.Cached || !second.Cached || second.ProbedAt == nil || second.Title != "cached" || atomic.LoadInt64(&requests) != 1 {
+		t.Errorf("repeated probe: cached %v then %v, %d requests to the site", first.Cached, second.Cached, requests)
+	}
+	if bypassed := probeAt(t, server, url.Values{"url": {target}, "nocache": {"1"}}); bypassed.Cached || atomic.LoadInt64(&requests) != 2 {
+		t.Errorf("nocache=1 probe cached %v, %d requests to the site", bypassed.Cached, requests)
+	}
+	if afterHits, afterMisses := cacheStats(); afterHits-hits != 1 || afterMisses-misses != 1 {
+		t.Errorf("stats counted %v hits and %v misses, want 1 and 1", afterHits-hits, afterMisses-misses)
+	}
+}
+
+// Default, parking and error pages are told apart from applications by
+// the embedded signatures, and class= filters a job's results by them
+func TestClassifyPage(t *testing.T) {
+	if _, err := parsePageSignatures(embeddedPageSignatures); err != nil || len(pageSignatures) == 0 {
+		t.Fatalf("embedded signatures: %d loaded, %v", len(pageSignatures), err)
+	}
+	for _, tc := range []struct {
+		status    int
+		title     string
+		body      string
+		class     string
+		signature string
+	}{
+		{200, "Welcome to nginx!", "<h1>Welcome to nginx!</h1>", pageClassDefault, "nginx default"},
+		{200, " IIS Windows Server ", "", pageClassDefault, "IIS default"},
+		{200, "Apache2 Ubuntu Default Page: It works", "", pageClassDefault, "Apache default"},
+		{200, "example.com", `<script src="https://img1.wsimg.com/parking-lander/static/js/main.js">`, pageClassParked, "GoDaddy parking"},
+		{200, "example.com", "<p>This domain is for sale!</p>", pageClassParked, "Domain for sale"},
+		{403, "Attention Required!", `<div id="cf-error-details">`, pageClassError, "Cloudflare error"},
+		{502, "Oops", "upstream went away", pageClassError, ""},
+		{200, "Acme Dashboard", "<h1>Sign in</h1>", pageClassApp, ""},
+	} {
+		class, signature := classifyPage(tc.status, tc.title, []byte(tc.body))
+		if class != tc.class || signature != tc.signature {
+			t.Errorf("%d %q: classed %s by %q, want %s by %q", tc.status, tc.title, class, signature, tc.class, tc.signature)
+		}
+	}
+	for _, invalid := range []string{
+		`{"signatures": [{"class": "default", "title": ["x"]}]}`,
+		`{"signatures": [{"name": "App", "class": "app", "title": ["x"]}]}`,
+		`{"signatures": [{"name": "Odd", "class": "odd", "title": ["x"]}]}`,
+		`{"signatures": [{"name": "Empty", "class": "default"}]}`,
+		`{"signatures": [{"name": "Broken", "class": "default", "body": ["("]}]}`,
+	} {
+		if _, err := parsePageSignatures([]byte(invalid)); err == nil {
+			t.Errorf("%s parsed", invalid)
+		}
+	}
+
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	for host, class := range map[string]string{"www": pageClassApp, "old": pageClassParked, "cdn": pageClassError, "new": ""} {
+		job.AddResult("test", Result{Host: host + ".example.com", Source: "test", Status: "discovered"})
+		if class != "" {
+			job.RecordProbe(host+".example.com", ProbeResponse{Status: "200", PageClass: class})
+		}
+	}
+	job.Complete()
+	for query, want := range map[string][]string{
+		"class=app":        {"www.example.com"},
+		"class=app,parked": {"old.example.com", "www.example.com"},
+		"":                 {"cdn.example.com", "new.example.com", "old.example.com", "www.example.com"},
+	} {
+		response := httptest.NewRecorder()
+		jobResultsHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"/results?"+query, nil), job)
+		var page struct {
+			Results []Result `json:"results"`
+		}
+		json.NewDecoder(response.Body).Decode(&page)
+		var hosts []string
+		for _, result := range page.Results {
+			hosts = append(hosts, result.Host)
+		}
+		slices.Sort(hosts)
+		if !slices.Equal(hosts, want) {
+			t.Errorf("%q listed %v, want %v", query, hosts, want)
+		}
+	}
+	response := httptest.NewRecorder()
+	jobResultsHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"/results?class=odd", nil), job)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("class=odd gave HTTP %d, want 400", response.Code)
+	}
+}
+
+// AAAA-only names and IPv6 literals are probed, with the family that
+// answered, and ALLOWED_DOMAINS matches literals by address or CIDR
+func TestProbeIPv6(t *testing.T) {
+	cfg := &Config{}
+	cfg.Security.AllowedDomains = []string{"example.com", "2001:db8::10", "2001:db8:1::/48", "192.0.2.0/24"}
+	for host, want := range map[string]bool{
+		"www.example.com":     true,
+		"2001:db8::10":        true,
+		"[2001:db8::10]":      true,
+		"[2001:DB8:1::5]":     true,
+		"[2001:db8:2::5]":     false,
+		"192.0.2.7":           true,
+		"198.51.100.1":        false,
+		"[fe80::1%eth0]":      false,
+		"www.example.org":     false,
+		"2001:db8::10.evil.x": false,
+	} {
+		if got := probeAllowed(cfg, host); got != want {
+			t.Errorf("probeAllowed(%s) = %v, want %v", host, got, want)
+		}
+	}
+	for host, want := range map[string]string{"::1": "[::1]", "[::1]": "[::1]", "127.0.0.1": "127.0.0.1", "www.example.com": "www.example.com"} {
+		if got := bracketIPv6(host); got != want {
+			t.Errorf("bracketIPv6(%s) = %s, want %s", host, got, want)
+		}
+	}
+
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	site := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>v6</title>"))
+	}))
+	site.Listener = listener
+	site.Start()
+	defer site.Close()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	tt, testCfg := startTestTargetT(t)
+	testCfg.HTTP.ProbeCacheTTL = 0
+	useConfig(t, testCfg)
+	server := httptest.NewServer(newRouter(testCfg))
+	defer server.Close()
+
+	v6 := "v6." + tt.Zone
+	_, probes := probeSummary(t, server, "http://"+net.JoinHostPort(v6, port)+"/,http://[::1]:"+port+"/")
+	for _, host := range []string{v6, "::1"} {
+		if probe := probes[host]; probe.Status != "200" || probe.RemoteIP != "::1" || probe.AddressFamily != "ipv6" {
+			t.Errorf("%s probed as status %s %q from %s (%s)", host, probe.Status, probe.Error, probe.RemoteIP, probe.AddressFamily)
+		}
+	}
+}
+
+// Resets are retried up to HTTP_PROBE_RETRIES, 5xx answers only with
+// HTTP_PROBE_RETRY_5XX and 4xx never; the probe counts its attempts and
+// the stats only its final outcome
+func TestProbeRetries(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/reset") && (n == 1 || r.URL.Path == "/reset-always"):
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		case r.URL.Path == "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("<title>up</title>"))
+		}
+	}))
+	defer site.Close()
+	cfg := *currentConfig()
+	cfg.HTTP.ProbeRetries = 1
+	cfg.HTTP.ProbeRetryBackoff = 10 * time.Millisecond
+	cfg.HTTP.ProbeCacheTTL = 0
+	retry5xx := cfg
+	retry5xx.HTTP.ProbeRetry5xx = true
+	twice := cfg
+	twice.HTTP.ProbeRetries = 2
+
+	for _, tc := range []struct {
+		cfg      *Config
+		path     string
+		status   string
+		attempts int
+	}{
+		{&cfg, "/reset-once", "200", 2},
+		{&twice, "/reset-always", "0", 3},
+		{&cfg, "/down", "503", 1},
+		{&retry5xx, "/down", "503", 2},
+		{&retry5xx, "/missing", "404", 1},
+	} {
+		mu.Lock()
+		requests[tc.path] = 0
+		mu.Unlock()
+		// The transport itself retries a request a reused connection reset
+		sharedProbeTransport().CloseIdleConnections()
+		ctx := withConfig(context.Background(), tc.cfg)
+		response := retryingProbe(ctx, site.URL+tc.path)
+		mu.Lock()
+		sent := requests[tc.path]
+		mu.Unlock()
+		if response.Status != tc.status || response.Attempts != tc.attempts || sent != tc.attempts {
+			t.Errorf("%s (retry 5xx %v): status %s after %d attempts, %d requests; want %s after %d",
+				tc.path, tc.cfg.HTTP.ProbeRetry5xx, response.Status, response.Attempts, sent, tc.status, tc.attempts)
+		}
+	}
+
+	useConfig(t, &cfg)
+	server := httptest.NewServer(newRouter(&cfg))
+	defer server.Close()
+	sharedProbeTransport().CloseIdleConnections()
+	total, successful := atomic.LoadInt64(&stats.TotalProbes), atomic.LoadInt64(&stats.SuccessfulProbes)
+	if probe := probeAt(t, server, url.Values{"url": {site.URL + "/reset-again"}}); probe.Attempts != 2 {
+		t.Errorf("probe took %d attempts, want 2", probe.Attempts)
+	}
+	if got, ok := atomic.LoadInt64(&stats.TotalProbes)-total, atomic.LoadInt64(&stats.SuccessfulProbes)-successful; got != 1 || ok != 1 {
+		t.Errorf("a retried probe counted as %d probes, %d successful; want 1 and 1", got, ok)
+	}
+}
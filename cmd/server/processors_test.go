@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegexTagger(t *testing.T) {
+	tagger := &regexTagger{name: "ci", pattern: regexp.MustCompile(`jenkins|gitlab`), tags: []string{"ci"}}
+	results := []Result{{Host: "jenkins.example.com"}, {Host: "www.example.com"}, {Host: "gitlab.example.com", Tags: []string{"ci"}}}
+	if err := tagger.Process(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range [][]string{{"ci"}, nil, {"ci"}} {
+		if !slices.Equal(results[i].Tags, want) {
+			t.Errorf("%s: tags %v, want %v", results[i].Host, results[i].Tags, want)
+		}
+	}
+}
+
+func TestIPRangeTagger(t *testing.T) {
+	tagger := &ipRangeTagger{name: "internal", ranges: &prefixTrie[struct{}]{}, tags: []string{"internal"}}
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	tagger.ranges.Insert(network, struct{}{})
+
+	// Results that come with addresses are not looked up again
+	results := []Result{
+		{Host: "vpn.example.com", IPs: []ResultIP{{Address: "10.1.2.3", Family: "ipv4"}}},
+		{Host: "www.example.com", IPs: []ResultIP{{Address: "93.184.216.34", Family: "ipv4"}}},
+		{Host: "VPN.example.com"},
+	}
+	if err := tagger.Process(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range [][]string{{"internal"}, nil, {"internal"}} {
+		if !slices.Equal(results[i].Tags, want) {
+			t.Errorf("%s: tags %v, want %v", results[i].Host, results[i].Tags, want)
+		}
+	}
+}
+
+func TestSeverityAssigner(t *testing.T) {
+	assigner := &severityAssigner{name: "severity", rules: []compiledSeverityRule{
+		{tag: "ci", severity: "high"},
+		{pattern: regexp.MustCompile(`^admin\.`), severity: "medium"},
+	}}
+	results := []Result{
+		{Host: "jenkins.example.com", Tags: []string{"ci"}},
+		{Host: "admin.example.com"},
+		{Host: "admin.ci.example.com", Tags: []string{"ci"}},
+		{Host: "www.example.com"},
+	}
+	if err := assigner.Process(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"high", "medium", "high", ""} {
+		if results[i].Severity != want {
+			t.Errorf("%s: severity %q, want %q", results[i].Host, results[i].Severity, want)
+		}
+	}
+}
+
+// hookServer tags every host it is sent with "hooked" after delay,
+// counting requests and the results in them
+func hookServer(delay time.Duration, requests, received *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var results []Result
+		json.NewDecoder(r.Body).Decode(&results)
+		atomic.AddInt64(requests, 1)
+		atomic.AddInt64(received, int64(len(results)))
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		tags := make([]hookTagResponse, len(results))
+		for i, result := range results {
+			tags[i] = hookTagResponse{Host: result.Host, Tags: []string{"hooked"}, Severity: "low"}
+		}
+		json.NewEncoder(w).Encode(tags)
+	}))
+}
+
+func TestHookProcessor(t *testing.T) {
+	var requests, received int64
+	server := hookServer(0, &requests, &received)
+	defer server.Close()
+
+	pipeline := &ProcessorPipeline{stages: []processorStage{
+		{processor: &hookProcessor{name: "hook", url: server.URL}, enabled: true, timeout: time.Second},
+	}}
+	results := pipeline.Apply(context.Background(), []Result{{Host: "a.example.com"}, {Host: "b.example.com"}})
+	for _, result := range results {
+		if !slices.Equal(result.Tags, []string{"hooked"}) || result.Severity != "low" {
+			t.Errorf("%s: tags %v severity %q", result.Host, result.Tags, result.Severity)
+		}
+	}
+	if requests != 1 || received != 2 {
+		t.Errorf("hook got %d requests with %d results, want 1 with 2", requests, received)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	var requests, received int64
+	server := hookServer(2*time.Second, &requests, &received)
+	defer server.Close()
+
+	// The slow hook is skipped; the stages around it still apply
+	pipeline := &ProcessorPipeline{stages: []processorStage{
+		{processor: &regexTagger{name: "ci", pattern: regexp.MustCompile(`jenkins`), tags: []string{"ci"}}, enabled: true, timeout: time.Second},
+		{processor: &hookProcessor{name: "hook", url: server.URL}, enabled: true, timeout: 100 * time.Millisecond},
+		{processor: &severityAssigner{name: "severity", rules: []compiledSeverityRule{{tag: "ci", severity: "high"}}}, enabled: true, timeout: time.Second},
+	}}
+	start := time.Now()
+	results := pipeline.Apply(context.Background(), []Result{{Host: "jenkins.example.com"}})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Apply took %v with a 100ms hook timeout", elapsed)
+	}
+	if !slices.Equal(results[0].Tags, []string{"ci"}) || results[0].Severity != "high" {
+		t.Errorf("got tags %v severity %q, want [ci] high", results[0].Tags, results[0].Severity)
+	}
+}
+
+// Hooks go out through the outbound proxy, and HTTP.Timeout bounds one
+// whose stage timeout doesn't
+func TestHookClient(t *testing.T) {
+	var requests, received int64
+	proxy := hookServer(0, &requests, &received)
+	defer proxy.Close()
+	cfg := *currentConfig()
+	cfg.HTTP.ProxyURL = proxy.URL
+	cfg.HTTP.Timeout = 200 * time.Millisecond
+	ctx := withConfig(context.Background(), &cfg)
+
+	hook := &hookProcessor{name: "hook", url: "http://hook.example/tag"}
+	results := []Result{{Host: "a.example.com"}}
+	if err := hook.Process(ctx, results); err != nil || !slices.Equal(results[0].Tags, []string{"hooked"}) {
+		t.Errorf("hook behind the proxy: %v, tags %v", err, results[0].Tags)
+	}
+	if requests != 1 {
+		t.Errorf("proxy got %d requests, want 1", requests)
+	}
+
+	slow := hookServer(2*time.Second, &requests, &received)
+	defer slow.Close()
+	cfg.HTTP.ProxyURL = ""
+	hook.url = slow.URL
+	start := time.Now()
+	if err := hook.Process(ctx, results); err == nil || time.Since(start) > time.Second {
+		t.Errorf("slow hook returned %v after %v, want a timeout after 200ms", err, time.Since(start))
+	}
+}
+
+func TestDisabledStage(t *testing.T) {
+	pipeline := &ProcessorPipeline{stages: []processorStage{
+		{processor: &regexTagger{name: "ci", pattern: regexp.MustCompile(`.`), tags: []string{"ci"}}, enabled: false, timeout: time.Second},
+	}}
+	if pipeline.Active() {
+		t.Error("pipeline with only a disabled stage is active")
+	}
+	if results := pipeline.Apply(context.Background(), []Result{{Host: "a.example.com"}}); results[0].Tags != nil {
+		t.Errorf("disabled stage tagged %v", results[0].Tags)
+	}
+}
+
+// Results are processed in batches off the ingest path: a slow hook does
+// not hold up AddResult, and the tags reach the stored results by the time
+// the job ends
+func TestProcessingBatches(t *testing.T) {
+	var requests, received int64
+	server := hookServer(300*time.Millisecond, &requests, &received)
+	defer server.Close()
+	saved := processors
+	defer func() { processors = saved }()
+	processors = &ProcessorPipeline{stages: []processorStage{
+		{processor: &hookProcessor{name: "hook", url: server.URL}, enabled: true, timeout: 5 * time.Second},
+	}}
+
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	const hosts = 250
+	start := time.Now()
+	for i := 0; i < hosts; i++ {
+		job.AddResult("test", Result{Host: fmt.Sprintf("h%d.example.com", i), Source: "test"})
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("adding %d results took %v behind a 300ms hook", hosts, elapsed)
+	}
+	job.Complete()
+
+	if received != hosts {
+		t.Errorf("hook received %d results, want %d", received, hosts)
+	}
+	if want := int64((hosts + processBatchSize - 1) / processBatchSize); requests > want+1 {
+		t.Errorf("hook got %d requests for %d results, want about %d", requests, hosts, want)
+	}
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	for _, result := range job.Results["test"] {
+		if !slices.Equal(result.Tags, []string{"hooked"}) || result.Severity != "low" {
+			t.Fatalf("%s: stored tags %v severity %q", result.Host, result.Tags, result.Severity)
+		}
+	}
+}
+
+// Aborting jobs whose post-processing is slow leaves the job list free
+// while they drain
+func TestAbortDrainUnlocked(t *testing.T) {
+	var requests, received int64
+	server := hookServer(time.Second, &requests, &received)
+	defer server.Close()
+	saved := processors
+	defer func() { processors = saved }()
+	processors = &ProcessorPipeline{stages: []processorStage{
+		{processor: &hookProcessor{name: "hook", url: server.URL}, enabled: true, timeout: 5 * time.Second},
+	}}
+
+	job, _ := createJob(context.Background(), "drain.example.com", []string{"test"}, nil)
+	job.AddResult("test", Result{Host: "www.drain.example.com", Source: "test"})
+	for atomic.LoadInt64(&requests) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	other, _ := createJob(context.Background(), "example.org", []string{"test"}, nil)
+	defer other.Complete()
+
+	aborted := make(chan struct{})
+	go func() {
+		defer close(aborted)
+		abortHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/abort?target=drain.example.com", nil))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if _, ok := lookupJob(other.ID); !ok {
+		t.Error("other job not found during the abort")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("looking a job up took %v while an abort drained", elapsed)
+	}
+	<-aborted
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	if job.Status != "cancelled" {
+		t.Errorf("aborted job is %s", job.Status)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// profile= presets a scan's sources and options, which the request's own
+// override; a passive profile's scan sends nothing to the target's DNS,
+// quick's options are recorded on the job and full probes what it found
+func TestScanProfiles(t *testing.T) {
+	useSources(t, staticSource{"static-passive", []string{"www"}})
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+	stream := func(query url.Values) []sseEvent {
+		t.Helper()
+		query.Set("target", tt.Zone)
+		return streamEvents(t, server.URL+"/api/enumerate/stream?"+query.Encode())
+	}
+
+	queries := atomic.LoadInt64(&tt.dnsQueries)
+	events := stream(url.Values{"profile": {"passive"}, "sources": {"crtsh,static-passive"}})
+	if got := atomic.LoadInt64(&tt.dnsQueries) - queries; got != 0 {
+		t.Errorf("passive scan sent %d DNS queries to the target", got)
+	}
+	if len(events) == 0 || events[len(events)-1].name != "complete" {
+		t.Errorf("passive scan ended with %v", events)
+	}
+
+	stream(url.Values{"profile": {"quick"}, "sources": {"static-passive"}})
+	job := latestJob(tt.Zone, nil)
+	job.mu.RLock()
+	options, sources := job.Options, job.Sources
+	job.mu.RUnlock()
+	if options["profile"] != "quick" || options["categories"] != "common" || options["concurrency"] != "25" || !slices.Equal(sources, []string{"static-passive"}) {
+		t.Errorf("quick scan recorded sources %v and options %v", sources, options)
+	}
+	// The request's own option wins over the profile's
+	stream(url.Values{"profile": {"quick"}, "sources": {"static-passive"}, "concurrency": {"5"}})
+	if job := latestJob(tt.Zone, nil); job.Options["concurrency"] != "5" {
+		t.Errorf("concurrency=5 recorded as %q", job.Options["concurrency"])
+	}
+
+	probed := false
+	for _, event := range stream(url.Values{"profile": {"full"}, "sources": {"static-passive"}}) {
+		var probe ProbeEvent
+		if event.name == "probe" && json.Unmarshal(event.data, &probe) == nil {
+			probed = probed || probe.Host == "www."+tt.Zone && probe.Status == "200"
+		}
+	}
+	if !probed {
+		t.Error("full scan sent no probe of www")
+	}
+
+	for _, tc := range []struct {
+		query url.Values
+		want  string
+	}{
+		{url.Values{"profile": {"bogus"}}, "valid profiles: passive, quick, full"},
+		{url.Values{"profile": {"passive"}, "sources": {"dns"}}, "dns"},
+		{url.Values{"profile": {"quick"}, "concurrency": {"1000000"}}, "concurrency"},
+	} {
+		tc.query.Set("target", tt.Zone)
+		resp, err := http.Get(server.URL + "/api/enumerate/stream?" + tc.query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), tc.want) {
+			t.Errorf("%s: HTTP %d %q, want 400 naming %s", tc.query.Encode(), resp.StatusCode, body, tc.want)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/api/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var config struct {
+		Profiles []ScanProfile `json:"profiles"`
+	}
+	json.NewDecoder(resp.Body).Decode(&config)
+	var names []string
+	for _, profile := range config.Profiles {
+		names = append(names, profile.Name)
+		if len(profile.Sources) == 0 {
+			t.Errorf("/api/config lists profile %s without sources", profile.Name)
+		}
+	}
+	if !slices.Equal(names, []string{"passive", "quick", "full"}) {
+		t.Errorf("/api/config lists profiles %v", names)
+	}
+}
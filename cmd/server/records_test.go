@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// A records stream of its own starts from the hosts of the job it names,
+// or of every job for the target, not from its own empty job
+func TestRecordsSeeds(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	// old.<zone> is a CNAME to retired.<zone>, which nothing else names
+	seeded, _ := createJob(context.Background(), tt.Zone, []string{"test"}, nil)
+	seeded.AddResult("test", Result{Host: "old." + tt.Zone, Source: "test", Status: "discovered", Timestamp: time.Now()})
+	seeded.Complete()
+	empty, _ := createJob(context.Background(), tt.Zone, []string{"test"}, nil)
+	empty.Complete()
+
+	for _, tc := range []struct {
+		job  string
+		want bool
+	}{
+		{job: seeded.ID, want: true},
+		{job: empty.ID, want: false},
+		{want: true},
+	} {
+		query := url.Values{"target": {tt.Zone}}
+		if tc.job != "" {
+			query.Set("job", tc.job)
+		}
+		found := make(map[string]bool)
+		for _, event := range streamEvents(t, server.URL+"/api/records/stream?"+query.Encode()) {
+			var result Result
+			if event.name == "message" && json.Unmarshal(event.data, &result) == nil {
+				found[result.Host] = true
+			}
+		}
+		if !found["mail."+tt.Zone] {
+			t.Errorf("job=%q: the apex MX mail.%s was not found", tc.job, tt.Zone)
+		}
+		if got := found["retired."+tt.Zone]; got != tc.want {
+			t.Errorf("job=%q: found retired.%s: %v, want %v", tc.job, tt.Zone, got, tc.want)
+		}
+	}
+}
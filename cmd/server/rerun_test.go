@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staticSource reports the same hosts for any target
+type staticSource struct {
+	name  string
+	hosts []string
+}
+
+func (s staticSource) Name() string { return s.name }
+
+func (s staticSource) Enumerate(ctx context.Context, target string, out chan<- Result) error {
+	for _, host := range s.hosts {
+		if !emit(ctx, out, Result{Host: host + "." + target}) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// useSources registers sources for the rest of the test
+func useSources(t *testing.T, sources ...Source) {
+	t.Helper()
+	saved := sourceRegistry
+	t.Cleanup(func() { sourceRegistry = saved })
+	sourceRegistry = slices.Clone(sourceRegistry)
+	for _, source := range sources {
+		sourceRegistry = append(sourceRegistry, sourceEntry{
+			source:  source,
+			label:   source.Name(),
+			timeout: func(TimeoutConfig) time.Duration { return 5 * time.Second },
+		})
+	}
+}
+
+// A rerun is one job, linked to the original, with every source in it
+func TestRerunJob(t *testing.T) {
+	useSources(t, staticSource{"static-a", []string{"www", "api"}}, staticSource{"static-b", []string{"mail"}})
+
+	parent, _ := createJob(context.Background(), "example.com", []string{"static-a"}, nil)
+	parent.Complete()
+
+	request := httptest.NewRequest(http.MethodPost, "/api/jobs/"+parent.ID+"/rerun", strings.NewReader(`{"add_sources": ["static-b"]}`))
+	response := httptest.NewRecorder()
+	rerunJobHandler(response, request, parent)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("rerun answered %d: %s", response.Code, response.Body)
+	}
+	var accepted struct {
+		JobID   string   `json:"job_id"`
+		RerunOf string   `json:"rerun_of"`
+		Sources []string `json:"sources"`
+	}
+	json.NewDecoder(response.Body).Decode(&accepted)
+	if accepted.RerunOf != parent.ID || !slices.Equal(accepted.Sources, []string{"static-a", "static-b"}) {
+		t.Errorf("rerun answered %+v", accepted)
+	}
+
+	job, ok := lookupJob(accepted.JobID)
+	if !ok {
+		t.Fatalf("rerun job %q not found", accepted.JobID)
+	}
+	var children []string
+	jobManager.mu.RLock()
+	for id, other := range jobManager.jobs {
+		if other.ParentID == parent.ID {
+			children = append(children, id)
+		}
+	}
+	jobManager.mu.RUnlock()
+	if !slices.Equal(children, []string{job.ID}) {
+		t.Errorf("jobs linked to the original: %v, want only %s", children, job.ID)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		job.mu.RLock()
+		done := !job.EndTime.IsZero()
+		job.mu.RUnlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rerun job did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	job.mu.RLock()
+	defer job.mu.RUnlock()
+	if job.BaselineID != parent.ID || !slices.Equal(job.Changes, []string{"+static-b"}) {
+		t.Errorf("rerun baseline %q changes %v", job.BaselineID, job.Changes)
+	}
+	if len(job.Results["static-a"]) != 2 || len(job.Results["static-b"]) != 1 {
+		t.Errorf("rerun results: %d from static-a, %d from static-b; want 2 and 1", len(job.Results["static-a"]), len(job.Results["static-b"]))
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixtureResults is a job's results in no particular order: sources and
+// hosts of two registrable domains, some hosts found by several sources
+func fixtureResults() []Result {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var results []Result
+	for i, host := range []string{"www.example.com", "example.com", "a.b.example.com", "api.example.com", "b.example.com", "mail.example.org"} {
+		for j, source := range []string{"crtsh", "dns"} {
+			if (i+j)%3 != 0 {
+				results = append(results, Result{Host: host, Source: source, Status: "discovered", Timestamp: at.Add(time.Duration(i+j) * time.Second)})
+			}
+		}
+	}
+	return results
+}
+
+// fixtureJob adds the fixture's results to a new job in a shuffled order
+func fixtureJob(t *testing.T) *Job {
+	t.Helper()
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	results := fixtureResults()
+	rand.Shuffle(len(results), func(i, j int) { results[i], results[j] = results[j], results[i] })
+	for _, result := range results {
+		job.AddResult(result.Source, result)
+	}
+	job.Complete()
+	return job
+}
+
+// export downloads job's export in format
+func export(job *Job, format string) string {
+	response := httptest.NewRecorder()
+	jobExportHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"/export?format="+format, nil), job)
+	return response.Body.String()
+}
+
+// Exports list hosts in canonical order, whatever order the results came
+// in, so two jobs with the same results export byte-identical files
+func TestExportOrder(t *testing.T) {
+	first, second := fixtureJob(t), fixtureJob(t)
+	for _, format := range []string{"json", "ndjson", "csv"} {
+		exported := export(first, format)
+		if again := export(first, format); again != exported {
+			t.Errorf("%s: two downloads of a job differ", format)
+		}
+		if other := export(second, format); other != exported {
+			t.Errorf("%s: jobs with the same results export\n%s\nand\n%s", format, exported, other)
+		}
+	}
+
+	var hosts []string
+	for _, line := range strings.Split(strings.TrimSpace(export(first, "ndjson")), "\n") {
+		var result Result
+		json.Unmarshal([]byte(line), &result)
+		hosts = append(hosts, result.Host)
+	}
+	want := []string{"example.com", "api.example.com", "b.example.com", "a.b.example.com", "www.example.com", "mail.example.org"}
+	if !slices.Equal(hosts, want) {
+		t.Errorf("exported %v, want %v", hosts, want)
+	}
+}
+
+// Exports write one row per host, merging its results: every source that
+// found it, the earliest timestamp and the first addresses and title. CSV
+// quotes what needs it and the download is named after target and date.
+func TestExportFormats(t *testing.T) {
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	job.AddResult("dns", Result{Host: "www.example.com", Source: "dns", Status: "resolved", Timestamp: at.Add(time.Minute),
+		IPs: []ResultIP{{Address: "192.0.2.1"}, {Address: "192.0.2.2"}}})
+	job.AddResult("probe", Result{Host: "www.example.com", Source: "probe", Status: "200", Timestamp: at.Add(2 * time.Minute),
+		Title: `Sign in, "admin"`, URL: "https://www.example.com/"})
+	job.AddResult("crtsh", Result{Host: "www.example.com", Source: "crtsh", Status: "discovered", Timestamp: at})
+	job.AddResult("crtsh", Result{Host: "mail.example.com", Source: "crtsh", Status: "discovered", Timestamp: at})
+	job.Complete()
+
+	response := httptest.NewRecorder()
+	jobExportHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"/export?format=csv", nil), job)
+	want := fmt.Sprintf("attachment; filename=%q", "example.com-"+job.StartTime.UTC().Format("2006-01-02")+".csv")
+	if got := response.Header().Get("Content-Disposition"); got != want {
+		t.Errorf("Content-Disposition %q, want %q", got, want)
+	}
+	if !strings.Contains(response.Body.String(), `"Sign in, ""admin"""`) {
+		t.Errorf("title not quoted in\n%s", response.Body.String())
+	}
+	rows, err := csv.NewReader(strings.NewReader(response.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0][:7], ",") != "host,source,status,ips,title,url,timestamp" {
+		t.Fatalf("CSV rows %q", rows)
+	}
+	www := rows[2]
+	if got := []string{www[0], www[1], www[2], www[3], www[4], www[5], www[6]}; !slices.Equal(got, []string{
+		"www.example.com", "crtsh,dns,probe", "resolved", "192.0.2.1 192.0.2.2", `Sign in, "admin"`, "https://www.example.com/", at.Format(time.RFC3339),
+	}) {
+		t.Errorf("merged row %q", got)
+	}
+
+	var listed []Result
+	if err := json.Unmarshal([]byte(export(job, "json")), &listed); err != nil || len(listed) != 2 {
+		t.Errorf("JSON export: %v, %d hosts", err, len(listed))
+	}
+	if lines := strings.Split(strings.TrimSpace(export(job, "ndjson")), "\n"); len(lines) != 2 {
+		t.Errorf("NDJSON export has %d lines, want 2", len(lines))
+	}
+	response = httptest.NewRecorder()
+	jobExportHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"/export?format=xml", nil), job)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("format=xml: HTTP %d", response.Code)
+	}
+}
+
+// A walk over a running job resumes after its last result when the job is
+// re-sorted between chunks, returning every result once
+func TestResultWalkerResort(t *testing.T) {
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	defer job.Complete()
+	for _, result := range fixtureResults() {
+		job.AddResult(result.Source, result)
+	}
+	walker := newResultWalker(job)
+	seen := make(map[string]int)
+	for _, item := range walker.chunk(3) {
+		seen[item.order.Source+" "+item.result.Host]++
+	}
+	// Sorts first in canonical order, before where the walk has got to
+	job.AddResult("crtsh", Result{Host: "0.example.com", Source: "crtsh", Status: "discovered"})
+	job.mu.Lock()
+	job.sortResults()
+	job.mu.Unlock()
+	for chunk := walker.chunk(3); len(chunk) > 0; chunk = walker.chunk(3) {
+		for _, item := range chunk {
+			seen[item.order.Source+" "+item.result.Host]++
+		}
+	}
+	for _, result := range fixtureResults() {
+		if n := seen[result.Source+" "+result.Host]; n != 1 {
+			t.Errorf("%s from %s returned %d times", result.Host, result.Source, n)
+		}
+	}
+}
+
+// /hosts lists each host once, lower-cased and sorted, with the sources
+// that found it, its earliest timestamp and the status of its probe for
+// the job; plain=1 lists just the names
+func TestJobHosts(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+	job, _ := createJob(context.Background(), tt.Zone, []string{"test"}, nil)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	www, intranet := "www."+tt.Zone, "intranet."+tt.Zone
+	job.AddResult("dns", Result{Host: www, Source: "dns", Status: "resolved", Timestamp: at.Add(time.Minute)})
+	job.AddResult("crtsh", Result{Host: strings.ToUpper(www), Source: "crtsh", Status: "discovered", Timestamp: at})
+	job.AddResult("dns", Result{Host: intranet, Source: "dns", Status: "discovered", Timestamp: at})
+	job.Complete()
+	streamEvents(t, server.URL+"/api/probe/stream?"+url.Values{"targets": {www + "," + intranet}, "job": {job.ID}}.Encode())
+
+	resp, err := http.Get(server.URL + "/api/jobs/" + job.ID + "/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed struct {
+		Count int       `json:"count"`
+		Hosts []JobHost `json:"hosts"`
+	}
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	got := make(map[string]JobHost)
+	var names []string
+	for _, host := range listed.Hosts {
+		got[host.Host] = host
+		names = append(names, host.Host)
+	}
+	if listed.Count != len(listed.Hosts) || !slices.IsSorted(names) || len(slices.Compact(slices.Clone(names))) != len(names) {
+		t.Errorf("hosts %v, count %d", names, listed.Count)
+	}
+	if host := got[www]; strings.Join(host.Sources, ",") != "crtsh,dns" || !host.FirstSeen.Equal(at) || host.ProbeStatus != "200" {
+		t.Errorf("www listed as %+v", host)
+	}
+	if host, ok := got[intranet]; !ok || host.ProbeStatus != "" {
+		t.Errorf("intranet, which has no DNS record, listed as %+v", host)
+	}
+
+	resp, err = http.Get(server.URL + "/api/jobs/" + job.ID + "/hosts?plain=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := strings.Join(names, "\n") + "\n"; string(body) != want || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("plain=1 answered %q (%s), want %q", body, resp.Header.Get("Content-Type"), want)
+	}
+}
+
+// /api/diff compares the two latest scans of a target by default, or the
+// named ones, paired with the latest scan before to= or after from=;
+// hosts match whatever their case, and probe status changes are listed
+func TestDiffJobs(t *testing.T) {
+	useJobManager(t)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	scan := func(day int, hosts []string, statuses map[string]string) *Job {
+		job, _ := createJob(context.Background(), "diff.example.com", []string{"test"}, nil)
+		job.mu.Lock()
+		job.StartTime = at.AddDate(0, 0, day)
+		job.mu.Unlock()
+		for _, host := range hosts {
+			job.AddResult("test", Result{Host: host + ".diff.example.com", Source: "test", Status: "discovered"})
+		}
+		for host, status := range statuses {
+			job.RecordProbe(host+".diff.example.com", ProbeResponse{Status: status})
+		}
+		job.Complete()
+		return job
+	}
+	first := scan(0, []string{"www", "old"}, nil)
+	second := scan(1, []string{"WWW", "api"}, map[string]string{"www": "200"})
+	third := scan(2, []string{"www", "api", "new"}, map[string]string{"www": "503", "api": "200"})
+	running, _ := createJob(context.Background(), "diff.example.com", []string{"test"}, nil)
+	defer running.Complete()
+
+	diff := func(query string) (JobDiff, int) {
+		t.Helper()
+		response := httptest.NewRecorder()
+		diffHandler(response, httptest.NewRequest(http.MethodGet, "/api/diff?"+query, nil))
+		var diff JobDiff
+		json.Unmarshal(response.Body.Bytes(), &diff)
+		return diff, response.Code
+	}
+	name := func(hosts ...string) []string {
+		for i, host := range hosts {
+			hosts[i] = host + ".diff.example.com"
+		}
+		return hosts
+	}
+	for _, tc := range []struct {
+		query          string
+		from, to       *Job
+		added, removed []string
+		changed        []ProbeChange
+		unchanged      int
+	}{
+		{query: "target=Diff.Example.com", from: second, to: third, added: name("new"),
+			unchanged: 1,
+			changed:   []ProbeChange{{Host: "www.diff.example.com", From: "200", To: "503"}}},
+		{query: "to=" + second.ID, from: first, to: second, added: name("api"), removed: name("old"), unchanged: 1},
+		{query: "from=" + first.ID, from: first, to: third, added: name("api", "new"), removed: name("old"), unchanged: 1},
+		{query: "from=" + third.ID + "&to=" + first.ID, from: third, to: first, added: name("old"), removed: name("api", "new"), unchanged: 1},
+	} {
+		got, code := diff(tc.query)
+		if code != http.StatusOK || got.From != tc.from.ID || got.To != tc.to.ID {
+			t.Errorf("%s: HTTP %d comparing %s to %s, want %s to %s", tc.query, code, got.From, got.To, tc.from.ID, tc.to.ID)
+			continue
+		}
+		if !slices.Equal(got.Added, tc.added) || !slices.Equal(got.Removed, tc.removed) || !slices.Equal(got.Changed, tc.changed) || got.Unchanged != tc.unchanged {
+			t.Errorf("%s: added %v, removed %v, changed %v, %d unchanged; want %v, %v, %v, %d", tc.query,
+				got.Added, got.Removed, got.Changed, got.Unchanged, tc.added, tc.removed, tc.changed, tc.unchanged)
+		}
+	}
+
+	only, _ := createJob(context.Background(), "lonely.example.com", []string{"test"}, nil)
+	only.Complete()
+	for query, want := range map[string]int{
+		"":                          http.StatusBadRequest,
+		"from=no-such-job":          http.StatusNotFound,
+		"target=lonely.example.com": http.StatusNotFound,
+		"from=" + third.ID:          http.StatusNotFound,
+		"target=other.example.com&to=" + third.ID: http.StatusBadRequest,
+	} {
+		if _, code := diff(query); code != want {
+			t.Errorf("%q: HTTP %d, want %d", query, code, want)
+		}
+	}
+}
+
+// Paging a job that keeps growing lists every result it had when paging
+// began exactly once
+func TestResultsPaging(t *testing.T) {
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	defer job.Complete()
+	original := make(map[string]bool)
+	for i := range 200 {
+		host := fmt.Sprintf("h%03d.example.com", i)
+		original[host] = true
+		job.AddResult("test", Result{Host: host, Source: "test", Status: "discovered"})
+	}
+
+	seen := make(map[string]int)
+	added := 0
+	for after, pages := "", 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("paging did not end")
+		}
+		response := httptest.NewRecorder()
+		jobResultsHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"/results?limit=13&after="+url.QueryEscape(after), nil), job)
+		var page struct {
+			Results []Result `json:"results"`
+			Next    string   `json:"next"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+			t.Fatalf("page after %q: HTTP %d, %v", after, response.Code, err)
+		}
+		for _, result := range page.Results {
+			seen[result.Host]++
+		}
+		if page.Next == "" {
+			break
+		}
+		after = page.Next
+		// New results land before and after the cursor while paging
+		for range 10 {
+			job.AddResult("test", Result{Host: fmt.Sprintf("h%03d-%d.example.com", rand.IntN(200), added), Source: "test", Status: "discovered"})
+			added++
+		}
+	}
+	for host := range original {
+		if seen[host] != 1 {
+			t.Errorf("%s listed %d times", host, seen[host])
+		}
+	}
+	for host, n := range seen {
+		if n > 1 {
+			t.Errorf("%s listed %d times", host, n)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failing returns an fn for retry that fails every attempt, with the
+// error of errs for that attempt (the last one past its end), counting
+// its calls
+func failing(calls *int, errs ...error) func(context.Context, int) error {
+	return func(_ context.Context, attempt int) error {
+		*calls++
+		return errs[min(attempt, len(errs)-1)]
+	}
+}
+
+// A retry whose backoff would outlast the deadline isn't waited for
+func TestRetryDeadline(t *testing.T) {
+	policy := RetryPolicy{Subsystem: "test", Attempts: 10, Base: 100 * time.Millisecond, Max: 100 * time.Millisecond, Deadline: 150 * time.Millisecond}
+	last := errors.New("second")
+	calls := 0
+	start := time.Now()
+	err := retry(context.Background(), policy, failing(&calls, errors.New("first"), last))
+	if elapsed := time.Since(start); calls != 2 || err != last || elapsed >= 150*time.Millisecond {
+		t.Errorf("%d attempts in %v returning %v; want 2 in under 150ms returning %v", calls, elapsed, err, last)
+	}
+}
+
+// An error the policy doesn't retry ends it at once, uncounted
+func TestRetryNonRetryable(t *testing.T) {
+	final := errors.New("final")
+	policy := RetryPolicy{Subsystem: "test", Attempts: 10, Base: time.Millisecond,
+		Retryable: func(err error) bool { return !errors.Is(err, final) }}
+	for _, tc := range []struct {
+		errs  []error
+		calls int
+	}{
+		{errs: []error{final}, calls: 1},
+		{errs: []error{errors.New("flaky"), errors.New("flaky"), fmt.Errorf("wrapped: %w", final)}, calls: 3},
+	} {
+		retries := atomic.LoadInt64(retryCounter("test"))
+		calls := 0
+		err := retry(context.Background(), policy, failing(&calls, tc.errs...))
+		if calls != tc.calls || !errors.Is(err, final) {
+			t.Errorf("%v: %d attempts returning %v; want %d returning final", tc.errs, calls, err, tc.calls)
+		}
+		if got := atomic.LoadInt64(retryCounter("test")) - retries; got != int64(tc.calls-1) {
+			t.Errorf("%v: counted %d retries, want %d", tc.errs, got, tc.calls-1)
+		}
+	}
+}
+
+// Cancelling the context ends the backoff it is sleeping in
+func TestRetryCancelled(t *testing.T) {
+	policy := RetryPolicy{Subsystem: "test", Attempts: 10, Base: 10 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	first := errors.New("first")
+	calls := 0
+	start := time.Now()
+	err := retry(ctx, policy, failing(&calls, first))
+	if elapsed := time.Since(start); calls != 1 || err != first || elapsed > time.Second {
+		t.Errorf("%d attempts in %v returning %v; want 1 ended by the cancel returning %v", calls, elapsed, err, first)
+	}
+}
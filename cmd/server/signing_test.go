@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useSigner signs evidence with a fresh key for the rest of the test and
+// returns the path of its public key
+func useSigner(t *testing.T) string {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "public.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := evidenceSigner
+	evidenceSigner = &Signer{key: private, fingerprint: keyFingerprint(public)}
+	t.Cleanup(func() { evidenceSigner = previous })
+	return path
+}
+
+// checkEvidence writes data to a file and verifies it against publicKey
+func checkEvidence(t *testing.T, publicKey string, data []byte) error {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "evidence")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := verifyEvidence(path, publicKey)
+	return err
+}
+
+// tamperOnce changes the first old in data to new, which must be as long
+func tamperOnce(t *testing.T, data []byte, old, new string) []byte {
+	t.Helper()
+	i := bytes.Index(data, []byte(old))
+	if i < 0 || len(old) != len(new) {
+		t.Fatalf("cannot change %q to %q", old, new)
+	}
+	tampered := bytes.Clone(data)
+	copy(tampered[i:], new)
+	return tampered
+}
+
+// rezip returns the bundle with edit applied to the named file, every
+// other file left as it was
+func rezip(t *testing.T, bundle []byte, name string, edit func([]byte) []byte) []byte {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	out := zip.NewWriter(&buf)
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name == name {
+			content = edit(content)
+		}
+		w, err := out.Create(f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Signed envelopes and bundles verify as served, and a single changed byte
+// in what they sign fails them
+func TestEvidenceSignature(t *testing.T) {
+	publicKey := useSigner(t)
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	job.AddResult("test", Result{Host: "www.example.com", Source: "test", Status: "discovered", Timestamp: time.Now()})
+	job.Complete()
+
+	response := httptest.NewRecorder()
+	signedJobHandler(response, job)
+	envelope := response.Body.Bytes()
+	if err := checkEvidence(t, publicKey, envelope); err != nil {
+		t.Errorf("envelope as served: %v", err)
+	}
+	// Re-serializing keeps the signature valid
+	var indented bytes.Buffer
+	json.Indent(&indented, envelope, "", "    ")
+	if err := checkEvidence(t, publicKey, indented.Bytes()); err != nil {
+		t.Errorf("re-indented envelope: %v", err)
+	}
+	if err := checkEvidence(t, publicKey, tamperOnce(t, envelope, "www.example", "vww.example")); err == nil {
+		t.Error("envelope with a changed host verified")
+	}
+
+	response = httptest.NewRecorder()
+	jobBundleHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"/bundle", nil), job)
+	bundle := response.Body.Bytes()
+	if err := checkEvidence(t, publicKey, bundle); err != nil {
+		t.Errorf("bundle as served: %v", err)
+	}
+	// Zipped again untouched, it still verifies
+	if err := checkEvidence(t, publicKey, rezip(t, bundle, "job.json", bytes.Clone)); err != nil {
+		t.Errorf("bundle zipped again: %v", err)
+	}
+	for name, edit := range map[string]func([]byte) []byte{
+		"job.json":         func(data []byte) []byte { return tamperOnce(t, data, "www.example", "vww.example") },
+		bundleManifestName: func(data []byte) []byte { return tamperOnce(t, data, `"version": 1`, `"version": 2`) },
+		bundleSignatureName: func(data []byte) []byte {
+			// Another base64 digit, so the signature still decodes
+			tampered := bytes.Clone(data)
+			if tampered[0] = 'A'; data[0] == 'A' {
+				tampered[0] = 'B'
+			}
+			return tampered
+		},
+	} {
+		if err := checkEvidence(t, publicKey, rezip(t, bundle, name, edit)); err == nil {
+			t.Errorf("bundle with a byte of %s changed verified", name)
+		}
+	}
+}
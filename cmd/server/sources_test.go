@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// LeakIX is asked about the lowercase ASCII form of a target given in
+// mixed case or Unicode, and its answers are matched against that form
+func TestLeakIXTargetForm(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		domain := strings.TrimPrefix(r.URL.Path, "/api/subdomains/")
+		json.NewEncoder(w).Encode([]leakixEntry{{Subdomain: "WWW." + domain, DistinctIPs: 1}})
+	}))
+	defer server.Close()
+	cfg := *currentConfig()
+	cfg.Upstreams.LeakIX = server.URL
+	ctx := withConfig(context.Background(), &cfg)
+
+	for target, want := range map[string]string{
+		"Example.COM":    "example.com",
+		"BÜCHER.example": "xn--bcher-kva.example",
+	} {
+		paths = nil
+		out := make(chan Result, 10)
+		if err := (leakixSource{}).Enumerate(ctx, target, out); err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		close(out)
+		if len(paths) != 1 || paths[0] != "/api/subdomains/"+want {
+			t.Errorf("%s: LeakIX asked for %v, want /api/subdomains/%s", target, paths, want)
+		}
+		var hosts []string
+		for result := range out {
+			hosts = append(hosts, result.Host)
+		}
+		if len(hosts) != 1 || hosts[0] != "www."+want {
+			t.Errorf("%s: found %v, want www.%s", target, hosts, want)
+		}
+	}
+}
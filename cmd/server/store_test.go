@@ -0,0 +1,398 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// useJobStore makes a bbolt store in a temporary directory the job store
+// for the rest of the test
+func useJobStore(t *testing.T) (path string) {
+	t.Helper()
+	path = filepath.Join(t.TempDir(), "jobs.db")
+	store, err := openBoltJobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setJobStore(store)
+	t.Cleanup(func() {
+		closeJobStore()
+		setJobStore(nil)
+	})
+	return path
+}
+
+// setJobStore swaps the job store under the locks its users take, which
+// the jobs of earlier tests may still be writing through. Jobs they left
+// queued are not the new store's.
+func setJobStore(store JobStore) {
+	jobStoreWrites.Lock()
+	defer jobStoreWrites.Unlock()
+	pendingJobs.mu.Lock()
+	defer pendingJobs.mu.Unlock()
+	jobStore = store
+	jobStoreClosed = false
+	clear(pendingJobs.jobs)
+}
+
+// reopenJobStore closes the job store, as a shutdown does, and opens its
+// file again
+func reopenJobStore(t *testing.T, path string) *boltJobStore {
+	t.Helper()
+	closeJobStore()
+	store, err := openBoltJobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// A job written before a shutdown reads back whole from a new store
+func TestJobStoreRestart(t *testing.T) {
+	path := useJobStore(t)
+
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	job.AddResult("test", Result{Host: "www.example.com", Source: "test", Status: "discovered", Timestamp: time.Now()})
+	job.AddResult("test", Result{Host: "api.example.com", Source: "test", Status: "discovered", Timestamp: time.Now(),
+		IPs: []ResultIP{{Address: "10.0.0.1", Family: "ipv4"}}})
+	job.FinishSource("test", "complete")
+	job.Complete()
+
+	running, _ := createJob(context.Background(), "example.org", []string{"test"}, nil)
+	running.AddResult("test", Result{Host: "www.example.org", Source: "test", Timestamp: time.Now()})
+	persistJob(running)
+	defer running.Complete()
+
+	// Nothing waits for the flush ticker: the shutdown writes both
+	store := reopenJobStore(t, path)
+	stored, err := store.Load(job.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("Load(%s) = %v, %v", job.ID, stored, err)
+	}
+	if stored.Target != "example.com" || stored.Status != "completed" || !stored.EndTime.Equal(job.EndTime) || stored.Duration != job.Duration {
+		t.Errorf("stored job: target %s status %s end %v duration %v; want example.com completed %v %v",
+			stored.Target, stored.Status, stored.EndTime, stored.Duration, job.EndTime, job.Duration)
+	}
+	var hosts []string
+	for _, result := range stored.Results["test"] {
+		hosts = append(hosts, result.Host)
+	}
+	slices.Sort(hosts)
+	if !slices.Equal(hosts, []string{"api.example.com", "www.example.com"}) || stored.UniqueHosts != 2 {
+		t.Errorf("stored hosts %v (%d unique), want api and www", hosts, stored.UniqueHosts)
+	}
+	if stored.SourceTimings["test"] == nil || stored.SourceTimings["test"].Outcome != "complete" {
+		t.Errorf("stored source timing %+v", stored.SourceTimings["test"])
+	}
+	if _, ok := stored.Hosts()["api.example.com"]; !ok {
+		t.Error("restored job lost its host set")
+	}
+
+	// A job stored while running was cut short by the restart
+	interrupted, err := store.Load(running.ID)
+	if err != nil || interrupted == nil {
+		t.Fatalf("Load(%s) = %v, %v", running.ID, interrupted, err)
+	}
+	if interrupted.Status != "interrupted" || interrupted.EndTime.IsZero() {
+		t.Errorf("running job came back %s ending %v, want interrupted", interrupted.Status, interrupted.EndTime)
+	}
+
+	all, err := store.All()
+	if err != nil || len(all) != 2 {
+		t.Errorf("All() = %d jobs, %v; want 2", len(all), err)
+	}
+}
+
+// After the store is closed, flushes and removals leave it alone
+func TestJobStoreClosed(t *testing.T) {
+	useJobStore(t)
+	closeJobStore()
+
+	job, _ := createJob(context.Background(), "example.net", []string{"test"}, nil)
+	job.Complete()
+	if err := flushJobs(); err != nil {
+		t.Errorf("flush after close: %v", err)
+	}
+	removeJobs([]string{job.ID})
+}
+
+// fillJobStore stores n finished jobs with results results each
+func fillJobStore(t *testing.T, target string, n, results int) []*Job {
+	t.Helper()
+	jobs := make([]*Job, n)
+	for i := range jobs {
+		job, _ := createJob(context.Background(), target, []string{"test"}, nil)
+		for j := 0; j < results; j++ {
+			job.AddResult("test", Result{Host: fmt.Sprintf("h%d-%d.%s", i, j, target), Source: "test", Status: "discovered", Timestamp: time.Now()})
+		}
+		job.FinishSource("test", "complete")
+		job.Complete()
+		jobs[i] = job
+	}
+	if err := flushJobs(); err != nil {
+		t.Fatal(err)
+	}
+	return jobs
+}
+
+// Compacting after deletions shrinks the file and keeps the surviving jobs
+// whole, while a scan goes on writing
+func TestJobStoreCompact(t *testing.T) {
+	path := useJobStore(t)
+	jobs := fillJobStore(t, "example.com", 40, 200)
+	var deleted []string
+	for _, job := range jobs[:30] {
+		deleted = append(deleted, job.ID)
+	}
+	removeJobs(deleted)
+
+	// A scan writing throughout the compaction
+	running, _ := createJob(context.Background(), "example.org", []string{"test"}, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			running.AddResult("test", Result{Host: fmt.Sprintf("w%d.example.org", i), Source: "test", Timestamp: time.Now()})
+			if i%20 == 0 {
+				flushJobs()
+			}
+		}
+		running.FinishSource("test", "complete")
+		running.Complete()
+	}()
+	compaction, err := compactJobStore()
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compaction.Reclaimed <= 0 || compaction.BytesAfter >= compaction.BytesBefore || compaction.Duration <= 0 {
+		t.Errorf("compaction %+v reclaimed nothing", compaction)
+	}
+	if stats := jobStoreStats(); stats == nil || stats.LastCompaction == nil || stats.LastCompaction.Reclaimed != compaction.Reclaimed {
+		t.Errorf("job store stats %+v lack the compaction", stats)
+	}
+
+	store := reopenJobStore(t, path)
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("compacted store fails its check: %v", err)
+	}
+	for _, id := range deleted {
+		if stored, _ := store.Load(id); stored != nil {
+			t.Errorf("deleted job %s came back", id)
+		}
+	}
+	for _, job := range append(jobs[30:], running) {
+		stored, err := store.Load(job.ID)
+		if err != nil || stored == nil {
+			t.Errorf("Load(%s) = %v, %v", job.ID, stored, err)
+			continue
+		}
+		if stored.Status != "completed" || stored.UniqueHosts != 200 || len(stored.Results["test"]) != 200 {
+			t.Errorf("%s came back %s with %d hosts, %d results", job.ID, stored.Status, stored.UniqueHosts, len(stored.Results["test"]))
+		}
+	}
+}
+
+// Past JOB_STORE_MAX_BYTES the earliest finished jobs go first
+func TestJobStoreMaxBytes(t *testing.T) {
+	useJobStore(t)
+	jobs := fillJobStore(t, "example.net", 10, 50)
+	sizes, err := jobStore.Sizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	before := atomic.LoadInt64(&stats.OversizeJobs)
+	if evicted := evictOversizeJobs(total / 2); evicted < 5 || evicted > 6 {
+		t.Errorf("evicted %d of 10 equal jobs to halve the store", evicted)
+	}
+	if got := atomic.LoadInt64(&stats.OversizeJobs) - before; got < 5 {
+		t.Errorf("over_size counted %d", got)
+	}
+	// Job 6 of 10 goes only if the sizes round that way
+	for i, job := range slices.Delete(slices.Clone(jobs), 5, 6) {
+		stored, _ := jobStore.Load(job.ID)
+		if kept, want := stored != nil, i >= 5; kept != want {
+			t.Errorf("job %s (finished %d of 9) kept: %v, want %v", job.ID, i+1, kept, want)
+		}
+	}
+}
+
+// useJobManager gives the test a job map of its own, so eviction sees
+// only the test's jobs; the others come back afterwards
+func useJobManager(t *testing.T) {
+	t.Helper()
+	jobManager.mu.Lock()
+	saved := jobManager.jobs
+	jobManager.jobs = make(map[string]*Job)
+	jobManager.mu.Unlock()
+	t.Cleanup(func() {
+		jobManager.mu.Lock()
+		defer jobManager.mu.Unlock()
+		maps.Copy(saved, jobManager.jobs)
+		jobManager.jobs = saved
+	})
+}
+
+// Finished jobs past JOB_RETENTION go, then the earliest finished while
+// there are more than MAX_JOBS; running jobs stay, and DELETE removes a
+// finished job from memory and the store
+func TestJobRetention(t *testing.T) {
+	useJobManager(t)
+	useJobStore(t)
+	now := time.Now()
+	finished := func(age time.Duration) *Job {
+		job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+		job.Complete()
+		job.mu.Lock()
+		job.EndTime = now.Add(-age)
+		job.mu.Unlock()
+		return job
+	}
+	expired, oldest, older, newest := finished(48*time.Hour), finished(2*time.Hour), finished(time.Hour), finished(0)
+	running, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+
+	kept := func() []*Job {
+		var jobs []*Job
+		for _, job := range []*Job{expired, oldest, older, newest, running} {
+			if _, ok := lookupJob(job.ID); ok {
+				jobs = append(jobs, job)
+			}
+		}
+		return jobs
+	}
+	expiredCount, overLimitCount := atomic.LoadInt64(&stats.ExpiredJobs), atomic.LoadInt64(&stats.OverLimitJobs)
+	evictJobs(JobConfig{Retention: 24 * time.Hour, MaxJobs: 3}, now)
+	if got := kept(); !slices.Equal(got, []*Job{older, newest, running}) {
+		t.Errorf("kept %d jobs, want the two newest finished and the running one", len(got))
+	}
+	if atomic.LoadInt64(&stats.ExpiredJobs)-expiredCount != 1 || atomic.LoadInt64(&stats.OverLimitJobs)-overLimitCount != 1 {
+		t.Error("evictions not counted by reason")
+	}
+	evictJobs(JobConfig{MaxJobs: 1}, now)
+	if got := kept(); !slices.Equal(got, []*Job{running}) {
+		t.Errorf("kept %d jobs under a limit of 1, want only the running one", len(got))
+	}
+
+	server := httptest.NewServer(newRouter(currentConfig()))
+	defer server.Close()
+	remove := func() int {
+		req, _ := http.NewRequest(http.MethodDelete, server.URL+"/api/jobs/"+running.ID, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := remove(); code != http.StatusConflict {
+		t.Errorf("DELETE of a running job gave HTTP %d, want 409", code)
+	}
+	running.Complete()
+	if err := flushJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := jobStore.Load(running.ID); stored == nil {
+		t.Fatal("finished job was not stored")
+	}
+	if code := remove(); code != http.StatusNoContent {
+		t.Errorf("DELETE of a finished job gave HTTP %d, want 204", code)
+	}
+	if stored, _ := jobStore.Load(running.ID); stored != nil || len(kept()) != 0 {
+		t.Error("deleted job is still kept")
+	}
+}
+
+// /api/jobs lists summaries, filtered by status and target, sorted by any
+// key either way with ties by ID, and paged; full=1 lists whole jobs
+func TestJobsList(t *testing.T) {
+	useJobManager(t)
+	newJob := func(target string, results int) *Job {
+		job, _ := createJob(context.Background(), target, []string{"test"}, nil)
+		for i := range results {
+			job.AddResult("test", Result{Host: fmt.Sprintf("h%d.%s", i, target), Source: "test", Status: "discovered"})
+		}
+		return job
+	}
+	done := newJob("a.example.com", 3)
+	done.Complete()
+	failed := newJob("b.example.com", 1)
+	failed.Fail(fmt.Errorf("no sources"))
+	running := newJob("a.example.com", 2)
+	defer running.Complete()
+
+	list := func(query string) (ids []string, total, code int) {
+		t.Helper()
+		response := httptest.NewRecorder()
+		jobsHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs?"+query, nil))
+		var page struct {
+			Jobs  []JobSummary `json:"jobs"`
+			Total int          `json:"total"`
+		}
+		json.Unmarshal(response.Body.Bytes(), &page)
+		for _, job := range page.Jobs {
+			ids = append(ids, job.ID)
+		}
+		return ids, page.Total, response.Code
+	}
+	for _, tc := range []struct {
+		query string
+		want  []*Job
+		total int
+	}{
+		{query: "status=failed", want: []*Job{failed}, total: 1},
+		{query: "status=completed,running&target=A.Example.com", want: []*Job{done, running}, total: 2},
+		{query: "sort=-results", want: []*Job{done, running, failed}, total: 3},
+		{query: "sort=target", want: slices.SortedFunc(slices.Values([]*Job{done, running, failed}), func(a, b *Job) int {
+			return cmp.Or(strings.Compare(a.Target, b.Target), strings.Compare(a.ID, b.ID))
+		}), total: 3},
+		{query: "sort=-results&limit=1&offset=1", want: []*Job{running}, total: 3},
+		{query: "offset=10", total: 3},
+	} {
+		var want []string
+		for _, job := range tc.want {
+			want = append(want, job.ID)
+		}
+		if ids, total, code := list(tc.query); code != http.StatusOK || total != tc.total || !slices.Equal(ids, want) {
+			t.Errorf("%s: HTTP %d, %v of %d; want %v of %d", tc.query, code, ids, total, want, tc.total)
+		}
+	}
+	for _, query := range []string{"sort=bogus", "limit=0", "offset=-1"} {
+		if _, _, code := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s: HTTP %d, want 400", query, code)
+		}
+	}
+
+	response := httptest.NewRecorder()
+	jobsHandler(response, httptest.NewRequest(http.MethodGet, "/api/jobs?full=1&status=completed", nil))
+	var full []struct {
+		ID      string              `json:"id"`
+		Results map[string][]Result `json:"results"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &full); err != nil || len(full) != 1 || full[0].ID != done.ID || len(full[0].Results["test"]) != 3 {
+		t.Errorf("full=1 listed %s", response.Body.String())
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Only the client that started a scan resumes it by naming its events;
+// the job ID alone is no key
+func TestResumeOwner(t *testing.T) {
+	job, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	job.Owner = "alice"
+	job.Complete()
+	byAddress, _ := createJob(context.Background(), "example.com", []string{"test"}, nil)
+	byAddress.Owner = "192.0.2.1"
+	byAddress.Complete()
+
+	for _, tc := range []struct {
+		job        *Job
+		query      string
+		remoteAddr string
+		want       bool
+	}{
+		{job: job, query: "&owner=alice", want: true},
+		{job: job, query: "&owner=mallory"},
+		{job: job},
+		{job: byAddress, remoteAddr: "192.0.2.1:4000", want: true},
+		{job: byAddress, remoteAddr: "198.51.100.9:4000"},
+		{job: byAddress, query: "&owner=192.0.2.1", remoteAddr: "198.51.100.9:4000", want: true},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/enumerate/stream?target=example.com"+tc.query, nil)
+		if tc.remoteAddr != "" {
+			r.RemoteAddr = tc.remoteAddr
+		}
+		r.Header.Set("Last-Event-ID", tc.job.eventID(3))
+		resumed, cursor, ok := resumableJob(r)
+		if ok != tc.want || ok && (resumed != tc.job || cursor != 3) {
+			t.Errorf("owner %s resuming as %q from %s: %v, want %v", tc.job.Owner, tc.query, r.RemoteAddr, ok, tc.want)
+		}
+	}
+}
+
+// Aborting a target stops the sources of its running job, on the enumerate
+// stream and a single source's, and the job leaves ActiveJobs once
+func TestAbortCancelsSources(t *testing.T) {
+	var running, peak int64
+	useSources(t, fixedSource{name: "slow", delay: time.Minute, running: &running, peak: &peak})
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	for _, stream := range []string{"/api/enumerate/stream", "/api/slow/stream"} {
+		query := url.Values{"target": {tt.Zone}, "sources": {"slow"}}
+		done := make(chan []sseEvent)
+		go func() { done <- streamEvents(t, server.URL+stream+"?"+query.Encode()) }()
+		for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&running) == 0; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: the source never started", stream)
+			}
+		}
+		job := latestJob(tt.Zone, nil)
+		active, completed := atomic.LoadInt64(&stats.ActiveJobs), atomic.LoadInt64(&stats.CompletedJobs)
+
+		start := time.Now()
+		resp, err := http.Post(server.URL+"/api/abort?target="+tt.Zone, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: stream still open after the abort", stream)
+		}
+		if elapsed := time.Since(start); atomic.LoadInt64(&running) != 0 || elapsed > 2*time.Second {
+			t.Errorf("%s: source still running %v after the abort", stream, elapsed)
+		}
+		job.mu.RLock()
+		status := job.Status
+		job.mu.RUnlock()
+		if status != "cancelled" {
+			t.Errorf("%s: aborted job is %s", stream, status)
+		}
+		if got := atomic.LoadInt64(&stats.ActiveJobs); got != active-1 {
+			t.Errorf("%s: ActiveJobs went from %d to %d, want %d", stream, active, got, active-1)
+		}
+		if got := atomic.LoadInt64(&stats.CompletedJobs); got != completed {
+			t.Errorf("%s: CompletedJobs went from %d to %d for an aborted job", stream, completed, got)
+		}
+	}
+}
+
+// POST /api/jobs/<id>/abort cancels that job alone, recording who asked,
+// and answers 409 once it has finished and 404 for an unknown job
+func TestAbortJob(t *testing.T) {
+	cfg := currentConfig()
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+	job, ctx := createJob(context.Background(), "abort.example.com", []string{"test"}, nil)
+	other, otherCtx := createJob(context.Background(), "abort.example.com", []string{"test"}, nil)
+	defer other.Complete()
+
+	abort := func(id string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(server.URL+"/api/jobs/"+id+"/abort?owner=alice", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := abort(job.ID)
+	var aborted struct {
+		Job       string    `json:"job"`
+		Status    string    `json:"status"`
+		AbortedBy string    `json:"aborted_by"`
+		AbortedAt time.Time `json:"aborted_at"`
+	}
+	json.NewDecoder(resp.Body).Decode(&aborted)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || aborted.Job != job.ID || aborted.Status != "cancelled" || aborted.AbortedBy != "alice" || aborted.AbortedAt.IsZero() {
+		t.Errorf("abort: HTTP %d %+v", resp.StatusCode, aborted)
+	}
+	if ctx.Err() == nil {
+		t.Error("aborted job's context not cancelled")
+	}
+	other.mu.RLock()
+	status := other.Status
+	other.mu.RUnlock()
+	if otherCtx.Err() != nil || status != "running" {
+		t.Errorf("other job for the target is %s, context %v", status, otherCtx.Err())
+	}
+
+	for id, want := range map[string]int{job.ID: http.StatusConflict, "no-such-job": http.StatusNotFound} {
+		resp := abort(id)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("abort of %s: HTTP %d, want %d", id, resp.StatusCode, want)
+		}
+	}
+	resp, err := http.Get(server.URL + "/api/jobs/" + other.ID + "/abort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET abort: HTTP %d", resp.StatusCode)
+	}
+}
+
+// A combined scan sends progress events, the last one before complete: a
+// source that knows its candidates counts them to 100%, one that doesn't
+// counts its hosts. The job record keeps the report.
+func TestJobProgress(t *testing.T) {
+	useSources(t, staticSource{"static-passive", []string{"a", "b", "c"}})
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	query := url.Values{"target": {tt.Zone}, "sources": {"dns,static-passive"}, "words": {"vpn,corp,nope"}}
+	var last *ProgressReport
+	events := streamEvents(t, server.URL+"/api/enumerate/stream?"+query.Encode())
+	for _, event := range events {
+		if event.name == "progress" {
+			last = &ProgressReport{}
+			json.Unmarshal(event.data, last)
+		}
+	}
+	if last == nil || len(events) < 2 {
+		t.Fatal("no progress events")
+	}
+	if events[len(events)-2].name != "progress" {
+		t.Errorf("complete preceded by %s, want the last progress event", events[len(events)-2].name)
+	}
+	if dns := last.Sources["dns"]; dns.Total != 3 || dns.Completed != 3 || dns.Percent != 100 || dns.Indeterminate {
+		t.Errorf("dns progress %+v, want 3 of 3", dns)
+	}
+	if static := last.Sources["static-passive"]; !static.Indeterminate || static.Completed != 3 || static.Total != 0 {
+		t.Errorf("static-passive progress %+v, want 3 hosts and no total", static)
+	}
+	if last.Total != 3 || last.Completed != 3 || last.Indeterminate {
+		t.Errorf("progress %+v, want the dns candidates only", last)
+	}
+
+	job := latestJob(tt.Zone, nil)
+	data, err := encodeJobRecord(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := decodeJobRecord(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, want := stored.Progress.Report(), job.Progress.Report(); !reflect.DeepEqual(report, want) {
+		t.Errorf("stored progress %+v, want %+v", report, want)
+	}
+}
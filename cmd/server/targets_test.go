@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Targets are taken in Unicode or punycode and kept in lowercase ASCII;
+// symbols and mixed scripts are not domain names
+func TestParseTarget(t *testing.T) {
+	for raw, want := range map[string]string{
+		"münchen.de":        "xn--mnchen-3ya.de",
+		"MÜNCHEN.de.":       "xn--mnchen-3ya.de",
+		"xn--mnchen-3ya.de": "xn--mnchen-3ya.de",
+		"Example.COM":       "example.com",
+		"例え.jp":             "xn--r8jz45g.jp",
+		"テスト漢字abc.example":  "xn--abc-4k4bocn9737efd7a.example",
+		"pаypal.com":        "", // Cyrillic а among Latin letters
+		"αβγabc.com":        "",
+		"i❤.ws":             "",
+		"😀.example":         "",
+		"bad_label.example": "",
+		"":                  "",
+	} {
+		got, err := parseTarget(raw)
+		if want == "" && !errors.Is(err, errInvalidDomain) || want != "" && (err != nil || got != want) {
+			t.Errorf("%q: %q, %v; want %q", raw, got, err, want)
+		}
+	}
+}
+
+// Sources see the ASCII target; results keep the ASCII host and go out
+// on the stream with their Unicode form alongside
+func TestUnicodeTargetStream(t *testing.T) {
+	useSources(t, staticSource{"static-idn", []string{"www"}})
+	cfg := currentConfig()
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	var results []Result
+	for _, event := range streamEvents(t, server.URL+"/api/static-idn/stream?target="+url.QueryEscape("münchen.de")) {
+		var result Result
+		if event.name == "message" && json.Unmarshal(event.data, &result) == nil {
+			results = append(results, result)
+		}
+	}
+	if len(results) != 1 || results[0].Host != "www.xn--mnchen-3ya.de" || results[0].Display != "www.münchen.de" {
+		t.Errorf("streamed %+v, want www.xn--mnchen-3ya.de shown as www.münchen.de", results)
+	}
+}
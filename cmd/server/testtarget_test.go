@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useConfig makes cfg the server's config, resolvers and probe client
+// included, for the rest of the test
+func useConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	previous := currentConfig()
+	setConfig(cfg)
+	reloadDNSResolver(cfg)
+	reloadProbeClient(cfg)
+	t.Cleanup(func() {
+		setConfig(previous)
+		reloadDNSResolver(previous)
+		reloadProbeClient(previous)
+	})
+}
+
+// startTestTargetT starts the synthetic test target on a free port and
+// points a copy of the current config at it
+func startTestTargetT(t *testing.T) (*TestTarget, *Config) {
+	t.Helper()
+	var tt *TestTarget
+	var err error
+	// The port is free for UDP when picked; TCP may still take it first
+	for range 5 {
+		var conn net.PacketConn
+		if conn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		addr := conn.LocalAddr().String()
+		conn.Close()
+		if tt, err = startTestTarget(addr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tt.Close)
+
+	cfg := *currentConfig()
+	if err := tt.Configure(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	return tt, &cfg
+}
+
+// sseEvent is one event of a scan stream
+type sseEvent struct {
+	name string
+	data json.RawMessage
+}
+
+// streamEvents runs a scan stream to its end and returns its events
+func streamEvents(t *testing.T, streamURL string) []sseEvent {
+	t.Helper()
+	resp, err := http.Get(streamURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readEvents(t, streamURL, resp)
+}
+
+// postEvents posts a JSON body to a stream and returns its events
+func postEvents(t *testing.T, streamURL, body string) []sseEvent {
+	t.Helper()
+	resp, err := http.Post(streamURL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readEvents(t, streamURL, resp)
+}
+
+// readEvents reads a stream's response to its end
+func readEvents(t *testing.T, streamURL string, resp *http.Response) []sseEvent {
+	t.Helper()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: HTTP %d", streamURL, resp.StatusCode)
+	}
+
+	var events []sseEvent
+	name := "message"
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			events = append(events, sseEvent{name, json.RawMessage(strings.TrimPrefix(line, "data: "))})
+		case line == "":
+			name = "message"
+		}
+	}
+	return events
+}
+
+// An aggregate scan of the synthetic target finds what each stage should,
+// without leaving the process
+func TestTestTargetScan(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	query := url.Values{
+		"target":   {tt.Zone},
+		"sources":  {"dns,permute,zone,crtsh,wayback"},
+		"words":    {"www,api,admin,legacy,nosuchname"},
+		"probe":    {"true"},
+		"takeover": {"true"},
+	}
+	events := streamEvents(t, server.URL+"/api/enumerate/stream?"+query.Encode())
+
+	var jobID string
+	probes := make(map[string]ProbeEvent)
+	outcomes := make(map[string]string)
+	for _, event := range events {
+		switch event.name {
+		case "start":
+			var start struct{ Job string }
+			json.Unmarshal(event.data, &start)
+			jobID = start.Job
+		case "probe":
+			var probe ProbeEvent
+			json.Unmarshal(event.data, &probe)
+			probes[probe.Host] = probe
+		case "source-complete":
+			var complete struct{ Source, Outcome string }
+			json.Unmarshal(event.data, &complete)
+			outcomes[complete.Source] = complete.Outcome
+		}
+	}
+	for _, source := range []string{"dns", "permute", "zone", "crtsh", "wayback", "probe", "takeover"} {
+		if outcomes[source] != "complete" {
+			t.Errorf("%s ended %q, want complete", source, outcomes[source])
+		}
+	}
+
+	job, ok := lookupJob(jobID)
+	if !ok {
+		t.Fatalf("job %q not found", jobID)
+	}
+	// Each source's own findings, whichever reported a host first
+	job.mu.RLock()
+	found := make(map[string]map[string]Result)
+	for source, results := range job.Results {
+		found[source] = make(map[string]Result)
+		for _, result := range results {
+			found[source][strings.TrimSuffix(result.Host, "."+tt.Zone)] = result
+		}
+	}
+	job.mu.RUnlock()
+	for source, labels := range map[string][]string{
+		"dns":     {"www", "api", "admin", "legacy"},
+		"permute": {"dev", "staging", "mobile"},
+		"zone":    {"dc1", "vpn.corp", "cdn", "jenkins", "wild"},
+		"crtsh":   {"www", "old-portal", "staging"},
+		"wayback": {"intranet", "blog"},
+	} {
+		for _, label := range labels {
+			if _, ok := found[source][label]; !ok {
+				t.Errorf("%s did not find %s.%s", source, label, tt.Zone)
+			}
+		}
+	}
+	if _, ok := found["dns"]["nosuchname"]; ok {
+		t.Error("dns found a name the zone does not have")
+	}
+
+	for host, want := range map[string]string{
+		"www":   "Synthetic www." + tt.Zone,
+		"docs":  "Synthetic docs." + tt.Zone,
+		"admin": "Admin Login",
+	} {
+		if probe := probes[host+"."+tt.Zone]; probe.Status != "200" || probe.Title != want {
+			t.Errorf("probe of %s: %s %q, want 200 %q", host, probe.Status, probe.Title, want)
+		}
+	}
+	if probe := probes["intranet."+tt.Zone]; probe.Error != "no-dns" {
+		t.Errorf("probe of intranet, gone from DNS: %s %q", probe.Status, probe.Error)
+	}
+
+	takeover, ok := found["takeover"]["legacy"]
+	if !ok || takeover.Status != "possible-takeover" || takeover.Title != "GitHub Pages" {
+		t.Errorf("takeover of legacy: %+v", takeover)
+	}
+	if len(found["takeover"]) != 1 {
+		t.Errorf("takeovers %v, want legacy only", found["takeover"])
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// wsClient is the client side of a test WebSocket: it masks what it sends
+// and reads the server's frames as they come
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialWebSocket opens /ws/enumerate?query on server
+func dialWebSocket(t *testing.T, server *httptest.Server, query url.Values) *wsClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/ws/enumerate?"+query.Encode(), nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accept := sha1.Sum([]byte(key + webSocketGUID))
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		t.Fatalf("handshake answered HTTP %d, accept %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return &wsClient{conn: conn, br: br}
+}
+
+// send writes payload as one masked frame
+func (c *wsClient) send(t *testing.T, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{head, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read returns the server's next frame
+func (c *wsClient) read(t *testing.T) (opcode byte, payload []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var size [2]byte
+		io.ReadFull(c.br, size[:])
+		n = uint64(binary.BigEndian.Uint16(size[:]))
+	case 127:
+		var size [8]byte
+		io.ReadFull(c.br, size[:])
+		n = binary.BigEndian.Uint64(size[:])
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+// events reads the server's messages up to its close frame, returning
+// them and the close code; pongs are collected as "pong" events
+func (c *wsClient) events(t *testing.T) (events []wsEvent, code int) {
+	t.Helper()
+	for {
+		opcode, payload := c.read(t)
+		switch opcode {
+		case wsText:
+			var event wsEvent
+			if err := json.Unmarshal(payload, &event); err != nil {
+				t.Fatalf("message %q: %v", payload, err)
+			}
+			events = append(events, event)
+		case wsPong:
+			events = append(events, wsEvent{Event: "pong", Data: payload})
+		case wsClose:
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			return events, code
+		}
+	}
+}
+
+// /ws/enumerate sends a scan's events as messages and closes with 1000;
+// it answers pings and unknown commands, aborts on {"action":"abort"},
+// turns binary messages away with 1003, and a client that goes away stops
+// the scan
+func TestEnumerateWebSocket(t *testing.T) {
+	var running, peak int64
+	tt, cfg := startTestTargetT(t)
+	useSources(t,
+		fixedSource{name: "fixed", hosts: []string{"www." + tt.Zone}, delay: 300 * time.Millisecond},
+		fixedSource{name: "stuck", delay: time.Minute, running: &running, peak: &peak})
+	// Browsers may only connect from the server's own origin
+	cfg.Security.EnableCORS = false
+	useConfig(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	ws := dialWebSocket(t, server, url.Values{"target": {tt.Zone}, "sources": {"fixed"}})
+	ws.send(t, true, wsPing, []byte("hi"))
+	ws.send(t, false, wsText, []byte(`{"action":`))
+	ws.send(t, true, wsContinuation, []byte(`"bogus"}`))
+	events, code := ws.events(t)
+	names := make(map[string]int)
+	found := false
+	for _, event := range events {
+		names[event.Event]++
+		var result Result
+		found = found || event.Event == "message" && json.Unmarshal(event.Data, &result) == nil && result.Host == "www."+tt.Zone
+	}
+	if !found || names["start"] != 1 || names["complete"] != 1 || names["pong"] != 1 || names["error"] != 1 || events[len(events)-1].Event != "complete" {
+		t.Errorf("scan sent %v", names)
+	}
+	if code != wsCloseNormal {
+		t.Errorf("scan closed with %d, want %d", code, wsCloseNormal)
+	}
+
+	ws = dialWebSocket(t, server, url.Values{"target": {tt.Zone}, "sources": {"stuck"}})
+	ws.send(t, true, wsText, []byte(`{"action":"abort"}`))
+	events, code = ws.events(t)
+	var complete struct {
+		Cancelled bool `json:"cancelled"`
+	}
+	if len(events) > 0 {
+		json.Unmarshal(events[len(events)-1].Data, &complete)
+	}
+	if !complete.Cancelled || code != wsCloseNormal {
+		t.Errorf("aborted scan ended with %v, close %d", events, code)
+	}
+	if job := latestJob(tt.Zone, nil); job.AbortedBy == "" {
+		t.Errorf("aborted job is %s, aborted by nobody", job.Status)
+	}
+
+	ws = dialWebSocket(t, server, url.Values{"target": {tt.Zone}, "sources": {"fixed"}})
+	ws.send(t, true, wsBinary, []byte{1})
+	if _, code := ws.events(t); code != wsCloseUnsupported {
+		t.Errorf("binary message closed with %d, want %d", code, wsCloseUnsupported)
+	}
+
+	ws = dialWebSocket(t, server, url.Values{"target": {tt.Zone}, "sources": {"stuck"}})
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&running) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the source never started")
+		}
+	}
+	ws.conn.Close()
+	for deadline := time.Now().Add(2 * time.Second); atomic.LoadInt64(&running) != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the scan kept running after the client went away")
+		}
+	}
+
+	for _, tc := range []struct {
+		header http.Header
+		query  string
+		want   int
+	}{
+		{query: "target=" + tt.Zone, want: http.StatusUpgradeRequired},
+		{header: http.Header{"Sec-WebSocket-Key": {"short"}}, query: "target=" + tt.Zone, want: http.StatusBadRequest},
+		{header: http.Header{"Origin": {"https://elsewhere.example"}}, query: "target=" + tt.Zone, want: http.StatusForbidden},
+		{header: http.Header{}, query: "target=not a domain", want: http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/ws/enumerate?"+tc.query, nil)
+		if tc.header != nil {
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%v %s: HTTP %d, want %d", tc.header, tc.query, resp.StatusCode, tc.want)
+		}
+	}
+}
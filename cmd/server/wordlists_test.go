@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// useWordlistDir keeps the test's uploads in a directory of its own and
+// forgets them afterwards
+func useWordlistDir(t *testing.T, cfg *Config) {
+	t.Helper()
+	cfg.DataDir = t.TempDir()
+	useConfig(t, cfg)
+	t.Cleanup(func() {
+		wordlistsMu.Lock()
+		for name, wl := range wordlists {
+			if wl.uploaded {
+				delete(wordlists, name)
+			}
+		}
+		wordlistsMu.Unlock()
+	})
+}
+
+// wordlistRequest sends body to /api/wordlists<path> with method
+func wordlistRequest(t *testing.T, server *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, server.URL+"/api/wordlists"+path, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	text, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(text)
+}
+
+// An uploaded list is validated and deduplicated, listed, used by
+// wordlist=, reloaded after a restart and deleted with its files
+func TestWordlistUpload(t *testing.T) {
+	tt, cfg := startTestTargetT(t)
+	cfg.DNS.UploadMaxWords = 3
+	useWordlistDir(t, cfg)
+	server := httptest.NewServer(newRouter(cfg))
+	defer server.Close()
+
+	for body, want := range map[string]int{
+		"vpn\na.b\n":                    http.StatusBadRequest,
+		"vpn\nbad label\n":              http.StatusBadRequest,
+		"# nothing\n\n":                 http.StatusBadRequest,
+		"a\nb\nc\nd\n":                  http.StatusRequestEntityTooLarge,
+		"vpn\n# comment\nVPN\r\ncorp\n": http.StatusCreated,
+	} {
+		if status, text := wordlistRequest(t, server, http.MethodPost, "?name=mine", body); status != want {
+			t.Errorf("upload %q: HTTP %d %s, want %d", body, status, text, want)
+		}
+	}
+	if status, _ := wordlistRequest(t, server, http.MethodPost, "?name=common", "vpn\n"); status != http.StatusConflict {
+		t.Errorf("upload over a built-in category: HTTP %d, want 409", status)
+	}
+
+	listed := func() map[string]WordlistInfo {
+		_, text := wordlistRequest(t, server, http.MethodGet, "", "")
+		var list struct {
+			Wordlists []WordlistInfo `json:"wordlists"`
+		}
+		json.Unmarshal([]byte(text), &list)
+		infos := make(map[string]WordlistInfo)
+		for _, info := range list.Wordlists {
+			infos[info.Name] = info
+		}
+		return infos
+	}
+	if info := listed()["mine"]; info.Kind != "upload" || info.Words != 2 || info.DiskBytes == 0 {
+		t.Errorf("listed %+v, want an upload of 2 words", info)
+	}
+
+	query := url.Values{"target": {tt.Zone}, "wordlist": {"mine"}}
+	hosts, _ := bruteStream(t, server, query)
+	slices.Sort(hosts)
+	if want := []string{"corp." + tt.Zone, "vpn." + tt.Zone}; !slices.Equal(hosts, want) {
+		t.Errorf("wordlist=mine found %v, want %v", hosts, want)
+	}
+
+	// A restart loads the upload again
+	wordlistsMu.Lock()
+	wl := wordlists["mine"]
+	delete(wordlists, "mine")
+	wordlistsMu.Unlock()
+	initializeWordlists()
+	if info := listed()["mine"]; info.Words != 2 {
+		t.Errorf("after a restart listed %+v, want the upload of 2 words", info)
+	}
+
+	if status, _ := wordlistRequest(t, server, http.MethodDelete, "/mine", ""); status != http.StatusNoContent {
+		t.Errorf("delete: HTTP %d, want 204", status)
+	}
+	if _, ok := listed()["mine"]; ok {
+		t.Error("deleted wordlist still listed")
+	}
+	for _, path := range []string{wl.source, wl.path} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", path, err)
+		}
+	}
+}
+
+// The extended category is the embedded list, extended or replaced by
+// DNS_WORDLIST_FILE and deduplicated, and is only tried when asked for
+func TestExtendedWordlist(t *testing.T) {
+	saved := commonSubdomains[extendedCategory]
+	t.Cleanup(func() { commonSubdomains[extendedCategory] = saved })
+	file := filepath.Join(t.TempDir(), "words.txt")
+	os.WriteFile(file, []byte("zz-extra-one\nWWW\n# comment\nzz-extra-two\nzz-extra-one\nbad label\n"), 0o600)
+	embedded := make(map[string]bool)
+	for _, word := range saved {
+		embedded[word] = true
+	}
+
+	cfg := *currentConfig()
+	cfg.DNS.WordlistFile = file
+	for _, tc := range []struct {
+		mode string
+		want int
+	}{{"extend", len(saved) + 2}, {"replace", 3}} {
+		mode, want := tc.mode, tc.want
+		cfg.DNS.WordlistMode = mode
+		loadExtendedWordlist(&cfg)
+		words := commonSubdomains[extendedCategory]
+		if len(words) != want || !slices.Contains(words, "zz-extra-two") || !slices.Contains(words, "www") {
+			t.Errorf("%s: %d words, want %d with the file's", mode, len(words), want)
+		}
+		if mode == "replace" && slices.ContainsFunc(words, func(word string) bool { return embedded[word] && word != "www" }) {
+			t.Errorf("replace kept embedded words: %v", words)
+		}
+	}
+
+	response := httptest.NewRecorder()
+	configHandler(response, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+	var reported struct {
+		Categories map[string]int `json:"wordlist_categories"`
+	}
+	json.NewDecoder(response.Body).Decode(&reported)
+	if reported.Categories[extendedCategory] != 3 {
+		t.Errorf("config reports %d extended words, want 3", reported.Categories[extendedCategory])
+	}
+
+	quick, _ := parseBruteLabels(url.Values{})
+	explicit, _ := parseBruteLabels(url.Values{"categories": {extendedCategory}})
+	if slices.Contains(quick.words, "zz-extra-one") || !slices.Contains(explicit.words, "zz-extra-one") {
+		t.Error("the extended category is tried without being asked for, or not when asked for")
+	}
+}
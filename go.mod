@@ -2,12 +2,16 @@ module github.com/thespecialone1/subdomain-enum
 
 go 1.24.0
 
-require github.com/miekg/dns v1.1.67
+require (
+	github.com/miekg/dns v1.1.67
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/net v0.40.0
+	golang.org/x/sync v0.14.0
+)
 
 require (
 	golang.org/x/mod v0.24.0 // indirect
-	golang.org/x/net v0.40.0 // indirect
-	golang.org/x/sync v0.14.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.25.0 // indirect
 	golang.org/x/tools v0.33.0 // indirect
 )